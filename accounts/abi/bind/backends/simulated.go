@@ -925,12 +925,8 @@ func (fb *filterBackend) SubscribeFinalizedHeaderEvent(ch chan<- core.FinalizedH
 	return fb.bc.SubscribeFinalizedHeaderEvent(ch)
 }
 
-func (fb *filterBackend) SubscribeRemovedLogsEvent(ch chan<- core.RemovedLogsEvent) event.Subscription {
-	return fb.bc.SubscribeRemovedLogsEvent(ch)
-}
-
-func (fb *filterBackend) SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscription {
-	return fb.bc.SubscribeLogsEvent(ch)
+func (fb *filterBackend) SubscribeCanonicalLogsEvent(ch chan<- core.CanonicalLogsEvent) event.Subscription {
+	return fb.bc.SubscribeCanonicalLogsEvent(ch)
 }
 
 func (fb *filterBackend) SubscribePendingLogsEvent(ch chan<- []*types.Log) event.Subscription {
@@ -51,6 +51,21 @@ import (
 	"github.com/ethereum/go-ethereum/trie/triedb/pathdb"
 )
 
+var (
+	exportReceiptsFromFlag = &cli.Uint64Flag{
+		Name:  "from",
+		Usage: "First block to export the receipts of",
+	}
+	exportReceiptsToFlag = &cli.Uint64Flag{
+		Name:  "to",
+		Usage: "Last block to export the receipts of (default = head block)",
+	}
+	exportReceiptsOutputFlag = &cli.StringFlag{
+		Name:  "output",
+		Usage: "File to export the receipts into",
+	}
+)
+
 var (
 	initCommand = &cli.Command{
 		Action:    initGenesis,
@@ -125,6 +140,7 @@ if one is set.  Otherwise it prints the genesis from the datadir.`,
 			utils.TransactionHistoryFlag,
 			utils.StateSchemeFlag,
 			utils.StateHistoryFlag,
+			utils.AncientFreezeThresholdFlag,
 		}, utils.DatabasePathFlags),
 		Description: `
 The import command imports blocks from an RLP-encoded form. The form can be one file
@@ -149,6 +165,21 @@ Optional second and third arguments control the first and
 last block to write. In this mode, the file will be appended
 if already existing. If the file ends with .gz, the output will
 be gzipped.`,
+	}
+	exportReceiptsCommand = &cli.Command{
+		Action: exportReceipts,
+		Name:   "export-receipts",
+		Usage:  "Export the receipts of a range of blocks into a JSON file",
+		Flags: flags.Merge([]cli.Flag{
+			exportReceiptsFromFlag,
+			exportReceiptsToFlag,
+			exportReceiptsOutputFlag,
+		}, utils.DatabasePathFlags),
+		Description: `
+The export-receipts command exports the receipts of the canonical blocks in the
+given range as newline delimited JSON, one receipt per line. Blocks whose
+receipts have been pruned are exported as a single {"pruned": true} object.
+If the file ends with .gz, the output will be gzipped.`,
 	}
 	importPreimagesCommand = &cli.Command{
 		Action:    importPreimages,
@@ -234,6 +265,11 @@ func initGenesis(ctx *cli.Context) error {
 	if err := json.NewDecoder(file).Decode(genesis); err != nil {
 		utils.Fatalf("invalid genesis file: %v", err)
 	}
+	if genesis.Config != nil {
+		if err := genesis.Config.CheckConfigForkOrder(); err != nil {
+			utils.Fatalf("invalid chain config in genesis file: %v", err)
+		}
+	}
 	// Open and initialise both full and light databases
 	stack, _ := makeConfigNode(ctx)
 	defer stack.Close()
@@ -374,6 +410,11 @@ func initNetwork(ctx *cli.Context) error {
 	if err := json.NewDecoder(inGenesisFile).Decode(genesis); err != nil {
 		utils.Fatalf("invalid genesis file: %v", err)
 	}
+	if genesis.Config != nil {
+		if err := genesis.Config.CheckConfigForkOrder(); err != nil {
+			utils.Fatalf("invalid chain config in genesis file: %v", err)
+		}
+	}
 
 	// load config
 	var config gethConfig
@@ -571,6 +612,47 @@ func exportChain(ctx *cli.Context) error {
 	return nil
 }
 
+// exportReceipts exports the receipts of a range of blocks into the specified
+// file as newline delimited JSON.
+func exportReceipts(ctx *cli.Context) error {
+	fn := ctx.String(exportReceiptsOutputFlag.Name)
+	if fn == "" {
+		utils.Fatalf("This command requires the --%s flag.", exportReceiptsOutputFlag.Name)
+	}
+	stack, _ := makeConfigNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack, true, false)
+	defer db.Close()
+
+	genesis := rawdb.ReadCanonicalHash(db, 0)
+	config := rawdb.ReadChainConfig(db, genesis)
+	if config == nil {
+		utils.Fatalf("Export error: chain config not found")
+	}
+	head := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadBlockHash(db))
+	if head == nil {
+		utils.Fatalf("Export error: head block not found")
+	}
+	first, last := ctx.Uint64(exportReceiptsFromFlag.Name), *head
+	if ctx.IsSet(exportReceiptsToFlag.Name) {
+		last = ctx.Uint64(exportReceiptsToFlag.Name)
+	}
+	if first > last {
+		utils.Fatalf("Export error: first block %d larger than last block %d\n", first, last)
+	}
+	if last > *head {
+		utils.Fatalf("Export error: block number %d larger than head block %d\n", last, *head)
+	}
+	start := time.Now()
+
+	if err := utils.ExportReceipts(db, config, fn, first, last); err != nil {
+		utils.Fatalf("Export error: %v\n", err)
+	}
+	fmt.Printf("Export done in %v\n", time.Since(start))
+	return nil
+}
+
 // importPreimages imports preimage data from the specified file.
 func importPreimages(ctx *cli.Context) error {
 	if ctx.Args().Len() < 1 {
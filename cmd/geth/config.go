@@ -185,6 +185,18 @@ func makeFullNode(ctx *cli.Context) (*node.Node, ethapi.Backend) {
 		v := ctx.Uint64(utils.OverrideKepler.Name)
 		cfg.Eth.OverrideKepler = &v
 	}
+	if ctx.IsSet(utils.OverrideDemeter.Name) {
+		v := ctx.Uint64(utils.OverrideDemeter.Name)
+		cfg.Eth.OverrideDemeter = &v
+	}
+	if ctx.IsSet(utils.OverrideAthena.Name) {
+		v := ctx.Uint64(utils.OverrideAthena.Name)
+		cfg.Eth.OverrideAthena = &v
+	}
+	if ctx.IsSet(utils.OverrideTheseus.Name) {
+		v := ctx.Uint64(utils.OverrideTheseus.Name)
+		cfg.Eth.OverrideTheseus = &v
+	}
 	if ctx.IsSet(utils.OverrideCancun.Name) {
 		v := ctx.Uint64(utils.OverrideCancun.Name)
 		cfg.Eth.OverrideCancun = &v
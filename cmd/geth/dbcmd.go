@@ -114,6 +114,7 @@ a data corruption.`,
 	dbHbss2PbssCmd = &cli.Command{
 		Action:    hbss2pbss,
 		Name:      "hbss-to-pbss",
+		Aliases:   []string{"migrate-state-scheme"},
 		ArgsUsage: "<jobnum (optional)>",
 		Flags: []cli.Flag{
 			utils.DataDirFlag,
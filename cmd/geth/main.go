@@ -67,10 +67,14 @@ var (
 		utils.EnableTrustProtocolFlag,
 		utils.PipeCommitFlag,
 		utils.RangeLimitFlag,
+		utils.StrictNonceOrderFlag,
 		utils.USBFlag,
 		utils.SmartCardDaemonPathFlag,
 		utils.OverrideShanghai,
 		utils.OverrideKepler,
+		utils.OverrideDemeter,
+		utils.OverrideAthena,
+		utils.OverrideTheseus,
 		utils.OverrideCancun,
 		utils.OverrideVerkle,
 		utils.EnablePersonal,
@@ -80,6 +84,7 @@ var (
 		utils.TxPoolRejournalFlag,
 		utils.TxPoolPriceLimitFlag,
 		utils.TxPoolPriceBumpFlag,
+		utils.TxPoolMinValidatorTipFlag,
 		utils.TxPoolAccountSlotsFlag,
 		utils.TxPoolGlobalSlotsFlag,
 		utils.TxPoolAccountQueueFlag,
@@ -99,6 +104,7 @@ var (
 		utils.TransactionHistoryFlag,
 		utils.StateSchemeFlag,
 		utils.StateHistoryFlag,
+		utils.AncientFreezeThresholdFlag,
 		utils.PathDBSyncFlag,
 		utils.LightServeFlag,       // deprecated
 		utils.LightIngressFlag,     // deprecated
@@ -233,6 +239,7 @@ func init() {
 		initNetworkCommand,
 		importCommand,
 		exportCommand,
+		exportReceiptsCommand,
 		importPreimagesCommand,
 		exportPreimagesCommand,
 		removedbCommand,
@@ -20,9 +20,11 @@ package utils
 import (
 	"bufio"
 	"compress/gzip"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"os/signal"
 	"runtime"
@@ -31,6 +33,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -40,6 +43,7 @@ import (
 	"github.com/ethereum/go-ethereum/internal/debug"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/urfave/cli/v2"
 )
@@ -374,6 +378,88 @@ func ExportPreimages(db ethdb.Database, fn string) error {
 	return nil
 }
 
+// receiptsFlushInterval is the number of receipts buffered by ExportReceipts
+// before being flushed out to the file.
+const receiptsFlushInterval = 1000
+
+// prunedReceipts is exported in place of the receipts of a block which are no
+// longer available in the database.
+type prunedReceipts struct {
+	BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	BlockHash   common.Hash    `json:"blockHash"`
+	Pruned      bool           `json:"pruned"`
+}
+
+// ExportReceipts exports the receipts of the canonical blocks in the range
+// [first, last] into the specified file as newline delimited JSON, one receipt
+// per line, truncating any data already present in the file. Blocks whose
+// receipts have been pruned are exported as a single object flagged pruned.
+func ExportReceipts(db ethdb.Reader, config *params.ChainConfig, fn string, first uint64, last uint64) error {
+	log.Info("Exporting receipts", "file", fn, "first", first, "last", last)
+
+	// Open the file handle and potentially wrap with a gzip stream
+	fh, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var writer io.Writer = fh
+	if strings.HasSuffix(fn, ".gz") {
+		writer = gzip.NewWriter(writer)
+		defer writer.(*gzip.Writer).Close()
+	}
+	buffer := bufio.NewWriter(writer)
+	encoder := json.NewEncoder(buffer)
+
+	var (
+		count, pruned int
+		start         = time.Now()
+		logged        = time.Now()
+	)
+	for number := first; number <= last; number++ {
+		hash := rawdb.ReadCanonicalHash(db, number)
+		if hash == (common.Hash{}) {
+			return fmt.Errorf("export failed on #%d: not found", number)
+		}
+		header := rawdb.ReadHeader(db, hash, number)
+		if header == nil {
+			return fmt.Errorf("export failed on #%d: header not found", number)
+		}
+		receipts := rawdb.ReadReceipts(db, hash, number, header.Time, config)
+		if receipts == nil {
+			if err := encoder.Encode(&prunedReceipts{BlockNumber: hexutil.Uint64(number), BlockHash: hash, Pruned: true}); err != nil {
+				return err
+			}
+			pruned++
+		}
+		for _, receipt := range receipts {
+			if err := encoder.Encode(receipt); err != nil {
+				return err
+			}
+			if count++; count%receiptsFlushInterval == 0 {
+				if err := buffer.Flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Exporting receipts", "file", fn, "number", number, "remaining", last-number,
+				"receipts", count, "pruned", pruned, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+		if number == math.MaxUint64 {
+			break
+		}
+	}
+	if err := buffer.Flush(); err != nil {
+		return err
+	}
+	log.Info("Exported receipts", "file", fn, "receipts", count, "pruned", pruned,
+		"elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
 // exportHeader is used in the export/import flow. When we do an export,
 // the first element we output is the exportHeader.
 // Whenever a backwards-incompatible change is made, the Version header
@@ -17,13 +17,22 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
+	"math/big"
 	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
@@ -196,3 +205,75 @@ func TestImportFutureFormat(t *testing.T) {
 		t.Fatalf("wrong error: %v", err)
 	}
 }
+
+// Tests that receipts are exported one per line, with pruned blocks replaced
+// by a marker object.
+func TestExportReceipts(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  core.GenesisAlloc{address: {Balance: big.NewInt(params.Ether)}},
+		}
+		signer = types.LatestSigner(gspec.Config)
+	)
+	_, blocks, receipts := core.GenerateChainWithGenesis(gspec, ethash.NewFaker(), 4, func(i int, b *core.BlockGen) {
+		var tx *types.Transaction
+		if i == 1 {
+			tx = types.NewContractCreation(b.TxNonce(address), common.Big0, 100000, b.BaseFee(), common.FromHex("0x6000600055"))
+		} else {
+			tx = types.NewTransaction(b.TxNonce(address), common.Address{0xaa}, common.Big1, params.TxGas, b.BaseFee(), nil)
+		}
+		signed, _ := types.SignTx(tx, signer, key)
+		b.AddTx(signed)
+	})
+	db := rawdb.NewMemoryDatabase()
+	for i, block := range blocks {
+		rawdb.WriteBlock(db, block)
+		rawdb.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		if i != 2 {
+			rawdb.WriteReceipts(db, block.Hash(), block.NumberU64(), receipts[i])
+		}
+	}
+	f := fmt.Sprintf("%v/tempreceipts", os.TempDir())
+	defer os.Remove(f)
+
+	if err := ExportReceipts(db, gspec.Config, f, 1, 4); err != nil {
+		t.Fatalf("failed to export receipts: %v", err)
+	}
+	blob, err := os.ReadFile(f)
+	if err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(blob), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("exported line count mismatch: have %d, want %d", len(lines), 4)
+	}
+	for i, line := range lines {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line %d: invalid json: %v", i, err)
+		}
+		if want := hexutil.EncodeUint64(uint64(i + 1)); entry["blockNumber"] != want {
+			t.Errorf("line %d: block number mismatch: have %v, want %v", i, entry["blockNumber"], want)
+		}
+		if i == 2 {
+			if entry["pruned"] != true {
+				t.Errorf("line %d: pruned block not marked: %v", i, line)
+			}
+			continue
+		}
+		if entry["status"] != "0x1" {
+			t.Errorf("line %d: status mismatch: have %v, want 0x1", i, entry["status"])
+		}
+		if want := blocks[i].Transactions()[0].Hash().Hex(); entry["transactionHash"] != want {
+			t.Errorf("line %d: transaction hash mismatch: have %v, want %v", i, entry["transactionHash"], want)
+		}
+		if i == 1 {
+			if want := crypto.CreateAddress(address, 1).Hex(); !strings.EqualFold(entry["contractAddress"].(string), want) {
+				t.Errorf("line %d: contract address mismatch: have %v, want %v", i, entry["contractAddress"], want)
+			}
+		}
+	}
+}
@@ -119,6 +119,11 @@ var (
 		Usage:    "Enable 5000 blocks limit for range query",
 		Category: flags.APICategory,
 	}
+	StrictNonceOrderFlag = &cli.BoolFlag{
+		Name:     "strictnonceorder",
+		Usage:    "Reject blocks containing transactions of a sender not in strictly increasing nonce order",
+		Category: flags.EthCategory,
+	}
 	DiffFlag = flags.DirectoryFlag{
 		Name:     "datadir.diff",
 		Usage:    "Data directory for difflayer segments (default = inside chaindata)",
@@ -304,6 +309,21 @@ var (
 		Usage:    "Manually specify the Kepler fork timestamp, overriding the bundled setting",
 		Category: flags.EthCategory,
 	}
+	OverrideDemeter = &cli.Uint64Flag{
+		Name:     "override.demeter",
+		Usage:    "Manually specify the Demeter fork timestamp, overriding the bundled setting",
+		Category: flags.EthCategory,
+	}
+	OverrideAthena = &cli.Uint64Flag{
+		Name:     "override.athena",
+		Usage:    "Manually specify the Athena fork timestamp, overriding the bundled setting",
+		Category: flags.EthCategory,
+	}
+	OverrideTheseus = &cli.Uint64Flag{
+		Name:     "override.theseus",
+		Usage:    "Manually specify the Theseus fork timestamp, overriding the bundled setting",
+		Category: flags.EthCategory,
+	}
 	OverrideCancun = &cli.Uint64Flag{
 		Name:     "override.cancun",
 		Usage:    "Manually specify the Cancun fork timestamp, overriding the bundled setting",
@@ -349,6 +369,11 @@ var (
 		Value:    ethconfig.Defaults.TransactionHistory,
 		Category: flags.StateCategory,
 	}
+	AncientFreezeThresholdFlag = &cli.Uint64Flag{
+		Name:     "history.ancient",
+		Usage:    "Number of recent blocks to keep in the key-value store before freezing them (default = 90,000 blocks, the minimum)",
+		Category: flags.StateCategory,
+	}
 	// Transaction pool settings
 	TxPoolLocalsFlag = &cli.StringFlag{
 		Name:     "txpool.locals",
@@ -384,6 +409,12 @@ var (
 		Value:    ethconfig.Defaults.TxPool.PriceBump,
 		Category: flags.TxPoolCategory,
 	}
+	TxPoolMinValidatorTipFlag = &cli.Uint64Flag{
+		Name:     "txpool.minvalidatortip",
+		Usage:    "Minimum effective gas tip in gwei to accept any transaction into the pool, local ones included (0 = no floor)",
+		Value:    0,
+		Category: flags.TxPoolCategory,
+	}
 	TxPoolAccountSlotsFlag = &cli.Uint64Flag{
 		Name:     "txpool.accountslots",
 		Usage:    "Minimum number of executable transaction slots guaranteed per account",
@@ -988,6 +1019,7 @@ Please note that --` + MetricsHTTPFlag.Name + ` must be set to start the server.
 
 	MetricsEnableInfluxDBV2Flag = &cli.BoolFlag{
 		Name:     "metrics.influxdbv2",
+		Aliases:  []string{"metrics.influxdb.v2"},
 		Usage:    "Enable metrics export/push to an external InfluxDB v2 database",
 		Category: flags.MetricsCategory,
 	}
@@ -1032,6 +1064,7 @@ Please note that --` + MetricsHTTPFlag.Name + ` must be set to start the server.
 	}
 	MetricsInfluxDBOrganizationFlag = &cli.StringFlag{
 		Name:     "metrics.influxdb.organization",
+		Aliases:  []string{"metrics.influxdb.org"},
 		Usage:    "InfluxDB organization name (v2 only)",
 		Value:    metrics.DefaultConfig.InfluxDBOrganization,
 		Category: flags.MetricsCategory,
@@ -1672,6 +1705,9 @@ func setTxPool(ctx *cli.Context, cfg *legacypool.Config) {
 	if ctx.IsSet(TxPoolPriceBumpFlag.Name) {
 		cfg.PriceBump = ctx.Uint64(TxPoolPriceBumpFlag.Name)
 	}
+	if ctx.IsSet(TxPoolMinValidatorTipFlag.Name) {
+		cfg.MinValidatorTip = ctx.Uint64(TxPoolMinValidatorTipFlag.Name) * params.GWei
+	}
 	if ctx.IsSet(TxPoolAccountSlotsFlag.Name) {
 		cfg.AccountSlots = ctx.Uint64(TxPoolAccountSlotsFlag.Name)
 	}
@@ -1844,6 +1880,9 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 	if ctx.IsSet(DiffBlockFlag.Name) {
 		cfg.DiffBlock = ctx.Uint64(DiffBlockFlag.Name)
 	}
+	if ctx.IsSet(StrictNonceOrderFlag.Name) {
+		cfg.StrictNonceOrder = ctx.Bool(StrictNonceOrderFlag.Name)
+	}
 	if ctx.IsSet(PruneAncientDataFlag.Name) {
 		if cfg.SyncMode == downloader.FullSync {
 			cfg.PruneAncientData = ctx.Bool(PruneAncientDataFlag.Name)
@@ -1884,6 +1923,9 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *ethconfig.Config) {
 	if ctx.IsSet(StateHistoryFlag.Name) {
 		cfg.StateHistory = ctx.Uint64(StateHistoryFlag.Name)
 	}
+	if ctx.IsSet(AncientFreezeThresholdFlag.Name) {
+		cfg.AncientFreezeThreshold = ctx.Uint64(AncientFreezeThresholdFlag.Name)
+	}
 	scheme, err := ParseCLIAndConfigStateScheme(ctx.String(StateSchemeFlag.Name), cfg.StateScheme)
 	if err != nil {
 		Fatalf("%v", err)
@@ -2376,6 +2418,8 @@ func MakeChain(ctx *cli.Context, stack *node.Node, readonly bool) (*core.BlockCh
 		Preimages:           ctx.Bool(CachePreimagesFlag.Name),
 		StateScheme:         scheme,
 		StateHistory:        ctx.Uint64(StateHistoryFlag.Name),
+
+		ConcurrentStateValidation: true,
 	}
 	if cache.TrieDirtyDisabled && !cache.Preimages {
 		cache.Preimages = true
@@ -2398,6 +2442,9 @@ func MakeChain(ctx *cli.Context, stack *node.Node, readonly bool) (*core.BlockCh
 	if ctx.IsSet(TriesInMemoryFlag.Name) {
 		cache.TriesInMemory = ctx.Uint64(TriesInMemoryFlag.Name)
 	}
+	if ctx.IsSet(AncientFreezeThresholdFlag.Name) {
+		cache.AncientFreezeThreshold = ctx.Uint64(AncientFreezeThresholdFlag.Name)
+	}
 	vmcfg := vm.Config{EnablePreimageRecording: ctx.Bool(VMEnableDebugFlag.Name)}
 
 	// Disable transaction indexing/unindexing by default.
@@ -160,4 +160,29 @@ type PoSA interface {
 	GetFinalizedHeader(chain ChainHeaderReader, header *types.Header) *types.Header
 	VerifyVote(chain ChainHeaderReader, vote *types.VoteEnvelope) error
 	IsActiveValidatorAt(chain ChainHeaderReader, header *types.Header, checkVoteKeyFn func(bLSPublicKey *types.BLSPublicKey) bool) bool
+	EffectiveValidatorSet(chain ChainHeaderReader, header *types.Header) ([]common.Address, error)
+}
+
+// SystemCallStats is the time a consensus engine spent in system contract calls
+// while processing a block, split by the kind of call.
+type SystemCallStats struct {
+	Number       uint64
+	Hash         common.Hash
+	ValidatorSet time.Duration // Validator set queries and round turns
+	Rewards      time.Duration // Distribution of the block fees to the validators
+	Slash        time.Duration // Slashing of validators that missed their turn
+	Other        time.Duration // Any other system call, e.g. contract initialisation
+}
+
+// Total returns the time spent in all the system calls of the block.
+func (s SystemCallStats) Total() time.Duration {
+	return s.ValidatorSet + s.Rewards + s.Slash + s.Other
+}
+
+// SystemCallReporter is implemented by the consensus engines which make system
+// contract calls while processing a block.
+type SystemCallReporter interface {
+	// SystemCallStats returns the system call timings of a recently processed
+	// block, or false if the block is not known to the engine.
+	SystemCallStats(hash common.Hash) (SystemCallStats, bool)
 }
@@ -38,4 +38,13 @@ var (
 	// ErrInvalidTerminalBlock is returned if a block is invalid wrt. the terminal
 	// total difficulty.
 	ErrInvalidTerminalBlock = errors.New("invalid terminal block")
+
+	// ErrInvalidGasLimit is returned if a block's gas limit deviates from its
+	// parent's by more than allowed by params.GasLimitBoundDivisor.
+	ErrInvalidGasLimit = errors.New("invalid gas limit")
+
+	// ErrEpochCheckpointMismatch is returned if the validator set carried by an
+	// epoch block differs from the one computed locally, meaning the sender is
+	// on an incompatible fork.
+	ErrEpochCheckpointMismatch = errors.New("epoch checkpoint mismatch")
 )
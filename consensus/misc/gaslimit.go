@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/params"
 )
 
@@ -33,7 +34,7 @@ func VerifyGaslimit(parentGasLimit, headerGasLimit uint64) error {
 	}
 	limit := parentGasLimit / params.GasLimitBoundDivisor
 	if uint64(diff) >= limit {
-		return fmt.Errorf("invalid gas limit: have %d, want %d +-= %d", headerGasLimit, parentGasLimit, limit-1)
+		return fmt.Errorf("%w: have %d, want %d +-= %d", consensus.ErrInvalidGasLimit, headerGasLimit, parentGasLimit, limit-1)
 	}
 	if headerGasLimit < params.MinGasLimit {
 		return errors.New("invalid gas limit below 5000")
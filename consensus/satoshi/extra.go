@@ -0,0 +1,71 @@
+package satoshi
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// SatoshiExtra is the decoded form of the extra-data section of a Satoshi header.
+//
+// The layout of the raw extra-data is:
+//
+//	vanity (32 bytes, the last 4 being the next fork hash) | validators (N*20 bytes, epoch blocks only) | seal (65 bytes)
+type SatoshiExtra struct {
+	Vanity       []byte                 // Signer vanity, including the trailing next fork hash
+	NextForkHash [nextForkHashSize]byte // Hash of the next scheduled fork, part of the vanity
+	Validators   []common.Address       // Validator set embedded in epoch blocks, nil otherwise
+	Seal         []byte                 // Secp256k1 signature of the block producer
+}
+
+// ExtraDataError is returned when the extra-data section of a header can't be
+// decoded into a SatoshiExtra.
+type ExtraDataError struct {
+	Number uint64 // Number of the offending header
+	Length int    // Length of the offending extra-data
+	Err    error  // Underlying reason of the failure
+}
+
+func (e *ExtraDataError) Error() string {
+	return fmt.Sprintf("malformed extra-data in header %d (length %d): %v", e.Number, e.Length, e.Err)
+}
+
+func (e *ExtraDataError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeSatoshiExtra splits the extra-data of a Satoshi header into its vanity,
+// validator list and seal. The validator list is only present in epoch blocks,
+// for every other block it's left nil.
+func DecodeSatoshiExtra(header *types.Header) (*SatoshiExtra, error) {
+	var number uint64
+	if header.Number != nil {
+		number = header.Number.Uint64()
+	}
+	extra := header.Extra
+	if len(extra) < extraVanity {
+		return nil, &ExtraDataError{Number: number, Length: len(extra), Err: errMissingVanity}
+	}
+	if len(extra) < extraVanity+extraSeal {
+		return nil, &ExtraDataError{Number: number, Length: len(extra), Err: errMissingSignature}
+	}
+	validatorBytes := extra[extraVanity : len(extra)-extraSeal]
+	if len(validatorBytes)%validatorBytesLength != 0 {
+		return nil, &ExtraDataError{Number: number, Length: len(extra), Err: errInvalidSpanValidators}
+	}
+	decoded := &SatoshiExtra{
+		Vanity: common.CopyBytes(extra[:extraVanity]),
+		Seal:   common.CopyBytes(extra[len(extra)-extraSeal:]),
+	}
+	copy(decoded.NextForkHash[:], extra[extraVanity-nextForkHashSize:extraVanity])
+
+	if len(validatorBytes) > 0 {
+		validators, err := ParseValidators(validatorBytes)
+		if err != nil {
+			return nil, &ExtraDataError{Number: number, Length: len(extra), Err: err}
+		}
+		decoded.Validators = validators
+	}
+	return decoded, nil
+}
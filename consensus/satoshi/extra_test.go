@@ -0,0 +1,54 @@
+package satoshi
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestDecodeSatoshiExtra(t *testing.T) {
+	vanity := bytes.Repeat([]byte{0x01}, extraVanity)
+	seal := bytes.Repeat([]byte{0x02}, extraSeal)
+	validators := []common.Address{randomAddress(), randomAddress(), randomAddress()}
+
+	// Epoch block carrying the validator set
+	extra := append([]byte{}, vanity...)
+	for _, val := range validators {
+		extra = append(extra, val.Bytes()...)
+	}
+	extra = append(extra, seal...)
+
+	decoded, err := DecodeSatoshiExtra(&types.Header{Number: big.NewInt(200), Extra: extra})
+	assert.NoError(t, err)
+	assert.Equal(t, vanity, decoded.Vanity)
+	assert.Equal(t, seal, decoded.Seal)
+	assert.Equal(t, validators, decoded.Validators)
+
+	// Non-epoch block only carrying the seal
+	extra = append(append([]byte{}, vanity...), seal...)
+	decoded, err = DecodeSatoshiExtra(&types.Header{Number: big.NewInt(201), Extra: extra})
+	assert.NoError(t, err)
+	assert.Equal(t, seal, decoded.Seal)
+	assert.Nil(t, decoded.Validators)
+
+	// Malformed extras
+	for _, tc := range []struct {
+		extra []byte
+		want  error
+	}{
+		{vanity[:extraVanity-1], errMissingVanity},
+		{append(append([]byte{}, vanity...), seal[:extraSeal-1]...), errMissingSignature},
+		{append(append(append([]byte{}, vanity...), 0x03), seal...), errInvalidSpanValidators},
+	} {
+		_, err := DecodeSatoshiExtra(&types.Header{Number: big.NewInt(1), Extra: tc.extra})
+		var extraErr *ExtraDataError
+		assert.True(t, errors.As(err, &extraErr))
+		assert.ErrorIs(t, err, tc.want)
+	}
+}
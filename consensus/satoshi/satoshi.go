@@ -3,6 +3,7 @@ package satoshi
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -10,7 +11,6 @@ import (
 	"math"
 	"math/big"
 	"math/rand"
-	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -119,10 +119,6 @@ var (
 	// errInvalidUncleHash is returned if a block contains an non-empty uncle list.
 	errInvalidUncleHash = errors.New("non empty uncle hash")
 
-	// errMismatchingEpochValidators is returned if a sprint block contains a
-	// list of validators different than the one the local node calculated.
-	errMismatchingEpochValidators = errors.New("mismatching validator list on epoch block")
-
 	// errInvalidDifficulty is returned if the difficulty of a block is missing.
 	errInvalidDifficulty = errors.New("invalid difficulty")
 
@@ -149,6 +145,14 @@ var (
 	// errRecentlySigned is returned if a header is signed by an authorized entity
 	// that already signed a header recently, thus is temporarily not allowed to.
 	errRecentlySigned = errors.New("recently signed")
+
+	// errFallbackBlockNotEmpty is returned if a block sealed by the fallback
+	// proposer of a slot ahead of the regular backoff contains transactions.
+	errFallbackBlockNotEmpty = errors.New("fallback block contains transactions")
+
+	// errMissingSigner is returned if a block is assembled before a validator was
+	// authorized to sign the system transactions it contains.
+	errMissingSigner = errors.New("no validator authorized to sign system transactions")
 )
 
 // SignerFn is a signer callback function to request a header to be signed by a
@@ -208,6 +212,9 @@ type Satoshi struct {
 	recentSnaps *lru.ARCCache // Snapshots for recent block to speed up
 	signatures  *lru.ARCCache // Signatures of recent blocks to speed up mining
 
+	systemCalls    *lru.ARCCache // System call timings of recently imported blocks
+	systemCallLock sync.Mutex    // Serializes the updates of the system call timings
+
 	signer types.Signer
 
 	val      common.Address // Ethereum address of the signing key
@@ -255,6 +262,10 @@ func New(
 	if err != nil {
 		panic(err)
 	}
+	systemCalls, err := lru.NewARC(inMemorySystemCallStats)
+	if err != nil {
+		panic(err)
+	}
 	vABI, err := abi.JSON(strings.NewReader(validatorSetABI))
 	if err != nil {
 		panic(err)
@@ -275,6 +286,7 @@ func New(
 		ethAPI:          ethAPI,
 		recentSnaps:     recentSnaps,
 		signatures:      signatures,
+		systemCalls:     systemCalls,
 		validatorSetABI: vABI,
 		slashABI:        sABI,
 		candidateHubABI: cABI,
@@ -335,20 +347,133 @@ func (p *Satoshi) VerifyHeader(chain consensus.ChainHeaderReader, header *types.
 // VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
 // method returns a quit channel to abort the operations and a results channel to
 // retrieve the async verifications (the order is that of the input slice).
+//
+// The signers of the batch are checked against the validator set upfront, so a
+// header signed by an unauthorized validator fails the batch before the headers
+// following it are verified at all.
 func (p *Satoshi) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header) (chan<- struct{}, <-chan error) {
 	abort := make(chan struct{})
 	results := make(chan error, len(headers))
 
 	gopool.Submit(func() {
-		for i, header := range headers {
-			err := p.verifyHeader(chain, header, headers[:i])
+		p.verifyHeaderBatch(chain, headers, abort, results)
+	})
+	return abort, results
+}
 
-			select {
-			case <-abort:
-				return
-			case results <- err:
+// verifyHeaderBatch verifies a batch of headers in order, delivering the results
+// until aborted. Headers from the first one signed by an unauthorized validator
+// on are rejected without being verified.
+func (p *Satoshi) verifyHeaderBatch(chain consensus.ChainHeaderReader, headers []*types.Header, abort <-chan struct{}, results chan<- error) {
+	unauthorized, unauthorizedErr := p.precheckSigners(chain, headers)
+	for i, header := range headers {
+		var err error
+		switch {
+		case i < unauthorized:
+			err = p.verifyHeader(chain, header, headers[:i])
+		case i == unauthorized:
+			err = unauthorizedErr
+		default:
+			err = consensus.ErrUnknownAncestor
+		}
+		select {
+		case <-abort:
+			return
+		case results <- err:
+		}
+	}
+}
+
+// precheckSigners recovers the signers of a batch of headers and checks them
+// against the validator set in effect at each header, following the validator
+// set switches within the batch. It returns the index of the first header signed
+// by an unauthorized validator along with the error, or len(headers) if there's
+// none. Batches which can't be checked this cheaply are left to the full header
+// verification.
+func (p *Satoshi) precheckSigners(chain consensus.ChainHeaderReader, headers []*types.Header) (int, error) {
+	if len(headers) == 0 || headers[0].Number == nil || headers[0].Number.Sign() == 0 {
+		return len(headers), nil
+	}
+	snap, err := p.snapshot(chain, headers[0].Number.Uint64()-1, headers[0].ParentHash, nil)
+	if err != nil {
+		return len(headers), nil
+	}
+	validators := snap.Validators
+	for i, header := range headers {
+		if header.Number == nil || len(header.Extra) < extraVanity+extraSeal {
+			break
+		}
+		if i > 0 && (header.Number.Uint64() != headers[i-1].Number.Uint64()+1 || header.ParentHash != headers[i-1].Hash()) {
+			break
+		}
+		signer, err := ecrecover(header, p.signatures, p.chainConfig.ChainID)
+		if err != nil {
+			break
+		}
+		if _, ok := validators[signer]; !ok {
+			return i, errUnauthorizedValidator(signer.String())
+		}
+		// Switch to the validator set of the last checkpoint at the same height
+		// as the snapshot does, the following headers are signed by that set
+		number := header.Number.Uint64()
+		if offset := uint64(len(validators) / 2); number%p.config.Epoch == offset {
+			checkpoint := FindAncientHeader(header, offset, chain, headers[:i])
+			if checkpoint == nil || len(checkpoint.Extra) < extraVanity+extraSeal {
+				break
+			}
+			vals, err := ParseValidators(checkpoint.Extra[extraVanity : len(checkpoint.Extra)-extraSeal])
+			if err != nil {
+				break
+			}
+			validators = make(map[common.Address]struct{}, len(vals))
+			for _, val := range vals {
+				validators[val] = struct{}{}
 			}
 		}
+	}
+	return len(headers), nil
+}
+
+// VerifyHeadersParallel is similar to VerifyHeaders, but recovers the signers of
+// the headers concurrently on the given number of workers before verifying them
+// exactly like VerifyHeaders does. The results are still delivered in the order
+// of the input slice.
+func (p *Satoshi) VerifyHeadersParallel(chain consensus.ChainHeaderReader, headers []*types.Header, workers int) (chan<- struct{}, <-chan error) {
+	if workers <= 1 {
+		return p.VerifyHeaders(chain, headers)
+	}
+	abort := make(chan struct{})
+	results := make(chan error, len(headers))
+
+	// Recover the signers into the signature cache
+	jobs := make(chan int, len(headers))
+	for i := range headers {
+		jobs <- i
+	}
+	close(jobs)
+
+	var pending sync.WaitGroup
+	pending.Add(workers)
+	for w := 0; w < workers; w++ {
+		gopool.Submit(func() {
+			defer pending.Done()
+			for i := range jobs {
+				select {
+				case <-abort:
+					return
+				default:
+				}
+				// Malformed headers are rejected by verifyHeader itself
+				if len(headers[i].Extra) >= extraSeal {
+					ecrecover(headers[i], p.signatures, p.chainConfig.ChainID)
+				}
+			}
+		})
+	}
+	// Verify the headers once all signers are cached
+	gopool.Submit(func() {
+		pending.Wait()
+		p.verifyHeaderBatch(chain, headers, abort, results)
 	})
 	return abort, results
 }
@@ -386,6 +511,11 @@ func (p *Satoshi) verifyHeader(chain consensus.ChainHeaderReader, header *types.
 	if isEpoch && signersBytes%validatorBytesLength != 0 {
 		return errInvalidSpanValidators
 	}
+	if isEpoch {
+		if _, err := ParseValidators(header.Extra[extraVanity : extraVanity+signersBytes]); err != nil {
+			return err
+		}
+	}
 
 	// Ensure that the mix digest is zero as we don't have fork protection currently
 	if header.MixDigest != (common.Hash{}) {
@@ -461,7 +591,7 @@ func (p *Satoshi) verifyCascadingFields(chain consensus.ChainHeaderReader, heade
 	}
 
 	// blockTimeVerify
-	if header.Time < parent.Time+p.config.Period+p.backOffTime(snap, header, header.Coinbase) {
+	if header.Time < parent.Time+p.config.Period+p.backOffTime(snap, header, parent, header.Coinbase) {
 		return consensus.ErrFutureBlock
 	}
 
@@ -475,7 +605,9 @@ func (p *Satoshi) verifyCascadingFields(chain consensus.ChainHeaderReader, heade
 		return fmt.Errorf("invalid gasUsed: have %d, gasLimit %d", header.GasUsed, header.GasLimit)
 	}
 
-	// Verify that the gas limit remains within allowed bounds
+	// Verify that the gas limit remains within allowed bounds, epoch blocks are
+	// no exception even though they rotate the validator set. The genesis gas
+	// limit has no parent to be checked against and was accepted above.
 	diff := int64(parent.GasLimit) - int64(header.GasLimit)
 	if diff < 0 {
 		diff *= -1
@@ -483,7 +615,7 @@ func (p *Satoshi) verifyCascadingFields(chain consensus.ChainHeaderReader, heade
 	limit := parent.GasLimit / params.GasLimitBoundDivisor
 
 	if uint64(diff) >= limit || header.GasLimit < params.MinGasLimit {
-		return fmt.Errorf("invalid gas limit: have %d, want %d += %d", header.GasLimit, parent.GasLimit, limit-1)
+		return fmt.Errorf("%w: have %d, want %d += %d", consensus.ErrInvalidGasLimit, header.GasLimit, parent.GasLimit, limit-1)
 	}
 
 	// All basic checks passed, verify the seal and return
@@ -684,7 +816,7 @@ func (p *Satoshi) Prepare(chain consensus.ChainHeaderReader, header *types.Heade
 	if parent == nil {
 		return consensus.ErrUnknownAncestor
 	}
-	header.Time = parent.Time + p.config.Period + p.backOffTime(snap, header, p.val)
+	header.Time = parent.Time + p.config.Period + p.backOffTime(snap, header, parent, p.val)
 	if header.Time < uint64(time.Now().Unix()) {
 		header.Time = uint64(time.Now().Unix())
 	}
@@ -698,11 +830,10 @@ func (p *Satoshi) Prepare(chain consensus.ChainHeaderReader, header *types.Heade
 		if err != nil {
 			return err
 		}
-		// sort validator by address
-		sort.Sort(validatorsAscending(newValidators))
-		for _, validator := range newValidators {
-			header.Extra = append(header.Extra, validator.Bytes()...)
+		if err := checkDuplicateValidators(newValidators); err != nil {
+			return err
 		}
+		header.Extra = append(header.Extra, sortedValidatorsBytes(newValidators)...)
 	}
 
 	// add extra seal space
@@ -718,6 +849,9 @@ func (p *Satoshi) BeforeValidateTx(chain consensus.ChainHeaderReader, header *ty
 	uncles []*types.Header, receipts *[]*types.Receipt, systemTxs *[]*types.Transaction, usedGas *uint64) (err error) {
 	cx := chainContext{Chain: chain, satoshi: p}
 
+	var stats consensus.SystemCallStats
+	defer func() { p.recordSystemCalls(header, stats, false) }()
+
 	parent := chain.GetHeaderByHash(header.ParentHash)
 	if p.chainConfig.IsOnDemeter(header.Number, parent.Time, header.Time) {
 		contracts := []string{
@@ -730,7 +864,9 @@ func (p *Satoshi) BeforeValidateTx(chain consensus.ChainHeaderReader, header *ty
 			systemcontracts.BTCLSTTokenContract,
 		}
 
+		start := time.Now()
 		err := p.initContractWithContracts(state, header, cx, txs, receipts, systemTxs, usedGas, false, contracts)
+		stats.Other += time.Since(start)
 		if err != nil {
 			log.Error("init contract failed on demeter fork")
 		}
@@ -740,7 +876,9 @@ func (p *Satoshi) BeforeValidateTx(chain consensus.ChainHeaderReader, header *ty
 	if p.isRoundEnd(chain, header) {
 		// try turnRound
 		log.Trace("turn round", "block hash", header.Hash())
+		start := time.Now()
 		err = p.turnRound(state, header, cx, txs, receipts, systemTxs, usedGas, false)
+		stats.ValidatorSet += time.Since(start)
 		if err != nil {
 			// it is possible that turn round failed.
 			log.Error("turn round failed", "block hash", header.Hash())
@@ -798,45 +936,52 @@ func (p *Satoshi) Finalize(chain consensus.ChainHeaderReader, header *types.Head
 	if !snap.isMajorityFork(hex.EncodeToString(nextForkHash[:])) {
 		log.Debug("there is a possible fork, and your client is not the majority. Please check...", "nextForkHash", hex.EncodeToString(nextForkHash[:]))
 	}
+	// Blocks sealed in the fallback slot may only carry the system transactions
+	if len(*txs) > 0 {
+		parent, err := p.getParent(chain, header, nil)
+		if err != nil {
+			return err
+		}
+		if p.isFallbackBlock(snap, header, parent) {
+			return errFallbackBlockNotEmpty
+		}
+	}
 	// If the block is a epoch end block, verify the validator list
 	// The verification can only be done when the state is ready, it can't be done in VerifyHeader.
+	var stats consensus.SystemCallStats
 	if header.Number.Uint64()%p.config.Epoch == 0 {
+		start := time.Now()
 		newValidators, err := p.getCurrentValidators(header.ParentHash)
+		stats.ValidatorSet += time.Since(start)
 		if err != nil {
 			return err
 		}
-		// sort validator by address
-		sort.Sort(validatorsAscending(newValidators))
-		validatorsBytes := make([]byte, len(newValidators)*validatorBytesLength)
-		for i, validator := range newValidators {
-			copy(validatorsBytes[i*validatorBytesLength:], validator.Bytes())
+		if err := checkDuplicateValidators(newValidators); err != nil {
+			return err
 		}
-
 		extraSuffix := len(header.Extra) - extraSeal
-		if !bytes.Equal(header.Extra[extraVanity:extraSuffix], validatorsBytes) {
-			return errMismatchingEpochValidators
+		have, want := crypto.Keccak256Hash(header.Extra[extraVanity:extraSuffix]), EpochCheckpoint(newValidators)
+		if have != want {
+			return fmt.Errorf("%w: have %x, want %x", consensus.ErrEpochCheckpointMismatch, have, want)
 		}
 	}
 	// No block rewards in PoA, so the state remains as is and uncles are dropped
 	cx := chainContext{Chain: chain, satoshi: p}
 	if header.Number.Cmp(common.Big1) == 0 {
+		start := time.Now()
 		err := p.initContract(state, header, cx, txs, receipts, systemTxs, usedGas, false)
+		stats.Other += time.Since(start)
 		if err != nil {
 			log.Error("init contract failed")
 		}
 	}
 	if header.Difficulty.Cmp(diffInTurn) != 0 {
 		spoiledVal := snap.supposeValidator()
-		signedRecently := false
-		for _, recent := range snap.Recents {
-			if recent == spoiledVal {
-				signedRecently = true
-				break
-			}
-		}
-		if !signedRecently {
+		if !snap.signedRecently(spoiledVal) {
 			log.Trace("slash validator", "block hash", header.Hash(), "address", spoiledVal)
+			start := time.Now()
 			err = p.slash(spoiledVal, state, header, cx, txs, receipts, systemTxs, usedGas, false)
+			stats.Slash += time.Since(start)
 			if err != nil {
 				// it is possible that slash validator failed because of the slash channel is disabled.
 				log.Error("slash validator failed", "block hash", header.Hash(), "address", spoiledVal, "err", err.Error())
@@ -844,13 +989,16 @@ func (p *Satoshi) Finalize(chain consensus.ChainHeaderReader, header *types.Head
 		}
 	}
 	val := header.Coinbase
+	start := time.Now()
 	err = p.distributeIncoming(val, state, header, cx, txs, receipts, systemTxs, usedGas, false)
+	stats.Rewards += time.Since(start)
 	if err != nil {
 		return err
 	}
 	if len(*systemTxs) > 0 {
 		return errors.New("the length of systemTxs do not match")
 	}
+	p.recordSystemCalls(header, stats, true)
 	return nil
 }
 
@@ -858,6 +1006,14 @@ func (p *Satoshi) Finalize(chain consensus.ChainHeaderReader, header *types.Head
 // nor block rewards given, and returns the final block.
 func (p *Satoshi) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB,
 	txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt, _ []*types.Withdrawal) (*types.Block, []*types.Receipt, error) {
+	// The system transactions are signed by the local validator, refuse to
+	// assemble anything until one was authorized
+	p.lock.RLock()
+	signTxFn := p.signTxFn
+	p.lock.RUnlock()
+	if signTxFn == nil {
+		return nil, nil, errMissingSigner
+	}
 	// No block rewards in PoA, so the state remains as is and uncles are dropped
 	cx := chainContext{Chain: chain, satoshi: p}
 	if txs == nil {
@@ -879,14 +1035,7 @@ func (p *Satoshi) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header
 			return nil, nil, err
 		}
 		spoiledVal := snap.supposeValidator()
-		signedRecently := false
-		for _, recent := range snap.Recents {
-			if recent == spoiledVal {
-				signedRecently = true
-				break
-			}
-		}
-		if !signedRecently {
+		if !snap.signedRecently(spoiledVal) {
 			err = p.slash(spoiledVal, state, header, cx, &txs, &receipts, nil, &header.GasUsed, true)
 			if err != nil {
 				// it is possible that slash validator failed because of the slash channel is disabled.
@@ -936,6 +1085,17 @@ func (p *Satoshi) Authorize(val common.Address, signFn SignerFn, signTxFn Signer
 func (p *Satoshi) Delay(chain consensus.ChainReader, header *types.Header, leftOver *time.Duration) *time.Duration {
 	delay := time.Until(time.Unix(int64(header.Time), 0))
 
+	// Fallback blocks are sealed empty, leave no time for packing transactions
+	if p.config.EmptyBlockTimeout > 0 {
+		number := header.Number.Uint64()
+		if snap, err := p.snapshot(chain, number-1, header.ParentHash, nil); err == nil {
+			if parent := chain.GetHeader(header.ParentHash, number-1); parent != nil && p.isFallbackBlock(snap, header, parent) {
+				delay = time.Duration(0)
+				return &delay
+			}
+		}
+	}
+
 	if *leftOver >= time.Duration(p.config.Period)*time.Second {
 		// ignore invalid leftOver
 		log.Error("Delay invalid argument", "leftOver", leftOver.String(), "Period", p.config.Period)
@@ -998,6 +1158,21 @@ func (p *Satoshi) Seal(chain consensus.ChainHeaderReader, block *types.Block, re
 	// Sweet, the protocol permits us to sign the block, wait for our time
 	delay := time.Until(time.Unix(int64(header.Time), 0))
 
+	// If we're the fallback proposer of the slot, only step in with an empty
+	// block once the in-turn validator missed the empty block timeout
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	if p.isFallbackBlock(snap, header, parent) {
+		for _, tx := range block.Transactions() {
+			if system, _ := p.IsSystemTransaction(tx, header); !system {
+				return errFallbackBlockNotEmpty
+			}
+		}
+		delay += time.Duration(p.config.EmptyBlockTimeout) * time.Millisecond
+	}
+
 	log.Info("Sealing block with", "number", number, "delay", delay, "headerDifficulty", header.Difficulty, "val", val.Hex())
 
 	// Sign all the things!
@@ -1106,6 +1281,9 @@ func (p *Satoshi) CalcDifficulty(chain consensus.ChainHeaderReader, time uint64,
 // CalcDifficulty is the difficulty adjustment algorithm. It returns the difficulty
 // that a new block should have based on the previous blocks in the chain and the
 // current signer.
+//
+// The fallback proposer of a slot is out-of-turn, so a late block of the in-turn
+// validator always outweighs a fallback block at the same height.
 func CalcDifficulty(snap *Snapshot, signer common.Address) *big.Int {
 	if snap.inturn(signer) {
 		return new(big.Int).Set(diffInTurn)
@@ -1444,7 +1622,74 @@ func encodeSigHeader(w io.Writer, header *types.Header, chainId *big.Int) {
 	}
 }
 
-func (s *Satoshi) backOffTime(snap *Snapshot, header *types.Header, val common.Address) uint64 {
+// fallbackProposer returns the validator which may seal an empty block in the
+// slot of the given block number, if the in-turn validator is offline. Starting
+// at keccak256(parentHash || number) mod len(validators), it's the first
+// validator which is neither in-turn nor recently signed, as those couldn't seal
+// the block anyway. The second return value is false if there is none.
+func fallbackProposer(snap *Snapshot, parentHash common.Hash, number uint64) (common.Address, bool) {
+	validators := snap.validators()
+
+	var blob [common.HashLength + 8]byte
+	copy(blob[:], parentHash[:])
+	binary.BigEndian.PutUint64(blob[common.HashLength:], number)
+
+	seed := new(big.Int).SetBytes(crypto.Keccak256(blob[:]))
+	offset := seed.Mod(seed, big.NewInt(int64(len(validators)))).Uint64()
+
+	recents := make(map[common.Address]struct{}, len(snap.Recents))
+	bound := uint64(0)
+	if limit := uint64(len(validators)/2 + 1); number > limit {
+		bound = number - limit
+	}
+	for seen, recent := range snap.Recents {
+		if seen > bound {
+			recents[recent] = struct{}{}
+		}
+	}
+	for i := uint64(0); i < uint64(len(validators)); i++ {
+		val := validators[(offset+i)%uint64(len(validators))]
+		if _, ok := recents[val]; ok || snap.inturn(val) {
+			continue
+		}
+		return val, true
+	}
+	return common.Address{}, false
+}
+
+// isFallbackProposer reports whether val is the fallback proposer of the slot
+// of the given header. The in-turn validator is never a fallback proposer. The
+// fallback slot is only active once the parent is past the Theseus fork.
+func (s *Satoshi) isFallbackProposer(snap *Snapshot, header, parent *types.Header, val common.Address) bool {
+	if s.config.EmptyBlockTimeout == 0 || !s.chainConfig.IsTheseus(header.Number, parent.Time) || snap.inturn(val) {
+		return false
+	}
+	proposer, ok := fallbackProposer(snap, header.ParentHash, header.Number.Uint64())
+	return ok && proposer == val
+}
+
+// isFallbackBlock reports whether the header was sealed by the fallback proposer
+// of its slot ahead of the regular backoff time, which is only allowed for
+// empty blocks.
+func (s *Satoshi) isFallbackBlock(snap *Snapshot, header *types.Header, parent *types.Header) bool {
+	if !s.isFallbackProposer(snap, header, parent, header.Coinbase) {
+		return false
+	}
+	return header.Time < parent.Time+s.config.Period+s.regularBackOffTime(snap, header, header.Coinbase)
+}
+
+// backOffTime returns the number of seconds a validator has to wait on top of
+// the period before sealing the given block. The fallback proposer of the slot
+// doesn't need to back off, it's held back locally by the empty block timeout.
+func (s *Satoshi) backOffTime(snap *Snapshot, header, parent *types.Header, val common.Address) uint64 {
+	if s.isFallbackProposer(snap, header, parent, val) {
+		return 0
+	}
+	return s.regularBackOffTime(snap, header, val)
+}
+
+// regularBackOffTime returns the shuffled backoff time of out-of-turn validators.
+func (s *Satoshi) regularBackOffTime(snap *Snapshot, header *types.Header, val common.Address) uint64 {
 	if snap.inturn(val) {
 		return 0
 	} else {
@@ -1533,6 +1778,75 @@ func (s *Satoshi) IsActiveValidatorAt(chain consensus.ChainHeaderReader, header
 	return false
 }
 
+// EffectiveValidatorSet returns the validators eligible to produce the given
+// header. The set is the one of the parent snapshot, minus the validators the
+// validator contract jailed. The snapshot switches to the set elected by an
+// epoch block only after len(validators)/2 further blocks; until then it holds
+// the previous set, whose jailing is read from the state before the epoch block.
+func (s *Satoshi) EffectiveValidatorSet(chain consensus.ChainHeaderReader, header *types.Header) ([]common.Address, error) {
+	number := header.Number.Uint64()
+	if number == 0 {
+		snap, err := s.snapshot(chain, 0, header.Hash(), nil)
+		if err != nil {
+			return nil, err
+		}
+		return snap.validators(), nil
+	}
+	snap, err := s.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return nil, err
+	}
+	unjailed, err := s.getCurrentValidators(header.ParentHash)
+	if err != nil {
+		return nil, err
+	}
+	epoch := (number - 1) / s.config.Epoch * s.config.Epoch
+	if epoch == 0 {
+		return snap.effectiveValidators(unjailed), nil
+	}
+	checkpoint := FindAncientHeader(header, number-epoch, chain, nil)
+	if checkpoint == nil {
+		return nil, consensus.ErrUnknownAncestor
+	}
+	elected, err := ParseValidators(checkpoint.Extra[extraVanity : len(checkpoint.Extra)-extraSeal])
+	if err != nil {
+		return nil, err
+	}
+	switched := len(elected) == len(snap.Validators)
+	for _, val := range elected {
+		if _, ok := snap.Validators[val]; !ok {
+			switched = false
+		}
+	}
+	if switched {
+		return snap.effectiveValidators(unjailed), nil
+	}
+	// The snapshot still holds the previous set. Validators the epoch rotated out
+	// aren't jailed, only the ones jailed before the epoch block or jailed since
+	// after being elected again are.
+	previous, err := s.getCurrentValidators(checkpoint.ParentHash)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[common.Address]struct{}, len(unjailed))
+	for _, val := range unjailed {
+		current[val] = struct{}{}
+	}
+	reelected := make(map[common.Address]struct{}, len(elected))
+	for _, val := range elected {
+		reelected[val] = struct{}{}
+	}
+	eligible := make([]common.Address, 0, len(previous))
+	for _, val := range previous {
+		_, isElected := reelected[val]
+		_, isCurrent := current[val]
+		if !isElected || isCurrent {
+			eligible = append(eligible, val)
+		}
+	}
+	return snap.effectiveValidators(eligible), nil
+}
+
 // chain context
 type chainContext struct {
 	Chain   consensus.ChainHeaderReader
@@ -1547,6 +1861,12 @@ func (c chainContext) GetHeader(hash common.Hash, number uint64) *types.Header {
 	return c.Chain.GetHeader(hash, number)
 }
 
+// RewardDistributor returns nil: system transactions carry no fee, so they
+// always use the default credit regardless of the chain they run on.
+func (c chainContext) RewardDistributor() core.RewardDistributor {
+	return nil
+}
+
 // callmsg implements core.Message to allow passing it as a transaction simulator.
 type callmsg struct {
 	ethereum.CallMsg
@@ -1,12 +1,33 @@
 package satoshi
 
 import (
+	"context"
+	"crypto/ecdsa"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"math/big"
 	mrand "math/rand"
+	"reflect"
+	"sort"
 	"testing"
+	"time"
 
+	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/systemcontracts"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
 )
 
 func TestImpactOfValidatorOutOfService(t *testing.T) {
@@ -144,3 +165,395 @@ func randomAddress() common.Address {
 	rand.Read(addrBytes)
 	return common.BytesToAddress(addrBytes)
 }
+
+// Tests that if the in-turn validator of a slot is offline, the deterministic
+// fallback proposer seals an empty block right after the empty block timeout,
+// ahead of the backoff other out-of-turn validators are subject to.
+func TestFallbackProposerSeal(t *testing.T) {
+	config := newTestSatoshiConfig()
+	config.Satoshi.EmptyBlockTimeout = 200
+	config.LondonBlock = new(big.Int)
+	config.TheseusTime = new(uint64)
+
+	keys := make(map[common.Address]*ecdsa.PrivateKey)
+	validators := make([]common.Address, 0, 5)
+	for i := 0; i < 5; i++ {
+		key, _ := crypto.GenerateKey()
+		addr := crypto.PubkeyToAddress(key.PublicKey)
+		keys[addr], validators = key, append(validators, addr)
+	}
+	extra := make([]byte, extraVanity)
+	extra = append(extra, sortedValidatorsBytes(append([]common.Address{}, validators...))...)
+	extra = append(extra, make([]byte, extraSeal)...)
+
+	// The in-turn validator of the first slot is offline
+	genesis := &types.Header{
+		UncleHash:  types.EmptyUncleHash,
+		Difficulty: new(big.Int).Set(diffInTurn),
+		Number:     new(big.Int),
+		GasLimit:   8_000_000,
+		Time:       uint64(time.Now().Unix()) - config.Satoshi.Period + 1,
+		Extra:      extra,
+		BaseFee:    new(big.Int),
+	}
+	snap := newSnapshot(config.Satoshi, nil, 0, genesis.Hash(), validators, nil)
+	inturn := snap.supposeValidator()
+	fallback, ok := fallbackProposer(snap, genesis.Hash(), 1)
+	if !ok || fallback == inturn {
+		t.Fatalf("invalid fallback proposer: %v, in-turn %v", fallback, inturn)
+	}
+	chain := &testChainReader{config: config, genesis: genesis}
+
+	engine := New(config, rawdb.NewMemoryDatabase(), nil, genesis.Hash())
+	engine.Authorize(fallback, func(account accounts.Account, mime string, data []byte) ([]byte, error) {
+		return crypto.Sign(crypto.Keccak256(data), keys[account.Address])
+	}, nil)
+
+	header := &types.Header{
+		ParentHash: genesis.Hash(),
+		UncleHash:  types.EmptyUncleHash,
+		Number:     big.NewInt(1),
+		GasLimit:   genesis.GasLimit,
+		BaseFee:    new(big.Int),
+	}
+	if err := engine.Prepare(chain, header); err != nil {
+		t.Fatalf("failed to prepare header: %v", err)
+	}
+	if want := genesis.Time + config.Satoshi.Period; header.Time != want {
+		t.Fatalf("fallback block time mismatch: have %d, want %d", header.Time, want)
+	}
+	if header.Difficulty.Cmp(diffNoTurn) != 0 {
+		t.Fatalf("fallback block difficulty mismatch: have %v, want %v", header.Difficulty, diffNoTurn)
+	}
+	// Fallback blocks carrying transactions must be refused
+	tx := types.NewTransaction(0, common.Address{0x01}, common.Big1, 21000, common.Big1, nil)
+	full := types.NewBlock(header, []*types.Transaction{tx}, nil, nil, trie.NewStackTrie(nil))
+	if err := engine.Seal(chain, full, make(chan *types.Block, 1), make(chan struct{})); !errors.Is(err, errFallbackBlockNotEmpty) {
+		t.Fatalf("non-empty fallback block error mismatch: have %v, want %v", err, errFallbackBlockNotEmpty)
+	}
+	txs := []*types.Transaction{tx}
+	if err := engine.Finalize(chain, header, nil, &txs, nil, nil, nil, nil, nil); !errors.Is(err, errFallbackBlockNotEmpty) {
+		t.Fatalf("non-empty fallback block finalize error mismatch: have %v, want %v", err, errFallbackBlockNotEmpty)
+	}
+	// The empty fallback block must be sealed once the timeout expired
+	var (
+		results  = make(chan *types.Block, 1)
+		deadline = time.Unix(int64(header.Time), 0).Add(time.Duration(config.Satoshi.EmptyBlockTimeout) * time.Millisecond)
+	)
+	if err := engine.Seal(chain, types.NewBlockWithHeader(header), results, make(chan struct{})); err != nil {
+		t.Fatalf("failed to seal fallback block: %v", err)
+	}
+	var sealed *types.Block
+	select {
+	case sealed = <-results:
+		if now := time.Now(); now.Before(deadline) {
+			t.Fatalf("fallback block sealed %v before the timeout", deadline.Sub(now))
+		}
+	case <-time.After(time.Until(deadline) + time.Second):
+		t.Fatalf("fallback block not sealed within the timeout")
+	}
+	if err := New(config, rawdb.NewMemoryDatabase(), nil, genesis.Hash()).VerifyHeader(chain, sealed.Header()); err != nil {
+		t.Fatalf("fallback block failed verification: %v", err)
+	}
+	// Any other out-of-turn validator must still back off in the same slot
+	var other common.Address
+	for _, val := range validators {
+		if val != fallback && val != inturn {
+			other = val
+			break
+		}
+	}
+	forged := types.CopyHeader(sealed.Header())
+	forged.Coinbase = other
+	sig, _ := crypto.Sign(SealHash(forged, config.ChainID).Bytes(), keys[other])
+	copy(forged.Extra[len(forged.Extra)-extraSeal:], sig)
+
+	if err := New(config, rawdb.NewMemoryDatabase(), nil, genesis.Hash()).VerifyHeader(chain, forged); !errors.Is(err, consensus.ErrFutureBlock) {
+		t.Fatalf("early out-of-turn block error mismatch: have %v, want %v", err, consensus.ErrFutureBlock)
+	}
+}
+
+// Tests that the fallback slot is only opened once the Theseus fork is active.
+func TestFallbackProposerBeforeTheseus(t *testing.T) {
+	config := newTestSatoshiConfig()
+	config.Satoshi.EmptyBlockTimeout = 200
+
+	validators := make([]common.Address, 0, 5)
+	for i := 0; i < 5; i++ {
+		validators = append(validators, randomAddress())
+	}
+	parent := &types.Header{Number: new(big.Int), Time: uint64(time.Now().Unix())}
+	header := &types.Header{ParentHash: parent.Hash(), Number: big.NewInt(1)}
+
+	snap := newSnapshot(config.Satoshi, nil, 0, parent.Hash(), validators, nil)
+	fallback, _ := fallbackProposer(snap, parent.Hash(), 1)
+
+	engine := New(config, rawdb.NewMemoryDatabase(), nil, parent.Hash())
+	if engine.isFallbackProposer(snap, header, parent, fallback) {
+		t.Fatalf("fallback proposer active before the fork")
+	}
+	if backoff := engine.backOffTime(snap, header, parent, fallback); backoff == 0 {
+		t.Fatalf("fallback proposer skipped the backoff before the fork")
+	}
+	config.LondonBlock = new(big.Int)
+	config.TheseusTime = new(uint64)
+	if !engine.isFallbackProposer(snap, header, parent, fallback) {
+		t.Fatalf("fallback proposer inactive after the fork")
+	}
+	if backoff := engine.backOffTime(snap, header, parent, fallback); backoff != 0 {
+		t.Fatalf("fallback proposer backoff mismatch: have %d, want 0", backoff)
+	}
+}
+
+// Tests that validators which recently signed, and couldn't seal the block, are
+// skipped over when picking the fallback proposer.
+func TestFallbackProposerSkipsRecents(t *testing.T) {
+	config := newTestSatoshiConfig()
+
+	newSnap := func(n int, number uint64, parentHash common.Hash) *Snapshot {
+		validators := make([]common.Address, 0, n)
+		for i := 0; i < n; i++ {
+			validators = append(validators, randomAddress())
+		}
+		return newSnapshot(config.Satoshi, nil, number-1, parentHash, validators, nil)
+	}
+	var (
+		number     = uint64(10)
+		parentHash = common.Hash{0x01}
+		snap       = newSnap(5, number, parentHash)
+	)
+	first, ok := fallbackProposer(snap, parentHash, number)
+	if !ok || snap.inturn(first) {
+		t.Fatalf("invalid fallback proposer %v", first)
+	}
+	// With 5 validators, the signers of the last 2 blocks are recent
+	snap.Recents = map[uint64]common.Address{number - 1: first}
+	second, ok := fallbackProposer(snap, parentHash, number)
+	if !ok || second == first || snap.inturn(second) {
+		t.Fatalf("invalid fallback proposer %v with %v recent", second, first)
+	}
+	snap.Recents = map[uint64]common.Address{number - 2: first, number - 1: second}
+	third, ok := fallbackProposer(snap, parentHash, number)
+	if !ok || third == first || third == second || snap.inturn(third) {
+		t.Fatalf("invalid fallback proposer %v with %v, %v recent", third, first, second)
+	}
+	// Signers shifted out of the recents window are eligible again
+	snap.Recents = map[uint64]common.Address{number - 3: first}
+	if proposer, _ := fallbackProposer(snap, parentHash, number); proposer != first {
+		t.Fatalf("fallback proposer mismatch: have %v, want %v", proposer, first)
+	}
+	// Without any eligible validator, there's no fallback proposer
+	snap = newSnap(2, number, parentHash)
+	other, _ := fallbackProposer(snap, parentHash, number)
+	snap.Recents = map[uint64]common.Address{number - 1: other}
+	if proposer, ok := fallbackProposer(snap, parentHash, number); ok {
+		t.Fatalf("unexpected fallback proposer %v", proposer)
+	}
+}
+
+// Tests that recent signers near genesis, where the recents window reaches back
+// past block zero, are still skipped over when picking the fallback proposer.
+func TestFallbackProposerSkipsRecentsNearGenesis(t *testing.T) {
+	config := newTestSatoshiConfig()
+
+	validators := make([]common.Address, 0, 5)
+	for i := 0; i < 5; i++ {
+		validators = append(validators, randomAddress())
+	}
+	// With 5 validators the recents window spans 3 blocks, more than the chain
+	var (
+		number     = uint64(2)
+		parentHash = common.Hash{0x01}
+		snap       = newSnapshot(config.Satoshi, nil, number-1, parentHash, validators, nil)
+	)
+	first, ok := fallbackProposer(snap, parentHash, number)
+	if !ok {
+		t.Fatal("no fallback proposer")
+	}
+	snap.Recents = map[uint64]common.Address{number - 1: first}
+	second, ok := fallbackProposer(snap, parentHash, number)
+	if !ok || second == first || snap.inturn(second) {
+		t.Fatalf("invalid fallback proposer %v with %v recent", second, first)
+	}
+}
+
+// validatorSetBackend is an ethapi backend executing calls on a state holding
+// a validator contract which returns a fixed validator set per block.
+type validatorSetBackend struct {
+	ethapi.Backend
+	config *params.ChainConfig
+	header *types.Header
+	codes  map[common.Hash][]byte
+}
+
+func (b *validatorSetBackend) RPCGasCap() uint64                         { return 50_000_000 }
+func (b *validatorSetBackend) RPCEVMTimeout() time.Duration              { return time.Second }
+func (b *validatorSetBackend) Engine() consensus.Engine                  { return ethash.NewFaker() }
+func (b *validatorSetBackend) ChainConfig() *params.ChainConfig          { return b.config }
+func (b *validatorSetBackend) RewardDistributor() core.RewardDistributor { return nil }
+
+func (b *validatorSetBackend) StateAndHeaderByNumberOrHash(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*state.StateDB, *types.Header, error) {
+	hash, _ := blockNrOrHash.Hash()
+	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedb.SetCode(common.HexToAddress(systemcontracts.ValidatorContract), b.codes[hash])
+	return statedb, b.header, nil
+}
+
+func (b *validatorSetBackend) GetEVM(ctx context.Context, msg *core.Message, state *state.StateDB, header *types.Header, vmConfig *vm.Config, blockCtx *vm.BlockContext) (*vm.EVM, func() error) {
+	return vm.NewEVM(*blockCtx, core.NewEVMTxContext(msg), state, b.config, *vmConfig), func() error { return nil }
+}
+
+// returnCode assembles contract code returning the given data on every call.
+func returnCode(data []byte) []byte {
+	var code []byte
+	for i := 0; i < len(data); i += 32 {
+		code = append(code, byte(vm.PUSH32))
+		code = append(code, common.RightPadBytes(data[i:], 32)[:32]...)
+		code = append(code, byte(vm.PUSH2), byte(i>>8), byte(i), byte(vm.MSTORE))
+	}
+	return append(code, byte(vm.PUSH2), byte(len(data)>>8), byte(len(data)), byte(vm.PUSH1), 0, byte(vm.RETURN))
+}
+
+// Tests that a header following the jailing of a validator is produced by the
+// snapshot set without the jailed validator.
+func TestEffectiveValidatorSetAfterJail(t *testing.T) {
+	var (
+		config     = newTestSatoshiConfig()
+		validators = []common.Address{randomAddress(), randomAddress(), randomAddress()}
+		extra      = make([]byte, extraVanity)
+	)
+	for _, val := range validators {
+		extra = append(extra, val.Bytes()...)
+	}
+	genesis := &types.Header{
+		Number:     big.NewInt(0),
+		Difficulty: big.NewInt(1),
+		GasLimit:   8_000_000,
+		Extra:      append(extra, make([]byte, extraSeal)...),
+	}
+	header := &types.Header{Number: big.NewInt(1), ParentHash: genesis.Hash()}
+	chain := &testChainReader{config: config, genesis: genesis}
+
+	effective := func(unjailed []common.Address) []common.Address {
+		backend := &validatorSetBackend{config: config, header: genesis}
+		engine := New(config, rawdb.NewMemoryDatabase(), ethapi.NewBlockChainAPI(backend), genesis.Hash())
+
+		ret, err := engine.validatorSetABI.Methods["getValidators"].Outputs.Pack(unjailed)
+		if err != nil {
+			t.Fatalf("failed to pack validators: %v", err)
+		}
+		backend.codes = map[common.Hash][]byte{genesis.Hash(): returnCode(ret)}
+
+		set, err := engine.EffectiveValidatorSet(chain, header)
+		if err != nil {
+			t.Fatalf("failed to retrieve effective validators: %v", err)
+		}
+		return set
+	}
+	snap := newSnapshot(config.Satoshi, nil, 0, genesis.Hash(), validators, nil)
+	all := snap.validators()
+
+	if have := effective(all); !reflect.DeepEqual(have, all) {
+		t.Fatalf("validator set before jailing mismatch: have %v, want %v", have, all)
+	}
+	jailed := all[1]
+	want := []common.Address{all[0], all[2]}
+	have := effective(want)
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("validator set after jailing mismatch: have %v, want %v", have, want)
+	}
+	for _, val := range have {
+		if val == jailed {
+			t.Fatalf("jailed validator %x still eligible", jailed)
+		}
+	}
+}
+
+// headerChainReader is a chain reader serving a fixed set of headers.
+type headerChainReader struct {
+	testChainReader
+	headers map[common.Hash]*types.Header
+}
+
+func (c *headerChainReader) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header, ok := c.headers[hash]; ok && header.Number.Uint64() == number {
+		return header
+	}
+	return c.testChainReader.GetHeader(hash, number)
+}
+
+// Tests that the headers following an epoch block, which are still produced by
+// the previous validator set, don't report the validators rotated out by the
+// epoch as jailed.
+func TestEffectiveValidatorSetSwitchWindow(t *testing.T) {
+	config := newTestSatoshiConfig()
+
+	validators := make([]common.Address, 4)
+	for i := range validators {
+		validators[i] = randomAddress()
+	}
+	sort.Sort(validatorsAscending(validators))
+	var (
+		previous = validators[:3] // Set producing until epoch+len(previous)/2
+		elected  = validators[1:] // Set elected by the epoch block
+		rotated  = validators[0]
+	)
+	newHeader := func(number uint64, parent common.Hash, vals []common.Address) *types.Header {
+		extra := make([]byte, extraVanity)
+		for _, val := range vals {
+			extra = append(extra, val.Bytes()...)
+		}
+		return &types.Header{
+			ParentHash: parent,
+			Number:     new(big.Int).SetUint64(number),
+			Difficulty: big.NewInt(1),
+			GasLimit:   8_000_000,
+			Extra:      append(extra, make([]byte, extraSeal)...),
+		}
+	}
+	var (
+		number     = config.Satoshi.Epoch
+		preEpoch   = newHeader(number-1, common.Hash{0x01}, nil)
+		epochBlock = newHeader(number, preEpoch.Hash(), elected)
+		header     = newHeader(number+1, epochBlock.Hash(), nil)
+		chain      = &headerChainReader{
+			testChainReader: testChainReader{config: config, genesis: newHeader(0, common.Hash{}, previous)},
+			headers:         map[common.Hash]*types.Header{preEpoch.Hash(): preEpoch, epochBlock.Hash(): epochBlock},
+		}
+	)
+	effective := func(unjailed []common.Address) []common.Address {
+		backend := &validatorSetBackend{config: config, header: epochBlock}
+		engine := New(config, rawdb.NewMemoryDatabase(), ethapi.NewBlockChainAPI(backend), chain.genesis.Hash())
+
+		// The parent snapshot still holds the previous set
+		snap := newSnapshot(config.Satoshi, nil, epochBlock.Number.Uint64(), epochBlock.Hash(), previous, nil)
+		engine.recentSnaps.Add(snap.Hash, snap)
+
+		pack := func(vals []common.Address) []byte {
+			ret, err := engine.validatorSetABI.Methods["getValidators"].Outputs.Pack(vals)
+			if err != nil {
+				t.Fatalf("failed to pack validators: %v", err)
+			}
+			return returnCode(ret)
+		}
+		backend.codes = map[common.Hash][]byte{
+			preEpoch.Hash():   pack(previous),
+			epochBlock.Hash(): pack(unjailed),
+		}
+		set, err := engine.EffectiveValidatorSet(chain, header)
+		if err != nil {
+			t.Fatalf("failed to retrieve effective validators: %v", err)
+		}
+		return set
+	}
+	// The rotated out validator keeps producing until the snapshot switches
+	if have := effective(elected); !reflect.DeepEqual(have, previous) {
+		t.Fatalf("validator set inside the switch window mismatch: have %v, want %v", have, previous)
+	}
+	// Validators jailed after the epoch block are excluded, the rotated out one isn't
+	jailed := elected[1]
+	want := []common.Address{rotated, elected[0]}
+	if have := effective([]common.Address{elected[0], elected[2]}); !reflect.DeepEqual(have, want) {
+		t.Fatalf("validator set after jailing %v mismatch: have %v, want %v", jailed, have, want)
+	}
+}
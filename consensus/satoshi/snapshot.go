@@ -27,6 +27,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/internal/ethapi"
 	"github.com/ethereum/go-ethereum/params"
@@ -237,6 +238,22 @@ func (s *Snapshot) validators() []common.Address {
 	return validators
 }
 
+// effectiveValidators returns the snapshot validators, in ascending order, that
+// are still part of the given unjailed set.
+func (s *Snapshot) effectiveValidators(unjailed []common.Address) []common.Address {
+	active := make(map[common.Address]struct{}, len(unjailed))
+	for _, v := range unjailed {
+		active[v] = struct{}{}
+	}
+	validators := make([]common.Address, 0, len(s.Validators))
+	for _, v := range s.validators() {
+		if _, ok := active[v]; ok {
+			validators = append(validators, v)
+		}
+	}
+	return validators
+}
+
 // inturn returns if a validator at a given block height is in-turn or not.
 func (s *Snapshot) inturn(validator common.Address) bool {
 	validators := s.validators()
@@ -280,6 +297,61 @@ func (s *Snapshot) supposeValidator() common.Address {
 	return validators[index]
 }
 
+// signedRecently returns whether the validator is amongst the recent signers.
+func (s *Snapshot) signedRecently(validator common.Address) bool {
+	for _, recent := range s.Recents {
+		if recent == validator {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedValidatorsBytes sorts the validators by address in place and returns
+// them concatenated, as stored in the extra-data of epoch headers. The order of
+// the input, e.g. as returned by the validator contract, doesn't matter.
+func sortedValidatorsBytes(validators []common.Address) []byte {
+	sort.Sort(validatorsAscending(validators))
+
+	blob := make([]byte, len(validators)*validatorBytesLength)
+	for i, validator := range validators {
+		copy(blob[i*validatorBytesLength:], validator.Bytes())
+	}
+	return blob
+}
+
+// EpochCheckpoint returns the checksum of a validator set as carried by epoch
+// blocks, i.e. the keccak256 hash of the ascending sorted validator addresses.
+// Two nodes agreeing on the checkpoint of an epoch agree on its validator set.
+func EpochCheckpoint(validators []common.Address) common.Hash {
+	sorted := make([]common.Address, len(validators))
+	copy(sorted, validators)
+	return crypto.Keccak256Hash(sortedValidatorsBytes(sorted))
+}
+
+// DuplicateValidatorError is returned if a validator set contains the same
+// validator address more than once.
+type DuplicateValidatorError struct {
+	Validator common.Address
+}
+
+func (e *DuplicateValidatorError) Error() string {
+	return "duplicate validator in validator set: " + e.Validator.String()
+}
+
+// checkDuplicateValidators returns a DuplicateValidatorError for the first
+// validator found more than once in the set.
+func checkDuplicateValidators(validators []common.Address) error {
+	seen := make(map[common.Address]struct{}, len(validators))
+	for _, validator := range validators {
+		if _, ok := seen[validator]; ok {
+			return &DuplicateValidatorError{Validator: validator}
+		}
+		seen[validator] = struct{}{}
+	}
+	return nil
+}
+
 func ParseValidators(validatorsBytes []byte) ([]common.Address, error) {
 	if len(validatorsBytes)%validatorBytesLength != 0 {
 		return nil, errors.New("invalid validators bytes")
@@ -291,6 +363,9 @@ func ParseValidators(validatorsBytes []byte) ([]common.Address, error) {
 		copy(address, validatorsBytes[i*validatorBytesLength:(i+1)*validatorBytesLength])
 		result[i] = common.BytesToAddress(address)
 	}
+	if err := checkDuplicateValidators(result); err != nil {
+		return nil, err
+	}
 	return result, nil
 }
 
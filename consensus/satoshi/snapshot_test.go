@@ -2,12 +2,15 @@ package satoshi
 
 import (
 	"bytes"
+	"math/rand"
 	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
 )
 
 func TestValidatorSetSort(t *testing.T) {
@@ -21,3 +24,95 @@ func TestValidatorSetSort(t *testing.T) {
 		assert.True(t, bytes.Compare(validators[i][:], validators[i+1][:]) < 0)
 	}
 }
+
+func TestSnapshotValidatorOrder(t *testing.T) {
+	size := 21
+	validators := make([]common.Address, size)
+	for i := 0; i < size; i++ {
+		validators[i] = randomAddress()
+	}
+	reversed := make([]common.Address, size)
+	for i, v := range validators {
+		reversed[size-1-i] = v
+	}
+	shuffled := make([]common.Address, size)
+	copy(shuffled, validators)
+	rand.Shuffle(size, func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	// Snapshots built from differently ordered sets must behave identically
+	base := newSnapshot(&params.SatoshiConfig{Period: 3, Epoch: 200}, nil, 0, common.Hash{}, validators, nil)
+	for _, order := range [][]common.Address{reversed, shuffled} {
+		snap := newSnapshot(&params.SatoshiConfig{Period: 3, Epoch: 200}, nil, 0, common.Hash{}, order, nil)
+		assert.Equal(t, base.validators(), snap.validators())
+		for number := uint64(0); number < uint64(2*size); number++ {
+			base.Number, snap.Number = number, number
+			assert.Equal(t, base.supposeValidator(), snap.supposeValidator())
+			for _, v := range validators {
+				assert.Equal(t, base.inturn(v), snap.inturn(v))
+				assert.Equal(t, base.indexOfVal(v), snap.indexOfVal(v))
+			}
+		}
+	}
+	// The epoch validator bytes must not depend on the contract's ordering
+	want := sortedValidatorsBytes(append([]common.Address{}, validators...))
+	assert.Equal(t, want, sortedValidatorsBytes(reversed))
+	assert.Equal(t, want, sortedValidatorsBytes(shuffled))
+
+	parsed, err := ParseValidators(want)
+	assert.NoError(t, err)
+	assert.Equal(t, base.validators(), parsed)
+}
+
+func TestEpochCheckpoint(t *testing.T) {
+	size := 21
+	validators := make([]common.Address, size)
+	for i := 0; i < size; i++ {
+		validators[i] = randomAddress()
+	}
+	shuffled := make([]common.Address, size)
+	copy(shuffled, validators)
+	rand.Shuffle(size, func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	order := append([]common.Address{}, shuffled...)
+
+	// The checkpoint must not depend on the ordering, nor reorder its input
+	want := EpochCheckpoint(validators)
+	assert.Equal(t, want, EpochCheckpoint(shuffled))
+	assert.Equal(t, order, shuffled)
+
+	// It must match the hash of the validator bytes carried by epoch blocks
+	assert.Equal(t, crypto.Keccak256Hash(sortedValidatorsBytes(shuffled)), want)
+
+	// Any change to the set must change the checkpoint
+	assert.NotEqual(t, want, EpochCheckpoint(validators[1:]))
+	changed := append([]common.Address{}, validators...)
+	changed[0] = randomAddress()
+	assert.NotEqual(t, want, EpochCheckpoint(changed))
+}
+
+func TestSnapshotSignedRecently(t *testing.T) {
+	validators := []common.Address{randomAddress(), randomAddress(), randomAddress()}
+	snap := newSnapshot(&params.SatoshiConfig{Period: 3, Epoch: 200}, nil, 10, common.Hash{}, validators, nil)
+	snap.Recents[9] = validators[0]
+	snap.Recents[10] = validators[1]
+
+	assert.True(t, snap.signedRecently(validators[0]))
+	assert.True(t, snap.signedRecently(validators[1]))
+	assert.False(t, snap.signedRecently(validators[2]))
+}
+
+func TestSnapshotEffectiveValidators(t *testing.T) {
+	validators := []common.Address{randomAddress(), randomAddress(), randomAddress()}
+	snap := newSnapshot(&params.SatoshiConfig{Period: 3, Epoch: 200}, nil, 10, common.Hash{}, validators, nil)
+	all := snap.validators()
+
+	// Nobody jailed, the whole snapshot set is eligible
+	assert.Equal(t, all, snap.effectiveValidators(validators))
+
+	// A validator jailed mid-epoch drops out, order is kept
+	jailed := all[1]
+	assert.Equal(t, []common.Address{all[0], all[2]}, snap.effectiveValidators([]common.Address{all[2], all[0]}))
+	assert.NotContains(t, snap.effectiveValidators([]common.Address{all[0], all[2]}), jailed)
+
+	// Validators joining the contract set mid-epoch wait for the next epoch
+	assert.Equal(t, all, snap.effectiveValidators(append(all, randomAddress())))
+}
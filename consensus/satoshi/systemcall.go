@@ -0,0 +1,57 @@
+package satoshi
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+const inMemorySystemCallStats = 128 // Number of recent blocks to keep the system call timings of
+
+var (
+	systemCallValidatorSetTimer = metrics.NewRegisteredTimer("satoshi/systemcall/validatorset", nil)
+	systemCallRewardsTimer      = metrics.NewRegisteredTimer("satoshi/systemcall/rewards", nil)
+	systemCallSlashTimer        = metrics.NewRegisteredTimer("satoshi/systemcall/slash", nil)
+	systemCallOtherTimer        = metrics.NewRegisteredTimer("satoshi/systemcall/other", nil)
+	systemCallTotalTimer        = metrics.NewRegisteredTimer("satoshi/systemcall/total", nil)
+)
+
+// SystemCallStats implements consensus.SystemCallReporter, returning the time
+// spent in system contract calls while importing a recent block.
+func (p *Satoshi) SystemCallStats(hash common.Hash) (consensus.SystemCallStats, bool) {
+	if stats, ok := p.systemCalls.Get(hash); ok {
+		return stats.(consensus.SystemCallStats), true
+	}
+	return consensus.SystemCallStats{}, false
+}
+
+// recordSystemCalls stores the system call timings of a block being imported.
+// The calls made before the transactions start a fresh entry, the ones made
+// when finalizing are added to it and update the metrics with the totals of the
+// whole block. An entry is stored even if no system calls were made, so such
+// blocks report zero timings instead of being unknown.
+func (p *Satoshi) recordSystemCalls(header *types.Header, stats consensus.SystemCallStats, final bool) {
+	hash := header.Hash()
+
+	p.systemCallLock.Lock()
+	defer p.systemCallLock.Unlock()
+
+	if prev, ok := p.systemCalls.Get(hash); ok && final {
+		prev := prev.(consensus.SystemCallStats)
+		stats.ValidatorSet += prev.ValidatorSet
+		stats.Rewards += prev.Rewards
+		stats.Slash += prev.Slash
+		stats.Other += prev.Other
+	}
+	stats.Number, stats.Hash = header.Number.Uint64(), hash
+	p.systemCalls.Add(hash, stats)
+
+	if final {
+		systemCallValidatorSetTimer.Update(stats.ValidatorSet)
+		systemCallRewardsTimer.Update(stats.Rewards)
+		systemCallSlashTimer.Update(stats.Slash)
+		systemCallOtherTimer.Update(stats.Other)
+		systemCallTotalTimer.Update(stats.Total())
+	}
+}
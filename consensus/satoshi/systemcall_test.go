@@ -0,0 +1,119 @@
+package satoshi
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Tests that the system call timings of a block are accumulated over the calls
+// made before and after its transactions, and that blocks without system calls
+// report zero timings instead of being unknown.
+func TestSystemCallStats(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	config := newTestSatoshiConfig()
+	genesis, headers := makeSignedHeaders(config, key, 3)
+	p := newTestSatoshi(config, genesis)
+
+	// The engine implements the optional reporter interface
+	var _ consensus.SystemCallReporter = p
+
+	if _, ok := p.SystemCallStats(headers[0].Hash()); ok {
+		t.Fatal("unknown block reported system call stats")
+	}
+	// Block with a round turn before the transactions and fee distribution after
+	p.recordSystemCalls(headers[0], consensus.SystemCallStats{ValidatorSet: time.Millisecond}, false)
+	p.recordSystemCalls(headers[0], consensus.SystemCallStats{ValidatorSet: time.Millisecond, Rewards: 2 * time.Millisecond}, true)
+
+	stats, ok := p.SystemCallStats(headers[0].Hash())
+	if !ok {
+		t.Fatal("missing system call stats")
+	}
+	want := consensus.SystemCallStats{
+		Number:       1,
+		Hash:         headers[0].Hash(),
+		ValidatorSet: 2 * time.Millisecond,
+		Rewards:      2 * time.Millisecond,
+	}
+	if stats != want {
+		t.Fatalf("stats mismatch: have %+v, want %+v", stats, want)
+	}
+	if total := stats.Total(); total != 4*time.Millisecond {
+		t.Fatalf("total mismatch: have %v, want %v", total, 4*time.Millisecond)
+	}
+	// Reprocessing the block starts over instead of adding to the old timings
+	p.recordSystemCalls(headers[0], consensus.SystemCallStats{}, false)
+	p.recordSystemCalls(headers[0], consensus.SystemCallStats{Slash: time.Millisecond}, true)
+	if stats, _ := p.SystemCallStats(headers[0].Hash()); stats.ValidatorSet != 0 || stats.Rewards != 0 || stats.Slash != time.Millisecond {
+		t.Fatalf("stats not reset on reprocessing: %+v", stats)
+	}
+	// Block without any system calls
+	p.recordSystemCalls(headers[1], consensus.SystemCallStats{}, false)
+	p.recordSystemCalls(headers[1], consensus.SystemCallStats{}, true)
+
+	stats, ok = p.SystemCallStats(headers[1].Hash())
+	if !ok {
+		t.Fatal("block without system calls not reported")
+	}
+	if stats.Total() != 0 || stats.Number != 2 || stats.Hash != headers[1].Hash() {
+		t.Fatalf("unexpected stats for block without system calls: %+v", stats)
+	}
+}
+
+// Tests that the system call timings the engine records while importing a block
+// are reported by the blockchain for its head.
+func TestSystemCallStatsImport(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	val := crypto.PubkeyToAddress(key.PublicKey)
+
+	config := newTestSatoshiConfig()
+	gspec := &core.Genesis{
+		Config:    config,
+		GasLimit:  8_000_000,
+		ExtraData: append(append(make([]byte, extraVanity), val.Bytes()...), make([]byte, extraSeal)...),
+	}
+	engine := New(config, rawdb.NewMemoryDatabase(), nil, gspec.ToBlock().Hash())
+	engine.Authorize(val, nil, func(account accounts.Account, tx *types.Transaction, _ *big.Int) (*types.Transaction, error) {
+		return types.SignTx(tx, types.LatestSigner(config), key)
+	})
+	_, blocks, _ := core.GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *core.BlockGen) {
+		b.SetCoinbase(val)
+		b.SetExtra(make([]byte, extraVanity+extraSeal))
+	})
+	header := blocks[0].Header()
+	sig, _ := crypto.Sign(SealHash(header, config.ChainID).Bytes(), key)
+	copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+	block := blocks[0].WithSeal(header)
+
+	chain, err := core.NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if stats := chain.SystemCallStats(); stats.Number != 0 || stats.Total() != 0 {
+		t.Fatalf("unexpected genesis stats: %+v", stats)
+	}
+	if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	stats := chain.SystemCallStats()
+	if stats.Number != 1 || stats.Hash != block.Hash() {
+		t.Fatalf("stats of wrong block: have #%d [%x], want #1 [%x]", stats.Number, stats.Hash, block.Hash())
+	}
+	// The first block initialises the system contracts and distributes the fees
+	if stats.Other == 0 {
+		t.Fatal("contract initialisation not timed")
+	}
+	if stats.Rewards == 0 {
+		t.Fatal("fee distribution not timed")
+	}
+}
@@ -0,0 +1,281 @@
+package satoshi
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// testChainReader is a consensus.ChainHeaderReader only knowing the genesis
+// header, every other header is passed in as a parent during verification.
+type testChainReader struct {
+	config  *params.ChainConfig
+	genesis *types.Header
+}
+
+func (c *testChainReader) Config() *params.ChainConfig  { return c.config }
+func (c *testChainReader) CurrentHeader() *types.Header { return c.genesis }
+func (c *testChainReader) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if number == 0 && hash == c.genesis.Hash() {
+		return c.genesis
+	}
+	return nil
+}
+func (c *testChainReader) GetHeaderByNumber(number uint64) *types.Header {
+	if number == 0 {
+		return c.genesis
+	}
+	return nil
+}
+func (c *testChainReader) GetHeaderByHash(hash common.Hash) *types.Header {
+	return c.GetHeader(hash, 0)
+}
+func (c *testChainReader) GetTd(hash common.Hash, number uint64) *big.Int { return nil }
+func (c *testChainReader) GetHighestVerifiedHeader() *types.Header        { return nil }
+
+// makeSignedHeaders creates a chain of n headers sealed by a single validator.
+func makeSignedHeaders(config *params.ChainConfig, key *ecdsa.PrivateKey, n int) (*types.Header, []*types.Header) {
+	val := crypto.PubkeyToAddress(key.PublicKey)
+	newHeader := func(number uint64, parent *types.Header) *types.Header {
+		extra := make([]byte, extraVanity)
+		if number%config.Satoshi.Epoch == 0 {
+			extra = append(extra, val.Bytes()...)
+		}
+		extra = append(extra, make([]byte, extraSeal)...)
+
+		header := &types.Header{
+			UncleHash:  types.EmptyUncleHash,
+			Coinbase:   val,
+			Difficulty: new(big.Int).Set(diffInTurn),
+			Number:     new(big.Int).SetUint64(number),
+			GasLimit:   8_000_000,
+			Time:       uint64(time.Now().Unix()) - config.Satoshi.Period*uint64(n+1),
+			Extra:      extra,
+		}
+		if parent != nil {
+			header.ParentHash = parent.Hash()
+			header.Time = parent.Time + config.Satoshi.Period
+		}
+		sig, _ := crypto.Sign(SealHash(header, config.ChainID).Bytes(), key)
+		copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+		return header
+	}
+	genesis := newHeader(0, nil)
+	headers := make([]*types.Header, n)
+	parent := genesis
+	for i := 0; i < n; i++ {
+		headers[i] = newHeader(uint64(i+1), parent)
+		parent = headers[i]
+	}
+	return genesis, headers
+}
+
+func newTestSatoshi(config *params.ChainConfig, genesis *types.Header) *Satoshi {
+	p := New(config, rawdb.NewMemoryDatabase(), nil, genesis.Hash())
+	p.fakeDiff = true
+	return p
+}
+
+func collectResults(abort chan<- struct{}, results <-chan error, n int) []error {
+	defer close(abort)
+
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		errs[i] = <-results
+	}
+	return errs
+}
+
+func newTestSatoshiConfig() *params.ChainConfig {
+	return &params.ChainConfig{
+		ChainID: big.NewInt(1),
+		Satoshi: &params.SatoshiConfig{Period: 3, Epoch: 200, Round: 86400},
+	}
+}
+
+func TestVerifyHeadersParallel(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	config := newTestSatoshiConfig()
+	genesis, headers := makeSignedHeaders(config, key, 500)
+	chain := &testChainReader{config: config, genesis: genesis}
+
+	// Valid headers must all pass, in order
+	abort, results := newTestSatoshi(config, genesis).VerifyHeadersParallel(chain, headers, 4)
+	for i, err := range collectResults(abort, results, len(headers)) {
+		if err != nil {
+			t.Fatalf("header %d: verification failed: %v", i, err)
+		}
+	}
+	// A corrupted seal must be reported at the exact position, matching the
+	// sequential verifier
+	headers[300].Extra[len(headers[300].Extra)-2] ^= 0xff
+
+	abort, results = newTestSatoshi(config, genesis).VerifyHeaders(chain, headers)
+	want := collectResults(abort, results, len(headers))
+	abort, results = newTestSatoshi(config, genesis).VerifyHeadersParallel(chain, headers, 4)
+	have := collectResults(abort, results, len(headers))
+
+	for i := range headers {
+		if (want[i] == nil) != (have[i] == nil) {
+			t.Fatalf("header %d: result mismatch: have %v, want %v", i, have[i], want[i])
+		}
+	}
+	if have[299] != nil || have[300] == nil {
+		t.Fatalf("corrupted header not detected in place: 299 %v, 300 %v", have[299], have[300])
+	}
+}
+
+func BenchmarkVerifyHeaders(b *testing.B)          { benchmarkVerifyHeaders(b, 1) }
+func BenchmarkVerifyHeadersParallel4(b *testing.B) { benchmarkVerifyHeaders(b, 4) }
+
+func benchmarkVerifyHeaders(b *testing.B, workers int) {
+	key, _ := crypto.GenerateKey()
+	config := newTestSatoshiConfig()
+	genesis, headers := makeSignedHeaders(config, key, 2000)
+	chain := &testChainReader{config: config, genesis: genesis}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		engine := newTestSatoshi(config, genesis)
+		b.StartTimer()
+
+		abort, results := engine.VerifyHeadersParallel(chain, headers, workers)
+		for j, err := range collectResults(abort, results, len(headers)) {
+			if err != nil {
+				b.Fatalf("header %d: verification failed: %v", j, err)
+			}
+		}
+	}
+}
+
+func TestVerifyHeaderDuplicateValidators(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	val := crypto.PubkeyToAddress(key.PublicKey)
+	config := newTestSatoshiConfig()
+	genesis, headers := makeSignedHeaders(config, key, 200)
+	chain := &testChainReader{config: config, genesis: genesis}
+
+	// A clean epoch validator list must pass
+	abort, results := newTestSatoshi(config, genesis).VerifyHeaders(chain, headers)
+	for i, err := range collectResults(abort, results, len(headers)) {
+		if err != nil {
+			t.Fatalf("header %d: verification failed: %v", i, err)
+		}
+	}
+	// Duplicate the validator in the epoch header and reseal it
+	epoch := headers[len(headers)-1]
+	extra := append([]byte{}, epoch.Extra[:extraVanity]...)
+	extra = append(extra, val.Bytes()...)
+	extra = append(extra, val.Bytes()...)
+	epoch.Extra = append(extra, make([]byte, extraSeal)...)
+
+	sig, _ := crypto.Sign(SealHash(epoch, config.ChainID).Bytes(), key)
+	copy(epoch.Extra[len(epoch.Extra)-extraSeal:], sig)
+
+	abort, results = newTestSatoshi(config, genesis).VerifyHeaders(chain, headers)
+	errs := collectResults(abort, results, len(headers))
+
+	var dupErr *DuplicateValidatorError
+	if !errors.As(errs[len(errs)-1], &dupErr) {
+		t.Fatalf("epoch header error mismatch: have %v, want %T", errs[len(errs)-1], dupErr)
+	}
+	if dupErr.Validator != val {
+		t.Fatalf("duplicate validator mismatch: have %v, want %v", dupErr.Validator, val)
+	}
+}
+
+func TestVerifyHeaderEpochGasLimitJump(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	config := newTestSatoshiConfig()
+	genesis, headers := makeSignedHeaders(config, key, 200)
+	chain := &testChainReader{config: config, genesis: genesis}
+
+	// Raise the gas limit of the epoch header past the allowed bound and reseal
+	epoch := headers[len(headers)-1]
+	epoch.GasLimit += epoch.GasLimit / params.GasLimitBoundDivisor
+
+	sig, _ := crypto.Sign(SealHash(epoch, config.ChainID).Bytes(), key)
+	copy(epoch.Extra[len(epoch.Extra)-extraSeal:], sig)
+
+	abort, results := newTestSatoshi(config, genesis).VerifyHeaders(chain, headers)
+	errs := collectResults(abort, results, len(headers))
+	for i, err := range errs[:len(errs)-1] {
+		if err != nil {
+			t.Fatalf("header %d: verification failed: %v", i, err)
+		}
+	}
+	if !errors.Is(errs[len(errs)-1], consensus.ErrInvalidGasLimit) {
+		t.Fatalf("epoch header error mismatch: have %v, want %v", errs[len(errs)-1], consensus.ErrInvalidGasLimit)
+	}
+}
+
+func TestVerifyHeadersUnauthorizedSigner(t *testing.T) {
+	oldKey, _ := crypto.GenerateKey()
+	newKey, _ := crypto.GenerateKey()
+	newVal := crypto.PubkeyToAddress(newKey.PublicKey)
+	config := newTestSatoshiConfig()
+	genesis, headers := makeSignedHeaders(config, oldKey, 205)
+	chain := &testChainReader{config: config, genesis: genesis}
+
+	reseal := func(header *types.Header, key *ecdsa.PrivateKey) {
+		header.Coinbase = crypto.PubkeyToAddress(key.PublicKey)
+		sig, _ := crypto.Sign(SealHash(header, config.ChainID).Bytes(), key)
+		copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+	}
+	// Hand over to a new validator at the epoch block, which takes effect right
+	// away with a single validator
+	epoch := headers[199]
+	extra := append([]byte{}, epoch.Extra[:extraVanity]...)
+	extra = append(extra, newVal.Bytes()...)
+	epoch.Extra = append(extra, make([]byte, extraSeal)...)
+	reseal(epoch, oldKey)
+	for i := 200; i < len(headers); i++ {
+		headers[i].ParentHash = headers[i-1].Hash()
+		reseal(headers[i], newKey)
+	}
+	abort, results := newTestSatoshi(config, genesis).VerifyHeaders(chain, headers)
+	for i, err := range collectResults(abort, results, len(headers)) {
+		if err != nil {
+			t.Fatalf("header %d: verification failed: %v", i, err)
+		}
+	}
+	// Forge a header after the epoch block with the seal of the old validator
+	reseal(headers[202], oldKey)
+	for i := 203; i < len(headers); i++ {
+		headers[i].ParentHash = headers[i-1].Hash()
+		reseal(headers[i], newKey)
+	}
+	abort, results = newTestSatoshi(config, genesis).VerifyHeaders(chain, headers)
+	errs := collectResults(abort, results, len(headers))
+	for i, err := range errs[:202] {
+		if err != nil {
+			t.Fatalf("header %d: verification failed: %v", i, err)
+		}
+	}
+	if want := errUnauthorizedValidator(crypto.PubkeyToAddress(oldKey.PublicKey).String()); errs[202] == nil || errs[202].Error() != want.Error() {
+		t.Fatalf("forged header error mismatch: have %v, want %v", errs[202], want)
+	}
+	for i := 203; i < len(errs); i++ {
+		if errs[i] == nil {
+			t.Fatalf("header %d: accepted after a forged header", i)
+		}
+	}
+	// The parallel verifier must reject the batch the exact same way
+	abort, results = newTestSatoshi(config, genesis).VerifyHeadersParallel(chain, headers, 4)
+	for i, err := range collectResults(abort, results, len(headers)) {
+		if fmt.Sprint(err) != fmt.Sprint(errs[i]) {
+			t.Fatalf("header %d: parallel result mismatch: have %v, want %v", i, err, errs[i])
+		}
+	}
+}
@@ -17,8 +17,10 @@
 package core
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -117,8 +119,14 @@ func (v *BlockValidator) ValidateBody(block *types.Block) error {
 				// happens in the state_transition check.
 			}
 			if header.BlobGasUsed != nil {
-				if want := *header.BlobGasUsed / params.BlobTxBlobGasPerBlob; uint64(blobs) != want { // div because the header is surely good vs the body might be bloated
-					return fmt.Errorf("blob gas used mismatch (header %v, calculated %v)", *header.BlobGasUsed, blobs*params.BlobTxBlobGasPerBlob)
+				// The header's excess blob gas is checked against the parent during
+				// header verification, here it only needs to be present.
+				if blobs > 0 && header.ExcessBlobGas == nil {
+					return fmt.Errorf("%w: blobs present without excess blob gas in header", ErrBlobGasMismatch)
+				}
+				want := *header.BlobGasUsed / params.BlobTxBlobGasPerBlob // div because the header is surely good vs the body might be bloated
+				if uint64(blobs) != want || *header.BlobGasUsed%params.BlobTxBlobGasPerBlob != 0 {
+					return fmt.Errorf("%w: header %v, calculated %v", ErrBlobGasMismatch, *header.BlobGasUsed, blobs*params.BlobTxBlobGasPerBlob)
 				}
 			} else {
 				if blobs > 0 {
@@ -142,6 +150,12 @@ func (v *BlockValidator) ValidateBody(block *types.Block) error {
 			}
 			return nil
 		},
+		func() error {
+			if v.bc.strictNonceOrder {
+				return validateNonceOrder(types.MakeSigner(v.config, header.Number, header.Time), block.Transactions())
+			}
+			return nil
+		},
 	}
 	validateRes := make(chan error, len(validateFuns))
 	for _, f := range validateFuns {
@@ -159,6 +173,40 @@ func (v *BlockValidator) ValidateBody(block *types.Block) error {
 	return nil
 }
 
+// validateNonceOrder checks that the transactions of every sender are included
+// in strictly increasing nonce order.
+func validateNonceOrder(signer types.Signer, txs types.Transactions) error {
+	last := make(map[common.Address]uint64)
+	for _, tx := range txs {
+		from, err := types.Sender(signer, tx)
+		if err != nil {
+			return fmt.Errorf("invalid transaction %v: %w", tx.Hash(), err)
+		}
+		if prev, ok := last[from]; ok && tx.Nonce() <= prev {
+			return &NonceOrderError{Sender: from, TxHash: tx.Hash(), Nonce: tx.Nonce(), Prev: prev}
+		}
+		last[from] = tx.Nonce()
+	}
+	return nil
+}
+
+// validateReceiptsSize checks that the total consensus encoding size of the
+// receipts doesn't exceed the given limit.
+func validateReceiptsSize(number uint64, receipts types.Receipts, limit uint64) error {
+	var (
+		buf  = new(bytes.Buffer)
+		size uint64
+	)
+	for i := range receipts {
+		buf.Reset()
+		receipts.EncodeIndex(i, buf)
+		if size += uint64(buf.Len()); size > limit {
+			return &ReceiptsSizeError{Number: number, Size: size, Limit: limit}
+		}
+	}
+	return nil
+}
+
 // ValidateState validates the various changes that happen after a state transition,
 // such as amount of used gas, the receipt roots and the state root itself.
 func (v *BlockValidator) ValidateState(block *types.Block, statedb *state.StateDB, receipts types.Receipts, usedGas uint64) error {
@@ -184,6 +232,11 @@ func (v *BlockValidator) ValidateState(block *types.Block, statedb *state.StateD
 			return nil
 		},
 	}
+	if limit := v.bc.cacheConfig.MaxBlockReceiptBytes; limit > 0 {
+		validateFuns = append(validateFuns, func() error {
+			return validateReceiptsSize(header.Number.Uint64(), receipts, limit)
+		})
+	}
 	if statedb.IsPipeCommit() {
 		validateFuns = append(validateFuns, func() error {
 			if err := statedb.WaitPipeVerification(); err != nil {
@@ -201,22 +254,39 @@ func (v *BlockValidator) ValidateState(block *types.Block, statedb *state.StateD
 			return nil
 		})
 	}
-	validateRes := make(chan error, len(validateFuns))
+	if v.bc.cacheConfig.ConcurrentStateValidation {
+		return validateConcurrent(validateFuns)
+	}
 	for _, f := range validateFuns {
-		tmpFunc := f
-		go func() {
-			validateRes <- tmpFunc()
-		}()
+		if err := f(); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	var err error
-	for i := 0; i < len(validateFuns); i++ {
-		r := <-validateRes
-		if r != nil && err == nil {
-			err = r
+// validateConcurrent runs all the validation functions in parallel and waits
+// for them to finish. The first failure in list order is returned, so that the
+// reported error matches the one of sequential validation.
+func validateConcurrent(validateFuns []func() error) error {
+	errs := make([]error, len(validateFuns))
+
+	var wg sync.WaitGroup
+	for i, f := range validateFuns {
+		wg.Add(1)
+		go func(i int, f func() error) {
+			defer wg.Done()
+			errs[i] = f()
+		}(i, f)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
 		}
 	}
-	return err
+	return nil
 }
 
 func (v *BlockValidator) RemoteVerifyManager() *remoteVerifyManager {
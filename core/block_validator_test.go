@@ -17,6 +17,7 @@
 package core
 
 import (
+	"errors"
 	"math/big"
 	"testing"
 	"time"
@@ -31,6 +32,8 @@ import (
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/holiman/uint256"
 )
 
 // Tests that simple header verification works, for both good and bad blocks.
@@ -270,3 +273,234 @@ func TestCalcGasLimit(t *testing.T) {
 		}
 	}
 }
+
+// Tests that blocks with transactions of a sender out of nonce order are rejected
+// if strict nonce ordering is enabled.
+func TestStrictNonceOrder(t *testing.T) {
+	var (
+		key, _ = crypto.GenerateKey()
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		gspec  = &Genesis{
+			Config:  params.TestChainConfig,
+			Alloc:   GenesisAlloc{addr: {Balance: big.NewInt(params.Ether)}},
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+		signer = types.LatestSigner(gspec.Config)
+		engine = ethash.NewFaker()
+	)
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 2, func(i int, b *BlockGen) {
+		for j := 0; j < 3; j++ {
+			tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), common.Address{0xaa}, big.NewInt(1), params.TxGas, b.header.BaseFee, nil), signer, key)
+			b.AddTx(tx)
+		}
+	})
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil, EnableStrictNonceOrder)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	// A block swapping two transactions of the same sender must be rejected
+	txs := blocks[0].Transactions()
+	swapped := types.Transactions{txs[0], txs[2], txs[1]}
+
+	header := blocks[0].Header()
+	header.TxHash = types.DeriveSha(swapped, trie.NewStackTrie(nil))
+	misordered := types.NewBlockWithHeader(header).WithBody(swapped, nil)
+
+	var orderErr *NonceOrderError
+	if _, err := chain.InsertChain(types.Blocks{misordered}); !errors.As(err, &orderErr) {
+		t.Fatalf("misordered block error mismatch: have %v, want %T", err, orderErr)
+	}
+	if chain.CurrentBlock().Number.Uint64() != 0 {
+		t.Fatalf("misordered block imported: head %d", chain.CurrentBlock().Number)
+	}
+	// Well ordered blocks must be accepted
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert ordered chain: %v", err)
+	}
+	err = validateNonceOrder(signer, swapped)
+	if !errors.As(err, &orderErr) {
+		t.Fatalf("out of order error mismatch: have %v, want %T", err, orderErr)
+	}
+	if orderErr.Sender != addr || orderErr.TxHash != txs[1].Hash() || orderErr.Nonce != 1 || orderErr.Prev != 2 {
+		t.Errorf("out of order error fields mismatch: %+v", orderErr)
+	}
+	// Repeating a nonce is rejected too
+	if err := validateNonceOrder(signer, types.Transactions{txs[0], txs[0]}); !errors.As(err, &orderErr) {
+		t.Fatalf("duplicate nonce error mismatch: have %v, want %T", err, orderErr)
+	}
+}
+
+// Tests that the blob gas used declared in the header must exactly match the
+// blobs carried by the body, and that blobs require the excess blob gas field.
+func TestValidateBodyBlobGas(t *testing.T) {
+	var (
+		gspec  = &Genesis{Config: params.TestChainConfig}
+		engine = ethash.NewFaker()
+	)
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	defer chain.Stop()
+
+	tx := types.NewTx(&types.BlobTx{
+		ChainID:    uint256.MustFromBig(params.TestChainConfig.ChainID),
+		GasTipCap:  uint256.NewInt(1),
+		GasFeeCap:  uint256.NewInt(1),
+		Gas:        params.TxGas,
+		Value:      new(uint256.Int),
+		BlobFeeCap: uint256.NewInt(1),
+		BlobHashes: []common.Hash{{0x01}},
+	})
+	makeBlock := func(blobGasUsed, excessBlobGas *uint64) *types.Block {
+		header := &types.Header{
+			ParentHash:    chain.Genesis().Hash(),
+			Number:        big.NewInt(1),
+			Difficulty:    big.NewInt(1),
+			GasLimit:      chain.Genesis().GasLimit(),
+			BlobGasUsed:   blobGasUsed,
+			ExcessBlobGas: excessBlobGas,
+		}
+		return types.NewBlock(header, types.Transactions{tx}, nil, nil, trie.NewStackTrie(nil))
+	}
+	tests := []struct {
+		name          string
+		blobGasUsed   *uint64
+		excessBlobGas *uint64
+		valid         bool
+	}{
+		{"exact", u64(params.BlobTxBlobGasPerBlob), u64(0), true},
+		{"too high", u64(2 * params.BlobTxBlobGasPerBlob), u64(0), false},
+		{"not a blob multiple", u64(params.BlobTxBlobGasPerBlob + 1), u64(0), false},
+		{"no excess blob gas", u64(params.BlobTxBlobGasPerBlob), nil, false},
+	}
+	for _, tt := range tests {
+		err := chain.Validator().ValidateBody(makeBlock(tt.blobGasUsed, tt.excessBlobGas))
+		switch {
+		case tt.valid && err != nil:
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		case !tt.valid && !errors.Is(err, ErrBlobGasMismatch):
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, ErrBlobGasMismatch)
+		}
+	}
+}
+
+// Tests that blocks with receipts exceeding the configured size are rejected.
+func TestMaxBlockReceiptBytes(t *testing.T) {
+	var (
+		key, _ = crypto.GenerateKey()
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		gspec  = &Genesis{
+			Config:  params.TestChainConfig,
+			Alloc:   GenesisAlloc{addr: {Balance: big.NewInt(params.Ether)}},
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+		signer = types.LatestSigner(gspec.Config)
+		engine = ethash.NewFaker()
+	)
+	_, blocks, receipts := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
+		for j := 0; j < 4; j++ {
+			tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), common.Address{0xaa}, big.NewInt(1), params.TxGas, b.header.BaseFee, nil), signer, key)
+			b.AddTx(tx)
+		}
+	})
+	var size uint64
+	for _, receipt := range receipts[0] {
+		blob, _ := receipt.MarshalBinary()
+		size += uint64(len(blob))
+	}
+	for i, tt := range []struct {
+		limit uint64
+		fail  bool
+	}{
+		{0, false},       // unset, no limit
+		{size, false},    // receipts exactly at the limit
+		{size - 1, true}, // receipts one byte over the limit
+		{size / 2, true}, // receipts about twice the limit
+		{1 << 20, false}, // receipts well under the limit
+	} {
+		cacheConfig := DefaultCacheConfigWithScheme(rawdb.HashScheme)
+		cacheConfig.MaxBlockReceiptBytes = tt.limit
+
+		chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), cacheConfig, gspec, nil, engine, vm.Config{}, nil, nil)
+		if err != nil {
+			t.Fatalf("test %d: failed to create tester chain: %v", i, err)
+		}
+		_, err = chain.InsertChain(blocks)
+		chain.Stop()
+
+		var sizeErr *ReceiptsSizeError
+		if tt.fail {
+			if !errors.As(err, &sizeErr) {
+				t.Errorf("test %d: error mismatch: have %v, want %T", i, err, sizeErr)
+			} else if sizeErr.Limit != tt.limit || sizeErr.Size <= tt.limit {
+				t.Errorf("test %d: error fields mismatch: %+v", i, sizeErr)
+			}
+		} else if err != nil {
+			t.Errorf("test %d: failed to insert chain: %v", i, err)
+		}
+	}
+}
+
+// Tests that concurrent state validation accepts and rejects the same blocks as
+// the sequential path, reporting the same error.
+func TestConcurrentStateValidation(t *testing.T) {
+	var (
+		key, _ = crypto.GenerateKey()
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		gspec  = &Genesis{
+			Config:  params.TestChainConfig,
+			Alloc:   GenesisAlloc{addr: {Balance: big.NewInt(params.Ether)}},
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+		signer = types.LatestSigner(gspec.Config)
+		engine = ethash.NewFaker()
+	)
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 8, func(i int, b *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), common.Address{0xaa}, big.NewInt(1), params.TxGas, b.header.BaseFee, nil), signer, key)
+		b.AddTx(tx)
+	})
+	// Corrupt both the receipt root and the bloom of a block in the middle
+	header := blocks[4].Header()
+	header.ReceiptHash = common.Hash{0x01}
+	header.Bloom = types.Bloom{0x01}
+	corrupted := make([]*types.Block, 5)
+	copy(corrupted, blocks[:4])
+	corrupted[4] = types.NewBlockWithHeader(header).WithBody(blocks[4].Transactions(), blocks[4].Uncles())
+
+	insert := func(concurrent bool, blocks []*types.Block) (common.Hash, error) {
+		cacheConfig := DefaultCacheConfigWithScheme(rawdb.HashScheme)
+		cacheConfig.ConcurrentStateValidation = concurrent
+
+		chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), cacheConfig, gspec, nil, engine, vm.Config{}, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to create tester chain: %v", err)
+		}
+		defer chain.Stop()
+
+		_, err = chain.InsertChain(blocks)
+		return chain.CurrentBlock().Hash(), err
+	}
+	for _, concurrent := range []bool{false, true} {
+		head, err := insert(concurrent, blocks)
+		if err != nil {
+			t.Fatalf("concurrent %v: failed to insert chain: %v", concurrent, err)
+		}
+		if want := blocks[len(blocks)-1].Hash(); head != want {
+			t.Fatalf("concurrent %v: head mismatch: have %x, want %x", concurrent, head, want)
+		}
+	}
+	seqHead, seqErr := insert(false, corrupted)
+	conHead, conErr := insert(true, corrupted)
+	if seqErr == nil || conErr == nil {
+		t.Fatalf("corrupted receipts accepted: sequential %v, concurrent %v", seqErr, conErr)
+	}
+	if seqErr.Error() != conErr.Error() {
+		t.Fatalf("error mismatch: sequential %v, concurrent %v", seqErr, conErr)
+	}
+	if seqHead != conHead || seqHead != blocks[3].Hash() {
+		t.Fatalf("head mismatch: sequential %x, concurrent %x, want %x", seqHead, conHead, blocks[3].Hash())
+	}
+}
@@ -18,9 +18,13 @@
 package core
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"math/big"
 	"runtime"
 	"sort"
@@ -96,11 +100,14 @@ var (
 	blockReorgAddMeter  = metrics.NewRegisteredMeter("chain/reorg/add", nil)
 	blockReorgDropMeter = metrics.NewRegisteredMeter("chain/reorg/drop", nil)
 
+	sideBlockEvictMeter = metrics.NewRegisteredMeter("chain/side/evict", nil)
+
 	errStateRootVerificationFailed = errors.New("state root verification failed")
 	errInsertionInterrupted        = errors.New("insertion is interrupted")
 	errChainStopped                = errors.New("blockchain is stopped")
 	errInvalidOldChain             = errors.New("invalid old chain")
 	errInvalidNewChain             = errors.New("invalid new chain")
+	errFinalizedNotAncestor        = errors.New("finalized block is not an ancestor of the head")
 )
 
 const (
@@ -109,6 +116,7 @@ const (
 	diffLayerCacheLimit = 1024
 	receiptsCacheLimit  = 10000
 	txLookupCacheLimit  = 1024
+	importCacheLimit    = 1024
 	maxBadBlockLimit    = 16
 	maxFutureBlocks     = 256
 	maxTimeFutureBlocks = 30
@@ -121,6 +129,15 @@ const (
 
 	rewindBadBlockInterval = 1 * time.Second
 
+	maxPrunedImports    = 64          // Number of distinct pruned-ancestor batches tracked for resubmission
+	prunedImportsWindow = time.Minute // Window within which pruned-ancestor resubmissions are counted
+
+	maxHeadRepairDepth = 2048 // Maximum number of blocks searched for a canonical head block on startup
+
+	// DefaultFinalityDepth is the number of blocks built on top of a block after
+	// which it's considered accepted, 2/3+1 of Core's 29 validators.
+	DefaultFinalityDepth = 21
+
 	// BlockChainVersion ensures that an incompatible database forces a resync from scratch.
 	//
 	// Changelog:
@@ -165,6 +182,18 @@ type CacheConfig struct {
 
 	SnapshotNoBuild bool // Whether the background generation is allowed
 	SnapshotWait    bool // Wait for snapshot construction on startup. TODO(karalabe): This is a dirty hack for testing, nuke it
+
+	MaxBlockReceiptBytes      uint64        // Maximum total encoded size of a block's receipts (0 = unlimited)
+	ConcurrentStateValidation bool          // Whether to run the post-state checks of ValidateState concurrently
+	MaxDiffLayers             int           // Maximum number of in-memory diff layers of the path scheme (0 = 128)
+	MaxPrunedReimports        int           // Number of identical pruned-ancestor resubmissions tolerated per window (0 = unlimited)
+	MaxReorgDepth             uint64        // Maximum number of canonical blocks a reorg may drop (0 = unlimited)
+	TrieFlushEveryBlocks      uint64        // Number of blocks after which dirty trie nodes are flushed regardless of their size (0 = disabled)
+	MaxPendingSideBlocks      int           // Maximum number of unprocessed side chain blocks retained, lightest branch tips evicted first (0 = unlimited)
+	MaxRecomputeBlocks        uint64        // Maximum number of blocks re-executed to recompute a missing historical state (0 = defaultMaxRecomputeBlocks)
+	AncientFreezeThreshold    uint64        // Number of recent blocks kept in the key-value store before freezing (0 = params.FullImmutabilityThreshold)
+	AncientWriteRetries       int           // Number of retries of a failed ancient write during receipt import (0 = defaultAncientWriteRetries, <0 = disabled)
+	AncientWriteBackoff       time.Duration // Delay before retrying a failed ancient write, doubled on each attempt (0 = defaultAncientWriteBackoff)
 }
 
 // triedbConfig derives the configures for trie database.
@@ -185,11 +214,27 @@ func (c *CacheConfig) triedbConfig() *trie.Config {
 			StateHistory:   c.StateHistory,
 			CleanCacheSize: c.TrieCleanLimit * 1024 * 1024,
 			DirtyCacheSize: c.TrieDirtyLimit * 1024 * 1024,
+			MaxDiffLayers:  c.MaxDiffLayers,
+			FlushLayers:    c.TrieFlushEveryBlocks,
 		}
 	}
 	return config
 }
 
+// defaultMaxRecomputeBlocks is the number of blocks RecomputeState walks back at
+// most to find an available state, unless configured otherwise.
+const defaultMaxRecomputeBlocks = 128
+
+const (
+	// defaultAncientWriteRetries is the number of times a failed ancient write
+	// is retried during receipt import, unless configured otherwise.
+	defaultAncientWriteRetries = 3
+
+	// defaultAncientWriteBackoff is the delay before the first retry of a failed
+	// ancient write, unless configured otherwise.
+	defaultAncientWriteBackoff = 100 * time.Millisecond
+)
+
 // defaultCacheConfig are the default caching values if none are specified by the
 // user (also used during testing).
 var defaultCacheConfig = &CacheConfig{
@@ -245,8 +290,9 @@ type BlockChain struct {
 	//  * 0:   means no limit and regenerate any missing indexes
 	//  * N:   means N block limit [HEAD-N+1, HEAD] and delete extra indexes
 	//  * nil: disable tx reindexer/deleter, but still index new blocks
-	txLookupLimit uint64
-	triesInMemory uint64
+	txLookupLimit   uint64
+	triesInMemory   uint64
+	freezeThreshold uint64 // Number of recent blocks kept in the key-value store before freezing
 
 	hc                  *HeaderChain
 	rmLogsFeed          event.Feed
@@ -255,8 +301,12 @@ type BlockChain struct {
 	chainHeadFeed       event.Feed
 	chainBlockFeed      event.Feed
 	logsFeed            event.Feed
+	canonLogsFeed       event.Feed
 	blockProcFeed       event.Feed
 	finalizedHeaderFeed event.Feed
+	chainAcceptedFeed   event.Feed
+	finalizedReorgFeed  event.Feed
+	reorgFeed           event.Feed
 	scope               event.SubscriptionScope
 	genesisBlock        *types.Block
 
@@ -267,17 +317,32 @@ type BlockChain struct {
 	highestVerifiedHeader atomic.Pointer[types.Header]
 	currentBlock          atomic.Pointer[types.Header] // Current head of the chain
 	currentSnapBlock      atomic.Pointer[types.Header] // Current head of snap-sync
+	currentFinalBlock     atomic.Pointer[types.Header] // Block explicitly marked as finalized
+
+	finalityDepth  uint64        // Number of blocks on top of a block to consider it accepted
+	acceptedNumber atomic.Uint64 // Number of the last block announced as accepted
+	acceptedCh     chan struct{} // Notification channel waking the accepted block announcer
+
+	deepReorgAllowed atomic.Bool // Whether reorgs deeper than MaxReorgDepth are permitted
+
+	gasProfiling   atomic.Bool                               // Whether per-contract gas is profiled during block processing
+	lastGasProfile atomic.Pointer[map[common.Address]uint64] // Per-contract gas of the last processed block
 
 	bodyCache     *lru.Cache[common.Hash, *types.Body]
 	bodyRLPCache  *lru.Cache[common.Hash, rlp.RawValue]
 	receiptsCache *lru.Cache[common.Hash, []*types.Receipt]
 	blockCache    *lru.Cache[common.Hash, *types.Block]
 	txLookupCache *lru.Cache[common.Hash, *rawdb.LegacyTxLookupEntry]
+	importCache   *lru.Cache[common.Hash, time.Time] // Local wall-clock time recent blocks were committed at
 
 	// future blocks are blocks added for later processing
 	futureBlocks *lru.Cache[common.Hash, *types.Block]
 	// Cache for the blocks that failed to pass MPT root verification
 	badBlockCache *lru.Cache[common.Hash, time.Time]
+	// Resubmission counters of batches hitting a pruned ancestor
+	prunedImports *lru.Cache[prunedImportKey, prunedImportRecord]
+	// Unprocessed side chain blocks written to disk, guarded by chainmu
+	sideBlocks map[common.Hash]sideBlock
 
 	// trusted diff layers
 	diffLayerCache             *exlru.Cache                          // Cache for the diffLayers
@@ -299,6 +364,10 @@ type BlockChain struct {
 	vmConfig   vm.Config
 	pipeCommit bool
 
+	strictNonceOrder bool // Whether to reject blocks with out of order nonces per sender
+
+	rewardDistributor RewardDistributor // Custom transaction fee reward distribution, nil for the default
+
 	// monitor
 	doubleSignMonitor *monitor.DoubleSignMonitor
 }
@@ -316,6 +385,20 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, genesis *Genesis
 		log.Warn("TriesInMemory isn't the default value(128), you need specify exact same TriesInMemory when prune data",
 			"triesInMemory", cacheConfig.TriesInMemory)
 	}
+	freezeThreshold := uint64(params.FullImmutabilityThreshold)
+	if threshold := cacheConfig.AncientFreezeThreshold; threshold != 0 {
+		// Freezing anything within the reorg range would make reorgs impossible,
+		// the threshold can only keep more blocks in the key-value store
+		if threshold < params.FullImmutabilityThreshold {
+			log.Warn("Ancient freeze threshold below the immutability threshold, raising", "threshold", threshold, "min", params.FullImmutabilityThreshold)
+			threshold = params.FullImmutabilityThreshold
+		}
+		if freezer, ok := db.(interface{ SetFreezeThreshold(uint64) }); ok {
+			freezer.SetFreezeThreshold(threshold)
+			log.Info("Configured ancient freeze threshold", "threshold", threshold)
+		}
+		freezeThreshold = threshold
+	}
 
 	diffLayerCache, _ := exlru.New(diffLayerCacheLimit)
 	diffLayerChanCache, _ := exlru.New(diffLayerCacheLimit)
@@ -350,20 +433,26 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, genesis *Genesis
 		triegc:             prque.New[int64, common.Hash](nil),
 		quit:               make(chan struct{}),
 		triesInMemory:      cacheConfig.TriesInMemory,
+		freezeThreshold:    freezeThreshold,
 		chainmu:            syncx.NewClosableMutex(),
 		bodyCache:          lru.NewCache[common.Hash, *types.Body](bodyCacheLimit),
 		bodyRLPCache:       lru.NewCache[common.Hash, rlp.RawValue](bodyCacheLimit),
 		receiptsCache:      lru.NewCache[common.Hash, []*types.Receipt](receiptsCacheLimit),
 		blockCache:         lru.NewCache[common.Hash, *types.Block](blockCacheLimit),
 		txLookupCache:      lru.NewCache[common.Hash, *rawdb.LegacyTxLookupEntry](txLookupCacheLimit),
+		importCache:        lru.NewCache[common.Hash, time.Time](importCacheLimit),
 		futureBlocks:       lru.NewCache[common.Hash, *types.Block](maxFutureBlocks),
 		badBlockCache:      lru.NewCache[common.Hash, time.Time](maxBadBlockLimit),
+		prunedImports:      lru.NewCache[prunedImportKey, prunedImportRecord](maxPrunedImports),
+		sideBlocks:         make(map[common.Hash]sideBlock),
 		diffLayerCache:     diffLayerCache,
 		diffLayerChanCache: diffLayerChanCache,
 		engine:             engine,
 		vmConfig:           vmConfig,
 		diffQueue:          prque.New[int64, *types.DiffLayer](nil),
 		diffQueueBuffer:    make(chan *types.DiffLayer),
+		finalityDepth:      DefaultFinalityDepth,
+		acceptedCh:         make(chan struct{}, 1),
 	}
 	bc.flushInterval.Store(int64(cacheConfig.TrieTimeLimit))
 	bc.forker = NewForkChoice(bc, shouldPreserve)
@@ -396,6 +485,9 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, genesis *Genesis
 	if err := bc.loadLastState(); err != nil {
 		return nil, err
 	}
+	// Make sure the head block is on the chain of the head header
+	bc.repairHeads()
+
 	// Make sure the state associated with the block is available
 	head := bc.CurrentBlock()
 	if !bc.stateCache.NoTries() && !bc.HasState(head.Root) {
@@ -449,10 +541,11 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, genesis *Genesis
 			low = fullBlock.Number.Uint64()
 		}
 		// In snap sync, it may happen that ancient data has been written to the
-		// ancient store, but the LastFastBlock has not been updated, truncate the
-		// extra data here.
+		// ancient store, but the LastFastBlock has not been updated. Keep the
+		// extra data if it's still on the canonical header chain, InsertReceiptChain
+		// will resume from it, truncate it otherwise.
 		snapBlock := bc.CurrentSnapBlock()
-		if snapBlock != nil && snapBlock.Number.Uint64() < frozen-1 {
+		if snapBlock != nil && snapBlock.Number.Uint64() < frozen-1 && !bc.isResumableAncient(frozen) {
 			needRewind = true
 			if snapBlock.Number.Uint64() < low || low == 0 {
 				low = snapBlock.Number.Uint64()
@@ -518,6 +611,11 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, genesis *Genesis
 	bc.wg.Add(1)
 	go bc.updateFutureBlocks()
 
+	// Start the accepted block announcer, skipping blocks accepted before startup
+	bc.acceptedNumber.Store(bc.acceptedHead())
+	bc.wg.Add(1)
+	go bc.acceptedLoop()
+
 	// Need persist and prune diff layer
 	if bc.db.DiffStore() != nil {
 		bc.wg.Add(1)
@@ -704,6 +802,14 @@ func (bc *BlockChain) loadLastState() error {
 		}
 	}
 
+	// Restore the last known finalized block, unless it's beyond the head
+	bc.currentFinalBlock.Store(nil)
+	if head := rawdb.ReadFinalizedBlockHash(bc.db); head != (common.Hash{}) {
+		if header := bc.GetHeaderByHash(head); header != nil && header.Number.Uint64() <= headBlock.NumberU64() {
+			bc.currentFinalBlock.Store(header)
+		}
+	}
+
 	// Issue a status log for the user
 	var (
 		currentSnapBlock = bc.CurrentSnapBlock()
@@ -734,6 +840,55 @@ func (bc *BlockChain) loadLastState() error {
 	return nil
 }
 
+// repairHeads ensures the head block is part of the canonical chain leading to
+// the head header. A crash may leave the persisted markers diverged, in which
+// case the head block is rolled back to the highest canonical block with a body,
+// searching at most maxHeadRepairDepth blocks. A missing state of the repaired
+// head is recovered afterwards, same as for any other head.
+//
+// The header chain is never rewound, snap synced nodes legitimately have it
+// ahead of the blocks.
+func (bc *BlockChain) repairHeads() {
+	var (
+		head       = bc.CurrentBlock()
+		headHeader = bc.CurrentHeader()
+		number     = head.Number.Uint64()
+	)
+	if number <= headHeader.Number.Uint64() && bc.GetCanonicalHash(number) == head.Hash() {
+		return
+	}
+	if number > headHeader.Number.Uint64() {
+		number = headHeader.Number.Uint64()
+	}
+	var repaired *types.Header
+	for n := number; number-n < maxHeadRepairDepth; n-- {
+		if header := bc.GetHeader(bc.GetCanonicalHash(n), n); header != nil && bc.HasBlock(header.Hash(), n) {
+			repaired = header
+			break
+		}
+		if n == 0 {
+			break
+		}
+	}
+	if repaired == nil {
+		log.Error("No canonical block found near the head header, resetting to genesis", "header", headHeader.Number, "depth", maxHeadRepairDepth)
+		repaired = bc.genesisBlock.Header()
+	}
+	log.Warn("Head block diverged from head header, repairing", "number", head.Number, "hash", head.Hash(),
+		"header", headHeader.Number, "repaired", repaired.Number, "repairedhash", repaired.Hash())
+
+	rawdb.WriteHeadBlockHash(bc.db, repaired.Hash())
+	bc.currentBlock.Store(repaired)
+	headBlockGauge.Update(repaired.Number.Int64())
+
+	// The snap block may only be ahead of the head block on the canonical chain
+	if snap := bc.CurrentSnapBlock(); snap.Number.Cmp(repaired.Number) < 0 || bc.GetCanonicalHash(snap.Number.Uint64()) != snap.Hash() {
+		rawdb.WriteHeadFastBlockHash(bc.db, repaired.Hash())
+		bc.currentSnapBlock.Store(repaired)
+		headFastBlockGauge.Update(repaired.Number.Int64())
+	}
+}
+
 // SetHead rewinds the local chain to a new head. Depending on whether the node
 // was snap synced or full synced and in which state, the method will try to
 // delete minimal data from disk whilst retaining chain consistency.
@@ -982,7 +1137,16 @@ func (bc *BlockChain) setHeadBeyondRoot(head uint64, time uint64, root common.Ha
 	bc.txLookupCache.Purge()
 	bc.futureBlocks.Purge()
 
-	return rootNumber, bc.loadLastState()
+	// Drop the finalized marker if its block was rewound
+	if finalized := bc.currentFinalBlock.Load(); finalized != nil && finalized.Number.Uint64() > bc.CurrentBlock().Number.Uint64() {
+		rawdb.WriteFinalizedBlockHash(bc.db, common.Hash{})
+	}
+	if err := bc.loadLastState(); err != nil {
+		return rootNumber, err
+	}
+	// Re-announce the blocks that get buried again on top of the new head
+	bc.resetChainAccepted(bc.acceptedHead())
+	return rootNumber, nil
 }
 
 // SnapSyncCommitHead sets the current head block to the one defined by the hash
@@ -1098,6 +1262,30 @@ func (bc *BlockChain) ExportN(w io.Writer, first uint64, last uint64) error {
 	return nil
 }
 
+// ExportRange writes the canonical blocks in the range [first, last] to the given
+// writer, optionally gzip compressed. The whole range is checked to be available
+// before anything is written, blocks are then streamed one by one.
+func (bc *BlockChain) ExportRange(w io.Writer, first, last uint64, gzipped bool) error {
+	if first > last {
+		return fmt.Errorf("export failed: first (%d) is greater than last (%d)", first, last)
+	}
+	for nr := first; nr <= last; nr++ {
+		hash := rawdb.ReadCanonicalHash(bc.db, nr)
+		if hash == (common.Hash{}) || !bc.HasBlock(hash, nr) {
+			return fmt.Errorf("%w: #%d", ErrBlockUnavailable, nr)
+		}
+	}
+	if !gzipped {
+		return bc.ExportN(w, first, last)
+	}
+	writer := gzip.NewWriter(w)
+	if err := bc.ExportN(writer, first, last); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
 // writeHeadBlock injects a new head block into the current block chain. This method
 // assumes that the block is indeed a true head. It will also reset the head
 // header and the head snap sync block to this very same block if they are older
@@ -1269,6 +1457,50 @@ const (
 	SideStatTy
 )
 
+// writeAncientBlocks appends a contiguous segment of blocks and receipts to the
+// ancient store, retrying transient failures with an exponential backoff. Only
+// file system errors, e.g. a full disk, are deemed transient; any other error,
+// like an append at the wrong position, would fail again and is returned right
+// away. Each retry first truncates anything a failed attempt might have left
+// above the segment start, so entries are never duplicated.
+func (bc *BlockChain) writeAncientBlocks(blocks []*types.Block, receipts []types.Receipts, td *big.Int) (int64, error) {
+	retries, backoff := bc.cacheConfig.AncientWriteRetries, bc.cacheConfig.AncientWriteBackoff
+	if retries == 0 {
+		retries = defaultAncientWriteRetries
+	}
+	if backoff == 0 {
+		backoff = defaultAncientWriteBackoff
+	}
+	start := blocks[0].NumberU64()
+	for attempt := 0; ; attempt++ {
+		size, err := rawdb.WriteAncientBlocks(bc.db, blocks, receipts, td)
+		if err == nil || attempt >= retries || !isTransientAncientError(err) {
+			return size, err
+		}
+		log.Warn("Failed to write ancient blocks, retrying", "number", start, "count", len(blocks), "attempt", attempt+1, "delay", backoff, "err", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-bc.quit:
+			return size, err
+		}
+		backoff *= 2
+
+		if frozen, _ := bc.db.Ancients(); frozen > start {
+			if _, err := bc.db.TruncateHead(start); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// isTransientAncientError reports whether a failed ancient write may succeed
+// if retried, which is only the case for failures of the file system.
+func isTransientAncientError(err error) bool {
+	var pathErr *fs.PathError
+	return errors.As(err, &pathErr)
+}
+
 // InsertReceiptChain attempts to complete an already existing header chain with
 // transaction and receipt data.
 func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain []types.Receipts, ancientLimit uint64) (int, error) {
@@ -1341,7 +1573,7 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 			if frozen, _ := bc.db.Ancients(); frozen == 0 {
 				b := bc.genesisBlock
 				td := bc.genesisBlock.Difficulty()
-				writeSize, err := rawdb.WriteAncientBlocks(bc.db, []*types.Block{b}, []types.Receipts{nil}, td)
+				writeSize, err := bc.writeAncientBlocks([]*types.Block{b}, []types.Receipts{nil}, td)
 				size += writeSize
 				if err != nil {
 					log.Error("Error writing genesis to ancients", "err", err)
@@ -1357,13 +1589,35 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 			return 0, fmt.Errorf("containing header #%d [%x..] unknown", last.Number(), last.Hash().Bytes()[:4])
 		}
 
+		// A previous import may have been interrupted after writing a part of
+		// the segment into the ancient store. Skip the frozen blocks if they
+		// match the incoming ones, otherwise roll them back.
+		writeBlocks, writeReceipts := blockChain, receiptChain
+		if frozen, _ := bc.db.Ancients(); frozen > first.NumberU64() {
+			skip := frozen - first.NumberU64()
+			if skip > uint64(len(blockChain)) {
+				skip = uint64(len(blockChain))
+			}
+			if rawdb.ReadCanonicalHash(bc.db, first.NumberU64()+skip-1) == blockChain[skip-1].Hash() {
+				log.Info("Resuming interrupted ancient import", "skipped", skip, "number", first.NumberU64()+skip)
+				writeBlocks, writeReceipts = blockChain[skip:], receiptChain[skip:]
+				stats.ignored += int32(skip)
+			} else {
+				log.Warn("Rolling back mismatching ancient segment", "number", first.NumberU64(), "frozen", frozen)
+				if _, err := bc.db.TruncateHead(first.NumberU64()); err != nil {
+					return 0, err
+				}
+			}
+		}
 		// Write all chain data to ancients.
-		td := bc.GetTd(first.Hash(), first.NumberU64())
-		writeSize, err := rawdb.WriteAncientBlocks(bc.db, blockChain, receiptChain, td)
-		size += writeSize
-		if err != nil {
-			log.Error("Error importing chain data to ancients", "err", err)
-			return 0, err
+		if len(writeBlocks) > 0 {
+			td := bc.GetTd(writeBlocks[0].Hash(), writeBlocks[0].NumberU64())
+			writeSize, err := bc.writeAncientBlocks(writeBlocks, writeReceipts, td)
+			size += writeSize
+			if err != nil {
+				log.Error("Error importing chain data to ancients", "err", err)
+				return 0, err
+			}
 		}
 
 		// Write tx indices if any condition is satisfied:
@@ -1388,7 +1642,7 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 
 			if batch.ValueSize() > ethdb.IdealBatchSize || i == len(blockChain)-1 {
 				size += int64(batch.ValueSize())
-				if err = batch.Write(); err != nil {
+				if err := batch.Write(); err != nil {
 					snapBlock := bc.CurrentSnapBlock().Number.Uint64()
 					if _, err := bc.db.TruncateHead(snapBlock + 1); err != nil {
 						log.Error("Can't truncate ancient store after failed insert", "err", err)
@@ -1537,6 +1791,17 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 	return 0, nil
 }
 
+// isResumableAncient reports whether the last frozen block is still the canonical
+// one in the key-value store, i.e. the ancient items above the snap block were
+// left by an interrupted InsertReceiptChain on the current header chain.
+func (bc *BlockChain) isResumableAncient(frozen uint64) bool {
+	if bc.CurrentBlock().Hash() != bc.genesisBlock.Hash() {
+		return false
+	}
+	_, hashes := rawdb.ReadAllCanonicalHashes(bc.db, frozen-1, frozen, 1)
+	return len(hashes) == 1 && hashes[0] == rawdb.ReadCanonicalHash(bc.db, frozen-1)
+}
+
 // writeBlockWithoutState writes only the block and its metadata to the database,
 // but does not write any state. This is used to construct competing side forks
 // up to the point where they exceed the canonical total difficulty.
@@ -1590,6 +1855,9 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 		rawdb.WriteTd(blockBatch, block.Hash(), block.NumberU64(), externTd)
 		rawdb.WriteBlock(blockBatch, block)
 		rawdb.WriteReceipts(blockBatch, block.Hash(), block.NumberU64(), receipts)
+		if posa, ok := bc.engine.(consensus.PoSA); ok {
+			rawdb.WriteSystemGasUsed(blockBatch, block.Hash(), block.NumberU64(), systemGasUsed(posa, block, receipts))
+		}
 		rawdb.WritePreimages(blockBatch, state.Preimages())
 		if err := blockBatch.Write(); err != nil {
 			log.Crit("Failed to write block into disk", "err", err)
@@ -1633,8 +1901,11 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 
 				flushInterval := time.Duration(bc.flushInterval.Load())
 
-				// If we exceeded out time allowance, flush an entire trie to disk
-				if bc.gcproc > flushInterval {
+				// If we exceeded out time allowance or the configured block cadence,
+				// flush an entire trie to disk. The chosen trie is always the one just
+				// leaving the in-memory window, so no retained state is flushed early.
+				cadence := bc.cacheConfig.TrieFlushEveryBlocks != 0 && chosen >= bc.lastWrite+bc.cacheConfig.TrieFlushEveryBlocks
+				if bc.gcproc > flushInterval || cadence {
 					canWrite := true
 					if posa, ok := bc.engine.(consensus.PoSA); ok {
 						if !posa.EnoughDistance(bc, block.Header()) {
@@ -1685,6 +1956,7 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 	if err != nil {
 		return err
 	}
+	bc.importCache.Add(block.Hash(), time.Now())
 
 	// Ensure no empty block body
 	if diffLayer != nil && block.Header().TxHash != types.EmptyRootHash {
@@ -1747,7 +2019,7 @@ func (bc *BlockChain) writeBlockAndSetHead(block *types.Block, receipts []*types
 	if status == CanonStatTy {
 		bc.chainFeed.Send(ChainEvent{Block: block, Hash: block.Hash(), Logs: logs})
 		if len(logs) > 0 {
-			bc.logsFeed.Send(logs)
+			bc.sendLogs(logs)
 		}
 		// In theory, we should fire a ChainHeadEvent when we inject
 		// a canonical block, but sometimes we can insert a batch of
@@ -1761,6 +2033,7 @@ func (bc *BlockChain) writeBlockAndSetHead(block *types.Block, receipts []*types
 					bc.finalizedHeaderFeed.Send(FinalizedHeaderEvent{finalizedHeader})
 				}
 			}
+			bc.signalChainAccepted()
 		}
 	} else {
 		bc.chainSideFeed.Send(ChainSideEvent{Block: block})
@@ -1768,6 +2041,78 @@ func (bc *BlockChain) writeBlockAndSetHead(block *types.Block, receipts []*types
 	return status, nil
 }
 
+// sendLogs announces logs added to the canonical chain.
+func (bc *BlockChain) sendLogs(logs []*types.Log) {
+	bc.logsFeed.Send(logs)
+	bc.canonLogsFeed.Send(CanonicalLogsEvent{Logs: logs})
+}
+
+// sendRemovedLogs announces logs removed from the canonical chain by a reorg.
+func (bc *BlockChain) sendRemovedLogs(logs []*types.Log) {
+	bc.rmLogsFeed.Send(RemovedLogsEvent{logs})
+	bc.canonLogsFeed.Send(CanonicalLogsEvent{Logs: logs})
+}
+
+// acceptedHead returns the number of the canonical block buried finalityDepth
+// blocks below the current head, or 0 if the chain is not that long yet.
+func (bc *BlockChain) acceptedHead() uint64 {
+	head := bc.CurrentBlock().Number.Uint64()
+	if head <= bc.finalityDepth {
+		return 0
+	}
+	return head - bc.finalityDepth
+}
+
+// signalChainAccepted wakes the accepted block announcer. It never blocks, the
+// insert path must not wait for subscribers of ChainAcceptedEvent.
+func (bc *BlockChain) signalChainAccepted() {
+	select {
+	case bc.acceptedCh <- struct{}{}:
+	default:
+	}
+}
+
+// resetChainAccepted lowers the last announced accepted block to the given
+// number, so blocks above it are announced again once buried on the new chain.
+func (bc *BlockChain) resetChainAccepted(number uint64) {
+	for {
+		last := bc.acceptedNumber.Load()
+		if last <= number || bc.acceptedNumber.CompareAndSwap(last, number) {
+			return
+		}
+	}
+}
+
+// acceptedLoop announces every canonical block once it is buried finalityDepth
+// blocks deep, including the ones passed over by batch imports.
+func (bc *BlockChain) acceptedLoop() {
+	defer bc.wg.Done()
+
+	for {
+		select {
+		case <-bc.acceptedCh:
+			target := bc.acceptedHead()
+			for {
+				last := bc.acceptedNumber.Load()
+				if last >= target {
+					break
+				}
+				block := bc.GetBlockByNumber(last + 1)
+				if block == nil {
+					break
+				}
+				// Skip the announcement if a rewind reset the counter meanwhile
+				if !bc.acceptedNumber.CompareAndSwap(last, last+1) {
+					continue
+				}
+				bc.chainAcceptedFeed.Send(ChainAcceptedEvent{Block: block})
+			}
+		case <-bc.quit:
+			return
+		}
+	}
+}
+
 // addFutureBlock checks if the block is within the max allowed window to get
 // accepted for future processing, and returns an error if the block is too far
 // ahead and was not added.
@@ -1792,10 +2137,21 @@ func (bc *BlockChain) addFutureBlock(block *types.Block) error {
 // the index number of the failing block as well an error describing what went
 // wrong. After insertion is done, all accumulated events will be fired.
 func (bc *BlockChain) InsertChain(chain types.Blocks) (int, error) {
+	return bc.InsertChainWithContext(context.Background(), chain)
+}
+
+// InsertChainWithContext is InsertChain with support for cancellation. The
+// context is checked before each block is processed; if it is done, the import
+// stops and the index of the first unprocessed block is returned along with the
+// context error. All blocks before it stay committed and the head consistent.
+func (bc *BlockChain) InsertChainWithContext(ctx context.Context, chain types.Blocks) (int, error) {
 	// Sanity check that we have something meaningful to import
 	if len(chain) == 0 {
 		return 0, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 	bc.blockProcFeed.Send(true)
 	defer bc.blockProcFeed.Send(false)
 
@@ -1819,7 +2175,7 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (int, error) {
 		return 0, errChainStopped
 	}
 	defer bc.chainmu.Unlock()
-	return bc.insertChain(chain, true)
+	return bc.insertChain(ctx, chain, true)
 }
 
 // insertChain is the internal implementation of InsertChain, which assumes that
@@ -1830,7 +2186,7 @@ func (bc *BlockChain) InsertChain(chain types.Blocks) (int, error) {
 // racey behaviour. If a sidechain import is in progress, and the historic state
 // is imported, but then new canon-head is added before the actual sidechain
 // completes, then the historic state could be pruned again
-func (bc *BlockChain) insertChain(chain types.Blocks, setHead bool) (int, error) {
+func (bc *BlockChain) insertChain(ctx context.Context, chain types.Blocks, setHead bool) (int, error) {
 	// If the chain is terminating, don't even bother starting up.
 	if bc.insertStopped() {
 		return 0, nil
@@ -1853,6 +2209,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks, setHead bool) (int, error)
 					bc.finalizedHeaderFeed.Send(FinalizedHeaderEvent{finalizedHeader})
 				}
 			}
+			bc.signalChainAccepted()
 		}
 	}()
 	// Start the parallel header verifier
@@ -1921,9 +2278,15 @@ func (bc *BlockChain) insertChain(chain types.Blocks, setHead bool) (int, error)
 	// First block is pruned
 	case errors.Is(err, consensus.ErrPrunedAncestor):
 		if setHead {
+			// Short circuit if the very same batch keeps being resubmitted on top
+			// of an unchanged head, it won't produce a different outcome.
+			if bc.trackPrunedImport(block, chain[len(chain)-1]) {
+				log.Debug("Ignoring resubmitted pruned ancestor", "number", block.Number(), "hash", block.Hash())
+				return it.index, ErrKnownPrunedBlock
+			}
 			// First block is pruned, insert as sidechain and reorg only if TD grows enough
 			log.Debug("Pruned ancestor, inserting as sidechain", "number", block.Number(), "hash", block.Hash())
-			return bc.insertSideChain(block, it)
+			return bc.insertSideChain(ctx, block, it)
 		} else {
 			// We're post-merge and the parent is pruned, try to recover the parent state
 			log.Debug("Pruned ancestor", "number", block.Number(), "hash", block.Hash())
@@ -1961,6 +2324,10 @@ func (bc *BlockChain) insertChain(chain types.Blocks, setHead bool) (int, error)
 			log.Debug("Abort during block processing")
 			break
 		}
+		if err := ctx.Err(); err != nil {
+			log.Debug("Block import cancelled", "number", block.Number(), "hash", block.Hash(), "err", err)
+			return it.index, err
+		}
 		// If the header is a banned one, straight out abort
 		if BadHashes[block.Hash()] {
 			bc.reportBlock(block, nil, ErrBannedHash)
@@ -2041,7 +2408,11 @@ func (bc *BlockChain) insertChain(chain types.Blocks, setHead bool) (int, error)
 		}
 		statedb.SetExpectedStateRoot(block.Root())
 		pstart := time.Now()
-		statedb, receipts, logs, usedGas, err := bc.processor.Process(block, statedb, bc.vmConfig)
+		vmConfig := bc.vmConfig
+		if bc.gasProfiling.Load() {
+			vmConfig.GasProfiler = vm.NewGasProfiler()
+		}
+		statedb, receipts, logs, usedGas, err := bc.processor.Process(block, statedb, vmConfig)
 		close(interruptCh) // state prefetch can be stopped
 		if err != nil {
 			bc.reportBlock(block, receipts, err)
@@ -2061,6 +2432,11 @@ func (bc *BlockChain) insertChain(chain types.Blocks, setHead bool) (int, error)
 		vtime := time.Since(vstart)
 		proctime := time.Since(start) // processing + validation
 
+		if vmConfig.GasProfiler != nil {
+			profile := vmConfig.GasProfiler.Profile()
+			bc.lastGasProfile.Store(&profile)
+		}
+
 		bc.cacheBlock(block.Hash(), block)
 
 		// Update the metrics touched during block processing and validation
@@ -2194,6 +2570,130 @@ func (bc *BlockChain) updateHighestVerifiedHeader(header *types.Header) {
 	}
 }
 
+// prunedImportKey identifies a batch hitting a pruned ancestor together with the
+// head it was imported on top of. Including the head ensures a batch is processed
+// again as soon as the local chain moved, e.g. when a reorg really needs it.
+type prunedImportKey struct {
+	first common.Hash
+	last  common.Hash
+	head  common.Hash
+}
+
+// prunedImportRecord counts the submissions of a pruned-ancestor batch.
+type prunedImportRecord struct {
+	count int
+	since time.Time
+}
+
+// trackPrunedImport records the import of a batch spanning [first, last] whose
+// first block hit a pruned ancestor, and reports whether the same batch was
+// submitted more than the configured number of times within the tracking window.
+func (bc *BlockChain) trackPrunedImport(first, last *types.Block) bool {
+	limit := bc.cacheConfig.MaxPrunedReimports
+	if limit <= 0 {
+		return false
+	}
+	var (
+		now = time.Now()
+		key = prunedImportKey{
+			first: first.Hash(),
+			last:  last.Hash(),
+			head:  bc.CurrentBlock().Hash(),
+		}
+	)
+	record, ok := bc.prunedImports.Get(key)
+	if !ok || now.Sub(record.since) > prunedImportsWindow {
+		record = prunedImportRecord{since: now}
+	}
+	record.count++
+	bc.prunedImports.Add(key, record)
+
+	return record.count > limit
+}
+
+// sideBlock identifies an unprocessed side chain block written to disk.
+type sideBlock struct {
+	number uint64
+	parent common.Hash
+	td     *big.Int
+}
+
+// trackSideBlock records a side chain block written to disk without state.
+//
+// Note, this method assumes the chain mutex is held!
+func (bc *BlockChain) trackSideBlock(block *types.Block, td *big.Int) {
+	if bc.cacheConfig.MaxPendingSideBlocks <= 0 {
+		return
+	}
+	bc.sideBlocks[block.Hash()] = sideBlock{number: block.NumberU64(), parent: block.ParentHash(), td: td}
+}
+
+// evictSideBlocks drops the tracked side chain blocks a reorg made canonical,
+// then deletes blocks from the tips of the retained branches, lowest total
+// difficulty first, until no more than the configured number remains. Since
+// only tips are ever evicted, no retained block loses its ancestors.
+//
+// Eviction must only run once a side chain import settled, otherwise the first
+// blocks of a heavy fork longer than the limit would be dropped before the
+// reorg onto it.
+//
+// Note, this method assumes the chain mutex is held!
+func (bc *BlockChain) evictSideBlocks() {
+	limit := bc.cacheConfig.MaxPendingSideBlocks
+	if limit <= 0 {
+		return
+	}
+	for hash, side := range bc.sideBlocks {
+		if rawdb.ReadCanonicalHash(bc.db, side.number) == hash {
+			delete(bc.sideBlocks, hash)
+		}
+	}
+	if len(bc.sideBlocks) <= limit {
+		return
+	}
+	children := make(map[common.Hash]int)
+	for _, side := range bc.sideBlocks {
+		children[side.parent]++
+	}
+	batch := bc.db.NewBatch()
+	for len(bc.sideBlocks) > limit {
+		var (
+			tip  common.Hash
+			best *sideBlock
+		)
+		for hash, side := range bc.sideBlocks {
+			if children[hash] > 0 {
+				continue
+			}
+			if best != nil {
+				if cmp := side.td.Cmp(best.td); cmp > 0 || (cmp == 0 && bytes.Compare(hash[:], tip[:]) > 0) {
+					continue
+				}
+			}
+			side := side
+			tip, best = hash, &side
+		}
+		delete(bc.sideBlocks, tip)
+		children[best.parent]--
+
+		rawdb.DeleteBlock(batch, tip, best.number)
+
+		bc.blockCache.Remove(tip)
+		bc.bodyCache.Remove(tip)
+		bc.bodyRLPCache.Remove(tip)
+		bc.receiptsCache.Remove(tip)
+		bc.hc.headerCache.Remove(tip)
+		bc.hc.tdCache.Remove(tip)
+		bc.hc.numberCache.Remove(tip)
+
+		sideBlockEvictMeter.Mark(1)
+		log.Debug("Evicted sidechain block", "number", best.number, "hash", tip)
+	}
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to evict sidechain blocks", "err", err)
+	}
+}
+
 func (bc *BlockChain) GetHighestVerifiedHeader() *types.Header {
 	return bc.highestVerifiedHeader.Load()
 }
@@ -2205,12 +2705,14 @@ func (bc *BlockChain) GetHighestVerifiedHeader() *types.Header {
 // The method writes all (header-and-body-valid) blocks to disk, then tries to
 // switch over to the new chain if the TD exceeded the current chain.
 // insertSideChain is only used pre-merge.
-func (bc *BlockChain) insertSideChain(block *types.Block, it *insertIterator) (int, error) {
+func (bc *BlockChain) insertSideChain(ctx context.Context, block *types.Block, it *insertIterator) (int, error) {
 	var (
 		externTd  *big.Int
 		lastBlock = block
 		current   = bc.CurrentBlock()
 	)
+	defer bc.evictSideBlocks()
+
 	// The first sidechain block error is already verified to be ErrPrunedAncestor.
 	// Since we don't import them here, we expect ErrUnknownAncestor for the remaining
 	// ones. Any other errors means that the block is invalid, and should not be written
@@ -2255,6 +2757,7 @@ func (bc *BlockChain) insertSideChain(block *types.Block, it *insertIterator) (i
 			if err := bc.writeBlockWithoutState(block, externTd); err != nil {
 				return it.index, err
 			}
+			bc.trackSideBlock(block, externTd)
 			log.Debug("Injected sidechain block", "number", block.Number(), "hash", block.Hash(),
 				"diff", block.Difficulty(), "elapsed", common.PrettyDuration(time.Since(start)),
 				"txs", len(block.Transactions()), "gas", block.GasUsed(), "uncles", len(block.Uncles()),
@@ -2318,7 +2821,7 @@ func (bc *BlockChain) insertSideChain(block *types.Block, it *insertIterator) (i
 		// memory here.
 		if len(blocks) >= 2048 || memory > 64*1024*1024 {
 			log.Info("Importing heavy sidechain segment", "blocks", len(blocks), "start", blocks[0].NumberU64(), "end", block.NumberU64())
-			if _, err := bc.insertChain(blocks, true); err != nil {
+			if _, err := bc.insertChain(ctx, blocks, true); err != nil {
 				return 0, err
 			}
 			blocks, memory = blocks[:0], 0
@@ -2332,7 +2835,7 @@ func (bc *BlockChain) insertSideChain(block *types.Block, it *insertIterator) (i
 	}
 	if len(blocks) > 0 {
 		log.Info("Importing sidechain segment", "start", blocks[0].NumberU64(), "end", blocks[len(blocks)-1].NumberU64())
-		return bc.insertChain(blocks, true)
+		return bc.insertChain(ctx, blocks, true)
 	}
 	return 0, nil
 }
@@ -2381,7 +2884,7 @@ func (bc *BlockChain) recoverAncestors(block *types.Block) (common.Hash, error)
 		} else {
 			b = bc.GetBlock(hashes[i], numbers[i])
 		}
-		if _, err := bc.insertChain(types.Blocks{b}, false); err != nil {
+		if _, err := bc.insertChain(context.Background(), types.Blocks{b}, false); err != nil {
 			return b.ParentHash(), err
 		}
 	}
@@ -2479,6 +2982,31 @@ func (bc *BlockChain) reorg(oldHead *types.Header, newHead *types.Block) error {
 		}
 	}
 
+	// Refuse to unwind finalized blocks, it's a consensus fault needing manual intervention
+	if posa, ok := bc.engine.(consensus.PoSA); ok && len(oldChain) > 0 {
+		if finalized := posa.GetFinalizedHeader(bc, oldHead); finalized != nil && commonBlock.NumberU64() < finalized.Number.Uint64() {
+			log.Error("Rejected reorg below finalized block", "finalized", finalized.Number, "finalizedhash", finalized.Hash(),
+				"common", commonBlock.Number(), "commonhash", commonBlock.Hash(), "drop", len(oldChain), "add", len(newChain))
+			bc.finalizedReorgFeed.Send(FinalizedReorgEvent{
+				Finalized: finalized,
+				Common:    commonBlock.Header(),
+				OldHead:   oldHead,
+				NewHead:   newHead.Header(),
+			})
+			return ErrFinalizedReorg
+		}
+	}
+	if finalized := bc.currentFinalBlock.Load(); finalized != nil && len(oldChain) > 0 && commonBlock.NumberU64() < finalized.Number.Uint64() {
+		log.Error("Rejected reorg below marked finalized block", "finalized", finalized.Number, "finalizedhash", finalized.Hash(),
+			"common", commonBlock.Number(), "commonhash", commonBlock.Hash(), "drop", len(oldChain), "add", len(newChain))
+		return ErrFinalizedReorg
+	}
+	// Refuse to drop more canonical blocks than configured, unless overridden
+	if limit := bc.cacheConfig.MaxReorgDepth; limit > 0 && uint64(len(oldChain)) > limit && !bc.deepReorgAllowed.Load() {
+		log.Error("Rejected deep chain reorg", "common", commonBlock.Number(), "commonhash", commonBlock.Hash(),
+			"drop", len(oldChain), "add", len(newChain), "limit", limit)
+		return fmt.Errorf("%w: dropping %d blocks, limit %d", ErrReorgTooDeep, len(oldChain), limit)
+	}
 	// Ensure the user sees large reorgs
 	if len(oldChain) > 0 && len(newChain) > 0 {
 		logFn := log.Info
@@ -2538,6 +3066,10 @@ func (bc *BlockChain) reorg(oldHead *types.Header, newHead *types.Block) error {
 	if err := indexesBatch.Write(); err != nil {
 		log.Crit("Failed to delete useless indexes", "err", err)
 	}
+	// Announce the replaced blocks again once the new chain buries them
+	if len(oldChain) > 0 {
+		bc.resetChainAccepted(commonBlock.NumberU64())
+	}
 
 	// Send out events for logs from the old canon chain, and 'reborn'
 	// logs from the new canon chain. The number of logs can be very
@@ -2554,12 +3086,12 @@ func (bc *BlockChain) reorg(oldHead *types.Header, newHead *types.Block) error {
 			deletedLogs = append(deletedLogs, logs...)
 		}
 		if len(deletedLogs) > 512 {
-			bc.rmLogsFeed.Send(RemovedLogsEvent{deletedLogs})
+			bc.sendRemovedLogs(deletedLogs)
 			deletedLogs = nil
 		}
 	}
 	if len(deletedLogs) > 0 {
-		bc.rmLogsFeed.Send(RemovedLogsEvent{deletedLogs})
+		bc.sendRemovedLogs(deletedLogs)
 	}
 
 	// New logs:
@@ -2569,12 +3101,27 @@ func (bc *BlockChain) reorg(oldHead *types.Header, newHead *types.Block) error {
 			rebirthLogs = append(rebirthLogs, logs...)
 		}
 		if len(rebirthLogs) > 512 {
-			bc.logsFeed.Send(rebirthLogs)
+			bc.sendLogs(rebirthLogs)
 			rebirthLogs = nil
 		}
 	}
 	if len(rebirthLogs) > 0 {
-		bc.logsFeed.Send(rebirthLogs)
+		bc.sendLogs(rebirthLogs)
+	}
+	// Summarize the reorg, plain extensions of the chain don't count as one
+	if len(oldChain) > 0 {
+		event := ReorgEvent{
+			OldChain:       make([]common.Hash, 0, len(oldChain)),
+			NewChain:       make([]common.Hash, 0, len(newChain)),
+			CommonAncestor: commonBlock.Hash(),
+		}
+		for i := len(oldChain) - 1; i >= 0; i-- {
+			event.OldChain = append(event.OldChain, oldChain[i].Hash())
+		}
+		for i := len(newChain) - 1; i >= 0; i-- {
+			event.NewChain = append(event.NewChain, newChain[i].Hash())
+		}
+		bc.reorgFeed.Send(event)
 	}
 	return nil
 }
@@ -2590,7 +3137,7 @@ func (bc *BlockChain) InsertBlockWithoutSetHead(block *types.Block) error {
 	}
 	defer bc.chainmu.Unlock()
 
-	_, err := bc.insertChain(types.Blocks{block}, false)
+	_, err := bc.insertChain(context.Background(), types.Blocks{block}, false)
 	return err
 }
 
@@ -2623,7 +3170,7 @@ func (bc *BlockChain) SetCanonical(head *types.Block) (common.Hash, error) {
 	logs := bc.collectLogs(head, false)
 	bc.chainFeed.Send(ChainEvent{Block: head, Hash: head.Hash(), Logs: logs})
 	if len(logs) > 0 {
-		bc.logsFeed.Send(logs)
+		bc.sendLogs(logs)
 	}
 	bc.chainHeadFeed.Send(ChainHeadEvent{Block: head})
 
@@ -2938,6 +3485,239 @@ Receipts: %v
 `, block.Number(), block.Hash(), block.Coinbase(), err, platform, vcs, config, receiptString)
 }
 
+// ValidateReceiptsAgainstReexecution re-executes a committed block on top of its
+// parent state and compares the regenerated receipts against the stored ones,
+// returning a ReceiptMismatchError on the first divergence. The parent state
+// must still be available.
+func (bc *BlockChain) ValidateReceiptsAgainstReexecution(hash common.Hash) error {
+	block := bc.GetBlockByHash(hash)
+	if block == nil {
+		return fmt.Errorf("block %#x not found", hash)
+	}
+	parent := bc.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	statedb, err := bc.StateAt(parent.Root)
+	if err != nil {
+		return err
+	}
+	_, receipts, _, _, err := bc.processor.Process(block, statedb, bc.vmConfig)
+	if err != nil {
+		return err
+	}
+	// Read the receipts from the database, bypassing the cache
+	stored := rawdb.ReadReceipts(bc.db, block.Hash(), block.NumberU64(), block.Time(), bc.chainConfig)
+	return compareReceipts(block.NumberU64(), stored, receipts)
+}
+
+// ReplayBlock re-executes the canonical block with the given number on top of its
+// parent state using the given vm config, returning the resulting state and
+// receipts. Nothing is written to the database. The parent state must still be
+// available, otherwise an error is returned. If the regenerated receipts don't
+// match the header's receipt root, the error details the first diverging receipt.
+func (bc *BlockChain) ReplayBlock(number uint64, vmConfig vm.Config) (*state.StateDB, types.Receipts, error) {
+	block := bc.GetBlockByNumber(number)
+	if block == nil {
+		return nil, nil, fmt.Errorf("%w: #%d", ErrBlockUnavailable, number)
+	}
+	if number == 0 {
+		return nil, nil, errors.New("genesis is not replayable")
+	}
+	parent := bc.GetHeader(block.ParentHash(), number-1)
+	if parent == nil {
+		return nil, nil, consensus.ErrUnknownAncestor
+	}
+	statedb, err := bc.StateAt(parent.Root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parent state of block #%d unavailable: %w", number, err)
+	}
+	statedb, receipts, _, _, err := bc.processor.Process(block, statedb, vmConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if root := types.DeriveSha(receipts, trie.NewStackTrie(nil)); root != block.ReceiptHash() {
+		stored := rawdb.ReadReceipts(bc.db, block.Hash(), number, block.Time(), bc.chainConfig)
+		if err := compareReceipts(number, stored, receipts); err != nil {
+			return nil, nil, fmt.Errorf("receipt root mismatch in block #%d (have %x, want %x): %w", number, root, block.ReceiptHash(), err)
+		}
+		return nil, nil, fmt.Errorf("receipt root mismatch in block #%d (have %x, want %x)", number, root, block.ReceiptHash())
+	}
+	return statedb, receipts, nil
+}
+
+// ReplayBlockWithConfig re-executes a block on top of its parent's state under
+// the rules of an alternate chain config, e.g. to evaluate how a fork changes
+// the outcome of historical blocks. Nothing is committed, the chain and its
+// live state are left untouched. As the results are expected to diverge, they
+// are not checked against the block's roots.
+//
+// Only the block processing follows the supplied config, the consensus engine
+// finalizes the block according to its own.
+func (bc *BlockChain) ReplayBlockWithConfig(block *types.Block, cfg *params.ChainConfig) (*state.StateDB, types.Receipts, error) {
+	number := block.NumberU64()
+	if number == 0 {
+		return nil, nil, errors.New("genesis is not replayable")
+	}
+	parent := bc.GetHeader(block.ParentHash(), number-1)
+	if parent == nil {
+		return nil, nil, consensus.ErrUnknownAncestor
+	}
+	statedb, err := bc.StateAt(parent.Root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parent state of block #%d unavailable: %w", number, err)
+	}
+	statedb, receipts, _, _, err := NewStateProcessor(cfg, bc, bc.engine).Process(block, statedb, bc.vmConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return statedb, receipts, nil
+}
+
+// RecomputeState regenerates the state of the given block if it is not available
+// in the database anymore. It walks back from the block to the nearest ancestor
+// whose state is still present, bounded by CacheConfig.MaxRecomputeBlocks, and
+// re-executes the blocks on top of it. ErrNoReachableState is returned if no
+// state is found within the limit.
+//
+// The recomputed state lives in an ephemeral trie database, nothing is written
+// to the live one. The returned function releases it and must be called once
+// the state is no longer needed.
+func (bc *BlockChain) RecomputeState(target *types.Block) (*state.StateDB, func(), error) {
+	// Use the live state if it's still around
+	if statedb, release, err := bc.StateAtWithRelease(target.Root()); err == nil {
+		return statedb, release, nil
+	}
+	if bc.triedb.Scheme() == rawdb.PathScheme {
+		return nil, nil, fmt.Errorf("%w: historical state not available in path scheme", ErrNoReachableState)
+	}
+	limit := bc.cacheConfig.MaxRecomputeBlocks
+	if limit == 0 {
+		limit = defaultMaxRecomputeBlocks
+	}
+	// Walk back until an available state is found, collecting the blocks to be
+	// re-executed. An ephemeral trie database is used to isolate the live one
+	// from the junk created during recomputation.
+	var (
+		triedb   = trie.NewDatabase(bc.db, trie.HashDefaults)
+		database = state.NewDatabaseWithNodeDB(bc.db, triedb)
+		blocks   = []*types.Block{target}
+		statedb  *state.StateDB
+	)
+	for current := target; ; current = blocks[len(blocks)-1] {
+		if uint64(len(blocks)) > limit || current.NumberU64() == 0 {
+			return nil, nil, fmt.Errorf("%w: no state within %d blocks of #%d", ErrNoReachableState, limit, target.NumberU64())
+		}
+		parent := bc.GetBlock(current.ParentHash(), current.NumberU64()-1)
+		if parent == nil {
+			return nil, nil, fmt.Errorf("missing block %#x #%d", current.ParentHash(), current.NumberU64()-1)
+		}
+		var err error
+		if statedb, err = state.New(parent.Root(), database, nil); err == nil {
+			break
+		}
+		blocks = append(blocks, parent)
+	}
+	// Re-execute the collected blocks on top of the found state, holding a
+	// reference to the latest state only. The ephemeral database is simply
+	// dropped on failure.
+	var (
+		start  = time.Now()
+		parent common.Hash
+	)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+
+		// Committing a fully processed state checks it against the expected root
+		statedb.SetExpectedStateRoot(block.Root())
+
+		var err error
+		statedb, _, _, _, err = bc.processor.Process(block, statedb, bc.vmConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("processing block #%d failed: %w", block.NumberU64(), err)
+		}
+		statedb.Finalise(bc.chainConfig.IsEIP158(block.Number()))
+		statedb.AccountsIntermediateRoot()
+		root, _, err := statedb.Commit(block.NumberU64(), nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("state commit of block #%d failed: %w", block.NumberU64(), err)
+		}
+		triedb.Reference(root, common.Hash{})
+		if parent != (common.Hash{}) {
+			triedb.Dereference(parent)
+		}
+		parent = root
+
+		if root != block.Root() {
+			return nil, nil, fmt.Errorf("recomputed state root mismatch in block #%d: have %x, want %x", block.NumberU64(), root, block.Root())
+		}
+		if statedb, err = state.New(root, database, nil); err != nil {
+			return nil, nil, fmt.Errorf("state reset after block #%d failed: %w", block.NumberU64(), err)
+		}
+	}
+	log.Info("Recomputed historical state", "number", target.NumberU64(), "hash", target.Hash(), "blocks", len(blocks), "elapsed", common.PrettyDuration(time.Since(start)))
+
+	var once sync.Once
+	return statedb, func() {
+		once.Do(func() { triedb.Dereference(target.Root()) })
+	}, nil
+}
+
+// systemGasUsed sums up the gas consumed by the system transactions of a block,
+// which is included in the block's gas used along with the user transactions.
+func systemGasUsed(posa consensus.PoSA, block *types.Block, receipts types.Receipts) uint64 {
+	var gas uint64
+	for i, tx := range block.Transactions() {
+		if i >= len(receipts) {
+			break
+		}
+		if system, _ := posa.IsSystemTransaction(tx, block.Header()); system {
+			gas += receipts[i].GasUsed
+		}
+	}
+	return gas
+}
+
+// compareReceipts checks the consensus and derived fields of the stored receipts
+// against the regenerated ones.
+func compareReceipts(number uint64, stored, local types.Receipts) error {
+	if len(stored) != len(local) {
+		return &ReceiptMismatchError{Number: number, Index: -1, Field: "count", Stored: len(stored), Local: len(local)}
+	}
+	for i := range local {
+		have, want := stored[i], local[i]
+		switch {
+		case have.Status != want.Status:
+			return &ReceiptMismatchError{Number: number, Index: i, Field: "status", Stored: have.Status, Local: want.Status}
+		case have.CumulativeGasUsed != want.CumulativeGasUsed:
+			return &ReceiptMismatchError{Number: number, Index: i, Field: "cumulative gas used", Stored: have.CumulativeGasUsed, Local: want.CumulativeGasUsed}
+		case have.GasUsed != want.GasUsed:
+			return &ReceiptMismatchError{Number: number, Index: i, Field: "gas used", Stored: have.GasUsed, Local: want.GasUsed}
+		case len(have.Logs) != len(want.Logs):
+			return &ReceiptMismatchError{Number: number, Index: i, Field: "log count", Stored: len(have.Logs), Local: len(want.Logs)}
+		}
+		for j := range want.Logs {
+			if !equalLogs(have.Logs[j], want.Logs[j]) {
+				return &ReceiptMismatchError{Number: number, Index: i, Field: fmt.Sprintf("log %d", j), Stored: have.Logs[j], Local: want.Logs[j]}
+			}
+		}
+	}
+	return nil
+}
+
+// equalLogs reports whether the consensus fields of two logs match.
+func equalLogs(a, b *types.Log) bool {
+	if a.Address != b.Address || !bytes.Equal(a.Data, b.Data) || len(a.Topics) != len(b.Topics) {
+		return false
+	}
+	for i := range a.Topics {
+		if a.Topics[i] != b.Topics[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // InsertHeaderChain attempts to insert the given header chain in to the local
 // chain, possibly creating a reorg. If an error is returned, it will return the
 // index number of the failing header as well an error describing what went wrong.
@@ -2960,6 +3740,14 @@ func (bc *BlockChain) InsertHeaderChain(chain []*types.Header) (int, error) {
 
 func (bc *BlockChain) TriesInMemory() uint64 { return bc.triesInMemory }
 
+// AncientFreezeThreshold returns the number of recent blocks kept in the
+// key-value store before they are moved into the ancient store.
+func (bc *BlockChain) AncientFreezeThreshold() uint64 { return bc.freezeThreshold }
+
+// RewardDistributor returns the custom transaction fee reward distributor, or
+// nil if rewards go to the block beneficiary.
+func (bc *BlockChain) RewardDistributor() RewardDistributor { return bc.rewardDistributor }
+
 func EnablePipelineCommit(bc *BlockChain) (*BlockChain, error) {
 	bc.pipeCommit = false
 	return bc, nil
@@ -2986,11 +3774,43 @@ func EnableBlockValidator(chainConfig *params.ChainConfig, engine consensus.Engi
 	}
 }
 
+// EnableFinalityDepth overrides the number of blocks on top of a block after
+// which ChainAcceptedEvent is fired for it.
+func EnableFinalityDepth(depth uint64) BlockChainOption {
+	return func(bc *BlockChain) (*BlockChain, error) {
+		if depth == 0 {
+			return nil, errors.New("finality depth must be positive")
+		}
+		bc.finalityDepth = depth
+		return bc, nil
+	}
+}
+
+// EnableRewardDistributor routes the fee reward of every transaction through the
+// given distributor instead of crediting the block beneficiary (the system
+// address on Satoshi chains).
+func EnableRewardDistributor(distributor RewardDistributor) BlockChainOption {
+	return func(bc *BlockChain) (*BlockChain, error) {
+		if distributor == nil {
+			return nil, errors.New("nil reward distributor")
+		}
+		bc.rewardDistributor = distributor
+		return bc, nil
+	}
+}
+
 func EnableDoubleSignChecker(bc *BlockChain) (*BlockChain, error) {
 	bc.doubleSignMonitor = monitor.NewDoubleSignMonitor()
 	return bc, nil
 }
 
+// EnableStrictNonceOrder makes block validation reject blocks in which the
+// transactions of a sender aren't included in strictly increasing nonce order.
+func EnableStrictNonceOrder(bc *BlockChain) (*BlockChain, error) {
+	bc.strictNonceOrder = true
+	return bc, nil
+}
+
 func (bc *BlockChain) GetVerifyResult(blockNumber uint64, blockHash common.Hash, diffHash common.Hash) *VerifyResult {
 	var res VerifyResult
 	res.BlockNumber = blockNumber
@@ -3112,6 +3932,54 @@ func (bc *BlockChain) SetTrieFlushInterval(interval time.Duration) {
 	bc.flushInterval.Store(int64(interval))
 }
 
+// AllowDeepReorg configures whether reorgs dropping more canonical blocks than
+// CacheConfig.MaxReorgDepth are permitted. Reorgs below the finalized block are
+// refused regardless.
+func (bc *BlockChain) AllowDeepReorg(allow bool) {
+	bc.deepReorgAllowed.Store(allow)
+}
+
+// EnableGasProfiling configures whether the gas consumed by each executed
+// contract is accumulated while processing blocks. Profiling is disabled by
+// default, enabling it only affects blocks imported afterwards.
+func (bc *BlockChain) EnableGasProfiling(enable bool) {
+	bc.gasProfiling.Store(enable)
+}
+
+// LastBlockGasProfile returns the gas consumed by each executed contract in the
+// last block processed with profiling enabled, or nil if there is none. The gas
+// forwarded to sub-calls is attributed to the callees. The returned map is
+// shared and must not be modified.
+func (bc *BlockChain) LastBlockGasProfile() map[common.Address]uint64 {
+	profile := bc.lastGasProfile.Load()
+	if profile == nil {
+		return nil
+	}
+	return *profile
+}
+
+// SetFinalized marks the given block as finalized, refusing any later reorg whose
+// common ancestor is below it. The block must be the current head or one of its
+// ancestors. A nil header clears the marker.
+func (bc *BlockChain) SetFinalized(header *types.Header) error {
+	if !bc.chainmu.TryLock() {
+		return errChainStopped
+	}
+	defer bc.chainmu.Unlock()
+
+	if header != nil {
+		number := header.Number.Uint64()
+		if number > bc.CurrentBlock().Number.Uint64() || bc.GetCanonicalHash(number) != header.Hash() {
+			return errFinalizedNotAncestor
+		}
+		rawdb.WriteFinalizedBlockHash(bc.db, header.Hash())
+	} else {
+		rawdb.WriteFinalizedBlockHash(bc.db, common.Hash{})
+	}
+	bc.currentFinalBlock.Store(header)
+	return nil
+}
+
 // GetTrieFlushInterval gets the in-memroy tries flush interval
 func (bc *BlockChain) GetTrieFlushInterval() time.Duration {
 	return time.Duration(bc.flushInterval.Load())
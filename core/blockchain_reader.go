@@ -17,7 +17,11 @@
 package core
 
 import (
+	"fmt"
 	"math/big"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus"
@@ -29,6 +33,7 @@ import (
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/trie"
+	"golang.org/x/exp/slices"
 )
 
 // CurrentHeader retrieves the current head header of the canonical chain. The
@@ -59,8 +64,16 @@ func (bc *BlockChain) CurrentFinalBlock() *types.Header {
 		}
 		return p.GetFinalizedHeader(bc, currentHeader)
 	}
+	return bc.currentFinalBlock.Load()
+}
 
-	return nil
+// EffectiveValidatorSet returns the validators eligible to produce the given
+// header, excluding the ones jailed since the last epoch transition.
+func (bc *BlockChain) EffectiveValidatorSet(header *types.Header) ([]common.Address, error) {
+	if p, ok := bc.engine.(consensus.PoSA); ok {
+		return p.EffectiveValidatorSet(bc, header)
+	}
+	return nil, ErrNotPoSA
 }
 
 // CurrentSafeBlock retrieves the current safe block of the canonical
@@ -129,6 +142,32 @@ func (bc *BlockChain) GetBody(hash common.Hash) *types.Body {
 	return body
 }
 
+// GetBlockTransactions retrieves up to limit transactions of a block, starting
+// at the given index, without decoding the rest of the block body.
+func (bc *BlockChain) GetBlockTransactions(hash common.Hash, offset, limit int) (types.Transactions, error) {
+	if offset < 0 || limit <= 0 {
+		return nil, fmt.Errorf("invalid transaction range: offset %d, limit %d", offset, limit)
+	}
+	// Slice the body if it's already cached, otherwise decode only the range
+	if cached, ok := bc.bodyCache.Get(hash); ok {
+		txs := cached.Transactions
+		if offset >= len(txs) {
+			return types.Transactions{}, nil
+		}
+		// Copy the range, callers must not be able to modify the cached body
+		return slices.Clone(txs[offset:min(offset+limit, len(txs))]), nil
+	}
+	number := bc.hc.GetBlockNumber(hash)
+	if number == nil {
+		return nil, fmt.Errorf("%w: %x", ErrBlockUnavailable, hash)
+	}
+	txs := rawdb.ReadTransactions(bc.db, hash, *number, offset, limit)
+	if txs == nil {
+		return nil, fmt.Errorf("%w: #%d [%x]", ErrBlockUnavailable, *number, hash)
+	}
+	return txs, nil
+}
+
 // GetBodyRLP retrieves a block body in RLP encoding from the database by hash,
 // caching it if found.
 func (bc *BlockChain) GetBodyRLP(hash common.Hash) rlp.RawValue {
@@ -206,6 +245,39 @@ func (bc *BlockChain) GetBlockByNumber(number uint64) *types.Block {
 	return bc.GetBlock(hash, number)
 }
 
+// BlockByTimestamp returns the first canonical block whose timestamp is not
+// earlier than ts, or the current head if all blocks precede it. If several
+// blocks share the same timestamp, the lowest numbered one is returned.
+//
+// Block timestamps are monotonic along the canonical chain, so the lookup is a
+// binary search over the canonical headers.
+func (bc *BlockChain) BlockByTimestamp(ts uint64) (*types.Block, error) {
+	head := bc.CurrentBlock()
+	if head == nil {
+		return nil, ErrCurrentBlockNotFound
+	}
+	var missing error
+	number := sort.Search(int(head.Number.Uint64())+1, func(n int) bool {
+		header := bc.GetHeaderByNumber(uint64(n))
+		if header == nil {
+			missing = fmt.Errorf("%w: #%d", ErrBlockUnavailable, n)
+			return true
+		}
+		return header.Time >= ts
+	})
+	if missing != nil {
+		return nil, missing
+	}
+	if uint64(number) > head.Number.Uint64() {
+		number = int(head.Number.Uint64())
+	}
+	block := bc.GetBlockByNumber(uint64(number))
+	if block == nil {
+		return nil, fmt.Errorf("%w: #%d", ErrBlockUnavailable, number)
+	}
+	return block, nil
+}
+
 // GetBlocksFromHash returns the block corresponding to hash and up to n-1 ancestors.
 // [deprecated by eth/62]
 func (bc *BlockChain) GetBlocksFromHash(hash common.Hash, n int) (blocks []*types.Block) {
@@ -262,6 +334,48 @@ func (bc *BlockChain) GetCanonicalHash(number uint64) common.Hash {
 	return bc.hc.GetCanonicalHash(number)
 }
 
+// GetCanonicalHashes retrieves the canonical hashes of count consecutive blocks
+// starting at from, reading the freezer and the key-value store in one batch
+// each. The range is truncated at the current head, so fewer hashes than asked
+// may be returned. Heights without a known canonical hash are left zero.
+func (bc *BlockChain) GetCanonicalHashes(from, count uint64) ([]common.Hash, error) {
+	head := bc.CurrentBlock().Number.Uint64()
+	if count == 0 || from > head {
+		return nil, nil
+	}
+	count = min(count, head-from+1)
+
+	var (
+		hashes = make([]common.Hash, count)
+		next   = from
+		end    = from + count
+	)
+	// Read the frozen part of the range in one go, skipping pruned items
+	frozen, _ := bc.db.Ancients()
+	tail, _ := bc.db.Tail()
+	if next < tail {
+		next = min(tail, end)
+	}
+	if next < frozen {
+		blobs, err := bc.db.AncientRange(rawdb.ChainFreezerHashTable, next, min(frozen, end)-next, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range blobs {
+			hashes[next-from] = common.BytesToHash(blob)
+			next++
+		}
+	}
+	// Read the remainder from the key-value store
+	if next < end {
+		numbers, live := rawdb.ReadAllCanonicalHashes(bc.db, next, end, int(end-next))
+		for i, number := range numbers {
+			hashes[number-from] = live[i]
+		}
+	}
+	return hashes, nil
+}
+
 // GetAncestor retrieves the Nth ancestor of a given block. It assumes that either the given block or
 // a close ancestor of it is canonical. maxNonCanonical points to a downwards counter limiting the
 // number of blocks to be individually checked before we reach the canonical chain.
@@ -287,6 +401,76 @@ func (bc *BlockChain) GetTransactionLookup(hash common.Hash) *rawdb.LegacyTxLook
 	return lookup
 }
 
+// proofList collects the nodes of a Merkle proof in the order they are visited.
+type proofList [][]byte
+
+func (n *proofList) Put(key []byte, value []byte) error {
+	*n = append(*n, value)
+	return nil
+}
+
+func (n *proofList) Delete(key []byte) error {
+	panic("not supported")
+}
+
+// GetReceiptProof retrieves the receipt of a canonical transaction along with
+// the Merkle proof linking it to the receipts root of its block, allowing light
+// clients to verify the receipt. The receipt trie is reconstructed on demand,
+// so receipts already moved into the freezer are supported too.
+//
+// ErrTxNotCanonical is returned if the transaction is indexed, but not part of
+// the canonical block at the indexed height anymore.
+func (bc *BlockChain) GetReceiptProof(txHash common.Hash) (*types.Receipt, [][]byte, error) {
+	number := rawdb.ReadTxLookupEntry(bc.db, txHash)
+	if number == nil {
+		return nil, nil, fmt.Errorf("transaction %#x not found", txHash)
+	}
+	hash := bc.GetCanonicalHash(*number)
+	block := bc.GetBlock(hash, *number)
+	if block == nil {
+		return nil, nil, fmt.Errorf("%w: #%d", ErrBlockUnavailable, *number)
+	}
+	index := -1
+	for i, tx := range block.Transactions() {
+		if tx.Hash() == txHash {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, nil, fmt.Errorf("%w: %#x", ErrTxNotCanonical, txHash)
+	}
+	receipts := bc.GetReceiptsByHash(hash)
+	if len(receipts) != len(block.Transactions()) {
+		return nil, nil, fmt.Errorf("receipts of block #%d unavailable", *number)
+	}
+	// Rebuild the receipt trie and ensure it matches the header before proving
+	tr := trie.NewEmpty(trie.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+	if root := types.DeriveSha(receipts, tr); root != block.ReceiptHash() {
+		return nil, nil, fmt.Errorf("receipt root mismatch in block #%d: have %x, want %x", *number, root, block.ReceiptHash())
+	}
+	var proof proofList
+	if err := tr.Prove(rlp.AppendUint64(nil, uint64(index)), &proof); err != nil {
+		return nil, nil, err
+	}
+	return receipts[index], proof, nil
+}
+
+// SealToImportLatency returns the time elapsed between the sealing of a block,
+// as reported by its header timestamp, and the moment the local node committed
+// it. Only the most recently committed blocks are tracked.
+func (bc *BlockChain) SealToImportLatency(hash common.Hash) (time.Duration, error) {
+	imported, ok := bc.importCache.Get(hash)
+	if !ok {
+		return 0, ErrUnknownImportTime
+	}
+	header := bc.GetHeaderByHash(hash)
+	if header == nil {
+		return 0, ErrUnknownImportTime
+	}
+	return imported.Sub(time.Unix(int64(header.Time), 0)), nil
+}
+
 // GetTd retrieves a block's total difficulty in the canonical chain from the
 // database by hash and number, caching it if found.
 func (bc *BlockChain) GetTd(hash common.Hash, number uint64) *big.Int {
@@ -319,6 +503,28 @@ func (bc *BlockChain) HasBlockAndState(hash common.Hash, number uint64) bool {
 	return bc.HasState(block.Root())
 }
 
+// HasFullState checks if the state trie with the given root is resolvable from
+// the persistent layer, i.e. without relying on in-memory diffs.
+func (bc *BlockChain) HasFullState(root common.Hash) bool {
+	_, onDisk := bc.StateAvailability(root)
+	return onDisk
+}
+
+// StateAvailability reports whether the state trie with the given root is only
+// available in memory (dirty trie nodes or path diff layers), or resolvable from
+// the persistent layer (flushed trie or path disk layer).
+func (bc *BlockChain) StateAvailability(root common.Hash) (inMemory, onDisk bool) {
+	if !bc.HasState(root) {
+		return false, false
+	}
+	if bc.triedb.Scheme() == rawdb.PathScheme {
+		onDisk = bc.triedb.DiskRoot() == root
+	} else {
+		onDisk = root == types.EmptyRootHash || rawdb.HasLegacyTrieNode(bc.db, root)
+	}
+	return !onDisk, onDisk
+}
+
 // stateRecoverable checks if the specified state is recoverable.
 // Note, this function assumes the state is not present, because
 // state is not treated as recoverable if it's available, thus
@@ -355,12 +561,73 @@ func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
 	return state.New(root, bc.stateCache, bc.snaps)
 }
 
+// GetSystemGasUsed retrieves the gas consumed by the system transactions of a
+// block. It is only recorded for blocks processed locally by a PoSA engine, the
+// second return value reports whether it is known.
+func (bc *BlockChain) GetSystemGasUsed(hash common.Hash, number uint64) (uint64, bool) {
+	return rawdb.ReadSystemGasUsed(bc.db, hash, number)
+}
+
+// StateAtWithRelease is like StateAt, but additionally pins the trie nodes of
+// the state in the in-memory trie database, so that they are not garbage
+// collected while the state is in use. The returned function drops the pin
+// and must be called once the state is no longer needed; calling it more than
+// once is harmless.
+//
+// Under the path scheme the state layers are not reference counted and the
+// release function is a noop.
+func (bc *BlockChain) StateAtWithRelease(root common.Hash) (*state.StateDB, func(), error) {
+	statedb, err := bc.StateAt(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bc.triedb.Scheme() == rawdb.PathScheme {
+		return statedb, func() {}, nil
+	}
+	bc.triedb.Reference(root, common.Hash{})
+
+	var once sync.Once
+	return statedb, func() {
+		once.Do(func() { bc.triedb.Dereference(root) })
+	}, nil
+}
+
+// DumpAccount retrieves the balance, nonce, code and entire storage of an account
+// in the state of the given root. The storage keys are only recoverable if the
+// chain records the trie key preimages, otherwise the slots are keyed by hash.
+func (bc *BlockChain) DumpAccount(root common.Hash, addr common.Address) (*state.AccountState, error) {
+	return bc.StreamAccount(root, addr, nil)
+}
+
+// StreamAccount is like DumpAccount, but streams the storage slots into onSlot
+// instead of collecting them, for contracts whose storage doesn't fit in memory.
+func (bc *BlockChain) StreamAccount(root common.Hash, addr common.Address, onSlot func(key, value common.Hash) error) (*state.AccountState, error) {
+	statedb, err := bc.StateAt(root)
+	if err != nil {
+		return nil, err
+	}
+	return statedb.DumpAccountState(addr, onSlot)
+}
+
 // Config retrieves the chain's fork configuration.
 func (bc *BlockChain) Config() *params.ChainConfig { return bc.chainConfig }
 
 // Engine retrieves the blockchain's consensus engine.
 func (bc *BlockChain) Engine() consensus.Engine { return bc.engine }
 
+// SystemCallStats returns the time the consensus engine spent in system contract
+// calls while importing the current head block. Blocks without system calls, or
+// whose calls were not timed by the engine, report zero durations.
+func (bc *BlockChain) SystemCallStats() consensus.SystemCallStats {
+	head := bc.CurrentBlock()
+	if reporter, ok := bc.engine.(consensus.SystemCallReporter); ok {
+		if stats, ok := reporter.SystemCallStats(head.Hash()); ok {
+			return stats
+		}
+	}
+	return consensus.SystemCallStats{Number: head.Number.Uint64(), Hash: head.Hash()}
+}
+
 // Snapshots returns the blockchain snapshot tree.
 func (bc *BlockChain) Snapshots() *snapshot.Tree {
 	return bc.snaps
@@ -391,6 +658,44 @@ func (bc *BlockChain) Genesis() *types.Block {
 	return bc.genesisBlock
 }
 
+// GenesisForkState returns whether each fork of the chain configuration is
+// active at the genesis block, keyed by fork name.
+func (bc *BlockChain) GenesisForkState() map[string]bool {
+	var (
+		c    = bc.chainConfig
+		num  = bc.genesisBlock.Number()
+		time = bc.genesisBlock.Time()
+	)
+	return map[string]bool{
+		"Homestead":      c.IsHomestead(num),
+		"EIP150":         c.IsEIP150(num),
+		"EIP155":         c.IsEIP155(num),
+		"EIP158":         c.IsEIP158(num),
+		"Byzantium":      c.IsByzantium(num),
+		"Constantinople": c.IsConstantinople(num),
+		"Petersburg":     c.IsPetersburg(num),
+		"Istanbul":       c.IsIstanbul(num),
+		"MuirGlacier":    c.IsMuirGlacier(num),
+		"Berlin":         c.IsBerlin(num),
+		"London":         c.IsLondon(num),
+		"HashPower":      c.IsHashPower(num),
+		"Zeus":           c.IsZeus(num),
+		"Hera":           c.IsHera(num),
+		"Poseidon":       c.IsPoseidon(num),
+		"Luban":          c.IsLuban(num),
+		"Plato":          c.IsPlato(num),
+		"Hertz":          c.IsHertz(num),
+		"Shanghai":       c.IsShanghai(num, time),
+		"Kepler":         c.IsKepler(num, time),
+		"Demeter":        c.IsDemeter(num, time),
+		"Athena":         c.IsAthena(num, time),
+		"Theseus":        c.IsTheseus(num, time),
+		"Cancun":         c.IsCancun(num, time),
+		"Prague":         c.IsPrague(num, time),
+		"Verkle":         c.IsVerkle(num, time),
+	}
+}
+
 // SetTxLookupLimit is responsible for updating the txlookup limit to the
 // original one stored in db if the new mismatches with the old one.
 func (bc *BlockChain) SetTxLookupLimit(limit uint64) {
@@ -408,7 +713,8 @@ func (bc *BlockChain) TrieDB() *trie.Database {
 	return bc.triedb
 }
 
-// SubscribeRemovedLogsEvent registers a subscription of RemovedLogsEvent.
+// SubscribeRemovedLogsEvent registers a subscription of RemovedLogsEvent. Use
+// SubscribeCanonicalLogsEvent to observe removed and new logs in chain order.
 func (bc *BlockChain) SubscribeRemovedLogsEvent(ch chan<- RemovedLogsEvent) event.Subscription {
 	return bc.scope.Track(bc.rmLogsFeed.Subscribe(ch))
 }
@@ -438,6 +744,13 @@ func (bc *BlockChain) SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscript
 	return bc.scope.Track(bc.logsFeed.Subscribe(ch))
 }
 
+// SubscribeCanonicalLogsEvent registers a subscription of CanonicalLogsEvent,
+// carrying both new and removed logs in the order the chain emits them: the
+// removed logs of a reorg always before the logs of the new canonical chain.
+func (bc *BlockChain) SubscribeCanonicalLogsEvent(ch chan<- CanonicalLogsEvent) event.Subscription {
+	return bc.scope.Track(bc.canonLogsFeed.Subscribe(ch))
+}
+
 // SubscribeBlockProcessingEvent registers a subscription of bool where true means
 // block processing has started while false means it has stopped.
 func (bc *BlockChain) SubscribeBlockProcessingEvent(ch chan<- bool) event.Subscription {
@@ -448,3 +761,18 @@ func (bc *BlockChain) SubscribeBlockProcessingEvent(ch chan<- bool) event.Subscr
 func (bc *BlockChain) SubscribeFinalizedHeaderEvent(ch chan<- FinalizedHeaderEvent) event.Subscription {
 	return bc.scope.Track(bc.finalizedHeaderFeed.Subscribe(ch))
 }
+
+// SubscribeChainAcceptedEvent registers a subscription of ChainAcceptedEvent.
+func (bc *BlockChain) SubscribeChainAcceptedEvent(ch chan<- ChainAcceptedEvent) event.Subscription {
+	return bc.scope.Track(bc.chainAcceptedFeed.Subscribe(ch))
+}
+
+// SubscribeReorgEvent registers a subscription of ReorgEvent.
+func (bc *BlockChain) SubscribeReorgEvent(ch chan<- ReorgEvent) event.Subscription {
+	return bc.scope.Track(bc.reorgFeed.Subscribe(ch))
+}
+
+// SubscribeFinalizedReorgEvent registers a subscription of FinalizedReorgEvent.
+func (bc *BlockChain) SubscribeFinalizedReorgEvent(ch chan<- FinalizedReorgEvent) event.Subscription {
+	return bc.scope.Track(bc.finalizedReorgFeed.Subscribe(ch))
+}
@@ -17,12 +17,20 @@
 package core
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"math/big"
 	"math/rand"
 	"os"
+	"reflect"
+	"slices"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -33,13 +41,16 @@ import (
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/systemcontracts"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/eth/tracers/logger"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/trie"
+	"github.com/holiman/uint256"
 )
 
 // So we can deterministically seed different blockchains
@@ -1417,6 +1428,208 @@ func checkLogEvents(t *testing.T, logsCh <-chan []*types.Log, rmLogsCh <-chan Re
 	}
 }
 
+// Tests that a reorg is summarized in a single event carrying the dropped and
+// the added blocks, while plain chain extensions post no such event.
+func TestReorgEvent(t *testing.T) {
+	var (
+		engine = ethash.NewFaker()
+		gspec  = &Genesis{Config: params.TestChainConfig, BaseFee: big.NewInt(params.InitialBaseFee)}
+	)
+	genDb, chain, _ := GenerateChainWithGenesis(gspec, engine, 5, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{1}) })
+
+	// Fork off block #2 with a heavier chain, overtaking the canonical one at #5
+	forks, _ := GenerateChain(gspec.Config, chain[1], engine, genDb, 4, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{2})
+		b.OffsetTime(-9) // higher block difficulty
+	})
+	blockchain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer blockchain.Stop()
+
+	reorgCh := make(chan ReorgEvent, 10)
+	sub := blockchain.SubscribeReorgEvent(reorgCh)
+	defer sub.Unsubscribe()
+
+	// Extending the chain, including with a single block, is not a reorg
+	if _, err := blockchain.InsertChain(chain[:4]); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	if _, err := blockchain.InsertChain(chain[4:]); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	select {
+	case ev := <-reorgCh:
+		t.Fatalf("reorg event posted for chain extension: %+v", ev)
+	default:
+	}
+	// Switching to the fork drops three blocks
+	if _, err := blockchain.InsertChain(forks); err != nil {
+		t.Fatalf("failed to insert fork: %v", err)
+	}
+	if head := blockchain.CurrentBlock().Hash(); head != forks[3].Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", head, forks[3].Hash())
+	}
+	want := ReorgEvent{
+		OldChain:       []common.Hash{chain[2].Hash(), chain[3].Hash(), chain[4].Hash()},
+		NewChain:       []common.Hash{forks[0].Hash(), forks[1].Hash(), forks[2].Hash()},
+		CommonAncestor: chain[1].Hash(),
+	}
+	select {
+	case ev := <-reorgCh:
+		if !reflect.DeepEqual(ev, want) {
+			t.Fatalf("reorg event mismatch:\nhave %+v\nwant %+v", ev, want)
+		}
+	default:
+		t.Fatal("no reorg event posted")
+	}
+	// The fork's last block merely extends the new chain
+	select {
+	case ev := <-reorgCh:
+		t.Fatalf("unexpected reorg event: %+v", ev)
+	default:
+	}
+}
+
+// Tests that every subscriber to both log feeds observes a reorg in chain order:
+// the logs of the old chain are added, then removed, and only then the logs of
+// the new chain are added, with no log removed before it was added.
+func TestLogEventOrderingDuringReorg(t *testing.T) {
+	var (
+		key1, _       = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr1         = crypto.PubkeyToAddress(key1.PublicKey)
+		gspec         = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{addr1: {Balance: big.NewInt(10000000000000000)}}}
+		signer        = types.LatestSigner(gspec.Config)
+		engine        = ethash.NewFaker()
+		blockchain, _ = NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	)
+	defer blockchain.Stop()
+
+	makeChain := func(gas uint64, offset int64) []*types.Block {
+		_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 3, func(i int, gen *BlockGen) {
+			for ii := 0; ii < 5; ii++ {
+				tx, err := types.SignNewTx(key1, signer, &types.LegacyTx{
+					Nonce:    gen.TxNonce(addr1),
+					GasPrice: gen.header.BaseFee,
+					Gas:      gas,
+					Data:     logCode,
+				})
+				if err != nil {
+					t.Fatalf("failed to create tx: %v", err)
+				}
+				gen.AddTx(tx)
+			}
+			gen.OffsetTime(offset)
+		})
+		return blocks
+	}
+	var (
+		chain     = makeChain(1000001, 0)
+		forkChain = makeChain(1000000, -9) // higher block difficulty
+	)
+	// Spin up the subscribers, each recording the events in the order received
+	type logEvent struct {
+		removed bool
+		logs    []*types.Log
+	}
+	var (
+		wg     sync.WaitGroup
+		quit   = make(chan struct{})
+		events = make([][]logEvent, 8)
+	)
+	for i := range events {
+		logsCh := make(chan CanonicalLogsEvent, 4)
+		logsSub := blockchain.SubscribeCanonicalLogsEvent(logsCh)
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer logsSub.Unsubscribe()
+
+			record := func(ev CanonicalLogsEvent) {
+				events[i] = append(events[i], logEvent{removed: ev.Logs[0].Removed, logs: ev.Logs})
+			}
+			for {
+				select {
+				case ev := <-logsCh:
+					record(ev)
+				case <-quit:
+					// Drain the events buffered before the chain returned
+					for {
+						select {
+						case ev := <-logsCh:
+							record(ev)
+						default:
+							return
+						}
+					}
+				}
+			}
+		}(i)
+	}
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	if _, err := blockchain.InsertChain(forkChain); err != nil {
+		t.Fatalf("failed to insert forked chain: %v", err)
+	}
+	close(quit)
+	wg.Wait()
+
+	canonical := make(map[common.Hash]bool)
+	for _, block := range forkChain {
+		canonical[block.Hash()] = true
+	}
+	type logID struct {
+		block common.Hash
+		index uint
+	}
+	for i, evs := range events {
+		var (
+			live  = make(map[logID]bool)
+			phase int // 0: old chain added, 1: old chain removed, 2: new chain added
+		)
+		for _, ev := range evs {
+			for _, log := range ev.logs {
+				id := logID{log.BlockHash, log.Index}
+				next := 0
+				switch {
+				case ev.removed:
+					if !live[id] {
+						t.Fatalf("subscriber %d: log %d of block %x removed before being added", i, log.Index, log.BlockHash)
+					}
+					delete(live, id)
+					next = 1
+				case canonical[log.BlockHash]:
+					next = 2
+					fallthrough
+				default:
+					if live[id] {
+						t.Fatalf("subscriber %d: log %d of block %x added twice", i, log.Index, log.BlockHash)
+					}
+					live[id] = true
+				}
+				if next < phase {
+					t.Fatalf("subscriber %d: events out of order, removed %v after phase %d", i, ev.removed, phase)
+				}
+				phase = next
+			}
+		}
+		if phase != 2 {
+			t.Fatalf("subscriber %d: reorg not fully observed, stopped at phase %d", i, phase)
+		}
+		if len(live) != 15 {
+			t.Fatalf("subscriber %d: wrong number of live logs: have %d, want %d", i, len(live), 15)
+		}
+		for id := range live {
+			if !canonical[id.block] {
+				t.Fatalf("subscriber %d: log %d of stale block %x still live", i, id.index, id.block)
+			}
+		}
+	}
+}
+
 func TestReorgSideEvent(t *testing.T) {
 	var (
 		key1, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
@@ -1653,6 +1866,60 @@ func TestEIP155Transition(t *testing.T) {
 	}
 }
 
+// Tests that the Athena system contract upgrade is applied in the first block
+// at or past the fork timestamp, and only there.
+func TestAthenaTransition(t *testing.T) {
+	// Both the chain constructor and the test below overwrite the global genesis
+	// hash of the upgrade lookup, restore it once done
+	defer func(hash common.Hash) { systemcontracts.GenesisHash = hash }(systemcontracts.GenesisHash)
+
+	config := *params.TestChainConfig
+	config.AthenaTime = u64(25)
+
+	var (
+		gspec     = &Genesis{Config: &config, BaseFee: big.NewInt(params.InitialBaseFee)}
+		validator = common.HexToAddress(systemcontracts.ValidatorContract)
+	)
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	// Pretend to be on Pigeon for the upgrade lookup, the chain constructor
+	// sets the genesis hash of the test chain.
+	systemcontracts.GenesisHash = params.PigeonGenesisHash
+
+	_, blocks, _ := GenerateChainWithGenesis(gspec, ethash.NewFaker(), 4, nil)
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	var upgraded []byte
+	for _, block := range blocks {
+		statedb, err := chain.StateAt(block.Root())
+		if err != nil {
+			t.Fatalf("block %d: failed to open state: %v", block.NumberU64(), err)
+		}
+		code, athena := statedb.GetCode(validator), config.IsAthena(block.Number(), block.Time())
+		switch {
+		case !athena && len(code) != 0:
+			t.Fatalf("block %d: contract upgraded before athena", block.NumberU64())
+		case athena && len(code) == 0:
+			t.Fatalf("block %d: contract not upgraded after athena", block.NumberU64())
+		case athena && upgraded == nil:
+			if block.Time() < *config.AthenaTime || blocks[block.NumberU64()-2].Time() >= *config.AthenaTime {
+				t.Fatalf("block %d: upgrade applied outside the activation block", block.NumberU64())
+			}
+			upgraded = code
+		case athena && !bytes.Equal(code, upgraded):
+			t.Fatalf("block %d: contract code changed after activation", block.NumberU64())
+		}
+	}
+	if upgraded == nil {
+		t.Fatal("athena never activated")
+	}
+}
+
 func TestEIP161AccountRemoval(t *testing.T) {
 	// Configure and generate a sample block chain
 	var (
@@ -1864,1785 +2131,3465 @@ func TestLargeReorgTrieGC(t *testing.T) {
 	}
 }
 
-func TestBlockchainRecovery(t *testing.T) {
-	// Configure and generate a sample block chain
-	var (
-		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		address = crypto.PubkeyToAddress(key.PublicKey)
-		funds   = big.NewInt(1000000000)
-		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{address: {Balance: funds}}}
-	)
-	height := uint64(1024)
-	_, blocks, receipts := GenerateChainWithGenesis(gspec, ethash.NewFaker(), int(height), nil)
-
-	// Import the chain as a ancient-first node and ensure all pointers are updated
-	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
-	if err != nil {
-		t.Fatalf("failed to create temp freezer db: %v", err)
-	}
-	defer ancientDb.Close()
-	ancient, _ := NewBlockChain(ancientDb, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
-
-	headers := make([]*types.Header, len(blocks))
-	for i, block := range blocks {
-		headers[i] = block.Header()
-	}
-	if n, err := ancient.InsertHeaderChain(headers); err != nil {
-		t.Fatalf("failed to insert header %d: %v", n, err)
-	}
-	if n, err := ancient.InsertReceiptChain(blocks, receipts, uint64(3*len(blocks)/4)); err != nil {
-		t.Fatalf("failed to insert receipt %d: %v", n, err)
+// Tests that the number of retained unprocessed side chain blocks is capped by
+// evicting branch tips, and that a heavier fork longer than the cap still
+// triggers a reorg.
+func TestSideChainBlocksCap(t *testing.T) {
+	// Generate the canonical chain, a long but light side chain forking off early
+	// and a heavier fork branching off later
+	engine := ethash.NewFaker()
+	genesis := &Genesis{
+		Config:  params.TestChainConfig,
+		BaseFee: big.NewInt(params.InitialBaseFee),
 	}
-	rawdb.WriteLastPivotNumber(ancientDb, blocks[len(blocks)-1].NumberU64()) // Force fast sync behavior
-	ancient.Stop()
+	genDb, original, _ := GenerateChainWithGenesis(genesis, engine, 64, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{1}) })
+	junk, _ := GenerateChain(genesis.Config, original[0], engine, genDb, 50, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{2}) })
+	competitor, _ := GenerateChain(genesis.Config, original[20], engine, genDb, 45, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{3}) })
 
-	// Destroy head fast block manually
-	midBlock := blocks[len(blocks)/2]
-	rawdb.WriteHeadFastBlockHash(ancientDb, midBlock.Hash())
+	cacheConfig := DefaultCacheConfigWithScheme(rawdb.HashScheme)
+	cacheConfig.TriesInMemory = 16
+	cacheConfig.MaxPendingSideBlocks = 16
 
-	// Reopen broken blockchain again
-	ancient, _ = NewBlockChain(ancientDb, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
-	defer ancient.Stop()
-	if num := ancient.CurrentBlock().Number.Uint64(); num != 0 {
-		t.Errorf("head block mismatch: have #%v, want #%v", num, 0)
-	}
-	if num := ancient.CurrentSnapBlock().Number.Uint64(); num != midBlock.NumberU64() {
-		t.Errorf("head snap-block mismatch: have #%v, want #%v", num, midBlock.NumberU64())
-	}
-	if num := ancient.CurrentHeader().Number.Uint64(); num != midBlock.NumberU64() {
-		t.Errorf("head header mismatch: have #%v, want #%v", num, midBlock.NumberU64())
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), cacheConfig, genesis, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
 	}
-}
+	defer chain.Stop()
 
-// This test checks that InsertReceiptChain will roll back correctly when attempting to insert a side chain.
-func TestInsertReceiptChainRollback(t *testing.T) {
-	// Generate forked chain. The returned BlockChain object is used to process the side chain blocks.
-	tmpChain, sideblocks, canonblocks, gspec, err := getLongAndShortChains()
-	if err != nil {
-		t.Fatal(err)
+	if _, err := chain.InsertChain(original); err != nil {
+		t.Fatalf("failed to insert original chain: %v", err)
 	}
-	defer tmpChain.Stop()
-	// Get the side chain receipts.
-	if _, err := tmpChain.InsertChain(sideblocks); err != nil {
-		t.Fatal("processing side chain failed:", err)
+	if chain.HasState(original[20].Root()) {
+		t.Fatalf("fork point state not pruned")
 	}
-	t.Log("sidechain head:", tmpChain.CurrentBlock().Number, tmpChain.CurrentBlock().Hash())
-	sidechainReceipts := make([]types.Receipts, len(sideblocks))
-	for i, block := range sideblocks {
-		sidechainReceipts[i] = tmpChain.GetReceiptsByHash(block.Hash())
+	// Import the junk side chain and ensure its tip is evicted, not its ancestors
+	if _, err := chain.InsertChain(junk); err != nil {
+		t.Fatalf("failed to insert junk chain: %v", err)
 	}
-	// Get the canon chain receipts.
-	if _, err := tmpChain.InsertChain(canonblocks); err != nil {
-		t.Fatal("processing canon chain failed:", err)
+	if have, want := len(chain.sideBlocks), cacheConfig.MaxPendingSideBlocks; have != want {
+		t.Fatalf("retained side block count mismatch: have %d, want %d", have, want)
 	}
-	t.Log("canon head:", tmpChain.CurrentBlock().Number, tmpChain.CurrentBlock().Hash())
-	canonReceipts := make([]types.Receipts, len(canonblocks))
-	for i, block := range canonblocks {
-		canonReceipts[i] = tmpChain.GetReceiptsByHash(block.Hash())
+	for i, block := range junk {
+		if have, want := chain.HasBlock(block.Hash(), block.NumberU64()), i < cacheConfig.MaxPendingSideBlocks; have != want {
+			t.Errorf("junk block %d: presence mismatch: have %v, want %v", i, have, want)
+		}
 	}
-
-	// Set up a BlockChain that uses the ancient store.
-	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
-	if err != nil {
-		t.Fatalf("failed to create temp freezer db: %v", err)
+	if head := chain.CurrentBlock().Hash(); head != original[len(original)-1].Hash() {
+		t.Fatalf("head changed by light side chain: have %x, want %x", head, original[len(original)-1].Hash())
 	}
-	defer ancientDb.Close()
-
-	ancientChain, _ := NewBlockChain(ancientDb, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
-	defer ancientChain.Stop()
-
-	// Import the canonical header chain.
-	canonHeaders := make([]*types.Header, len(canonblocks))
-	for i, block := range canonblocks {
-		canonHeaders[i] = block.Header()
+	// Import the heavier fork, which is longer than the cap and must still reorg
+	if _, err := chain.InsertChain(competitor); err != nil {
+		t.Fatalf("failed to insert competitor chain: %v", err)
 	}
-	if _, err = ancientChain.InsertHeaderChain(canonHeaders); err != nil {
-		t.Fatal("can't import canon headers:", err)
+	if head := chain.CurrentBlock().Hash(); head != competitor[len(competitor)-1].Hash() {
+		t.Fatalf("reorg to heavier fork failed: have %x, want %x", head, competitor[len(competitor)-1].Hash())
 	}
-
-	// Try to insert blocks/receipts of the side chain.
-	_, err = ancientChain.InsertReceiptChain(sideblocks, sidechainReceipts, uint64(len(sideblocks)))
-	if err == nil {
-		t.Fatal("expected error from InsertReceiptChain.")
+	for i, block := range competitor {
+		if chain.GetCanonicalHash(block.NumberU64()) != block.Hash() {
+			t.Errorf("competitor block %d: not canonical", i)
+		}
+		if _, ok := chain.sideBlocks[block.Hash()]; ok {
+			t.Errorf("competitor block %d: still tracked after becoming canonical", i)
+		}
 	}
-	if ancientChain.CurrentSnapBlock().Number.Uint64() != 0 {
-		t.Fatalf("failed to rollback ancient data, want %d, have %d", 0, ancientChain.CurrentSnapBlock().Number)
+	if have, want := len(chain.sideBlocks), cacheConfig.MaxPendingSideBlocks; have != want {
+		t.Fatalf("retained side block count mismatch: have %d, want %d", have, want)
 	}
-	if frozen, err := ancientChain.db.Ancients(); err != nil || frozen != 1 {
-		t.Fatalf("failed to truncate ancient data, frozen index is %d", frozen)
+	for i, block := range junk[:cacheConfig.MaxPendingSideBlocks] {
+		if !chain.HasBlock(block.Hash(), block.NumberU64()) {
+			t.Errorf("junk block %d: evicted below the cap", i)
+		}
 	}
+}
 
-	// Insert blocks/receipts of the canonical chain.
-	_, err = ancientChain.InsertReceiptChain(canonblocks, canonReceipts, uint64(len(canonblocks)))
-	if err != nil {
-		t.Fatalf("can't import canon chain receipts: %v", err)
-	}
-	if ancientChain.CurrentSnapBlock().Number.Uint64() != canonblocks[len(canonblocks)-1].NumberU64() {
-		t.Fatalf("failed to insert ancient recept chain after rollback")
-	}
-	if frozen, _ := ancientChain.db.Ancients(); frozen != uint64(len(canonblocks))+1 {
-		t.Fatalf("wrong ancients count %d", frozen)
-	}
+// Tests that the trie flush cadence persists a state every configured number of
+// blocks, only ever flushing the states leaving the in-memory window.
+func TestTrieFlushEveryBlocks(t *testing.T) {
+	testTrieFlushEveryBlocks(t, 0, nil)
+	testTrieFlushEveryBlocks(t, 50, []uint64{50, 100, 150})
 }
 
-// Tests that importing a very large side fork, which is larger than the canon chain,
-// but where the difficulty per block is kept low: this means that it will not
-// overtake the 'canon' chain until after it's passed canon by about 200 blocks.
-//
-// Details at:
-//   - https://github.com/ethereum/go-ethereum/issues/18977
-//   - https://github.com/ethereum/go-ethereum/pull/18988
-func TestLowDiffLongChain(t *testing.T) {
-	// Generate a canonical chain to act as the main dataset
+func testTrieFlushEveryBlocks(t *testing.T, every uint64, want []uint64) {
 	engine := ethash.NewFaker()
 	genesis := &Genesis{
 		Config:  params.TestChainConfig,
 		BaseFee: big.NewInt(params.InitialBaseFee),
 	}
-	// We must use a pretty long chain to ensure that the fork doesn't overtake us
-	// until after at least 128 blocks post tip
-	genDb, blocks, _ := GenerateChainWithGenesis(genesis, engine, 6*TriesInMemory, func(i int, b *BlockGen) {
-		b.SetCoinbase(common.Address{1})
-		b.OffsetTime(-9)
-	})
+	_, blocks, _ := GenerateChainWithGenesis(genesis, engine, 300, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{1}) })
 
-	// Import the canonical chain
-	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, genesis, nil, engine, vm.Config{}, nil, nil)
+	db := rawdb.NewMemoryDatabase()
+	cacheConfig := DefaultCacheConfigWithScheme(rawdb.HashScheme)
+	cacheConfig.TrieTimeLimit = time.Hour // Only flush on the block cadence
+	cacheConfig.TrieFlushEveryBlocks = every
+
+	chain, err := NewBlockChain(db, cacheConfig, genesis, nil, engine, vm.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("failed to create tester chain: %v", err)
 	}
-	defer chain.stopWithoutSaving()
+	defer chain.Stop()
 
-	if n, err := chain.InsertChain(blocks); err != nil {
-		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	var flushes []uint64
+	for i := range blocks {
+		if _, err := chain.InsertChain(blocks[i : i+1]); err != nil {
+			t.Fatalf("block %d: failed to insert into chain: %v", i, err)
+		}
+		if flushed := rawdb.ReadSafePointBlockNumber(db); flushed != 0 && (len(flushes) == 0 || flushes[len(flushes)-1] != flushed) {
+			if head := blocks[i].NumberU64(); head-flushed != chain.triesInMemory {
+				t.Fatalf("every %d: state %d flushed at head %d, within the in-memory window", every, flushed, head)
+			}
+			flushes = append(flushes, flushed)
+		}
 	}
-	// Generate fork chain, starting from an early block
-	parent := blocks[10]
-	fork, _ := GenerateChain(genesis.Config, parent, engine, genDb, 8*TriesInMemory, func(i int, b *BlockGen) {
-		b.SetCoinbase(common.Address{2})
-	})
-
-	// And now import the fork
-	if i, err := chain.InsertChain(fork); err != nil {
-		t.Fatalf("block %d: failed to insert into chain: %v", i, err)
+	if !slices.Equal(flushes, want) {
+		t.Fatalf("every %d: flush points mismatch: have %v, want %v", every, flushes, want)
 	}
-	head := chain.CurrentBlock()
-	if got := fork[len(fork)-1].Hash(); got != head.Hash() {
-		t.Fatalf("head wrong, expected %x got %x", head.Hash(), got)
+	// Only the flushed states are on disk, the rest are either in memory or
+	// garbage collected
+	persisted := make(map[uint64]bool)
+	for _, number := range want {
+		persisted[number] = true
 	}
-	// Sanity check that all the canonical numbers are present
-	header := chain.CurrentHeader()
-	for number := head.Number.Uint64(); number > 0; number-- {
-		if hash := chain.GetHeaderByNumber(number).Hash(); hash != header.Hash() {
-			t.Fatalf("header %d: canonical hash mismatch: have %x, want %x", number, hash, header.Hash())
+	for _, block := range blocks {
+		if have := rawdb.HasLegacyTrieNode(db, block.Root()); have != persisted[block.NumberU64()] {
+			t.Errorf("every %d: block %d state persisted %v, want %v", every, block.NumberU64(), have, persisted[block.NumberU64()])
 		}
-		header = chain.GetHeader(header.ParentHash, number-1)
 	}
 }
 
-// Tests that importing a sidechain (S), where
-// - S is sidechain, containing blocks [Sn...Sm]
-// - C is canon chain, containing blocks [G..Cn..Cm]
-// - A common ancestor is placed at prune-point + blocksBetweenCommonAncestorAndPruneblock
-// - The sidechain S is prepended with numCanonBlocksInSidechain blocks from the canon chain
-//
-// The mergePoint can be these values:
-// -1: the transition won't happen
-// 0:  the transition happens since genesis
-// 1:  the transition happens after some chain segments
-func testSideImport(t *testing.T, numCanonBlocksInSidechain, blocksBetweenCommonAncestorAndPruneblock int, mergePoint int) {
-	// Generate a canonical chain to act as the main dataset
-	chainConfig := *params.TestChainConfig
+// Tests that the full state of a single contract can be dumped from the chain,
+// both collected and streamed, and imported into a fresh state.
+func TestDumpAccount(t *testing.T) {
 	var (
-		merger = consensus.NewMerger(rawdb.NewMemoryDatabase())
-		engine = beacon.New(ethash.NewFaker())
-		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		addr   = crypto.PubkeyToAddress(key.PublicKey)
-		nonce  = uint64(0)
-
-		gspec = &Genesis{
-			Config:  &chainConfig,
-			Alloc:   GenesisAlloc{addr: {Balance: big.NewInt(math.MaxInt64)}},
-			BaseFee: big.NewInt(params.InitialBaseFee),
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address  = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.HexToAddress("0x000000000000000000000000000000000000cafe")
+		engine   = ethash.NewFaker()
+		gspec    = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				address: {Balance: big.NewInt(params.Ether)},
+				// Configuration slots set up at genesis, plus code storing
+				// 0x2a into slot 5 when called
+				contract: {
+					Balance: big.NewInt(1),
+					Code:    common.FromHex("0x602a60055500"),
+					Storage: map[common.Hash]common.Hash{
+						common.BigToHash(big.NewInt(0)): common.BigToHash(big.NewInt(3)),
+						common.BigToHash(big.NewInt(1)): common.HexToHash("0xdeadbeef"),
+						common.BigToHash(big.NewInt(2)): common.BigToHash(big.NewInt(1)),
+					},
+				},
+			},
 		}
-		signer     = types.LatestSigner(gspec.Config)
-		mergeBlock = math.MaxInt32
+		signer = types.LatestSigner(gspec.Config)
 	)
-	// Generate and import the canonical chain
-	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(address), contract, common.Big0, 100000, b.header.BaseFee, nil), signer, key)
+		b.AddTx(tx)
+	})
+	cacheConfig := DefaultCacheConfigWithScheme(rawdb.HashScheme)
+	cacheConfig.Preimages = true // Needed to recover the storage keys
+
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), cacheConfig, gspec, nil, engine, vm.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("failed to create tester chain: %v", err)
 	}
 	defer chain.Stop()
 
-	// Activate the transition since genesis if required
-	if mergePoint == 0 {
-		mergeBlock = 0
-		merger.ReachTTD()
-		merger.FinalizePoS()
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	root := chain.CurrentBlock().Root
 
-		// Set the terminal total difficulty in the config
-		gspec.Config.TerminalTotalDifficulty = big.NewInt(0)
+	dump, err := chain.DumpAccount(root, contract)
+	if err != nil {
+		t.Fatalf("failed to dump account: %v", err)
+	}
+	want := &state.AccountState{
+		Balance: big.NewInt(1),
+		Code:    common.FromHex("0x602a60055500"),
+		Storage: map[common.Hash]common.Hash{
+			common.BigToHash(big.NewInt(0)): common.BigToHash(big.NewInt(3)),
+			common.BigToHash(big.NewInt(1)): common.HexToHash("0xdeadbeef"),
+			common.BigToHash(big.NewInt(2)): common.BigToHash(big.NewInt(1)),
+			common.BigToHash(big.NewInt(5)): common.BigToHash(big.NewInt(0x2a)),
+		},
 	}
-	genDb, blocks, _ := GenerateChainWithGenesis(gspec, engine, 2*TriesInMemory, func(i int, gen *BlockGen) {
-		tx, err := types.SignTx(types.NewTransaction(nonce, common.HexToAddress("deadbeef"), big.NewInt(100), 21000, big.NewInt(int64(i+1)*params.GWei), nil), signer, key)
-		if err != nil {
-			t.Fatalf("failed to create tx: %v", err)
-		}
-		gen.AddTx(tx)
-		if int(gen.header.Number.Uint64()) >= mergeBlock {
-			gen.SetPoS()
-		}
-		nonce++
+	if !reflect.DeepEqual(dump, want) {
+		t.Fatalf("account dump mismatch:\nhave %+v\nwant %+v", dump, want)
+	}
+	// Streaming the storage must yield the same slots without collecting them
+	streamed := make(map[common.Hash]common.Hash)
+	account, err := chain.StreamAccount(root, contract, func(key, value common.Hash) error {
+		streamed[key] = value
+		return nil
 	})
-	if n, err := chain.InsertChain(blocks); err != nil {
-		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	if err != nil {
+		t.Fatalf("failed to stream account: %v", err)
 	}
+	if account.Storage != nil {
+		t.Errorf("streamed storage collected into the account state: %v", account.Storage)
+	}
+	if !reflect.DeepEqual(streamed, want.Storage) {
+		t.Errorf("streamed storage mismatch: have %v, want %v", streamed, want.Storage)
+	}
+	if _, err := chain.DumpAccount(root, common.Address{0xff}); err == nil {
+		t.Error("dumped non-existent account")
+	}
+	// Import the dump into a fresh state and ensure the storage trie matches
+	sdb := state.NewDatabaseWithConfig(rawdb.NewMemoryDatabase(), &trie.Config{Preimages: true})
+	statedb, _ := state.New(types.EmptyRootHash, sdb, nil)
+	if err := statedb.ImportAccountState(contract, dump); err != nil {
+		t.Fatalf("failed to import account: %v", err)
+	}
+	statedb.Finalise(false)
+	statedb.AccountsIntermediateRoot()
+	imported, _, err := statedb.Commit(0, nil)
+	if err != nil {
+		t.Fatalf("failed to commit imported state: %v", err)
+	}
+	statedb, _ = state.New(imported, sdb, nil)
+	redump, err := statedb.DumpAccountState(contract, nil)
+	if err != nil {
+		t.Fatalf("failed to dump imported account: %v", err)
+	}
+	if !reflect.DeepEqual(redump, want) {
+		t.Fatalf("imported account mismatch:\nhave %+v\nwant %+v", redump, want)
+	}
+	origin, _ := chain.StateAt(root)
+	have, _ := statedb.StorageTrie(contract)
+	expect, _ := origin.StorageTrie(contract)
+	if have.Hash() != expect.Hash() {
+		t.Errorf("imported storage root mismatch: have %x, want %x", have.Hash(), expect.Hash())
+	}
+	// Without preimages, the slots must be keyed by their hash
+	plain, err := NewBlockChain(rawdb.NewMemoryDatabase(), DefaultCacheConfigWithScheme(rawdb.HashScheme), gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer plain.Stop()
 
-	lastPrunedIndex := len(blocks) - TestTriesInMemory - 1
-	lastPrunedBlock := blocks[lastPrunedIndex-1]
-	firstNonPrunedBlock := blocks[len(blocks)-TestTriesInMemory]
-
-	// Verify pruning of lastPrunedBlock
-	if chain.HasBlockAndState(lastPrunedBlock.Hash(), lastPrunedBlock.NumberU64()) {
-		t.Errorf("Block %d not pruned", lastPrunedBlock.NumberU64())
+	if _, err := plain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
 	}
-	// Verify firstNonPrunedBlock is not pruned
-	if !chain.HasBlockAndState(firstNonPrunedBlock.Hash(), firstNonPrunedBlock.NumberU64()) {
-		t.Errorf("Block %d pruned", firstNonPrunedBlock.NumberU64())
+	hashed, err := plain.DumpAccount(root, contract)
+	if err != nil {
+		t.Fatalf("failed to dump account without preimages: %v", err)
+	}
+	if hashed.MissingPreimages != len(want.Storage) {
+		t.Errorf("missing preimages mismatch: have %d, want %d", hashed.MissingPreimages, len(want.Storage))
 	}
+	for key, value := range want.Storage {
+		if have := hashed.Storage[crypto.Keccak256Hash(key.Bytes())]; have != value {
+			t.Errorf("hashed slot %x mismatch: have %x, want %x", key, have, value)
+		}
+	}
+	if err := statedb.ImportAccountState(contract, hashed); err == nil {
+		t.Error("imported account state keyed by hashes")
+	}
+}
 
-	// Activate the transition in the middle of the chain
-	if mergePoint == 1 {
-		merger.ReachTTD()
-		merger.FinalizePoS()
-		// Set the terminal total difficulty in the config
-		ttd := big.NewInt(int64(len(blocks)))
-		ttd.Mul(ttd, params.GenesisDifficulty)
-		gspec.Config.TerminalTotalDifficulty = ttd
-		mergeBlock = len(blocks)
+// Tests that states retrieved with StateAtWithRelease keep their trie nodes
+// alive until released, and that releasing them leaves no nodes behind.
+func TestStateAtWithRelease(t *testing.T) {
+	var (
+		engine = ethash.NewFaker()
+		gspec  = &Genesis{Config: params.TestChainConfig}
+	)
+	// Mining rewards change the state of every block
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 10, func(i int, b *BlockGen) {})
+
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), DefaultCacheConfigWithScheme(rawdb.HashScheme), gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
 	}
+	defer chain.Stop()
 
-	// Generate the sidechain
-	// First block should be a known block, block after should be a pruned block. So
-	// canon(pruned), side, side...
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	_, baseline, _, _ := chain.TrieDB().Size()
 
-	// Generate fork chain, make it longer than canon
-	parentIndex := lastPrunedIndex + blocksBetweenCommonAncestorAndPruneblock
-	parent := blocks[parentIndex]
-	fork, _ := GenerateChain(gspec.Config, parent, engine, genDb, 2*TriesInMemory, func(i int, b *BlockGen) {
-		b.SetCoinbase(common.Address{2})
-		if int(b.header.Number.Uint64()) >= mergeBlock {
-			b.SetPoS()
+	// Open and release a large number of historical states
+	for i := 0; i < 1000; i++ {
+		statedb, release, err := chain.StateAtWithRelease(blocks[i%len(blocks)].Root())
+		if err != nil {
+			t.Fatalf("failed to open state %d: %v", i, err)
 		}
-	})
-	// Prepend the parent(s)
-	var sidechain []*types.Block
-	for i := numCanonBlocksInSidechain; i > 0; i-- {
-		sidechain = append(sidechain, blocks[parentIndex+1-i])
+		statedb.GetBalance(common.Address{})
+		release()
 	}
-	sidechain = append(sidechain, fork...)
-	n, err := chain.InsertChain(sidechain)
+	if _, size, _, _ := chain.TrieDB().Size(); size != baseline {
+		t.Fatalf("trie database size mismatch after release: have %v, want %v", size, baseline)
+	}
+	// Ensure a held state survives the chain dropping its own reference
+	root := blocks[5].Root()
+	statedb, release, err := chain.StateAtWithRelease(root)
 	if err != nil {
-		t.Errorf("Got error, %v number %d - %d", err, sidechain[n].NumberU64(), n)
+		t.Fatalf("failed to open state: %v", err)
 	}
-	head := chain.CurrentBlock()
-	if got := fork[len(fork)-1].Hash(); got != head.Hash() {
-		t.Fatalf("head wrong, expected %x got %x", head.Hash(), got)
+	chain.TrieDB().Dereference(root)
+	if _, size, _, _ := chain.TrieDB().Size(); size != baseline {
+		t.Fatalf("held state garbage collected: have %v, want %v", size, baseline)
 	}
-}
+	if statedb.GetBalance(common.Address{}).Sign() == 0 {
+		t.Fatal("held state lost the mining rewards")
+	}
+	release()
+	release() // Releasing twice must be harmless
 
-// Tests that importing a sidechain (S), where
-//   - S is sidechain, containing blocks [Sn...Sm]
-//   - C is canon chain, containing blocks [G..Cn..Cm]
-//   - The common ancestor Cc is pruned
-//   - The first block in S: Sn, is == Cn
-//
-// That is: the sidechain for import contains some blocks already present in canon chain.
-// So the blocks are:
-//
-//	[ Cn, Cn+1, Cc, Sn+3 ... Sm]
-//	^    ^    ^  pruned
-func TestPrunedImportSide(t *testing.T) {
-	//glogger := log.NewGlogHandler(log.StreamHandler(os.Stdout, log.TerminalFormat(false)))
-	//glogger.Verbosity(3)
-	//log.Root().SetHandler(log.Handler(glogger))
-	testSideImport(t, 3, 3, -1)
-	testSideImport(t, 3, -3, -1)
-	testSideImport(t, 10, 0, -1)
-	testSideImport(t, 1, 10, -1)
-	testSideImport(t, 1, -10, -1)
+	if _, size, _, _ := chain.TrieDB().Size(); size >= baseline {
+		t.Fatalf("released state not garbage collected: have %v, baseline %v", size, baseline)
+	}
 }
 
-func TestPrunedImportSideWithMerging(t *testing.T) {
-	//glogger := log.NewGlogHandler(log.StreamHandler(os.Stdout, log.TerminalFormat(false)))
-	//glogger.Verbosity(3)
-	//log.Root().SetHandler(log.Handler(glogger))
-	testSideImport(t, 3, 3, 0)
-	testSideImport(t, 3, -3, 0)
-	testSideImport(t, 10, 0, 0)
-	testSideImport(t, 1, 10, 0)
-	testSideImport(t, 1, -10, 0)
+// Tests that a pruned historical state can be recomputed from an earlier retained
+// state, as long as it is within the configured limit.
+func TestRecomputeState(t *testing.T) {
+	var (
+		engine = ethash.NewFaker()
+		gspec  = &Genesis{Config: params.TestChainConfig}
+		db     = rawdb.NewMemoryDatabase()
+	)
+	// Mining rewards change the state of every block
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 10, func(i int, b *BlockGen) {})
 
-	testSideImport(t, 3, 3, 1)
-	testSideImport(t, 3, -3, 1)
-	testSideImport(t, 10, 0, 1)
-	testSideImport(t, 1, 10, 1)
-	testSideImport(t, 1, -10, 1)
-}
+	// Import the chain in archive mode, then prune the state of blocks #6 and #7
+	cacheConfig := DefaultCacheConfigWithScheme(rawdb.HashScheme)
+	cacheConfig.TrieDirtyDisabled = true
+	cacheConfig.SnapshotLimit = 0
 
-func TestInsertKnownHeaders(t *testing.T)      { testInsertKnownChainData(t, "headers") }
-func TestInsertKnownReceiptChain(t *testing.T) { testInsertKnownChainData(t, "receipts") }
-func TestInsertKnownBlocks(t *testing.T)       { testInsertKnownChainData(t, "blocks") }
+	chain, err := NewBlockChain(db, cacheConfig, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	chain.Stop()
 
-func testInsertKnownChainData(t *testing.T, typ string) {
-	engine := ethash.NewFaker()
-	genesis := &Genesis{
-		Config:  params.TestChainConfig,
-		BaseFee: big.NewInt(params.InitialBaseFee),
+	rawdb.DeleteLegacyTrieNode(db, blocks[5].Root())
+	rawdb.DeleteLegacyTrieNode(db, blocks[6].Root())
+
+	cacheConfig.MaxRecomputeBlocks = 1
+	chain, err = NewBlockChain(db, cacheConfig, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to reopen tester chain: %v", err)
 	}
-	genDb, blocks, receipts := GenerateChainWithGenesis(genesis, engine, 32, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{1}) })
+	defer chain.Stop()
 
-	// A longer chain but total difficulty is lower.
-	blocks2, receipts2 := GenerateChain(genesis.Config, blocks[len(blocks)-1], engine, genDb, 65, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{1}) })
+	target := blocks[6]
+	if _, err := chain.StateAt(target.Root()); err == nil {
+		t.Fatal("pruned state still available")
+	}
+	// The nearest retained state is two blocks back, out of reach with a limit of one
+	if _, _, err := chain.RecomputeState(target); !errors.Is(err, ErrNoReachableState) {
+		t.Fatalf("recompute error mismatch: have %v, want %v", err, ErrNoReachableState)
+	}
+	chain.cacheConfig.MaxRecomputeBlocks = 2
 
-	// A shorter chain but total difficulty is higher.
-	blocks3, receipts3 := GenerateChain(genesis.Config, blocks[len(blocks)-1], engine, genDb, 64, func(i int, b *BlockGen) {
-		b.SetCoinbase(common.Address{1})
-		b.OffsetTime(-9) // A higher difficulty
-	})
-	// Import the shared chain and the original canonical one
-	chaindb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
+	statedb, release, err := chain.RecomputeState(target)
 	if err != nil {
-		t.Fatalf("failed to create temp freezer db: %v", err)
+		t.Fatalf("failed to recompute state: %v", err)
 	}
-	defer chaindb.Close()
+	defer release()
 
-	chain, err := NewBlockChain(chaindb, nil, genesis, nil, engine, vm.Config{}, nil, nil)
+	if root := statedb.IntermediateRoot(false); root != target.Root() {
+		t.Fatalf("recomputed state root mismatch: have %x, want %x", root, target.Root())
+	}
+	// The live database must not be affected by the recomputation
+	if _, err := chain.StateAt(target.Root()); err == nil {
+		t.Fatal("recomputed state leaked into the live database")
+	}
+}
+
+// Tests that a persisted head block diverged from the head header is rolled back
+// onto the canonical chain on startup, leaving the header chain untouched.
+func TestRepairDivergedHeads(t *testing.T) {
+	var (
+		engine = ethash.NewFaker()
+		gspec  = &Genesis{Config: params.TestChainConfig}
+		db     = rawdb.NewMemoryDatabase()
+	)
+	genDb, blocks, _ := GenerateChainWithGenesis(gspec, engine, 10, func(i int, b *BlockGen) {})
+	forks, _ := GenerateChain(gspec.Config, blocks[4], engine, genDb, 3, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{0x01})
+	})
+	// Keep every state on disk, so that the repair doesn't need to rewind further
+	cacheConfig := DefaultCacheConfigWithScheme(rawdb.HashScheme)
+	cacheConfig.TrieDirtyDisabled = true
+	cacheConfig.SnapshotLimit = 0
+
+	chain, err := NewBlockChain(db, cacheConfig, gspec, nil, engine, vm.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("failed to create tester chain: %v", err)
 	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	if _, err := chain.InsertChain(forks); err != nil {
+		t.Fatalf("failed to insert side chain: %v", err)
+	}
+	chain.Stop()
+
+	// Corrupt the head block marker to point into the side chain
+	rawdb.WriteHeadBlockHash(db, forks[2].Hash())
+
+	chain, err = NewBlockChain(db, cacheConfig, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to reopen tester chain: %v", err)
+	}
 	defer chain.Stop()
 
+	want := blocks[forks[2].NumberU64()-1]
+	if head := chain.CurrentBlock(); head.Hash() != want.Hash() {
+		t.Fatalf("head block mismatch: have #%d [%x], want #%d [%x]", head.Number, head.Hash(), want.NumberU64(), want.Hash())
+	}
+	if head := rawdb.ReadHeadBlockHash(db); head != want.Hash() {
+		t.Fatalf("persisted head block mismatch: have %x, want %x", head, want.Hash())
+	}
+	if head := chain.CurrentHeader(); head.Hash() != blocks[len(blocks)-1].Hash() {
+		t.Fatalf("head header rewound: have #%d, want #%d", head.Number, blocks[len(blocks)-1].NumberU64())
+	}
+	// The remaining blocks must import on top of the repaired head
+	if _, err := chain.InsertChain(blocks[want.NumberU64():]); err != nil {
+		t.Fatalf("failed to reimport chain: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != blocks[len(blocks)-1].Hash() {
+		t.Fatalf("head block mismatch after reimport: have #%d, want #%d", head.Number, blocks[len(blocks)-1].NumberU64())
+	}
+}
+
+// Tests that a diverged head block is rolled back onto the canonical chain even
+// if the state of the repaired head is missing, which is then recovered by
+// rewinding further.
+func TestRepairDivergedHeadsMissingState(t *testing.T) {
 	var (
-		inserter func(blocks []*types.Block, receipts []types.Receipts) error
-		asserter func(t *testing.T, block *types.Block)
+		engine = ethash.NewFaker()
+		gspec  = &Genesis{Config: params.TestChainConfig}
+		db     = rawdb.NewMemoryDatabase()
 	)
-	if typ == "headers" {
-		inserter = func(blocks []*types.Block, receipts []types.Receipts) error {
-			headers := make([]*types.Header, 0, len(blocks))
-			for _, block := range blocks {
-				headers = append(headers, block.Header())
-			}
-			_, err := chain.InsertHeaderChain(headers)
-			return err
-		}
-		asserter = func(t *testing.T, block *types.Block) {
-			if chain.CurrentHeader().Hash() != block.Hash() {
-				t.Fatalf("current head header mismatch, have %v, want %v", chain.CurrentHeader().Hash().Hex(), block.Hash().Hex())
-			}
-		}
-	} else if typ == "receipts" {
-		inserter = func(blocks []*types.Block, receipts []types.Receipts) error {
-			headers := make([]*types.Header, 0, len(blocks))
-			for _, block := range blocks {
-				headers = append(headers, block.Header())
-			}
-			_, err := chain.InsertHeaderChain(headers)
-			if err != nil {
-				return err
-			}
-			_, err = chain.InsertReceiptChain(blocks, receipts, 0)
-			return err
-		}
-		asserter = func(t *testing.T, block *types.Block) {
-			if chain.CurrentSnapBlock().Hash() != block.Hash() {
-				t.Fatalf("current head fast block mismatch, have %v, want %v", chain.CurrentSnapBlock().Hash().Hex(), block.Hash().Hex())
-			}
-		}
-	} else {
-		inserter = func(blocks []*types.Block, receipts []types.Receipts) error {
-			_, err := chain.InsertChain(blocks)
-			return err
-		}
-		asserter = func(t *testing.T, block *types.Block) {
-			if chain.CurrentBlock().Hash() != block.Hash() {
-				t.Fatalf("current head block mismatch, have %v, want %v", chain.CurrentBlock().Hash().Hex(), block.Hash().Hex())
-			}
-		}
-	}
+	genDb, blocks, _ := GenerateChainWithGenesis(gspec, engine, 10, func(i int, b *BlockGen) {})
+	forks, _ := GenerateChain(gspec.Config, blocks[4], engine, genDb, 3, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{0x01})
+	})
+	// Only the genesis state is flushed to disk
+	cacheConfig := DefaultCacheConfigWithScheme(rawdb.HashScheme)
+	cacheConfig.SnapshotLimit = 0
 
-	if err := inserter(blocks, receipts); err != nil {
-		t.Fatalf("failed to insert chain data: %v", err)
+	chain, err := NewBlockChain(db, cacheConfig, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
 	}
-
-	// Reimport the chain data again. All the imported
-	// chain data are regarded "known" data.
-	if err := inserter(blocks, receipts); err != nil {
-		t.Fatalf("failed to insert chain data: %v", err)
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
 	}
-	asserter(t, blocks[len(blocks)-1])
+	if _, err := chain.InsertChain(forks); err != nil {
+		t.Fatalf("failed to insert side chain: %v", err)
+	}
+	chain.StopInsert()
+	chain.Stop()
 
-	// Import a long canonical chain with some known data as prefix.
-	rollback := blocks[len(blocks)/2].NumberU64()
+	// Corrupt the head block marker to point into the side chain
+	rawdb.WriteHeadBlockHash(db, forks[2].Hash())
 
-	chain.SetHead(rollback - 1)
-	if err := inserter(append(blocks, blocks2...), append(receipts, receipts2...)); err != nil {
-		t.Fatalf("failed to insert chain data: %v", err)
+	chain, err = NewBlockChain(db, cacheConfig, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to reopen tester chain: %v", err)
 	}
-	asserter(t, blocks2[len(blocks2)-1])
+	defer chain.Stop()
 
-	// Import a heavier shorter but higher total difficulty chain with some known data as prefix.
-	if err := inserter(append(blocks, blocks3...), append(receipts, receipts3...)); err != nil {
-		t.Fatalf("failed to insert chain data: %v", err)
+	// The state of block 8 was never flushed, the chain must have rewound below
+	head := chain.CurrentBlock()
+	if head.Number.Uint64() >= forks[2].NumberU64() || chain.GetCanonicalHash(head.Number.Uint64()) != head.Hash() {
+		t.Fatalf("head block not repaired onto the canonical chain: #%d [%x]", head.Number, head.Hash())
 	}
-	asserter(t, blocks3[len(blocks3)-1])
-
-	// Import a longer but lower total difficulty chain with some known data as prefix.
-	if err := inserter(append(blocks, blocks2...), append(receipts, receipts2...)); err != nil {
-		t.Fatalf("failed to insert chain data: %v", err)
+	if !chain.HasState(head.Root) {
+		t.Fatalf("state of repaired head #%d missing", head.Number)
 	}
-	// The head shouldn't change.
-	asserter(t, blocks3[len(blocks3)-1])
-
-	// Rollback the heavier chain and re-insert the longer chain again
-	chain.SetHead(rollback - 1)
-	if err := inserter(append(blocks, blocks2...), append(receipts, receipts2...)); err != nil {
-		t.Fatalf("failed to insert chain data: %v", err)
+	if _, err := chain.InsertChain(blocks[head.Number.Uint64():]); err != nil {
+		t.Fatalf("failed to reimport chain: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != blocks[len(blocks)-1].Hash() {
+		t.Fatalf("head block mismatch after reimport: have #%d, want #%d", head.Number, blocks[len(blocks)-1].NumberU64())
 	}
-	asserter(t, blocks2[len(blocks2)-1])
-}
-
-func TestInsertKnownHeadersWithMerging(t *testing.T) {
-	testInsertKnownChainDataWithMerging(t, "headers", 0)
-}
-func TestInsertKnownReceiptChainWithMerging(t *testing.T) {
-	testInsertKnownChainDataWithMerging(t, "receipts", 0)
-}
-func TestInsertKnownBlocksWithMerging(t *testing.T) {
-	testInsertKnownChainDataWithMerging(t, "blocks", 0)
-}
-func TestInsertKnownHeadersAfterMerging(t *testing.T) {
-	testInsertKnownChainDataWithMerging(t, "headers", 1)
-}
-func TestInsertKnownReceiptChainAfterMerging(t *testing.T) {
-	testInsertKnownChainDataWithMerging(t, "receipts", 1)
-}
-func TestInsertKnownBlocksAfterMerging(t *testing.T) {
-	testInsertKnownChainDataWithMerging(t, "blocks", 1)
 }
 
-// mergeHeight can be assigned in these values:
-// 0: means the merging is applied since genesis
-// 1: means the merging is applied after the first segment
-func testInsertKnownChainDataWithMerging(t *testing.T, typ string, mergeHeight int) {
-	// Copy the TestChainConfig so we can modify it during tests
-	chainConfig := *params.TestChainConfig
+// FuzzInsertChain imports randomly shuffled, duplicated and truncated sequences
+// of a valid chain, ensuring the canonical chain stays consistent whatever the
+// order the blocks arrive in.
+func FuzzInsertChain(f *testing.F) {
 	var (
-		genesis = &Genesis{
-			BaseFee: big.NewInt(params.InitialBaseFee),
-			Config:  &chainConfig,
-		}
-		engine     = beacon.New(ethash.NewFaker())
-		mergeBlock = uint64(math.MaxUint64)
+		engine = ethash.NewFaker()
+		gspec  = &Genesis{Config: params.TestChainConfig}
 	)
-	// Apply merging since genesis
-	if mergeHeight == 0 {
-		genesis.Config.TerminalTotalDifficulty = big.NewInt(0)
-		mergeBlock = uint64(0)
-	}
-
-	genDb, blocks, receipts := GenerateChainWithGenesis(genesis, engine, 32,
-		func(i int, b *BlockGen) {
-			if b.header.Number.Uint64() >= mergeBlock {
-				b.SetPoS()
+	// Mining rewards change the state of every block
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 16, func(i int, b *BlockGen) {})
+
+	f.Add([]byte{})
+	f.Add([]byte{0, 0x31, 0, 0x75, 1, 3, 2, 11})
+	f.Add([]byte{2, 7, 1, 2, 0, 0xf0})
+	f.Add([]byte{0, 0x0f, 0, 0xe1, 1, 9, 1, 9, 0, 0x5a})
+
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		// Mutate the block sequence as instructed by the operation pairs
+		seq := append([]*types.Block{}, blocks...)
+		for i := 0; i+1 < len(ops) && len(seq) > 0; i += 2 {
+			arg := int(ops[i+1])
+			switch ops[i] % 3 {
+			case 0: // Swap two blocks
+				a, b := arg%len(seq), (arg>>4)%len(seq)
+				seq[a], seq[b] = seq[b], seq[a]
+			case 1: // Duplicate a block in place
+				at := arg % len(seq)
+				seq = append(seq[:at+1], append([]*types.Block{seq[at]}, seq[at+1:]...)...)
+			case 2: // Truncate the sequence
+				seq = seq[:arg%len(seq)+1]
 			}
-			b.SetCoinbase(common.Address{1})
-		})
+		}
+		var highest uint64
+		for _, block := range seq {
+			if block.NumberU64() > highest {
+				highest = block.NumberU64()
+			}
+		}
+		chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to create tester chain: %v", err)
+		}
+		defer chain.Stop()
 
-	// Apply merging after the first segment
-	if mergeHeight == 1 {
-		// TTD is genesis diff + blocks
-		ttd := big.NewInt(1 + int64(len(blocks)))
-		ttd.Mul(ttd, params.GenesisDifficulty)
-		genesis.Config.TerminalTotalDifficulty = ttd
-		mergeBlock = uint64(len(blocks))
-	}
-	// Longer chain and shorter chain
-	blocks2, receipts2 := GenerateChain(genesis.Config, blocks[len(blocks)-1], engine, genDb, 65, func(i int, b *BlockGen) {
-		b.SetCoinbase(common.Address{1})
-		if b.header.Number.Uint64() >= mergeBlock {
-			b.SetPoS()
+		check := func(stage string) {
+			head := chain.CurrentBlock()
+			if head.Number.Uint64() > highest {
+				t.Fatalf("%s: head #%d beyond highest imported block #%d", stage, head.Number, highest)
+			}
+			for n := uint64(0); n <= head.Number.Uint64(); n++ {
+				if chain.GetBlockByNumber(n) == nil {
+					t.Fatalf("%s: canonical block #%d missing below head #%d", stage, n, head.Number)
+				}
+			}
+			if !chain.HasState(head.Root) {
+				t.Fatalf("%s: state of head #%d missing", stage, head.Number)
+			}
 		}
-	})
-	blocks3, receipts3 := GenerateChain(genesis.Config, blocks[len(blocks)-1], engine, genDb, 64, func(i int, b *BlockGen) {
-		b.SetCoinbase(common.Address{1})
-		b.OffsetTime(-9) // Time shifted, difficulty shouldn't be changed
-		if b.header.Number.Uint64() >= mergeBlock {
-			b.SetPoS()
+		// Import the sequence as a single batch, then block by block. Errors are
+		// expected for out of order blocks, the chain must stay consistent anyway.
+		chain.InsertChain(seq)
+		check("batch")
+
+		for _, block := range seq {
+			chain.InsertChain(types.Blocks{block})
 		}
+		check("single")
 	})
-	// Import the shared chain and the original canonical one
-	chaindb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
-	if err != nil {
-		t.Fatalf("failed to create temp freezer db: %v", err)
-	}
-	defer chaindb.Close()
-
-	chain, err := NewBlockChain(chaindb, nil, genesis, nil, engine, vm.Config{}, nil, nil)
-	if err != nil {
-		t.Fatalf("failed to create tester chain: %v", err)
-	}
-	defer chain.Stop()
+}
 
+// Tests that receipt proofs can be generated for live and frozen receipts and
+// verified against the receipts root of the block.
+func TestGetReceiptProof(t *testing.T) {
 	var (
-		inserter func(blocks []*types.Block, receipts []types.Receipts) error
-		asserter func(t *testing.T, block *types.Block)
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		engine  = ethash.NewFaker()
+		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{address: {Balance: big.NewInt(params.Ether)}}}
+		signer  = types.LatestSigner(gspec.Config)
 	)
-	if typ == "headers" {
-		inserter = func(blocks []*types.Block, receipts []types.Receipts) error {
-			headers := make([]*types.Header, 0, len(blocks))
-			for _, block := range blocks {
-				headers = append(headers, block.Header())
-			}
-			i, err := chain.InsertHeaderChain(headers)
-			if err != nil {
-				return fmt.Errorf("index %d, number %d: %w", i, headers[i].Number, err)
-			}
-			return err
+	genDb, blocks, receipts := GenerateChainWithGenesis(gspec, engine, 8, func(i int, b *BlockGen) {
+		for j := 0; j < 3; j++ {
+			tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(address), common.Address{0x01}, big.NewInt(1), params.TxGas, b.BaseFee(), nil), signer, key)
+			b.AddTx(tx)
 		}
-		asserter = func(t *testing.T, block *types.Block) {
-			if chain.CurrentHeader().Hash() != block.Hash() {
-				t.Fatalf("current head header mismatch, have %v, want %v", chain.CurrentHeader().Hash().Hex(), block.Hash().Hex())
-			}
+	})
+	verify := func(chain *BlockChain, block *types.Block, index int) {
+		t.Helper()
+
+		tx := block.Transactions()[index]
+		receipt, proof, err := chain.GetReceiptProof(tx.Hash())
+		if err != nil {
+			t.Fatalf("block #%d tx %d: failed to get receipt proof: %v", block.NumberU64(), index, err)
 		}
-	} else if typ == "receipts" {
-		inserter = func(blocks []*types.Block, receipts []types.Receipts) error {
-			headers := make([]*types.Header, 0, len(blocks))
-			for _, block := range blocks {
-				headers = append(headers, block.Header())
-			}
-			i, err := chain.InsertHeaderChain(headers)
-			if err != nil {
-				return fmt.Errorf("index %d: %w", i, err)
-			}
-			_, err = chain.InsertReceiptChain(blocks, receipts, 0)
-			return err
+		if receipt.TxHash != tx.Hash() {
+			t.Fatalf("block #%d tx %d: receipt mismatch: have %x, want %x", block.NumberU64(), index, receipt.TxHash, tx.Hash())
 		}
-		asserter = func(t *testing.T, block *types.Block) {
-			if chain.CurrentSnapBlock().Hash() != block.Hash() {
-				t.Fatalf("current head fast block mismatch, have %v, want %v", chain.CurrentSnapBlock().Hash().Hex(), block.Hash().Hex())
-			}
+		proofDb := rawdb.NewMemoryDatabase()
+		for _, node := range proof {
+			proofDb.Put(crypto.Keccak256(node), node)
 		}
-	} else {
-		inserter = func(blocks []*types.Block, receipts []types.Receipts) error {
-			i, err := chain.InsertChain(blocks)
-			if err != nil {
-				return fmt.Errorf("index %d: %w", i, err)
-			}
-			return nil
+		have, err := trie.VerifyProof(block.ReceiptHash(), rlp.AppendUint64(nil, uint64(index)), proofDb)
+		if err != nil {
+			t.Fatalf("block #%d tx %d: invalid proof: %v", block.NumberU64(), index, err)
 		}
-		asserter = func(t *testing.T, block *types.Block) {
-			if chain.CurrentBlock().Hash() != block.Hash() {
-				t.Fatalf("current head block mismatch, have %v, want %v", chain.CurrentBlock().Hash().Hex(), block.Hash().Hex())
-			}
+		want, _ := receipt.MarshalBinary()
+		if !bytes.Equal(have, want) {
+			t.Fatalf("block #%d tx %d: proven receipt mismatch: have %x, want %x", block.NumberU64(), index, have, want)
 		}
 	}
-	if err := inserter(blocks, receipts); err != nil {
-		t.Fatalf("failed to insert chain data: %v", err)
+	// Prove receipts of a fully imported chain
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
 	}
+	defer chain.Stop()
 
-	// Reimport the chain data again. All the imported
-	// chain data are regarded "known" data.
-	if err := inserter(blocks, receipts); err != nil {
-		t.Fatalf("failed to insert chain data: %v", err)
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
 	}
-	asserter(t, blocks[len(blocks)-1])
-
-	// Import a long canonical chain with some known data as prefix.
-	rollback := blocks[len(blocks)/2].NumberU64()
-	chain.SetHead(rollback - 1)
-	if err := inserter(blocks, receipts); err != nil {
-		t.Fatalf("failed to insert chain data: %v", err)
+	for i := 0; i < 3; i++ {
+		verify(chain, blocks[4], i)
 	}
-	asserter(t, blocks[len(blocks)-1])
+	// A transaction indexed at a height whose canonical block doesn't contain it
+	// must be reported as non canonical
+	forks, _ := GenerateChain(gspec.Config, blocks[3], engine, genDb, 1, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{0x02})
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(address), common.Address{0x02}, big.NewInt(1), params.TxGas, b.BaseFee(), nil), signer, key)
+		b.AddTx(tx)
+	})
+	if _, err := chain.InsertChain(forks); err != nil {
+		t.Fatalf("failed to insert side chain: %v", err)
+	}
+	stale := forks[0].Transactions()[0].Hash()
+	rawdb.WriteTxLookupEntries(chain.db, forks[0].NumberU64(), []common.Hash{stale})
+	if _, _, err := chain.GetReceiptProof(stale); !errors.Is(err, ErrTxNotCanonical) {
+		t.Fatalf("side chain proof error mismatch: have %v, want %v", err, ErrTxNotCanonical)
+	}
+	// Prove receipts moved into the freezer
+	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
+	if err != nil {
+		t.Fatalf("failed to create temp freezer db: %v", err)
+	}
+	defer ancientDb.Close()
 
-	// Import a longer chain with some known data as prefix.
-	if err := inserter(append(blocks, blocks2...), append(receipts, receipts2...)); err != nil {
-		t.Fatalf("failed to insert chain data: %v", err)
+	ancient, err := NewBlockChain(ancientDb, nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create ancient chain: %v", err)
 	}
-	asserter(t, blocks2[len(blocks2)-1])
+	defer ancient.Stop()
 
-	// Import a shorter chain with some known data as prefix.
-	// The reorg is expected since the fork choice rule is
-	// already changed.
-	if err := inserter(append(blocks, blocks3...), append(receipts, receipts3...)); err != nil {
-		t.Fatalf("failed to insert chain data: %v", err)
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
 	}
-	// The head shouldn't change.
-	asserter(t, blocks3[len(blocks3)-1])
-
-	// Reimport the longer chain again, the reorg is still expected
-	chain.SetHead(rollback - 1)
-	if err := inserter(append(blocks, blocks2...), append(receipts, receipts2...)); err != nil {
-		t.Fatalf("failed to insert chain data: %v", err)
+	if n, err := ancient.InsertHeaderChain(headers); err != nil {
+		t.Fatalf("failed to insert header %d: %v", n, err)
 	}
-	asserter(t, blocks2[len(blocks2)-1])
-}
-
-// getLongAndShortChains returns two chains: A is longer, B is heavier.
-func getLongAndShortChains() (*BlockChain, []*types.Block, []*types.Block, *Genesis, error) {
-	// Generate a canonical chain to act as the main dataset
-	engine := ethash.NewFaker()
-	genesis := &Genesis{
-		Config:  params.TestChainConfig,
-		BaseFee: big.NewInt(params.InitialBaseFee),
+	if n, err := ancient.InsertReceiptChain(blocks, receipts, uint64(len(blocks)/2)); err != nil {
+		t.Fatalf("failed to insert receipt %d: %v", n, err)
 	}
-	// Generate and import the canonical chain,
-	// Offset the time, to keep the difficulty low
-	genDb, longChain, _ := GenerateChainWithGenesis(genesis, engine, 80, func(i int, b *BlockGen) {
-		b.SetCoinbase(common.Address{1})
-	})
-	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, genesis, nil, engine, vm.Config{}, nil, nil)
-	if err != nil {
-		return nil, nil, nil, nil, fmt.Errorf("failed to create tester chain: %v", err)
+	frozen := blocks[1]
+	if n, _ := ancientDb.Ancients(); n <= frozen.NumberU64() {
+		t.Fatalf("block not frozen: %d ancients", n)
 	}
-	// Generate fork chain, make it shorter than canon, with common ancestor pretty early
-	parentIndex := 3
-	parent := longChain[parentIndex]
-	heavyChainExt, _ := GenerateChain(genesis.Config, parent, engine, genDb, 75, func(i int, b *BlockGen) {
-		b.SetCoinbase(common.Address{2})
-		b.OffsetTime(-9)
-	})
-	var heavyChain []*types.Block
-	heavyChain = append(heavyChain, longChain[:parentIndex+1]...)
-	heavyChain = append(heavyChain, heavyChainExt...)
+	// Frozen blocks are indexed in the background, do it by hand
+	rawdb.WriteTxLookupEntriesByBlock(ancientDb, frozen)
+	verify(ancient, frozen, 2)
+}
 
-	// Verify that the test is sane
+func TestBlockchainRecovery(t *testing.T) {
+	// Configure and generate a sample block chain
 	var (
-		longerTd  = new(big.Int)
-		shorterTd = new(big.Int)
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(1000000000)
+		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{address: {Balance: funds}}}
 	)
-	for index, b := range longChain {
-		longerTd.Add(longerTd, b.Difficulty())
-		if index <= parentIndex {
-			shorterTd.Add(shorterTd, b.Difficulty())
-		}
+	height := uint64(1024)
+	_, blocks, receipts := GenerateChainWithGenesis(gspec, ethash.NewFaker(), int(height), nil)
+
+	// Import the chain as a ancient-first node and ensure all pointers are updated
+	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
+	if err != nil {
+		t.Fatalf("failed to create temp freezer db: %v", err)
 	}
-	for _, b := range heavyChain {
-		shorterTd.Add(shorterTd, b.Difficulty())
+	defer ancientDb.Close()
+	ancient, _ := NewBlockChain(ancientDb, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
 	}
-	if shorterTd.Cmp(longerTd) <= 0 {
-		return nil, nil, nil, nil, fmt.Errorf("test is moot, heavyChain td (%v) must be larger than canon td (%v)", shorterTd, longerTd)
+	if n, err := ancient.InsertHeaderChain(headers); err != nil {
+		t.Fatalf("failed to insert header %d: %v", n, err)
 	}
-	longerNum := longChain[len(longChain)-1].NumberU64()
-	shorterNum := heavyChain[len(heavyChain)-1].NumberU64()
-	if shorterNum >= longerNum {
-		return nil, nil, nil, nil, fmt.Errorf("test is moot, heavyChain num (%v) must be lower than canon num (%v)", shorterNum, longerNum)
+	if n, err := ancient.InsertReceiptChain(blocks, receipts, uint64(3*len(blocks)/4)); err != nil {
+		t.Fatalf("failed to insert receipt %d: %v", n, err)
 	}
-	return chain, longChain, heavyChain, genesis, nil
-}
+	rawdb.WriteLastPivotNumber(ancientDb, blocks[len(blocks)-1].NumberU64()) // Force fast sync behavior
+	ancient.Stop()
 
-// TestReorgToShorterRemovesCanonMapping tests that if we
-// 1. Have a chain [0 ... N .. X]
-// 2. Reorg to shorter but heavier chain [0 ... N ... Y]
-// 3. Then there should be no canon mapping for the block at height X
-// 4. The forked block should still be retrievable by hash
-func TestReorgToShorterRemovesCanonMapping(t *testing.T) {
-	chain, canonblocks, sideblocks, _, err := getLongAndShortChains()
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer chain.Stop()
+	// Destroy head fast block manually
+	midBlock := blocks[len(blocks)/2]
+	rawdb.WriteHeadFastBlockHash(ancientDb, midBlock.Hash())
 
-	if n, err := chain.InsertChain(canonblocks); err != nil {
-		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	// Reopen broken blockchain again
+	ancient, _ = NewBlockChain(ancientDb, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer ancient.Stop()
+	if num := ancient.CurrentBlock().Number.Uint64(); num != 0 {
+		t.Errorf("head block mismatch: have #%v, want #%v", num, 0)
 	}
-	canonNum := chain.CurrentBlock().Number.Uint64()
-	canonHash := chain.CurrentBlock().Hash()
-	_, err = chain.InsertChain(sideblocks)
-	if err != nil {
-		t.Errorf("Got error, %v", err)
+	if num := ancient.CurrentSnapBlock().Number.Uint64(); num != midBlock.NumberU64() {
+		t.Errorf("head snap-block mismatch: have #%v, want #%v", num, midBlock.NumberU64())
 	}
-	head := chain.CurrentBlock()
-	if got := sideblocks[len(sideblocks)-1].Hash(); got != head.Hash() {
-		t.Fatalf("head wrong, expected %x got %x", head.Hash(), got)
+	if num := ancient.CurrentHeader().Number.Uint64(); num != midBlock.NumberU64() {
+		t.Errorf("head header mismatch: have #%v, want #%v", num, midBlock.NumberU64())
 	}
-	// We have now inserted a sidechain.
-	if blockByNum := chain.GetBlockByNumber(canonNum); blockByNum != nil {
-		t.Errorf("expected block to be gone: %v", blockByNum.NumberU64())
+}
+
+// This test checks that InsertReceiptChain will roll back correctly when attempting to insert a side chain.
+func TestInsertReceiptChainRollback(t *testing.T) {
+	// Generate forked chain. The returned BlockChain object is used to process the side chain blocks.
+	tmpChain, sideblocks, canonblocks, gspec, err := getLongAndShortChains()
+	if err != nil {
+		t.Fatal(err)
 	}
-	if headerByNum := chain.GetHeaderByNumber(canonNum); headerByNum != nil {
-		t.Errorf("expected header to be gone: %v", headerByNum.Number)
+	defer tmpChain.Stop()
+	// Get the side chain receipts.
+	if _, err := tmpChain.InsertChain(sideblocks); err != nil {
+		t.Fatal("processing side chain failed:", err)
 	}
-	if blockByHash := chain.GetBlockByHash(canonHash); blockByHash == nil {
-		t.Errorf("expected block to be present: %x", blockByHash.Hash())
+	t.Log("sidechain head:", tmpChain.CurrentBlock().Number, tmpChain.CurrentBlock().Hash())
+	sidechainReceipts := make([]types.Receipts, len(sideblocks))
+	for i, block := range sideblocks {
+		sidechainReceipts[i] = tmpChain.GetReceiptsByHash(block.Hash())
 	}
-	if headerByHash := chain.GetHeaderByHash(canonHash); headerByHash == nil {
-		t.Errorf("expected header to be present: %x", headerByHash.Hash())
+	// Get the canon chain receipts.
+	if _, err := tmpChain.InsertChain(canonblocks); err != nil {
+		t.Fatal("processing canon chain failed:", err)
+	}
+	t.Log("canon head:", tmpChain.CurrentBlock().Number, tmpChain.CurrentBlock().Hash())
+	canonReceipts := make([]types.Receipts, len(canonblocks))
+	for i, block := range canonblocks {
+		canonReceipts[i] = tmpChain.GetReceiptsByHash(block.Hash())
 	}
-}
 
-// TestReorgToShorterRemovesCanonMappingHeaderChain is the same scenario
-// as TestReorgToShorterRemovesCanonMapping, but applied on headerchain
-// imports -- that is, for fast sync
-func TestReorgToShorterRemovesCanonMappingHeaderChain(t *testing.T) {
-	chain, canonblocks, sideblocks, _, err := getLongAndShortChains()
+	// Set up a BlockChain that uses the ancient store.
+	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("failed to create temp freezer db: %v", err)
 	}
-	defer chain.Stop()
+	defer ancientDb.Close()
 
-	// Convert into headers
+	ancientChain, _ := NewBlockChain(ancientDb, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	defer ancientChain.Stop()
+
+	// Import the canonical header chain.
 	canonHeaders := make([]*types.Header, len(canonblocks))
 	for i, block := range canonblocks {
 		canonHeaders[i] = block.Header()
 	}
-	if n, err := chain.InsertHeaderChain(canonHeaders); err != nil {
-		t.Fatalf("header %d: failed to insert into chain: %v", n, err)
-	}
-	canonNum := chain.CurrentHeader().Number.Uint64()
-	canonHash := chain.CurrentBlock().Hash()
-	sideHeaders := make([]*types.Header, len(sideblocks))
-	for i, block := range sideblocks {
-		sideHeaders[i] = block.Header()
+	if _, err = ancientChain.InsertHeaderChain(canonHeaders); err != nil {
+		t.Fatal("can't import canon headers:", err)
 	}
-	if n, err := chain.InsertHeaderChain(sideHeaders); err != nil {
-		t.Fatalf("header %d: failed to insert into chain: %v", n, err)
+
+	// Try to insert blocks/receipts of the side chain.
+	_, err = ancientChain.InsertReceiptChain(sideblocks, sidechainReceipts, uint64(len(sideblocks)))
+	if err == nil {
+		t.Fatal("expected error from InsertReceiptChain.")
 	}
-	head := chain.CurrentHeader()
-	if got := sideblocks[len(sideblocks)-1].Hash(); got != head.Hash() {
-		t.Fatalf("head wrong, expected %x got %x", head.Hash(), got)
+	if ancientChain.CurrentSnapBlock().Number.Uint64() != 0 {
+		t.Fatalf("failed to rollback ancient data, want %d, have %d", 0, ancientChain.CurrentSnapBlock().Number)
 	}
-	// We have now inserted a sidechain.
-	if blockByNum := chain.GetBlockByNumber(canonNum); blockByNum != nil {
-		t.Errorf("expected block to be gone: %v", blockByNum.NumberU64())
+	if frozen, err := ancientChain.db.Ancients(); err != nil || frozen != 1 {
+		t.Fatalf("failed to truncate ancient data, frozen index is %d", frozen)
 	}
-	if headerByNum := chain.GetHeaderByNumber(canonNum); headerByNum != nil {
-		t.Errorf("expected header to be gone: %v", headerByNum.Number.Uint64())
+
+	// Insert blocks/receipts of the canonical chain.
+	_, err = ancientChain.InsertReceiptChain(canonblocks, canonReceipts, uint64(len(canonblocks)))
+	if err != nil {
+		t.Fatalf("can't import canon chain receipts: %v", err)
 	}
-	if blockByHash := chain.GetBlockByHash(canonHash); blockByHash == nil {
-		t.Errorf("expected block to be present: %x", blockByHash.Hash())
+	if ancientChain.CurrentSnapBlock().Number.Uint64() != canonblocks[len(canonblocks)-1].NumberU64() {
+		t.Fatalf("failed to insert ancient recept chain after rollback")
 	}
-	if headerByHash := chain.GetHeaderByHash(canonHash); headerByHash == nil {
-		t.Errorf("expected header to be present: %x", headerByHash.Hash())
+	if frozen, _ := ancientChain.db.Ancients(); frozen != uint64(len(canonblocks))+1 {
+		t.Fatalf("wrong ancients count %d", frozen)
 	}
 }
 
-func TestTransactionIndices(t *testing.T) {
-	// Configure and generate a sample block chain
-	var (
-		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		address = crypto.PubkeyToAddress(key.PublicKey)
-		funds   = big.NewInt(100000000000000000)
-		gspec   = &Genesis{
-			Config:  params.TestChainConfig,
-			Alloc:   GenesisAlloc{address: {Balance: funds}},
-			BaseFee: big.NewInt(params.InitialBaseFee),
+// flakyAncientDB is a database whose ancient writes fail a number of times after
+// having been fully applied, leaving the freezer to roll them back.
+type flakyAncientDB struct {
+	ethdb.Database
+	failures int   // Number of upcoming ancient writes to fail
+	attempts int   // Number of ancient writes attempted
+	err      error // Error failing the writes, a file system error if nil
+}
+
+func (db *flakyAncientDB) ModifyAncients(fn func(ethdb.AncientWriteOp) error) (int64, error) {
+	db.attempts++
+	if db.failures == 0 {
+		return db.Database.ModifyAncients(fn)
+	}
+	db.failures--
+	return db.Database.ModifyAncients(func(op ethdb.AncientWriteOp) error {
+		if err := fn(op); err != nil {
+			return err
 		}
-		signer = types.LatestSigner(gspec.Config)
-	)
-	_, blocks, receipts := GenerateChainWithGenesis(gspec, ethash.NewFaker(), 128, func(i int, block *BlockGen) {
-		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x00}, big.NewInt(1000), params.TxGas, block.header.BaseFee, nil), signer, key)
-		if err != nil {
-			panic(err)
+		if db.err != nil {
+			return db.err
 		}
-		block.AddTx(tx)
+		return &fs.PathError{Op: "write", Path: "injected", Err: syscall.ENOSPC}
 	})
+}
 
-	check := func(tail *uint64, chain *BlockChain) {
-		stored := rawdb.ReadTxIndexTail(chain.db)
-		if tail == nil && stored != nil {
-			t.Fatalf("Oldest indexded block mismatch, want nil, have %d", *stored)
-		}
-		if tail != nil && *stored != *tail {
-			t.Fatalf("Oldest indexded block mismatch, want %d, have %d", *tail, *stored)
-		}
-		if tail != nil {
-			for i := *tail; i <= chain.CurrentBlock().Number.Uint64(); i++ {
-				block := rawdb.ReadBlock(chain.db, rawdb.ReadCanonicalHash(chain.db, i), i)
-				if block.Transactions().Len() == 0 {
-					continue
-				}
-				for _, tx := range block.Transactions() {
-					if index := rawdb.ReadTxLookupEntry(chain.db, tx.Hash()); index == nil {
-						t.Fatalf("Miss transaction indice, number %d hash %s", i, tx.Hash().Hex())
-					}
-				}
-			}
-			for i := uint64(0); i < *tail; i++ {
-				block := rawdb.ReadBlock(chain.db, rawdb.ReadCanonicalHash(chain.db, i), i)
-				if block.Transactions().Len() == 0 {
-					continue
-				}
-				for _, tx := range block.Transactions() {
-					if index := rawdb.ReadTxLookupEntry(chain.db, tx.Hash()); index != nil {
-						t.Fatalf("Transaction indice should be deleted, number %d hash %s", i, tx.Hash().Hex())
-					}
-				}
-			}
-		}
+// Tests that transient ancient write failures during InsertReceiptChain are
+// retried without duplicating entries, that permanent ones aren't retried, and
+// that the import is rolled back once the retries are exhausted.
+func TestInsertReceiptChainAncientRetry(t *testing.T) {
+	tmpChain, _, canonblocks, gspec, err := getLongAndShortChains()
+	if err != nil {
+		t.Fatal(err)
 	}
-	// Init block chain with external ancients, check all needed indices has been indexed.
-	limit := []uint64{0, 32, 64, 128}
-	for _, l := range limit {
-		frdir := t.TempDir()
-		ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "", false, false, false, false)
-		if err != nil {
-			t.Fatalf("failed to create temp freezer db: %v", err)
-		}
-		rawdb.WriteAncientBlocks(ancientDb, append([]*types.Block{gspec.ToBlock()}, blocks...), append([]types.Receipts{{}}, receipts...), big.NewInt(0))
-
-		l := l
-		chain, err := NewBlockChain(ancientDb, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, &l)
-		if err != nil {
-			t.Fatalf("failed to create tester chain: %v", err)
-		}
-		chain.indexBlocks(rawdb.ReadTxIndexTail(ancientDb), 128, make(chan struct{}))
+	defer tmpChain.Stop()
 
-		var tail uint64
-		if l != 0 {
-			tail = uint64(128) - l + 1
-		}
-		check(&tail, chain)
-		chain.Stop()
-		ancientDb.Close()
-		os.RemoveAll(frdir)
+	if _, err := tmpChain.InsertChain(canonblocks); err != nil {
+		t.Fatal("processing canon chain failed:", err)
 	}
-
-	// Reconstruct a block chain which only reserves HEAD-64 tx indices
-	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
-	if err != nil {
-		t.Fatalf("failed to create temp freezer db: %v", err)
+	canonReceipts := make([]types.Receipts, len(canonblocks))
+	for i, block := range canonblocks {
+		canonReceipts[i] = tmpChain.GetReceiptsByHash(block.Hash())
 	}
-	defer ancientDb.Close()
+	canonHeaders := make([]*types.Header, len(canonblocks))
+	for i, block := range canonblocks {
+		canonHeaders[i] = block.Header()
+	}
+	permanent := errors.New("injected permanent failure")
+	for _, tt := range []struct {
+		failures int
+		err      error
+		attempts int
+		fail     bool
+	}{
+		{failures: 2, attempts: 3, fail: false},                // Recovers on the last retry
+		{failures: 3, attempts: 3, fail: true},                 // Retries exhausted
+		{failures: 3, err: permanent, attempts: 1, fail: true}, // Not retried
+	} {
+		db, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
+		if err != nil {
+			t.Fatalf("failed to create temp freezer db: %v", err)
+		}
+		flaky := &flakyAncientDB{Database: db, err: tt.err}
+		cacheConfig := *defaultCacheConfig
+		cacheConfig.AncientWriteRetries = 2
+		cacheConfig.AncientWriteBackoff = time.Millisecond
 
-	rawdb.WriteAncientBlocks(ancientDb, append([]*types.Block{gspec.ToBlock()}, blocks...), append([]types.Receipts{{}}, receipts...), big.NewInt(0))
-	limit = []uint64{0, 64 /* drop stale */, 32 /* shorten history */, 64 /* extend history */, 0 /* restore all */}
-	for _, l := range limit {
-		l := l
-		chain, err := NewBlockChain(ancientDb, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, &l)
+		chain, err := NewBlockChain(flaky, &cacheConfig, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
 		if err != nil {
 			t.Fatalf("failed to create tester chain: %v", err)
 		}
-		var tail uint64
-		if l != 0 {
-			tail = uint64(128) - l + 1
+		if _, err := chain.InsertHeaderChain(canonHeaders); err != nil {
+			t.Fatal("can't import canon headers:", err)
 		}
-		chain.indexBlocks(rawdb.ReadTxIndexTail(ancientDb), 128, make(chan struct{}))
-		check(&tail, chain)
-		chain.Stop()
-	}
-}
-
-func TestSkipStaleTxIndicesInSnapSync(t *testing.T) {
-	// Configure and generate a sample block chain
-	var (
-		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		address = crypto.PubkeyToAddress(key.PublicKey)
-		funds   = big.NewInt(100000000000000000)
-		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{address: {Balance: funds}}}
-		signer  = types.LatestSigner(gspec.Config)
-	)
-	_, blocks, receipts := GenerateChainWithGenesis(gspec, ethash.NewFaker(), 128, func(i int, block *BlockGen) {
-		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x00}, big.NewInt(1000), params.TxGas, block.header.BaseFee, nil), signer, key)
-		if err != nil {
-			panic(err)
+		// Freeze the genesis upfront so only the imported segment hits the faults
+		if _, err := rawdb.WriteAncientBlocks(db, []*types.Block{chain.genesisBlock}, []types.Receipts{nil}, chain.genesisBlock.Difficulty()); err != nil {
+			t.Fatalf("failed to write genesis to ancients: %v", err)
 		}
-		block.AddTx(tx)
-	})
+		flaky.failures = tt.failures
 
-	check := func(tail *uint64, chain *BlockChain) {
-		stored := rawdb.ReadTxIndexTail(chain.db)
-		if tail == nil && stored != nil {
-			t.Fatalf("Oldest indexded block mismatch, want nil, have %d", *stored)
-		}
-		if tail != nil && *stored != *tail {
-			t.Fatalf("Oldest indexded block mismatch, want %d, have %d", *tail, *stored)
+		_, err = chain.InsertReceiptChain(canonblocks, canonReceipts, uint64(len(canonblocks)))
+		if flaky.attempts != tt.attempts {
+			t.Errorf("failures %d: write attempt count mismatch: have %d, want %d", tt.failures, flaky.attempts, tt.attempts)
 		}
-		if tail != nil {
-			for i := *tail; i <= chain.CurrentBlock().Number.Uint64(); i++ {
-				block := rawdb.ReadBlock(chain.db, rawdb.ReadCanonicalHash(chain.db, i), i)
-				if block.Transactions().Len() == 0 {
-					continue
-				}
-				for _, tx := range block.Transactions() {
-					if index := rawdb.ReadTxLookupEntry(chain.db, tx.Hash()); index == nil {
-						t.Fatalf("Miss transaction indice, number %d hash %s", i, tx.Hash().Hex())
-					}
-				}
+		if tt.fail {
+			if err == nil {
+				t.Fatalf("failures %d: import succeeded with exhausted retries", tt.failures)
 			}
-			for i := uint64(0); i < *tail; i++ {
-				block := rawdb.ReadBlock(chain.db, rawdb.ReadCanonicalHash(chain.db, i), i)
-				if block.Transactions().Len() == 0 {
-					continue
-				}
-				for _, tx := range block.Transactions() {
-					if index := rawdb.ReadTxLookupEntry(chain.db, tx.Hash()); index != nil {
-						t.Fatalf("Transaction indice should be deleted, number %d hash %s", i, tx.Hash().Hex())
-					}
+			if head := chain.CurrentSnapBlock().Number.Uint64(); head != 0 {
+				t.Fatalf("failures %d: snap block not rolled back: have %d", tt.failures, head)
+			}
+			if frozen, _ := db.Ancients(); frozen != 1 {
+				t.Fatalf("failures %d: ancients not rolled back, frozen %d", tt.failures, frozen)
+			}
+		} else {
+			if err != nil {
+				t.Fatalf("failures %d: can't import canon chain receipts: %v", tt.failures, err)
+			}
+			if head := chain.CurrentSnapBlock().Number.Uint64(); head != canonblocks[len(canonblocks)-1].NumberU64() {
+				t.Fatalf("failures %d: snap block mismatch: have %d", tt.failures, head)
+			}
+			if frozen, _ := db.Ancients(); frozen != uint64(len(canonblocks))+1 {
+				t.Fatalf("failures %d: wrong ancients count %d", tt.failures, frozen)
+			}
+			for _, block := range canonblocks {
+				if hash := rawdb.ReadCanonicalHash(db, block.NumberU64()); hash != block.Hash() {
+					t.Fatalf("failures %d: block %d: ancient hash mismatch", tt.failures, block.NumberU64())
 				}
 			}
 		}
+		chain.Stop()
+		db.Close()
 	}
+}
 
-	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
+// Tests that InsertReceiptChain resumes from a partially written ancient segment
+// left over by a crash, and that a segment not matching the header chain is
+// rolled back.
+func TestInsertReceiptChainResume(t *testing.T) {
+	tmpChain, sideblocks, canonblocks, gspec, err := getLongAndShortChains()
 	if err != nil {
-		t.Fatalf("failed to create temp freezer db: %v", err)
+		t.Fatal(err)
 	}
-	defer ancientDb.Close()
+	defer tmpChain.Stop()
 
-	// Import all blocks into ancient db, only HEAD-32 indices are kept.
-	l := uint64(32)
-	chain, err := NewBlockChain(ancientDb, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, &l)
-	if err != nil {
-		t.Fatalf("failed to create tester chain: %v", err)
+	if _, err := tmpChain.InsertChain(sideblocks); err != nil {
+		t.Fatal("processing side chain failed:", err)
 	}
-	defer chain.Stop()
-
-	headers := make([]*types.Header, len(blocks))
-	for i, block := range blocks {
-		headers[i] = block.Header()
+	sideReceipts := make([]types.Receipts, len(sideblocks))
+	for i, block := range sideblocks {
+		sideReceipts[i] = tmpChain.GetReceiptsByHash(block.Hash())
 	}
-	if n, err := chain.InsertHeaderChain(headers); err != nil {
-		t.Fatalf("failed to insert header %d: %v", n, err)
+	if _, err := tmpChain.InsertChain(canonblocks); err != nil {
+		t.Fatal("processing canon chain failed:", err)
 	}
-	// The indices before ancient-N(32) should be ignored. After that all blocks should be indexed.
-	if n, err := chain.InsertReceiptChain(blocks, receipts, 64); err != nil {
-		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	canonReceipts := make([]types.Receipts, len(canonblocks))
+	for i, block := range canonblocks {
+		canonReceipts[i] = tmpChain.GetReceiptsByHash(block.Hash())
 	}
-	tail := uint64(32)
-	check(&tail, chain)
-}
-
-// Benchmarks large blocks with value transfers to non-existing accounts
-func benchmarkLargeNumberOfValueToNonexisting(b *testing.B, numTxs, numBlocks int, recipientFn func(uint64) common.Address, dataFn func(uint64) []byte) {
-	var (
-		signer          = types.HomesteadSigner{}
-		testBankKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		testBankAddress = crypto.PubkeyToAddress(testBankKey.PublicKey)
-		bankFunds       = big.NewInt(100000000000000000)
-		gspec           = &Genesis{
-			Config: params.TestChainConfig,
-			Alloc: GenesisAlloc{
-				testBankAddress: {Balance: bankFunds},
-				common.HexToAddress("0xc0de"): {
-					Code:    []byte{0x60, 0x01, 0x50},
-					Balance: big.NewInt(0),
-				}, // push 1, pop
-			},
-			GasLimit: 100e6, // 100 M
-		}
-	)
-	// Generate the original common chain segment and the two competing forks
-	engine := ethash.NewFaker()
-
-	blockGenerator := func(i int, block *BlockGen) {
-		block.SetCoinbase(common.Address{1})
-		for txi := 0; txi < numTxs; txi++ {
-			uniq := uint64(i*numTxs + txi)
-			recipient := recipientFn(uniq)
-			tx, err := types.SignTx(types.NewTransaction(uniq, recipient, big.NewInt(1), params.TxGas, block.header.BaseFee, nil), signer, testBankKey)
-			if err != nil {
-				b.Error(err)
-			}
-			block.AddTx(tx)
-		}
+	canonHeaders := make([]*types.Header, len(canonblocks))
+	for i, block := range canonblocks {
+		canonHeaders[i] = block.Header()
 	}
-
-	_, shared, _ := GenerateChainWithGenesis(gspec, engine, numBlocks, blockGenerator)
-	b.StopTimer()
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		// Import the shared chain and the original canonical one
-		chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	// crash imports the canonical headers and writes the first half of the given
+	// chain into the ancients without updating the snap block, then restarts.
+	crash := func(blocks types.Blocks, receipts []types.Receipts) (ethdb.Database, *BlockChain) {
+		db, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
 		if err != nil {
-			b.Fatalf("failed to create tester chain: %v", err)
+			t.Fatalf("failed to create temp freezer db: %v", err)
 		}
-		b.StartTimer()
-		if _, err := chain.InsertChain(shared); err != nil {
-			b.Fatalf("failed to insert shared chain: %v", err)
+		chain, _ := NewBlockChain(db, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+		if _, err := chain.InsertHeaderChain(canonHeaders); err != nil {
+			t.Fatal("can't import canon headers:", err)
 		}
-		b.StopTimer()
-		block := chain.GetBlockByHash(chain.CurrentBlock().Hash())
-		if got := block.Transactions().Len(); got != numTxs*numBlocks {
-			b.Fatalf("Transactions were not included, expected %d, got %d", numTxs*numBlocks, got)
+		half := len(blocks) / 2
+		if _, err := rawdb.WriteAncientBlocks(db, []*types.Block{chain.genesisBlock}, []types.Receipts{nil}, chain.genesisBlock.Difficulty()); err != nil {
+			t.Fatalf("failed to write genesis to ancients: %v", err)
 		}
-	}
-}
+		td := new(big.Int).Add(chain.genesisBlock.Difficulty(), blocks[0].Difficulty())
+		if _, err := rawdb.WriteAncientBlocks(db, blocks[:half], receipts[:half], td); err != nil {
+			t.Fatalf("failed to write partial ancients: %v", err)
+		}
+		chain.Stop()
 
-func BenchmarkBlockChain_1x1000ValueTransferToNonexisting(b *testing.B) {
-	var (
-		numTxs    = 1000
-		numBlocks = 1
-	)
-	recipientFn := func(nonce uint64) common.Address {
-		return common.BigToAddress(new(big.Int).SetUint64(1337 + nonce))
+		chain, err = NewBlockChain(db, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to restart chain: %v", err)
+		}
+		return db, chain
 	}
-	dataFn := func(nonce uint64) []byte {
-		return nil
+	// A partial canonical segment is kept and only the remainder is appended
+	db, chain := crash(canonblocks, canonReceipts)
+	if frozen, _ := db.Ancients(); frozen != uint64(len(canonblocks)/2)+1 {
+		t.Fatalf("partial ancients not kept on restart, frozen %d", frozen)
 	}
-	benchmarkLargeNumberOfValueToNonexisting(b, numTxs, numBlocks, recipientFn, dataFn)
-}
+	if _, err := chain.InsertReceiptChain(canonblocks, canonReceipts, uint64(len(canonblocks))); err != nil {
+		t.Fatalf("can't resume canon chain receipts: %v", err)
+	}
+	if head := chain.CurrentSnapBlock().Number.Uint64(); head != canonblocks[len(canonblocks)-1].NumberU64() {
+		t.Fatalf("snap block mismatch after resume: have %d", head)
+	}
+	if frozen, _ := db.Ancients(); frozen != uint64(len(canonblocks))+1 {
+		t.Fatalf("wrong ancients count %d", frozen)
+	}
+	for _, block := range canonblocks {
+		if hash := rawdb.ReadCanonicalHash(db, block.NumberU64()); hash != block.Hash() {
+			t.Fatalf("block %d: ancient hash mismatch", block.NumberU64())
+		}
+	}
+	chain.Stop()
+	db.Close()
 
-func BenchmarkBlockChain_1x1000ValueTransferToExisting(b *testing.B) {
-	var (
-		numTxs    = 1000
-		numBlocks = 1
-	)
-	b.StopTimer()
-	b.ResetTimer()
+	// A partial segment of another chain is rolled back as before
+	db, chain = crash(sideblocks, sideReceipts)
+	defer db.Close()
+	defer chain.Stop()
 
-	recipientFn := func(nonce uint64) common.Address {
-		return common.BigToAddress(new(big.Int).SetUint64(1337))
+	if frozen, _ := db.Ancients(); frozen != 1 {
+		t.Fatalf("mismatching ancients not truncated on restart, frozen %d", frozen)
 	}
-	dataFn := func(nonce uint64) []byte {
-		return nil
+	if _, err := chain.InsertHeaderChain(canonHeaders); err != nil {
+		t.Fatal("can't import canon headers:", err)
+	}
+	if _, err := chain.InsertReceiptChain(canonblocks, canonReceipts, uint64(len(canonblocks))); err != nil {
+		t.Fatalf("can't import canon chain receipts: %v", err)
+	}
+	if frozen, _ := db.Ancients(); frozen != uint64(len(canonblocks))+1 {
+		t.Fatalf("wrong ancients count %d", frozen)
 	}
-	benchmarkLargeNumberOfValueToNonexisting(b, numTxs, numBlocks, recipientFn, dataFn)
 }
 
-func BenchmarkBlockChain_1x1000Executions(b *testing.B) {
-	var (
-		numTxs    = 1000
-		numBlocks = 1
-	)
-	b.StopTimer()
-	b.ResetTimer()
-
-	recipientFn := func(nonce uint64) common.Address {
-		return common.BigToAddress(new(big.Int).SetUint64(0xc0de))
-	}
-	dataFn := func(nonce uint64) []byte {
-		return nil
-	}
-	benchmarkLargeNumberOfValueToNonexisting(b, numTxs, numBlocks, recipientFn, dataFn)
-}
-
-// Tests that importing a some old blocks, where all blocks are before the
-// pruning point.
-// This internally leads to a sidechain import, since the blocks trigger an
-// ErrPrunedAncestor error.
-// This may e.g. happen if
-//  1. Downloader rollbacks a batch of inserted blocks and exits
-//  2. Downloader starts to sync again
-//  3. The blocks fetched are all known and canonical blocks
-func TestSideImportPrunedBlocks(t *testing.T) {
+// Tests that importing a very large side fork, which is larger than the canon chain,
+// but where the difficulty per block is kept low: this means that it will not
+// overtake the 'canon' chain until after it's passed canon by about 200 blocks.
+//
+// Details at:
+//   - https://github.com/ethereum/go-ethereum/issues/18977
+//   - https://github.com/ethereum/go-ethereum/pull/18988
+func TestLowDiffLongChain(t *testing.T) {
 	// Generate a canonical chain to act as the main dataset
 	engine := ethash.NewFaker()
 	genesis := &Genesis{
 		Config:  params.TestChainConfig,
 		BaseFee: big.NewInt(params.InitialBaseFee),
 	}
-	// Generate and import the canonical chain
-	_, blocks, _ := GenerateChainWithGenesis(genesis, engine, 2*TriesInMemory, nil)
+	// We must use a pretty long chain to ensure that the fork doesn't overtake us
+	// until after at least 128 blocks post tip
+	genDb, blocks, _ := GenerateChainWithGenesis(genesis, engine, 6*TriesInMemory, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{1})
+		b.OffsetTime(-9)
+	})
 
+	// Import the canonical chain
 	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, genesis, nil, engine, vm.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("failed to create tester chain: %v", err)
 	}
-	defer chain.Stop()
+	defer chain.stopWithoutSaving()
 
 	if n, err := chain.InsertChain(blocks); err != nil {
 		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
 	}
+	// Generate fork chain, starting from an early block
+	parent := blocks[10]
+	fork, _ := GenerateChain(genesis.Config, parent, engine, genDb, 8*TriesInMemory, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{2})
+	})
 
-	lastPrunedIndex := len(blocks) - TestTriesInMemory - 1
-	lastPrunedBlock := blocks[lastPrunedIndex-1]
-
-	// Verify pruning of lastPrunedBlock
-	if chain.HasBlockAndState(lastPrunedBlock.Hash(), lastPrunedBlock.NumberU64()) {
-		t.Errorf("Block %d not pruned", lastPrunedBlock.NumberU64())
+	// And now import the fork
+	if i, err := chain.InsertChain(fork); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", i, err)
 	}
-	firstNonPrunedBlock := blocks[len(blocks)-TestTriesInMemory]
-	// Verify firstNonPrunedBlock is not pruned
-	if !chain.HasBlockAndState(firstNonPrunedBlock.Hash(), firstNonPrunedBlock.NumberU64()) {
-		t.Errorf("Block %d pruned", firstNonPrunedBlock.NumberU64())
+	head := chain.CurrentBlock()
+	if got := fork[len(fork)-1].Hash(); got != head.Hash() {
+		t.Fatalf("head wrong, expected %x got %x", head.Hash(), got)
 	}
-	// Now re-import some old blocks
-	blockToReimport := blocks[5:8]
-	_, err = chain.InsertChain(blockToReimport)
-	if err != nil {
-		t.Errorf("Got error, %v", err)
+	// Sanity check that all the canonical numbers are present
+	header := chain.CurrentHeader()
+	for number := head.Number.Uint64(); number > 0; number-- {
+		if hash := chain.GetHeaderByNumber(number).Hash(); hash != header.Hash() {
+			t.Fatalf("header %d: canonical hash mismatch: have %x, want %x", number, hash, header.Hash())
+		}
+		header = chain.GetHeader(header.ParentHash, number-1)
 	}
 }
 
-// TestDeleteCreateRevert tests a weird state transition corner case that we hit
-// while changing the internals of statedb. The workflow is that a contract is
-// self destructed, then in a followup transaction (but same block) it's created
-// again and the transaction reverted.
+// Tests that importing a sidechain (S), where
+// - S is sidechain, containing blocks [Sn...Sm]
+// - C is canon chain, containing blocks [G..Cn..Cm]
+// - A common ancestor is placed at prune-point + blocksBetweenCommonAncestorAndPruneblock
+// - The sidechain S is prepended with numCanonBlocksInSidechain blocks from the canon chain
 //
-// The original statedb implementation flushed dirty objects to the tries after
-// each transaction, so this works ok. The rework accumulated writes in memory
-// first, but the journal wiped the entire state object on create-revert.
-func TestDeleteCreateRevert(t *testing.T) {
+// The mergePoint can be these values:
+// -1: the transition won't happen
+// 0:  the transition happens since genesis
+// 1:  the transition happens after some chain segments
+func testSideImport(t *testing.T, numCanonBlocksInSidechain, blocksBetweenCommonAncestorAndPruneblock int, mergePoint int) {
+	// Generate a canonical chain to act as the main dataset
+	chainConfig := *params.TestChainConfig
 	var (
-		aa     = common.HexToAddress("0x000000000000000000000000000000000000aaaa")
-		bb     = common.HexToAddress("0x000000000000000000000000000000000000bbbb")
-		engine = ethash.NewFaker()
+		merger = consensus.NewMerger(rawdb.NewMemoryDatabase())
+		engine = beacon.New(ethash.NewFaker())
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		nonce  = uint64(0)
 
-		// A sender who makes transactions, has some funds
-		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		address = crypto.PubkeyToAddress(key.PublicKey)
-		funds   = big.NewInt(100000000000000000)
-		gspec   = &Genesis{
-			Config: params.TestChainConfig,
-			Alloc: GenesisAlloc{
-				address: {Balance: funds},
-				// The address 0xAAAAA selfdestructs if called
-				aa: {
-					// Code needs to just selfdestruct
-					Code:    []byte{byte(vm.PC), byte(vm.SELFDESTRUCT)},
-					Nonce:   1,
-					Balance: big.NewInt(0),
-				},
-				// The address 0xBBBB send 1 wei to 0xAAAA, then reverts
-				bb: {
-					Code: []byte{
-						byte(vm.PC),          // [0]
-						byte(vm.DUP1),        // [0,0]
-						byte(vm.DUP1),        // [0,0,0]
-						byte(vm.DUP1),        // [0,0,0,0]
-						byte(vm.PUSH1), 0x01, // [0,0,0,0,1] (value)
-						byte(vm.PUSH2), 0xaa, 0xaa, // [0,0,0,0,1, 0xaaaa]
-						byte(vm.GAS),
-						byte(vm.CALL),
-						byte(vm.REVERT),
-					},
-					Balance: big.NewInt(1),
-				},
-			},
+		gspec = &Genesis{
+			Config:  &chainConfig,
+			Alloc:   GenesisAlloc{addr: {Balance: big.NewInt(math.MaxInt64)}},
+			BaseFee: big.NewInt(params.InitialBaseFee),
 		}
+		signer     = types.LatestSigner(gspec.Config)
+		mergeBlock = math.MaxInt32
 	)
-
-	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
-		b.SetCoinbase(common.Address{1})
-		// One transaction to AAAA
-		tx, _ := types.SignTx(types.NewTransaction(0, aa,
-			big.NewInt(0), 50000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
-		b.AddTx(tx)
-		// One transaction to BBBB
-		tx, _ = types.SignTx(types.NewTransaction(1, bb,
-			big.NewInt(0), 100000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
-		b.AddTx(tx)
-	})
-	// Import the canonical chain
+	// Generate and import the canonical chain
 	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("failed to create tester chain: %v", err)
 	}
 	defer chain.Stop()
 
+	// Activate the transition since genesis if required
+	if mergePoint == 0 {
+		mergeBlock = 0
+		merger.ReachTTD()
+		merger.FinalizePoS()
+
+		// Set the terminal total difficulty in the config
+		gspec.Config.TerminalTotalDifficulty = big.NewInt(0)
+	}
+	genDb, blocks, _ := GenerateChainWithGenesis(gspec, engine, 2*TriesInMemory, func(i int, gen *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(nonce, common.HexToAddress("deadbeef"), big.NewInt(100), 21000, big.NewInt(int64(i+1)*params.GWei), nil), signer, key)
+		if err != nil {
+			t.Fatalf("failed to create tx: %v", err)
+		}
+		gen.AddTx(tx)
+		if int(gen.header.Number.Uint64()) >= mergeBlock {
+			gen.SetPoS()
+		}
+		nonce++
+	})
 	if n, err := chain.InsertChain(blocks); err != nil {
 		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
 	}
-}
-
-// TestDeleteRecreateSlots tests a state-transition that contains both deletion
-// and recreation of contract state.
-// Contract A exists, has slots 1 and 2 set
-// Tx 1: Selfdestruct A
-// Tx 2: Re-create A, set slots 3 and 4
-// Expected outcome is that _all_ slots are cleared from A, due to the selfdestruct,
-// and then the new slots exist
-func TestDeleteRecreateSlots(t *testing.T) {
-	var (
-		engine = ethash.NewFaker()
-
-		// A sender who makes transactions, has some funds
-		key, _    = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		address   = crypto.PubkeyToAddress(key.PublicKey)
-		funds     = big.NewInt(1000000000000000)
-		bb        = common.HexToAddress("0x000000000000000000000000000000000000bbbb")
-		aaStorage = make(map[common.Hash]common.Hash)          // Initial storage in AA
-		aaCode    = []byte{byte(vm.PC), byte(vm.SELFDESTRUCT)} // Code for AA (simple selfdestruct)
-	)
-	// Populate two slots
-	aaStorage[common.HexToHash("01")] = common.HexToHash("01")
-	aaStorage[common.HexToHash("02")] = common.HexToHash("02")
 
-	// The bb-code needs to CREATE2 the aa contract. It consists of
-	// both initcode and deployment code
-	// initcode:
-	// 1. Set slots 3=3, 4=4,
-	// 2. Return aaCode
+	lastPrunedIndex := len(blocks) - TestTriesInMemory - 1
+	lastPrunedBlock := blocks[lastPrunedIndex-1]
+	firstNonPrunedBlock := blocks[len(blocks)-TestTriesInMemory]
 
-	initCode := []byte{
-		byte(vm.PUSH1), 0x3, // value
-		byte(vm.PUSH1), 0x3, // location
-		byte(vm.SSTORE),     // Set slot[3] = 3
-		byte(vm.PUSH1), 0x4, // value
-		byte(vm.PUSH1), 0x4, // location
-		byte(vm.SSTORE), // Set slot[4] = 4
-		// Slots are set, now return the code
-		byte(vm.PUSH2), byte(vm.PC), byte(vm.SELFDESTRUCT), // Push code on stack
-		byte(vm.PUSH1), 0x0, // memory start on stack
-		byte(vm.MSTORE),
-		// Code is now in memory.
-		byte(vm.PUSH1), 0x2, // size
-		byte(vm.PUSH1), byte(32 - 2), // offset
-		byte(vm.RETURN),
+	// Verify pruning of lastPrunedBlock
+	if chain.HasBlockAndState(lastPrunedBlock.Hash(), lastPrunedBlock.NumberU64()) {
+		t.Errorf("Block %d not pruned", lastPrunedBlock.NumberU64())
 	}
-	if l := len(initCode); l > 32 {
-		t.Fatalf("init code is too long for a pushx, need a more elaborate deployer")
+	// Verify firstNonPrunedBlock is not pruned
+	if !chain.HasBlockAndState(firstNonPrunedBlock.Hash(), firstNonPrunedBlock.NumberU64()) {
+		t.Errorf("Block %d pruned", firstNonPrunedBlock.NumberU64())
 	}
-	bbCode := []byte{
-		// Push initcode onto stack
-		byte(vm.PUSH1) + byte(len(initCode)-1)}
-	bbCode = append(bbCode, initCode...)
-	bbCode = append(bbCode, []byte{
-		byte(vm.PUSH1), 0x0, // memory start on stack
-		byte(vm.MSTORE),
-		byte(vm.PUSH1), 0x00, // salt
-		byte(vm.PUSH1), byte(len(initCode)), // size
-		byte(vm.PUSH1), byte(32 - len(initCode)), // offset
-		byte(vm.PUSH1), 0x00, // endowment
-		byte(vm.CREATE2),
-	}...)
 
-	initHash := crypto.Keccak256Hash(initCode)
-	aa := crypto.CreateAddress2(bb, [32]byte{}, initHash[:])
-	t.Logf("Destination address: %x\n", aa)
+	// Activate the transition in the middle of the chain
+	if mergePoint == 1 {
+		merger.ReachTTD()
+		merger.FinalizePoS()
+		// Set the terminal total difficulty in the config
+		ttd := big.NewInt(int64(len(blocks)))
+		ttd.Mul(ttd, params.GenesisDifficulty)
+		gspec.Config.TerminalTotalDifficulty = ttd
+		mergeBlock = len(blocks)
+	}
 
-	gspec := &Genesis{
-		Config: params.TestChainConfig,
-		Alloc: GenesisAlloc{
-			address: {Balance: funds},
-			// The address 0xAAAAA selfdestructs if called
-			aa: {
-				// Code needs to just selfdestruct
-				Code:    aaCode,
-				Nonce:   1,
-				Balance: big.NewInt(0),
-				Storage: aaStorage,
-			},
-			// The contract BB recreates AA
-			bb: {
-				Code:    bbCode,
-				Balance: big.NewInt(1),
-			},
-		},
-	}
-	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
-		b.SetCoinbase(common.Address{1})
-		// One transaction to AA, to kill it
-		tx, _ := types.SignTx(types.NewTransaction(0, aa,
-			big.NewInt(0), 50000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
-		b.AddTx(tx)
-		// One transaction to BB, to recreate AA
-		tx, _ = types.SignTx(types.NewTransaction(1, bb,
-			big.NewInt(0), 100000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
-		b.AddTx(tx)
+	// Generate the sidechain
+	// First block should be a known block, block after should be a pruned block. So
+	// canon(pruned), side, side...
+
+	// Generate fork chain, make it longer than canon
+	parentIndex := lastPrunedIndex + blocksBetweenCommonAncestorAndPruneblock
+	parent := blocks[parentIndex]
+	fork, _ := GenerateChain(gspec.Config, parent, engine, genDb, 2*TriesInMemory, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{2})
+		if int(b.header.Number.Uint64()) >= mergeBlock {
+			b.SetPoS()
+		}
 	})
-	// Import the canonical chain
-	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{
-		Tracer: logger.NewJSONLogger(nil, os.Stdout),
-	}, nil, nil)
+	// Prepend the parent(s)
+	var sidechain []*types.Block
+	for i := numCanonBlocksInSidechain; i > 0; i-- {
+		sidechain = append(sidechain, blocks[parentIndex+1-i])
+	}
+	sidechain = append(sidechain, fork...)
+	n, err := chain.InsertChain(sidechain)
 	if err != nil {
-		t.Fatalf("failed to create tester chain: %v", err)
+		t.Errorf("Got error, %v number %d - %d", err, sidechain[n].NumberU64(), n)
 	}
-	defer chain.Stop()
-
-	if n, err := chain.InsertChain(blocks); err != nil {
-		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	head := chain.CurrentBlock()
+	if got := fork[len(fork)-1].Hash(); got != head.Hash() {
+		t.Fatalf("head wrong, expected %x got %x", head.Hash(), got)
 	}
-	statedb, _ := chain.State()
+}
 
-	// If all is correct, then slot 1 and 2 are zero
-	if got, exp := statedb.GetState(aa, common.HexToHash("01")), (common.Hash{}); got != exp {
-		t.Errorf("got %x exp %x", got, exp)
-	}
-	if got, exp := statedb.GetState(aa, common.HexToHash("02")), (common.Hash{}); got != exp {
-		t.Errorf("got %x exp %x", got, exp)
-	}
-	// Also, 3 and 4 should be set
-	if got, exp := statedb.GetState(aa, common.HexToHash("03")), common.HexToHash("03"); got != exp {
-		t.Fatalf("got %x exp %x", got, exp)
-	}
-	if got, exp := statedb.GetState(aa, common.HexToHash("04")), common.HexToHash("04"); got != exp {
-		t.Fatalf("got %x exp %x", got, exp)
-	}
+// Tests that importing a sidechain (S), where
+//   - S is sidechain, containing blocks [Sn...Sm]
+//   - C is canon chain, containing blocks [G..Cn..Cm]
+//   - The common ancestor Cc is pruned
+//   - The first block in S: Sn, is == Cn
+//
+// That is: the sidechain for import contains some blocks already present in canon chain.
+// So the blocks are:
+//
+//	[ Cn, Cn+1, Cc, Sn+3 ... Sm]
+//	^    ^    ^  pruned
+func TestPrunedImportSide(t *testing.T) {
+	//glogger := log.NewGlogHandler(log.StreamHandler(os.Stdout, log.TerminalFormat(false)))
+	//glogger.Verbosity(3)
+	//log.Root().SetHandler(log.Handler(glogger))
+	testSideImport(t, 3, 3, -1)
+	testSideImport(t, 3, -3, -1)
+	testSideImport(t, 10, 0, -1)
+	testSideImport(t, 1, 10, -1)
+	testSideImport(t, 1, -10, -1)
 }
 
-// TestDeleteRecreateAccount tests a state-transition that contains deletion of a
-// contract with storage, and a recreate of the same contract via a
-// regular value-transfer
-// Expected outcome is that _all_ slots are cleared from A
-func TestDeleteRecreateAccount(t *testing.T) {
-	var (
-		engine = ethash.NewFaker()
+func TestPrunedImportSideWithMerging(t *testing.T) {
+	//glogger := log.NewGlogHandler(log.StreamHandler(os.Stdout, log.TerminalFormat(false)))
+	//glogger.Verbosity(3)
+	//log.Root().SetHandler(log.Handler(glogger))
+	testSideImport(t, 3, 3, 0)
+	testSideImport(t, 3, -3, 0)
+	testSideImport(t, 10, 0, 0)
+	testSideImport(t, 1, 10, 0)
+	testSideImport(t, 1, -10, 0)
 
-		// A sender who makes transactions, has some funds
-		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		address = crypto.PubkeyToAddress(key.PublicKey)
-		funds   = big.NewInt(1000000000000000)
+	testSideImport(t, 3, 3, 1)
+	testSideImport(t, 3, -3, 1)
+	testSideImport(t, 10, 0, 1)
+	testSideImport(t, 1, 10, 1)
+	testSideImport(t, 1, -10, 1)
+}
 
-		aa        = common.HexToAddress("0x7217d81b76bdd8707601e959454e3d776aee5f43")
-		aaStorage = make(map[common.Hash]common.Hash)          // Initial storage in AA
-		aaCode    = []byte{byte(vm.PC), byte(vm.SELFDESTRUCT)} // Code for AA (simple selfdestruct)
-	)
-	// Populate two slots
-	aaStorage[common.HexToHash("01")] = common.HexToHash("01")
-	aaStorage[common.HexToHash("02")] = common.HexToHash("02")
+func TestInsertKnownHeaders(t *testing.T)      { testInsertKnownChainData(t, "headers") }
+func TestInsertKnownReceiptChain(t *testing.T) { testInsertKnownChainData(t, "receipts") }
+func TestInsertKnownBlocks(t *testing.T)       { testInsertKnownChainData(t, "blocks") }
 
-	gspec := &Genesis{
-		Config: params.TestChainConfig,
-		Alloc: GenesisAlloc{
-			address: {Balance: funds},
-			// The address 0xAAAAA selfdestructs if called
-			aa: {
-				// Code needs to just selfdestruct
-				Code:    aaCode,
-				Nonce:   1,
-				Balance: big.NewInt(0),
-				Storage: aaStorage,
-			},
-		},
+func testInsertKnownChainData(t *testing.T, typ string) {
+	engine := ethash.NewFaker()
+	genesis := &Genesis{
+		Config:  params.TestChainConfig,
+		BaseFee: big.NewInt(params.InitialBaseFee),
 	}
+	genDb, blocks, receipts := GenerateChainWithGenesis(genesis, engine, 32, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{1}) })
 
-	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
+	// A longer chain but total difficulty is lower.
+	blocks2, receipts2 := GenerateChain(genesis.Config, blocks[len(blocks)-1], engine, genDb, 65, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{1}) })
+
+	// A shorter chain but total difficulty is higher.
+	blocks3, receipts3 := GenerateChain(genesis.Config, blocks[len(blocks)-1], engine, genDb, 64, func(i int, b *BlockGen) {
 		b.SetCoinbase(common.Address{1})
-		// One transaction to AA, to kill it
-		tx, _ := types.SignTx(types.NewTransaction(0, aa,
-			big.NewInt(0), 50000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
-		b.AddTx(tx)
-		// One transaction to AA, to recreate it (but without storage
-		tx, _ = types.SignTx(types.NewTransaction(1, aa,
-			big.NewInt(1), 100000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
-		b.AddTx(tx)
+		b.OffsetTime(-9) // A higher difficulty
 	})
-	// Import the canonical chain
-	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{
-		Tracer: logger.NewJSONLogger(nil, os.Stdout),
-	}, nil, nil)
+	// Import the shared chain and the original canonical one
+	chaindb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
+	if err != nil {
+		t.Fatalf("failed to create temp freezer db: %v", err)
+	}
+	defer chaindb.Close()
+
+	chain, err := NewBlockChain(chaindb, nil, genesis, nil, engine, vm.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("failed to create tester chain: %v", err)
 	}
 	defer chain.Stop()
 
-	if n, err := chain.InsertChain(blocks); err != nil {
-		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	var (
+		inserter func(blocks []*types.Block, receipts []types.Receipts) error
+		asserter func(t *testing.T, block *types.Block)
+	)
+	if typ == "headers" {
+		inserter = func(blocks []*types.Block, receipts []types.Receipts) error {
+			headers := make([]*types.Header, 0, len(blocks))
+			for _, block := range blocks {
+				headers = append(headers, block.Header())
+			}
+			_, err := chain.InsertHeaderChain(headers)
+			return err
+		}
+		asserter = func(t *testing.T, block *types.Block) {
+			if chain.CurrentHeader().Hash() != block.Hash() {
+				t.Fatalf("current head header mismatch, have %v, want %v", chain.CurrentHeader().Hash().Hex(), block.Hash().Hex())
+			}
+		}
+	} else if typ == "receipts" {
+		inserter = func(blocks []*types.Block, receipts []types.Receipts) error {
+			headers := make([]*types.Header, 0, len(blocks))
+			for _, block := range blocks {
+				headers = append(headers, block.Header())
+			}
+			_, err := chain.InsertHeaderChain(headers)
+			if err != nil {
+				return err
+			}
+			_, err = chain.InsertReceiptChain(blocks, receipts, 0)
+			return err
+		}
+		asserter = func(t *testing.T, block *types.Block) {
+			if chain.CurrentSnapBlock().Hash() != block.Hash() {
+				t.Fatalf("current head fast block mismatch, have %v, want %v", chain.CurrentSnapBlock().Hash().Hex(), block.Hash().Hex())
+			}
+		}
+	} else {
+		inserter = func(blocks []*types.Block, receipts []types.Receipts) error {
+			_, err := chain.InsertChain(blocks)
+			return err
+		}
+		asserter = func(t *testing.T, block *types.Block) {
+			if chain.CurrentBlock().Hash() != block.Hash() {
+				t.Fatalf("current head block mismatch, have %v, want %v", chain.CurrentBlock().Hash().Hex(), block.Hash().Hex())
+			}
+		}
 	}
-	statedb, _ := chain.State()
 
-	// If all is correct, then both slots are zero
-	if got, exp := statedb.GetState(aa, common.HexToHash("01")), (common.Hash{}); got != exp {
-		t.Errorf("got %x exp %x", got, exp)
+	if err := inserter(blocks, receipts); err != nil {
+		t.Fatalf("failed to insert chain data: %v", err)
 	}
-	if got, exp := statedb.GetState(aa, common.HexToHash("02")), (common.Hash{}); got != exp {
-		t.Errorf("got %x exp %x", got, exp)
+
+	// Reimport the chain data again. All the imported
+	// chain data are regarded "known" data.
+	if err := inserter(blocks, receipts); err != nil {
+		t.Fatalf("failed to insert chain data: %v", err)
 	}
-}
+	asserter(t, blocks[len(blocks)-1])
 
-// TestDeleteRecreateSlotsAcrossManyBlocks tests multiple state-transition that contains both deletion
-// and recreation of contract state.
-// Contract A exists, has slots 1 and 2 set
-// Tx 1: Selfdestruct A
-// Tx 2: Re-create A, set slots 3 and 4
-// Expected outcome is that _all_ slots are cleared from A, due to the selfdestruct,
-// and then the new slots exist
-func TestDeleteRecreateSlotsAcrossManyBlocks(t *testing.T) {
-	var (
-		engine = ethash.NewFaker()
+	// Import a long canonical chain with some known data as prefix.
+	rollback := blocks[len(blocks)/2].NumberU64()
 
-		// A sender who makes transactions, has some funds
-		key, _    = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		address   = crypto.PubkeyToAddress(key.PublicKey)
-		funds     = big.NewInt(1000000000000000)
-		bb        = common.HexToAddress("0x000000000000000000000000000000000000bbbb")
-		aaStorage = make(map[common.Hash]common.Hash)          // Initial storage in AA
-		aaCode    = []byte{byte(vm.PC), byte(vm.SELFDESTRUCT)} // Code for AA (simple selfdestruct)
-	)
-	// Populate two slots
-	aaStorage[common.HexToHash("01")] = common.HexToHash("01")
-	aaStorage[common.HexToHash("02")] = common.HexToHash("02")
-
-	// The bb-code needs to CREATE2 the aa contract. It consists of
-	// both initcode and deployment code
-	// initcode:
-	// 1. Set slots 3=blocknum+1, 4=4,
-	// 2. Return aaCode
-
-	initCode := []byte{
-		byte(vm.PUSH1), 0x1, //
-		byte(vm.NUMBER),     // value = number + 1
-		byte(vm.ADD),        //
-		byte(vm.PUSH1), 0x3, // location
-		byte(vm.SSTORE),     // Set slot[3] = number + 1
-		byte(vm.PUSH1), 0x4, // value
-		byte(vm.PUSH1), 0x4, // location
-		byte(vm.SSTORE), // Set slot[4] = 4
-		// Slots are set, now return the code
-		byte(vm.PUSH2), byte(vm.PC), byte(vm.SELFDESTRUCT), // Push code on stack
-		byte(vm.PUSH1), 0x0, // memory start on stack
-		byte(vm.MSTORE),
-		// Code is now in memory.
-		byte(vm.PUSH1), 0x2, // size
-		byte(vm.PUSH1), byte(32 - 2), // offset
-		byte(vm.RETURN),
-	}
-	if l := len(initCode); l > 32 {
-		t.Fatalf("init code is too long for a pushx, need a more elaborate deployer")
+	chain.SetHead(rollback - 1)
+	if err := inserter(append(blocks, blocks2...), append(receipts, receipts2...)); err != nil {
+		t.Fatalf("failed to insert chain data: %v", err)
 	}
-	bbCode := []byte{
-		// Push initcode onto stack
-		byte(vm.PUSH1) + byte(len(initCode)-1)}
-	bbCode = append(bbCode, initCode...)
-	bbCode = append(bbCode, []byte{
-		byte(vm.PUSH1), 0x0, // memory start on stack
-		byte(vm.MSTORE),
-		byte(vm.PUSH1), 0x00, // salt
-		byte(vm.PUSH1), byte(len(initCode)), // size
-		byte(vm.PUSH1), byte(32 - len(initCode)), // offset
-		byte(vm.PUSH1), 0x00, // endowment
-		byte(vm.CREATE2),
-	}...)
+	asserter(t, blocks2[len(blocks2)-1])
 
-	initHash := crypto.Keccak256Hash(initCode)
-	aa := crypto.CreateAddress2(bb, [32]byte{}, initHash[:])
-	t.Logf("Destination address: %x\n", aa)
-	gspec := &Genesis{
-		Config: params.TestChainConfig,
-		Alloc: GenesisAlloc{
-			address: {Balance: funds},
-			// The address 0xAAAAA selfdestructs if called
-			aa: {
-				// Code needs to just selfdestruct
-				Code:    aaCode,
-				Nonce:   1,
-				Balance: big.NewInt(0),
-				Storage: aaStorage,
-			},
-			// The contract BB recreates AA
-			bb: {
-				Code:    bbCode,
-				Balance: big.NewInt(1),
-			},
-		},
+	// Import a heavier shorter but higher total difficulty chain with some known data as prefix.
+	if err := inserter(append(blocks, blocks3...), append(receipts, receipts3...)); err != nil {
+		t.Fatalf("failed to insert chain data: %v", err)
 	}
-	var nonce uint64
+	asserter(t, blocks3[len(blocks3)-1])
 
-	type expectation struct {
-		exist    bool
-		blocknum int
-		values   map[int]int
-	}
-	var current = &expectation{
-		exist:    true, // exists in genesis
-		blocknum: 0,
-		values:   map[int]int{1: 1, 2: 2},
+	// Import a longer but lower total difficulty chain with some known data as prefix.
+	if err := inserter(append(blocks, blocks2...), append(receipts, receipts2...)); err != nil {
+		t.Fatalf("failed to insert chain data: %v", err)
 	}
-	var expectations []*expectation
-	var newDestruct = func(e *expectation, b *BlockGen) *types.Transaction {
-		tx, _ := types.SignTx(types.NewTransaction(nonce, aa,
-			big.NewInt(0), 50000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
-		nonce++
-		if e.exist {
-			e.exist = false
-			e.values = nil
-		}
-		//t.Logf("block %d; adding destruct\n", e.blocknum)
-		return tx
+	// The head shouldn't change.
+	asserter(t, blocks3[len(blocks3)-1])
+
+	// Rollback the heavier chain and re-insert the longer chain again
+	chain.SetHead(rollback - 1)
+	if err := inserter(append(blocks, blocks2...), append(receipts, receipts2...)); err != nil {
+		t.Fatalf("failed to insert chain data: %v", err)
 	}
-	var newResurrect = func(e *expectation, b *BlockGen) *types.Transaction {
-		tx, _ := types.SignTx(types.NewTransaction(nonce, bb,
-			big.NewInt(0), 100000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
-		nonce++
-		if !e.exist {
-			e.exist = true
-			e.values = map[int]int{3: e.blocknum + 1, 4: 4}
+	asserter(t, blocks2[len(blocks2)-1])
+}
+
+func TestInsertKnownHeadersWithMerging(t *testing.T) {
+	testInsertKnownChainDataWithMerging(t, "headers", 0)
+}
+func TestInsertKnownReceiptChainWithMerging(t *testing.T) {
+	testInsertKnownChainDataWithMerging(t, "receipts", 0)
+}
+func TestInsertKnownBlocksWithMerging(t *testing.T) {
+	testInsertKnownChainDataWithMerging(t, "blocks", 0)
+}
+func TestInsertKnownHeadersAfterMerging(t *testing.T) {
+	testInsertKnownChainDataWithMerging(t, "headers", 1)
+}
+func TestInsertKnownReceiptChainAfterMerging(t *testing.T) {
+	testInsertKnownChainDataWithMerging(t, "receipts", 1)
+}
+func TestInsertKnownBlocksAfterMerging(t *testing.T) {
+	testInsertKnownChainDataWithMerging(t, "blocks", 1)
+}
+
+// mergeHeight can be assigned in these values:
+// 0: means the merging is applied since genesis
+// 1: means the merging is applied after the first segment
+func testInsertKnownChainDataWithMerging(t *testing.T, typ string, mergeHeight int) {
+	// Copy the TestChainConfig so we can modify it during tests
+	chainConfig := *params.TestChainConfig
+	var (
+		genesis = &Genesis{
+			BaseFee: big.NewInt(params.InitialBaseFee),
+			Config:  &chainConfig,
 		}
-		//t.Logf("block %d; adding resurrect\n", e.blocknum)
-		return tx
+		engine     = beacon.New(ethash.NewFaker())
+		mergeBlock = uint64(math.MaxUint64)
+	)
+	// Apply merging since genesis
+	if mergeHeight == 0 {
+		genesis.Config.TerminalTotalDifficulty = big.NewInt(0)
+		mergeBlock = uint64(0)
 	}
 
-	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 150, func(i int, b *BlockGen) {
-		var exp = new(expectation)
-		exp.blocknum = i + 1
-		exp.values = make(map[int]int)
-		for k, v := range current.values {
-			exp.values[k] = v
-		}
-		exp.exist = current.exist
+	genDb, blocks, receipts := GenerateChainWithGenesis(genesis, engine, 32,
+		func(i int, b *BlockGen) {
+			if b.header.Number.Uint64() >= mergeBlock {
+				b.SetPoS()
+			}
+			b.SetCoinbase(common.Address{1})
+		})
 
+	// Apply merging after the first segment
+	if mergeHeight == 1 {
+		// TTD is genesis diff + blocks
+		ttd := big.NewInt(1 + int64(len(blocks)))
+		ttd.Mul(ttd, params.GenesisDifficulty)
+		genesis.Config.TerminalTotalDifficulty = ttd
+		mergeBlock = uint64(len(blocks))
+	}
+	// Longer chain and shorter chain
+	blocks2, receipts2 := GenerateChain(genesis.Config, blocks[len(blocks)-1], engine, genDb, 65, func(i int, b *BlockGen) {
 		b.SetCoinbase(common.Address{1})
-		if i%2 == 0 {
-			b.AddTx(newDestruct(exp, b))
-		}
-		if i%3 == 0 {
-			b.AddTx(newResurrect(exp, b))
-		}
-		if i%5 == 0 {
-			b.AddTx(newDestruct(exp, b))
+		if b.header.Number.Uint64() >= mergeBlock {
+			b.SetPoS()
 		}
-		if i%7 == 0 {
-			b.AddTx(newResurrect(exp, b))
+	})
+	blocks3, receipts3 := GenerateChain(genesis.Config, blocks[len(blocks)-1], engine, genDb, 64, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{1})
+		b.OffsetTime(-9) // Time shifted, difficulty shouldn't be changed
+		if b.header.Number.Uint64() >= mergeBlock {
+			b.SetPoS()
 		}
-		expectations = append(expectations, exp)
-		current = exp
 	})
-	// Import the canonical chain
-	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{
-		//Debug:  true,
-		//Tracer: vm.NewJSONLogger(nil, os.Stdout),
-	}, nil, nil)
+	// Import the shared chain and the original canonical one
+	chaindb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
+	if err != nil {
+		t.Fatalf("failed to create temp freezer db: %v", err)
+	}
+	defer chaindb.Close()
+
+	chain, err := NewBlockChain(chaindb, nil, genesis, nil, engine, vm.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("failed to create tester chain: %v", err)
 	}
 	defer chain.Stop()
 
-	var asHash = func(num int) common.Hash {
-		return common.BytesToHash([]byte{byte(num)})
-	}
-	for i, block := range blocks {
-		blockNum := i + 1
-		if n, err := chain.InsertChain([]*types.Block{block}); err != nil {
-			t.Fatalf("block %d: failed to insert into chain: %v", n, err)
-		}
-		statedb, _ := chain.State()
-		// If all is correct, then slot 1 and 2 are zero
-		if got, exp := statedb.GetState(aa, common.HexToHash("01")), (common.Hash{}); got != exp {
-			t.Errorf("block %d, got %x exp %x", blockNum, got, exp)
-		}
-		if got, exp := statedb.GetState(aa, common.HexToHash("02")), (common.Hash{}); got != exp {
-			t.Errorf("block %d, got %x exp %x", blockNum, got, exp)
-		}
-		exp := expectations[i]
-		if exp.exist {
-			if !statedb.Exist(aa) {
-				t.Fatalf("block %d, expected %v to exist, it did not", blockNum, aa)
-			}
-			for slot, val := range exp.values {
-				if gotValue, expValue := statedb.GetState(aa, asHash(slot)), asHash(val); gotValue != expValue {
-					t.Fatalf("block %d, slot %d, got %x exp %x", blockNum, slot, gotValue, expValue)
-				}
+	var (
+		inserter func(blocks []*types.Block, receipts []types.Receipts) error
+		asserter func(t *testing.T, block *types.Block)
+	)
+	if typ == "headers" {
+		inserter = func(blocks []*types.Block, receipts []types.Receipts) error {
+			headers := make([]*types.Header, 0, len(blocks))
+			for _, block := range blocks {
+				headers = append(headers, block.Header())
 			}
-		} else {
-			if statedb.Exist(aa) {
-				t.Fatalf("block %d, expected %v to not exist, it did", blockNum, aa)
+			i, err := chain.InsertHeaderChain(headers)
+			if err != nil {
+				return fmt.Errorf("index %d, number %d: %w", i, headers[i].Number, err)
 			}
+			return err
 		}
+		asserter = func(t *testing.T, block *types.Block) {
+			if chain.CurrentHeader().Hash() != block.Hash() {
+				t.Fatalf("current head header mismatch, have %v, want %v", chain.CurrentHeader().Hash().Hex(), block.Hash().Hex())
+			}
+		}
+	} else if typ == "receipts" {
+		inserter = func(blocks []*types.Block, receipts []types.Receipts) error {
+			headers := make([]*types.Header, 0, len(blocks))
+			for _, block := range blocks {
+				headers = append(headers, block.Header())
+			}
+			i, err := chain.InsertHeaderChain(headers)
+			if err != nil {
+				return fmt.Errorf("index %d: %w", i, err)
+			}
+			_, err = chain.InsertReceiptChain(blocks, receipts, 0)
+			return err
+		}
+		asserter = func(t *testing.T, block *types.Block) {
+			if chain.CurrentSnapBlock().Hash() != block.Hash() {
+				t.Fatalf("current head fast block mismatch, have %v, want %v", chain.CurrentSnapBlock().Hash().Hex(), block.Hash().Hex())
+			}
+		}
+	} else {
+		inserter = func(blocks []*types.Block, receipts []types.Receipts) error {
+			i, err := chain.InsertChain(blocks)
+			if err != nil {
+				return fmt.Errorf("index %d: %w", i, err)
+			}
+			return nil
+		}
+		asserter = func(t *testing.T, block *types.Block) {
+			if chain.CurrentBlock().Hash() != block.Hash() {
+				t.Fatalf("current head block mismatch, have %v, want %v", chain.CurrentBlock().Hash().Hex(), block.Hash().Hex())
+			}
+		}
+	}
+	if err := inserter(blocks, receipts); err != nil {
+		t.Fatalf("failed to insert chain data: %v", err)
+	}
+
+	// Reimport the chain data again. All the imported
+	// chain data are regarded "known" data.
+	if err := inserter(blocks, receipts); err != nil {
+		t.Fatalf("failed to insert chain data: %v", err)
+	}
+	asserter(t, blocks[len(blocks)-1])
+
+	// Import a long canonical chain with some known data as prefix.
+	rollback := blocks[len(blocks)/2].NumberU64()
+	chain.SetHead(rollback - 1)
+	if err := inserter(blocks, receipts); err != nil {
+		t.Fatalf("failed to insert chain data: %v", err)
+	}
+	asserter(t, blocks[len(blocks)-1])
+
+	// Import a longer chain with some known data as prefix.
+	if err := inserter(append(blocks, blocks2...), append(receipts, receipts2...)); err != nil {
+		t.Fatalf("failed to insert chain data: %v", err)
+	}
+	asserter(t, blocks2[len(blocks2)-1])
+
+	// Import a shorter chain with some known data as prefix.
+	// The reorg is expected since the fork choice rule is
+	// already changed.
+	if err := inserter(append(blocks, blocks3...), append(receipts, receipts3...)); err != nil {
+		t.Fatalf("failed to insert chain data: %v", err)
+	}
+	// The head shouldn't change.
+	asserter(t, blocks3[len(blocks3)-1])
+
+	// Reimport the longer chain again, the reorg is still expected
+	chain.SetHead(rollback - 1)
+	if err := inserter(append(blocks, blocks2...), append(receipts, receipts2...)); err != nil {
+		t.Fatalf("failed to insert chain data: %v", err)
+	}
+	asserter(t, blocks2[len(blocks2)-1])
+}
+
+// getLongAndShortChains returns two chains: A is longer, B is heavier.
+func getLongAndShortChains() (*BlockChain, []*types.Block, []*types.Block, *Genesis, error) {
+	// Generate a canonical chain to act as the main dataset
+	engine := ethash.NewFaker()
+	genesis := &Genesis{
+		Config:  params.TestChainConfig,
+		BaseFee: big.NewInt(params.InitialBaseFee),
+	}
+	// Generate and import the canonical chain,
+	// Offset the time, to keep the difficulty low
+	genDb, longChain, _ := GenerateChainWithGenesis(genesis, engine, 80, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{1})
+	})
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, genesis, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("failed to create tester chain: %v", err)
+	}
+	// Generate fork chain, make it shorter than canon, with common ancestor pretty early
+	parentIndex := 3
+	parent := longChain[parentIndex]
+	heavyChainExt, _ := GenerateChain(genesis.Config, parent, engine, genDb, 75, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{2})
+		b.OffsetTime(-9)
+	})
+	var heavyChain []*types.Block
+	heavyChain = append(heavyChain, longChain[:parentIndex+1]...)
+	heavyChain = append(heavyChain, heavyChainExt...)
+
+	// Verify that the test is sane
+	var (
+		longerTd  = new(big.Int)
+		shorterTd = new(big.Int)
+	)
+	for index, b := range longChain {
+		longerTd.Add(longerTd, b.Difficulty())
+		if index <= parentIndex {
+			shorterTd.Add(shorterTd, b.Difficulty())
+		}
+	}
+	for _, b := range heavyChain {
+		shorterTd.Add(shorterTd, b.Difficulty())
+	}
+	if shorterTd.Cmp(longerTd) <= 0 {
+		return nil, nil, nil, nil, fmt.Errorf("test is moot, heavyChain td (%v) must be larger than canon td (%v)", shorterTd, longerTd)
+	}
+	longerNum := longChain[len(longChain)-1].NumberU64()
+	shorterNum := heavyChain[len(heavyChain)-1].NumberU64()
+	if shorterNum >= longerNum {
+		return nil, nil, nil, nil, fmt.Errorf("test is moot, heavyChain num (%v) must be lower than canon num (%v)", shorterNum, longerNum)
+	}
+	return chain, longChain, heavyChain, genesis, nil
+}
+
+// TestReorgToShorterRemovesCanonMapping tests that if we
+// 1. Have a chain [0 ... N .. X]
+// 2. Reorg to shorter but heavier chain [0 ... N ... Y]
+// 3. Then there should be no canon mapping for the block at height X
+// 4. The forked block should still be retrievable by hash
+func TestReorgToShorterRemovesCanonMapping(t *testing.T) {
+	chain, canonblocks, sideblocks, _, err := getLongAndShortChains()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(canonblocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	canonNum := chain.CurrentBlock().Number.Uint64()
+	canonHash := chain.CurrentBlock().Hash()
+	_, err = chain.InsertChain(sideblocks)
+	if err != nil {
+		t.Errorf("Got error, %v", err)
+	}
+	head := chain.CurrentBlock()
+	if got := sideblocks[len(sideblocks)-1].Hash(); got != head.Hash() {
+		t.Fatalf("head wrong, expected %x got %x", head.Hash(), got)
+	}
+	// We have now inserted a sidechain.
+	if blockByNum := chain.GetBlockByNumber(canonNum); blockByNum != nil {
+		t.Errorf("expected block to be gone: %v", blockByNum.NumberU64())
+	}
+	if headerByNum := chain.GetHeaderByNumber(canonNum); headerByNum != nil {
+		t.Errorf("expected header to be gone: %v", headerByNum.Number)
+	}
+	if blockByHash := chain.GetBlockByHash(canonHash); blockByHash == nil {
+		t.Errorf("expected block to be present: %x", blockByHash.Hash())
+	}
+	if headerByHash := chain.GetHeaderByHash(canonHash); headerByHash == nil {
+		t.Errorf("expected header to be present: %x", headerByHash.Hash())
+	}
+}
+
+// TestReorgToShorterRemovesCanonMappingHeaderChain is the same scenario
+// as TestReorgToShorterRemovesCanonMapping, but applied on headerchain
+// imports -- that is, for fast sync
+func TestReorgToShorterRemovesCanonMappingHeaderChain(t *testing.T) {
+	chain, canonblocks, sideblocks, _, err := getLongAndShortChains()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Stop()
+
+	// Convert into headers
+	canonHeaders := make([]*types.Header, len(canonblocks))
+	for i, block := range canonblocks {
+		canonHeaders[i] = block.Header()
+	}
+	if n, err := chain.InsertHeaderChain(canonHeaders); err != nil {
+		t.Fatalf("header %d: failed to insert into chain: %v", n, err)
+	}
+	canonNum := chain.CurrentHeader().Number.Uint64()
+	canonHash := chain.CurrentBlock().Hash()
+	sideHeaders := make([]*types.Header, len(sideblocks))
+	for i, block := range sideblocks {
+		sideHeaders[i] = block.Header()
+	}
+	if n, err := chain.InsertHeaderChain(sideHeaders); err != nil {
+		t.Fatalf("header %d: failed to insert into chain: %v", n, err)
+	}
+	head := chain.CurrentHeader()
+	if got := sideblocks[len(sideblocks)-1].Hash(); got != head.Hash() {
+		t.Fatalf("head wrong, expected %x got %x", head.Hash(), got)
+	}
+	// We have now inserted a sidechain.
+	if blockByNum := chain.GetBlockByNumber(canonNum); blockByNum != nil {
+		t.Errorf("expected block to be gone: %v", blockByNum.NumberU64())
+	}
+	if headerByNum := chain.GetHeaderByNumber(canonNum); headerByNum != nil {
+		t.Errorf("expected header to be gone: %v", headerByNum.Number.Uint64())
+	}
+	if blockByHash := chain.GetBlockByHash(canonHash); blockByHash == nil {
+		t.Errorf("expected block to be present: %x", blockByHash.Hash())
+	}
+	if headerByHash := chain.GetHeaderByHash(canonHash); headerByHash == nil {
+		t.Errorf("expected header to be present: %x", headerByHash.Hash())
+	}
+}
+
+func TestTransactionIndices(t *testing.T) {
+	// Configure and generate a sample block chain
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(100000000000000000)
+		gspec   = &Genesis{
+			Config:  params.TestChainConfig,
+			Alloc:   GenesisAlloc{address: {Balance: funds}},
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+		signer = types.LatestSigner(gspec.Config)
+	)
+	_, blocks, receipts := GenerateChainWithGenesis(gspec, ethash.NewFaker(), 128, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x00}, big.NewInt(1000), params.TxGas, block.header.BaseFee, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+
+	check := func(tail *uint64, chain *BlockChain) {
+		stored := rawdb.ReadTxIndexTail(chain.db)
+		if tail == nil && stored != nil {
+			t.Fatalf("Oldest indexded block mismatch, want nil, have %d", *stored)
+		}
+		if tail != nil && *stored != *tail {
+			t.Fatalf("Oldest indexded block mismatch, want %d, have %d", *tail, *stored)
+		}
+		if tail != nil {
+			for i := *tail; i <= chain.CurrentBlock().Number.Uint64(); i++ {
+				block := rawdb.ReadBlock(chain.db, rawdb.ReadCanonicalHash(chain.db, i), i)
+				if block.Transactions().Len() == 0 {
+					continue
+				}
+				for _, tx := range block.Transactions() {
+					if index := rawdb.ReadTxLookupEntry(chain.db, tx.Hash()); index == nil {
+						t.Fatalf("Miss transaction indice, number %d hash %s", i, tx.Hash().Hex())
+					}
+				}
+			}
+			for i := uint64(0); i < *tail; i++ {
+				block := rawdb.ReadBlock(chain.db, rawdb.ReadCanonicalHash(chain.db, i), i)
+				if block.Transactions().Len() == 0 {
+					continue
+				}
+				for _, tx := range block.Transactions() {
+					if index := rawdb.ReadTxLookupEntry(chain.db, tx.Hash()); index != nil {
+						t.Fatalf("Transaction indice should be deleted, number %d hash %s", i, tx.Hash().Hex())
+					}
+				}
+			}
+		}
+	}
+	// Init block chain with external ancients, check all needed indices has been indexed.
+	limit := []uint64{0, 32, 64, 128}
+	for _, l := range limit {
+		frdir := t.TempDir()
+		ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "", false, false, false, false)
+		if err != nil {
+			t.Fatalf("failed to create temp freezer db: %v", err)
+		}
+		rawdb.WriteAncientBlocks(ancientDb, append([]*types.Block{gspec.ToBlock()}, blocks...), append([]types.Receipts{{}}, receipts...), big.NewInt(0))
+
+		l := l
+		chain, err := NewBlockChain(ancientDb, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, &l)
+		if err != nil {
+			t.Fatalf("failed to create tester chain: %v", err)
+		}
+		chain.indexBlocks(rawdb.ReadTxIndexTail(ancientDb), 128, make(chan struct{}))
+
+		var tail uint64
+		if l != 0 {
+			tail = uint64(128) - l + 1
+		}
+		check(&tail, chain)
+		chain.Stop()
+		ancientDb.Close()
+		os.RemoveAll(frdir)
+	}
+
+	// Reconstruct a block chain which only reserves HEAD-64 tx indices
+	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
+	if err != nil {
+		t.Fatalf("failed to create temp freezer db: %v", err)
+	}
+	defer ancientDb.Close()
+
+	rawdb.WriteAncientBlocks(ancientDb, append([]*types.Block{gspec.ToBlock()}, blocks...), append([]types.Receipts{{}}, receipts...), big.NewInt(0))
+	limit = []uint64{0, 64 /* drop stale */, 32 /* shorten history */, 64 /* extend history */, 0 /* restore all */}
+	for _, l := range limit {
+		l := l
+		chain, err := NewBlockChain(ancientDb, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, &l)
+		if err != nil {
+			t.Fatalf("failed to create tester chain: %v", err)
+		}
+		var tail uint64
+		if l != 0 {
+			tail = uint64(128) - l + 1
+		}
+		chain.indexBlocks(rawdb.ReadTxIndexTail(ancientDb), 128, make(chan struct{}))
+		check(&tail, chain)
+		chain.Stop()
+	}
+}
+
+func TestSkipStaleTxIndicesInSnapSync(t *testing.T) {
+	// Configure and generate a sample block chain
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(100000000000000000)
+		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{address: {Balance: funds}}}
+		signer  = types.LatestSigner(gspec.Config)
+	)
+	_, blocks, receipts := GenerateChainWithGenesis(gspec, ethash.NewFaker(), 128, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{0x00}, big.NewInt(1000), params.TxGas, block.header.BaseFee, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+
+	check := func(tail *uint64, chain *BlockChain) {
+		stored := rawdb.ReadTxIndexTail(chain.db)
+		if tail == nil && stored != nil {
+			t.Fatalf("Oldest indexded block mismatch, want nil, have %d", *stored)
+		}
+		if tail != nil && *stored != *tail {
+			t.Fatalf("Oldest indexded block mismatch, want %d, have %d", *tail, *stored)
+		}
+		if tail != nil {
+			for i := *tail; i <= chain.CurrentBlock().Number.Uint64(); i++ {
+				block := rawdb.ReadBlock(chain.db, rawdb.ReadCanonicalHash(chain.db, i), i)
+				if block.Transactions().Len() == 0 {
+					continue
+				}
+				for _, tx := range block.Transactions() {
+					if index := rawdb.ReadTxLookupEntry(chain.db, tx.Hash()); index == nil {
+						t.Fatalf("Miss transaction indice, number %d hash %s", i, tx.Hash().Hex())
+					}
+				}
+			}
+			for i := uint64(0); i < *tail; i++ {
+				block := rawdb.ReadBlock(chain.db, rawdb.ReadCanonicalHash(chain.db, i), i)
+				if block.Transactions().Len() == 0 {
+					continue
+				}
+				for _, tx := range block.Transactions() {
+					if index := rawdb.ReadTxLookupEntry(chain.db, tx.Hash()); index != nil {
+						t.Fatalf("Transaction indice should be deleted, number %d hash %s", i, tx.Hash().Hex())
+					}
+				}
+			}
+		}
+	}
+
+	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
+	if err != nil {
+		t.Fatalf("failed to create temp freezer db: %v", err)
+	}
+	defer ancientDb.Close()
+
+	// Import all blocks into ancient db, only HEAD-32 indices are kept.
+	l := uint64(32)
+	chain, err := NewBlockChain(ancientDb, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, &l)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	if n, err := chain.InsertHeaderChain(headers); err != nil {
+		t.Fatalf("failed to insert header %d: %v", n, err)
+	}
+	// The indices before ancient-N(32) should be ignored. After that all blocks should be indexed.
+	if n, err := chain.InsertReceiptChain(blocks, receipts, 64); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	tail := uint64(32)
+	check(&tail, chain)
+}
+
+// Benchmarks large blocks with value transfers to non-existing accounts
+func benchmarkLargeNumberOfValueToNonexisting(b *testing.B, numTxs, numBlocks int, recipientFn func(uint64) common.Address, dataFn func(uint64) []byte) {
+	var (
+		signer          = types.HomesteadSigner{}
+		testBankKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		testBankAddress = crypto.PubkeyToAddress(testBankKey.PublicKey)
+		bankFunds       = big.NewInt(100000000000000000)
+		gspec           = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				testBankAddress: {Balance: bankFunds},
+				common.HexToAddress("0xc0de"): {
+					Code:    []byte{0x60, 0x01, 0x50},
+					Balance: big.NewInt(0),
+				}, // push 1, pop
+			},
+			GasLimit: 100e6, // 100 M
+		}
+	)
+	// Generate the original common chain segment and the two competing forks
+	engine := ethash.NewFaker()
+
+	blockGenerator := func(i int, block *BlockGen) {
+		block.SetCoinbase(common.Address{1})
+		for txi := 0; txi < numTxs; txi++ {
+			uniq := uint64(i*numTxs + txi)
+			recipient := recipientFn(uniq)
+			tx, err := types.SignTx(types.NewTransaction(uniq, recipient, big.NewInt(1), params.TxGas, block.header.BaseFee, nil), signer, testBankKey)
+			if err != nil {
+				b.Error(err)
+			}
+			block.AddTx(tx)
+		}
+	}
+
+	_, shared, _ := GenerateChainWithGenesis(gspec, engine, numBlocks, blockGenerator)
+	b.StopTimer()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Import the shared chain and the original canonical one
+		chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+		if err != nil {
+			b.Fatalf("failed to create tester chain: %v", err)
+		}
+		b.StartTimer()
+		if _, err := chain.InsertChain(shared); err != nil {
+			b.Fatalf("failed to insert shared chain: %v", err)
+		}
+		b.StopTimer()
+		block := chain.GetBlockByHash(chain.CurrentBlock().Hash())
+		if got := block.Transactions().Len(); got != numTxs*numBlocks {
+			b.Fatalf("Transactions were not included, expected %d, got %d", numTxs*numBlocks, got)
+		}
+	}
+}
+
+func BenchmarkBlockChain_1x1000ValueTransferToNonexisting(b *testing.B) {
+	var (
+		numTxs    = 1000
+		numBlocks = 1
+	)
+	recipientFn := func(nonce uint64) common.Address {
+		return common.BigToAddress(new(big.Int).SetUint64(1337 + nonce))
+	}
+	dataFn := func(nonce uint64) []byte {
+		return nil
+	}
+	benchmarkLargeNumberOfValueToNonexisting(b, numTxs, numBlocks, recipientFn, dataFn)
+}
+
+func BenchmarkBlockChain_1x1000ValueTransferToExisting(b *testing.B) {
+	var (
+		numTxs    = 1000
+		numBlocks = 1
+	)
+	b.StopTimer()
+	b.ResetTimer()
+
+	recipientFn := func(nonce uint64) common.Address {
+		return common.BigToAddress(new(big.Int).SetUint64(1337))
+	}
+	dataFn := func(nonce uint64) []byte {
+		return nil
+	}
+	benchmarkLargeNumberOfValueToNonexisting(b, numTxs, numBlocks, recipientFn, dataFn)
+}
+
+func BenchmarkBlockChain_1x1000Executions(b *testing.B) {
+	var (
+		numTxs    = 1000
+		numBlocks = 1
+	)
+	b.StopTimer()
+	b.ResetTimer()
+
+	recipientFn := func(nonce uint64) common.Address {
+		return common.BigToAddress(new(big.Int).SetUint64(0xc0de))
+	}
+	dataFn := func(nonce uint64) []byte {
+		return nil
+	}
+	benchmarkLargeNumberOfValueToNonexisting(b, numTxs, numBlocks, recipientFn, dataFn)
+}
+
+// Tests that importing a some old blocks, where all blocks are before the
+// pruning point.
+// This internally leads to a sidechain import, since the blocks trigger an
+// ErrPrunedAncestor error.
+// This may e.g. happen if
+//  1. Downloader rollbacks a batch of inserted blocks and exits
+//  2. Downloader starts to sync again
+//  3. The blocks fetched are all known and canonical blocks
+func TestSideImportPrunedBlocks(t *testing.T) {
+	// Generate a canonical chain to act as the main dataset
+	engine := ethash.NewFaker()
+	genesis := &Genesis{
+		Config:  params.TestChainConfig,
+		BaseFee: big.NewInt(params.InitialBaseFee),
+	}
+	// Generate and import the canonical chain
+	_, blocks, _ := GenerateChainWithGenesis(genesis, engine, 2*TriesInMemory, nil)
+
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, genesis, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+
+	lastPrunedIndex := len(blocks) - TestTriesInMemory - 1
+	lastPrunedBlock := blocks[lastPrunedIndex-1]
+
+	// Verify pruning of lastPrunedBlock
+	if chain.HasBlockAndState(lastPrunedBlock.Hash(), lastPrunedBlock.NumberU64()) {
+		t.Errorf("Block %d not pruned", lastPrunedBlock.NumberU64())
+	}
+	firstNonPrunedBlock := blocks[len(blocks)-TestTriesInMemory]
+	// Verify firstNonPrunedBlock is not pruned
+	if !chain.HasBlockAndState(firstNonPrunedBlock.Hash(), firstNonPrunedBlock.NumberU64()) {
+		t.Errorf("Block %d pruned", firstNonPrunedBlock.NumberU64())
+	}
+	// Now re-import some old blocks
+	blockToReimport := blocks[5:8]
+	_, err = chain.InsertChain(blockToReimport)
+	if err != nil {
+		t.Errorf("Got error, %v", err)
+	}
+}
+
+// Tests that a batch hitting a pruned ancestor which is resubmitted over and over
+// on top of the same head is short circuited, but processed again once the head
+// moves on.
+func TestSideImportPrunedBlocksResubmit(t *testing.T) {
+	engine := ethash.NewFaker()
+	genesis := &Genesis{
+		Config:  params.TestChainConfig,
+		BaseFee: big.NewInt(params.InitialBaseFee),
+	}
+	_, blocks, _ := GenerateChainWithGenesis(genesis, engine, 2*TriesInMemory+1, nil)
+
+	cacheConfig := *defaultCacheConfig
+	cacheConfig.MaxPrunedReimports = 2
+
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), &cacheConfig, genesis, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks[:len(blocks)-1]); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	reimport := blocks[5:8]
+	for i := 0; i < cacheConfig.MaxPrunedReimports; i++ {
+		if _, err := chain.InsertChain(reimport); err != nil {
+			t.Fatalf("reimport %d: unexpected error: %v", i, err)
+		}
+	}
+	if _, err := chain.InsertChain(reimport); !errors.Is(err, ErrKnownPrunedBlock) {
+		t.Fatalf("resubmission error mismatch: have %v, want %v", err, ErrKnownPrunedBlock)
+	}
+	// Extend the chain, the same batch is now imported in a different context
+	if _, err := chain.InsertChain(blocks[len(blocks)-1:]); err != nil {
+		t.Fatalf("failed to extend chain: %v", err)
+	}
+	if _, err := chain.InsertChain(reimport); err != nil {
+		t.Fatalf("reimport after head change: unexpected error: %v", err)
+	}
+}
+
+// Tests that the availability of states is reported correctly for states kept
+// in memory, persisted ones and pruned ones.
+func TestStateAvailability(t *testing.T) {
+	testStateAvailability(t, rawdb.HashScheme)
+	testStateAvailability(t, rawdb.PathScheme)
+}
+
+func testStateAvailability(t *testing.T, scheme string) {
+	engine := ethash.NewFaker()
+	genesis := &Genesis{
+		Config:  params.TestChainConfig,
+		BaseFee: big.NewInt(params.InitialBaseFee),
+	}
+	_, blocks, _ := GenerateChainWithGenesis(genesis, engine, 2*TriesInMemory, nil)
+
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), DefaultCacheConfigWithScheme(scheme), genesis, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	check := func(name string, root common.Hash, wantMemory, wantDisk bool) {
+		t.Helper()
+		if inMemory, onDisk := chain.StateAvailability(root); inMemory != wantMemory || onDisk != wantDisk {
+			t.Errorf("%s, %s: availability mismatch: have (%v, %v), want (%v, %v)", scheme, name, inMemory, onDisk, wantMemory, wantDisk)
+		}
+		if have := chain.HasFullState(root); have != wantDisk {
+			t.Errorf("%s, %s: full state mismatch: have %v, want %v", scheme, name, have, wantDisk)
+		}
+	}
+	// Find the oldest state still retained by the chain
+	oldest := len(blocks) - 1
+	for oldest > 0 && chain.HasState(blocks[oldest-1].Root()) {
+		oldest--
+	}
+	if oldest == 0 {
+		t.Fatalf("%s: no state pruned", scheme)
+	}
+	check("head", blocks[len(blocks)-1].Root(), true, false)
+	check("pruned", blocks[oldest-1].Root(), false, false)
+
+	if scheme == rawdb.PathScheme {
+		// The oldest retained state is the disk layer
+		check("oldest", blocks[oldest].Root(), false, true)
+		check("oldest diff", blocks[oldest+1].Root(), true, false)
+	} else {
+		// Only the genesis was flushed, the other retained states are in memory
+		check("genesis", chain.Genesis().Root(), false, true)
+		check("oldest", blocks[oldest].Root(), true, false)
+	}
+}
+
+// TestDeleteCreateRevert tests a weird state transition corner case that we hit
+// while changing the internals of statedb. The workflow is that a contract is
+// self destructed, then in a followup transaction (but same block) it's created
+// again and the transaction reverted.
+//
+// The original statedb implementation flushed dirty objects to the tries after
+// each transaction, so this works ok. The rework accumulated writes in memory
+// first, but the journal wiped the entire state object on create-revert.
+func TestDeleteCreateRevert(t *testing.T) {
+	var (
+		aa     = common.HexToAddress("0x000000000000000000000000000000000000aaaa")
+		bb     = common.HexToAddress("0x000000000000000000000000000000000000bbbb")
+		engine = ethash.NewFaker()
+
+		// A sender who makes transactions, has some funds
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(100000000000000000)
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				address: {Balance: funds},
+				// The address 0xAAAAA selfdestructs if called
+				aa: {
+					// Code needs to just selfdestruct
+					Code:    []byte{byte(vm.PC), byte(vm.SELFDESTRUCT)},
+					Nonce:   1,
+					Balance: big.NewInt(0),
+				},
+				// The address 0xBBBB send 1 wei to 0xAAAA, then reverts
+				bb: {
+					Code: []byte{
+						byte(vm.PC),          // [0]
+						byte(vm.DUP1),        // [0,0]
+						byte(vm.DUP1),        // [0,0,0]
+						byte(vm.DUP1),        // [0,0,0,0]
+						byte(vm.PUSH1), 0x01, // [0,0,0,0,1] (value)
+						byte(vm.PUSH2), 0xaa, 0xaa, // [0,0,0,0,1, 0xaaaa]
+						byte(vm.GAS),
+						byte(vm.CALL),
+						byte(vm.REVERT),
+					},
+					Balance: big.NewInt(1),
+				},
+			},
+		}
+	)
+
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{1})
+		// One transaction to AAAA
+		tx, _ := types.SignTx(types.NewTransaction(0, aa,
+			big.NewInt(0), 50000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
+		b.AddTx(tx)
+		// One transaction to BBBB
+		tx, _ = types.SignTx(types.NewTransaction(1, bb,
+			big.NewInt(0), 100000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
+		b.AddTx(tx)
+	})
+	// Import the canonical chain
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+}
+
+// TestDeleteRecreateSlots tests a state-transition that contains both deletion
+// and recreation of contract state.
+// Contract A exists, has slots 1 and 2 set
+// Tx 1: Selfdestruct A
+// Tx 2: Re-create A, set slots 3 and 4
+// Expected outcome is that _all_ slots are cleared from A, due to the selfdestruct,
+// and then the new slots exist
+func TestDeleteRecreateSlots(t *testing.T) {
+	var (
+		engine = ethash.NewFaker()
+
+		// A sender who makes transactions, has some funds
+		key, _    = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address   = crypto.PubkeyToAddress(key.PublicKey)
+		funds     = big.NewInt(1000000000000000)
+		bb        = common.HexToAddress("0x000000000000000000000000000000000000bbbb")
+		aaStorage = make(map[common.Hash]common.Hash)          // Initial storage in AA
+		aaCode    = []byte{byte(vm.PC), byte(vm.SELFDESTRUCT)} // Code for AA (simple selfdestruct)
+	)
+	// Populate two slots
+	aaStorage[common.HexToHash("01")] = common.HexToHash("01")
+	aaStorage[common.HexToHash("02")] = common.HexToHash("02")
+
+	// The bb-code needs to CREATE2 the aa contract. It consists of
+	// both initcode and deployment code
+	// initcode:
+	// 1. Set slots 3=3, 4=4,
+	// 2. Return aaCode
+
+	initCode := []byte{
+		byte(vm.PUSH1), 0x3, // value
+		byte(vm.PUSH1), 0x3, // location
+		byte(vm.SSTORE),     // Set slot[3] = 3
+		byte(vm.PUSH1), 0x4, // value
+		byte(vm.PUSH1), 0x4, // location
+		byte(vm.SSTORE), // Set slot[4] = 4
+		// Slots are set, now return the code
+		byte(vm.PUSH2), byte(vm.PC), byte(vm.SELFDESTRUCT), // Push code on stack
+		byte(vm.PUSH1), 0x0, // memory start on stack
+		byte(vm.MSTORE),
+		// Code is now in memory.
+		byte(vm.PUSH1), 0x2, // size
+		byte(vm.PUSH1), byte(32 - 2), // offset
+		byte(vm.RETURN),
+	}
+	if l := len(initCode); l > 32 {
+		t.Fatalf("init code is too long for a pushx, need a more elaborate deployer")
+	}
+	bbCode := []byte{
+		// Push initcode onto stack
+		byte(vm.PUSH1) + byte(len(initCode)-1)}
+	bbCode = append(bbCode, initCode...)
+	bbCode = append(bbCode, []byte{
+		byte(vm.PUSH1), 0x0, // memory start on stack
+		byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x00, // salt
+		byte(vm.PUSH1), byte(len(initCode)), // size
+		byte(vm.PUSH1), byte(32 - len(initCode)), // offset
+		byte(vm.PUSH1), 0x00, // endowment
+		byte(vm.CREATE2),
+	}...)
+
+	initHash := crypto.Keccak256Hash(initCode)
+	aa := crypto.CreateAddress2(bb, [32]byte{}, initHash[:])
+	t.Logf("Destination address: %x\n", aa)
+
+	gspec := &Genesis{
+		Config: params.TestChainConfig,
+		Alloc: GenesisAlloc{
+			address: {Balance: funds},
+			// The address 0xAAAAA selfdestructs if called
+			aa: {
+				// Code needs to just selfdestruct
+				Code:    aaCode,
+				Nonce:   1,
+				Balance: big.NewInt(0),
+				Storage: aaStorage,
+			},
+			// The contract BB recreates AA
+			bb: {
+				Code:    bbCode,
+				Balance: big.NewInt(1),
+			},
+		},
+	}
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{1})
+		// One transaction to AA, to kill it
+		tx, _ := types.SignTx(types.NewTransaction(0, aa,
+			big.NewInt(0), 50000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
+		b.AddTx(tx)
+		// One transaction to BB, to recreate AA
+		tx, _ = types.SignTx(types.NewTransaction(1, bb,
+			big.NewInt(0), 100000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
+		b.AddTx(tx)
+	})
+	// Import the canonical chain
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{
+		Tracer: logger.NewJSONLogger(nil, os.Stdout),
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	statedb, _ := chain.State()
+
+	// If all is correct, then slot 1 and 2 are zero
+	if got, exp := statedb.GetState(aa, common.HexToHash("01")), (common.Hash{}); got != exp {
+		t.Errorf("got %x exp %x", got, exp)
+	}
+	if got, exp := statedb.GetState(aa, common.HexToHash("02")), (common.Hash{}); got != exp {
+		t.Errorf("got %x exp %x", got, exp)
+	}
+	// Also, 3 and 4 should be set
+	if got, exp := statedb.GetState(aa, common.HexToHash("03")), common.HexToHash("03"); got != exp {
+		t.Fatalf("got %x exp %x", got, exp)
+	}
+	if got, exp := statedb.GetState(aa, common.HexToHash("04")), common.HexToHash("04"); got != exp {
+		t.Fatalf("got %x exp %x", got, exp)
+	}
+}
+
+// TestDeleteRecreateAccount tests a state-transition that contains deletion of a
+// contract with storage, and a recreate of the same contract via a
+// regular value-transfer
+// Expected outcome is that _all_ slots are cleared from A
+func TestDeleteRecreateAccount(t *testing.T) {
+	var (
+		engine = ethash.NewFaker()
+
+		// A sender who makes transactions, has some funds
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(1000000000000000)
+
+		aa        = common.HexToAddress("0x7217d81b76bdd8707601e959454e3d776aee5f43")
+		aaStorage = make(map[common.Hash]common.Hash)          // Initial storage in AA
+		aaCode    = []byte{byte(vm.PC), byte(vm.SELFDESTRUCT)} // Code for AA (simple selfdestruct)
+	)
+	// Populate two slots
+	aaStorage[common.HexToHash("01")] = common.HexToHash("01")
+	aaStorage[common.HexToHash("02")] = common.HexToHash("02")
+
+	gspec := &Genesis{
+		Config: params.TestChainConfig,
+		Alloc: GenesisAlloc{
+			address: {Balance: funds},
+			// The address 0xAAAAA selfdestructs if called
+			aa: {
+				// Code needs to just selfdestruct
+				Code:    aaCode,
+				Nonce:   1,
+				Balance: big.NewInt(0),
+				Storage: aaStorage,
+			},
+		},
+	}
+
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{1})
+		// One transaction to AA, to kill it
+		tx, _ := types.SignTx(types.NewTransaction(0, aa,
+			big.NewInt(0), 50000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
+		b.AddTx(tx)
+		// One transaction to AA, to recreate it (but without storage
+		tx, _ = types.SignTx(types.NewTransaction(1, aa,
+			big.NewInt(1), 100000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
+		b.AddTx(tx)
+	})
+	// Import the canonical chain
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{
+		Tracer: logger.NewJSONLogger(nil, os.Stdout),
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	statedb, _ := chain.State()
+
+	// If all is correct, then both slots are zero
+	if got, exp := statedb.GetState(aa, common.HexToHash("01")), (common.Hash{}); got != exp {
+		t.Errorf("got %x exp %x", got, exp)
+	}
+	if got, exp := statedb.GetState(aa, common.HexToHash("02")), (common.Hash{}); got != exp {
+		t.Errorf("got %x exp %x", got, exp)
+	}
+}
+
+// TestDeleteRecreateSlotsAcrossManyBlocks tests multiple state-transition that contains both deletion
+// and recreation of contract state.
+// Contract A exists, has slots 1 and 2 set
+// Tx 1: Selfdestruct A
+// Tx 2: Re-create A, set slots 3 and 4
+// Expected outcome is that _all_ slots are cleared from A, due to the selfdestruct,
+// and then the new slots exist
+func TestDeleteRecreateSlotsAcrossManyBlocks(t *testing.T) {
+	var (
+		engine = ethash.NewFaker()
+
+		// A sender who makes transactions, has some funds
+		key, _    = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address   = crypto.PubkeyToAddress(key.PublicKey)
+		funds     = big.NewInt(1000000000000000)
+		bb        = common.HexToAddress("0x000000000000000000000000000000000000bbbb")
+		aaStorage = make(map[common.Hash]common.Hash)          // Initial storage in AA
+		aaCode    = []byte{byte(vm.PC), byte(vm.SELFDESTRUCT)} // Code for AA (simple selfdestruct)
+	)
+	// Populate two slots
+	aaStorage[common.HexToHash("01")] = common.HexToHash("01")
+	aaStorage[common.HexToHash("02")] = common.HexToHash("02")
+
+	// The bb-code needs to CREATE2 the aa contract. It consists of
+	// both initcode and deployment code
+	// initcode:
+	// 1. Set slots 3=blocknum+1, 4=4,
+	// 2. Return aaCode
+
+	initCode := []byte{
+		byte(vm.PUSH1), 0x1, //
+		byte(vm.NUMBER),     // value = number + 1
+		byte(vm.ADD),        //
+		byte(vm.PUSH1), 0x3, // location
+		byte(vm.SSTORE),     // Set slot[3] = number + 1
+		byte(vm.PUSH1), 0x4, // value
+		byte(vm.PUSH1), 0x4, // location
+		byte(vm.SSTORE), // Set slot[4] = 4
+		// Slots are set, now return the code
+		byte(vm.PUSH2), byte(vm.PC), byte(vm.SELFDESTRUCT), // Push code on stack
+		byte(vm.PUSH1), 0x0, // memory start on stack
+		byte(vm.MSTORE),
+		// Code is now in memory.
+		byte(vm.PUSH1), 0x2, // size
+		byte(vm.PUSH1), byte(32 - 2), // offset
+		byte(vm.RETURN),
+	}
+	if l := len(initCode); l > 32 {
+		t.Fatalf("init code is too long for a pushx, need a more elaborate deployer")
+	}
+	bbCode := []byte{
+		// Push initcode onto stack
+		byte(vm.PUSH1) + byte(len(initCode)-1)}
+	bbCode = append(bbCode, initCode...)
+	bbCode = append(bbCode, []byte{
+		byte(vm.PUSH1), 0x0, // memory start on stack
+		byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x00, // salt
+		byte(vm.PUSH1), byte(len(initCode)), // size
+		byte(vm.PUSH1), byte(32 - len(initCode)), // offset
+		byte(vm.PUSH1), 0x00, // endowment
+		byte(vm.CREATE2),
+	}...)
+
+	initHash := crypto.Keccak256Hash(initCode)
+	aa := crypto.CreateAddress2(bb, [32]byte{}, initHash[:])
+	t.Logf("Destination address: %x\n", aa)
+	gspec := &Genesis{
+		Config: params.TestChainConfig,
+		Alloc: GenesisAlloc{
+			address: {Balance: funds},
+			// The address 0xAAAAA selfdestructs if called
+			aa: {
+				// Code needs to just selfdestruct
+				Code:    aaCode,
+				Nonce:   1,
+				Balance: big.NewInt(0),
+				Storage: aaStorage,
+			},
+			// The contract BB recreates AA
+			bb: {
+				Code:    bbCode,
+				Balance: big.NewInt(1),
+			},
+		},
+	}
+	var nonce uint64
+
+	type expectation struct {
+		exist    bool
+		blocknum int
+		values   map[int]int
+	}
+	var current = &expectation{
+		exist:    true, // exists in genesis
+		blocknum: 0,
+		values:   map[int]int{1: 1, 2: 2},
+	}
+	var expectations []*expectation
+	var newDestruct = func(e *expectation, b *BlockGen) *types.Transaction {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, aa,
+			big.NewInt(0), 50000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
+		nonce++
+		if e.exist {
+			e.exist = false
+			e.values = nil
+		}
+		//t.Logf("block %d; adding destruct\n", e.blocknum)
+		return tx
+	}
+	var newResurrect = func(e *expectation, b *BlockGen) *types.Transaction {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, bb,
+			big.NewInt(0), 100000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
+		nonce++
+		if !e.exist {
+			e.exist = true
+			e.values = map[int]int{3: e.blocknum + 1, 4: 4}
+		}
+		//t.Logf("block %d; adding resurrect\n", e.blocknum)
+		return tx
+	}
+
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 150, func(i int, b *BlockGen) {
+		var exp = new(expectation)
+		exp.blocknum = i + 1
+		exp.values = make(map[int]int)
+		for k, v := range current.values {
+			exp.values[k] = v
+		}
+		exp.exist = current.exist
+
+		b.SetCoinbase(common.Address{1})
+		if i%2 == 0 {
+			b.AddTx(newDestruct(exp, b))
+		}
+		if i%3 == 0 {
+			b.AddTx(newResurrect(exp, b))
+		}
+		if i%5 == 0 {
+			b.AddTx(newDestruct(exp, b))
+		}
+		if i%7 == 0 {
+			b.AddTx(newResurrect(exp, b))
+		}
+		expectations = append(expectations, exp)
+		current = exp
+	})
+	// Import the canonical chain
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{
+		//Debug:  true,
+		//Tracer: vm.NewJSONLogger(nil, os.Stdout),
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	var asHash = func(num int) common.Hash {
+		return common.BytesToHash([]byte{byte(num)})
+	}
+	for i, block := range blocks {
+		blockNum := i + 1
+		if n, err := chain.InsertChain([]*types.Block{block}); err != nil {
+			t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+		}
+		statedb, _ := chain.State()
+		// If all is correct, then slot 1 and 2 are zero
+		if got, exp := statedb.GetState(aa, common.HexToHash("01")), (common.Hash{}); got != exp {
+			t.Errorf("block %d, got %x exp %x", blockNum, got, exp)
+		}
+		if got, exp := statedb.GetState(aa, common.HexToHash("02")), (common.Hash{}); got != exp {
+			t.Errorf("block %d, got %x exp %x", blockNum, got, exp)
+		}
+		exp := expectations[i]
+		if exp.exist {
+			if !statedb.Exist(aa) {
+				t.Fatalf("block %d, expected %v to exist, it did not", blockNum, aa)
+			}
+			for slot, val := range exp.values {
+				if gotValue, expValue := statedb.GetState(aa, asHash(slot)), asHash(val); gotValue != expValue {
+					t.Fatalf("block %d, slot %d, got %x exp %x", blockNum, slot, gotValue, expValue)
+				}
+			}
+		} else {
+			if statedb.Exist(aa) {
+				t.Fatalf("block %d, expected %v to not exist, it did", blockNum, aa)
+			}
+		}
+	}
+}
+
+// TestInitThenFailCreateContract tests a pretty notorious case that happened
+// on mainnet over blocks 7338108, 7338110 and 7338115.
+//   - Block 7338108: address e771789f5cccac282f23bb7add5690e1f6ca467c is initiated
+//     with 0.001 ether (thus created but no code)
+//   - Block 7338110: a CREATE2 is attempted. The CREATE2 would deploy code on
+//     the same address e771789f5cccac282f23bb7add5690e1f6ca467c. However, the
+//     deployment fails due to OOG during initcode execution
+//   - Block 7338115: another tx checks the balance of
+//     e771789f5cccac282f23bb7add5690e1f6ca467c, and the snapshotter returned it as
+//     zero.
+//
+// The problem being that the snapshotter maintains a destructset, and adds items
+// to the destructset in case something is created "onto" an existing item.
+// We need to either roll back the snapDestructs, or not place it into snapDestructs
+// in the first place.
+func TestInitThenFailCreateContract(t *testing.T) {
+	var (
+		engine = ethash.NewFaker()
+
+		// A sender who makes transactions, has some funds
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(1000000000000000)
+		bb      = common.HexToAddress("0x000000000000000000000000000000000000bbbb")
+	)
+
+	// The bb-code needs to CREATE2 the aa contract. It consists of
+	// both initcode and deployment code
+	// initcode:
+	// 1. If blocknum < 1, error out (e.g invalid opcode)
+	// 2. else, return a snippet of code
+	initCode := []byte{
+		byte(vm.PUSH1), 0x1, // y (2)
+		byte(vm.NUMBER), // x (number)
+		byte(vm.GT),     // x > y?
+		byte(vm.PUSH1), byte(0x8),
+		byte(vm.JUMPI), // jump to label if number > 2
+		byte(0xFE),     // illegal opcode
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 0x2, // size
+		byte(vm.PUSH1), 0x0, // offset
+		byte(vm.RETURN), // return 2 bytes of zero-code
+	}
+	if l := len(initCode); l > 32 {
+		t.Fatalf("init code is too long for a pushx, need a more elaborate deployer")
+	}
+	bbCode := []byte{
+		// Push initcode onto stack
+		byte(vm.PUSH1) + byte(len(initCode)-1)}
+	bbCode = append(bbCode, initCode...)
+	bbCode = append(bbCode, []byte{
+		byte(vm.PUSH1), 0x0, // memory start on stack
+		byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x00, // salt
+		byte(vm.PUSH1), byte(len(initCode)), // size
+		byte(vm.PUSH1), byte(32 - len(initCode)), // offset
+		byte(vm.PUSH1), 0x00, // endowment
+		byte(vm.CREATE2),
+	}...)
+
+	initHash := crypto.Keccak256Hash(initCode)
+	aa := crypto.CreateAddress2(bb, [32]byte{}, initHash[:])
+	t.Logf("Destination address: %x\n", aa)
+
+	gspec := &Genesis{
+		Config: params.TestChainConfig,
+		Alloc: GenesisAlloc{
+			address: {Balance: funds},
+			// The address aa has some funds
+			aa: {Balance: big.NewInt(100000)},
+			// The contract BB tries to create code onto AA
+			bb: {
+				Code:    bbCode,
+				Balance: big.NewInt(1),
+			},
+		},
+	}
+	nonce := uint64(0)
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 4, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{1})
+		// One transaction to BB
+		tx, _ := types.SignTx(types.NewTransaction(nonce, bb,
+			big.NewInt(0), 100000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
+		b.AddTx(tx)
+		nonce++
+	})
+
+	// Import the canonical chain
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{
+		//Debug:  true,
+		//Tracer: vm.NewJSONLogger(nil, os.Stdout),
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	statedb, _ := chain.State()
+	if got, exp := statedb.GetBalance(aa), big.NewInt(100000); got.Cmp(exp) != 0 {
+		t.Fatalf("Genesis err, got %v exp %v", got, exp)
+	}
+	// First block tries to create, but fails
+	{
+		block := blocks[0]
+		if _, err := chain.InsertChain([]*types.Block{blocks[0]}); err != nil {
+			t.Fatalf("block %d: failed to insert into chain: %v", block.NumberU64(), err)
+		}
+		statedb, _ = chain.State()
+		if got, exp := statedb.GetBalance(aa), big.NewInt(100000); got.Cmp(exp) != 0 {
+			t.Fatalf("block %d: got %v exp %v", block.NumberU64(), got, exp)
+		}
+	}
+	// Import the rest of the blocks
+	for _, block := range blocks[1:] {
+		if _, err := chain.InsertChain([]*types.Block{block}); err != nil {
+			t.Fatalf("block %d: failed to insert into chain: %v", block.NumberU64(), err)
+		}
+	}
+}
+
+// TestEIP2718Transition* tests that an EIP-2718 transaction will be accepted
+// after the fork block has passed. This is verified by sending an EIP-2930
+// access list transaction, which specifies a single slot access, and then
+// checking that the gas usage of a hot SLOAD and a cold SLOAD are calculated
+// correctly.
+
+// TestEIP2718TransitionWithTestChainConfig tests EIP-2718 with TestChainConfig.
+func TestEIP2718TransitionWithTestChainConfig(t *testing.T) {
+	testEIP2718TransitionWithConfig(t, params.TestChainConfig)
+}
+
+// TestEIP2718TransitionWithSatoshiConfig tests EIP-2718 with Satoshi Config.
+func TestEIP2718TransitionWithSatoshiConfig(t *testing.T) {
+	testEIP2718TransitionWithConfig(t, params.SatoshiTestChainConfig)
+}
+
+// testEIP2718TransitionWithConfig tests EIP02718 with given ChainConfig.
+func testEIP2718TransitionWithConfig(t *testing.T, config *params.ChainConfig) {
+	var (
+		aa     = common.HexToAddress("0x000000000000000000000000000000000000aaaa")
+		engine = ethash.NewFaker()
+
+		// A sender who makes transactions, has some funds
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(1000000000000000)
+		gspec   = &Genesis{
+			Config: config,
+			Alloc: GenesisAlloc{
+				address: {Balance: funds},
+				// The address 0xAAAA sloads 0x00 and 0x01
+				aa: {
+					Code: []byte{
+						byte(vm.PC),
+						byte(vm.PC),
+						byte(vm.SLOAD),
+						byte(vm.SLOAD),
+					},
+					Nonce:   0,
+					Balance: big.NewInt(0),
+				},
+			},
+		}
+	)
+	// Generate blocks
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{1})
+
+		// One transaction to 0xAAAA
+		signer := types.LatestSigner(gspec.Config)
+		tx, _ := types.SignNewTx(key, signer, &types.AccessListTx{
+			ChainID:  gspec.Config.ChainID,
+			Nonce:    0,
+			To:       &aa,
+			Gas:      30000,
+			GasPrice: b.header.BaseFee,
+			AccessList: types.AccessList{{
+				Address:     aa,
+				StorageKeys: []common.Hash{{0}},
+			}},
+		})
+		b.AddTx(tx)
+	})
+
+	// Import the canonical chain
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+
+	block := chain.GetBlockByNumber(1)
+
+	// Expected gas is intrinsic + 2 * pc + hot load + cold load, since only one load is in the access list
+	expected := params.TxGas + params.TxAccessListAddressGas + params.TxAccessListStorageKeyGas +
+		vm.GasQuickStep*2 + params.WarmStorageReadCostEIP2929 + params.ColdSloadCostEIP2929
+	if block.GasUsed() != expected {
+		t.Fatalf("incorrect amount of gas spent: expected %d, got %d", expected, block.GasUsed())
+	}
+}
+
+// TestEIP1559Transition tests the following:
+//
+//  1. A transaction whose gasFeeCap is greater than the baseFee is valid.
+//  2. Gas accounting for access lists on EIP-1559 transactions is correct.
+//  3. Only the transaction's tip will be received by the coinbase.
+//  4. The transaction sender pays for both the tip and baseFee.
+//  5. The coinbase receives only the partially realized tip when
+//     gasFeeCap - gasTipCap < baseFee.
+//  6. Legacy transaction behave as expected (e.g. gasPrice = gasFeeCap = gasTipCap).
+func TestEIP1559Transition(t *testing.T) {
+	var (
+		aa     = common.HexToAddress("0x000000000000000000000000000000000000aaaa")
+		engine = ethash.NewFaker()
+
+		// A sender who makes transactions, has some funds
+		key1, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		key2, _ = crypto.HexToECDSA("8a1f9a8f95be41cd7ccb6168179afb4504aefe388d1e14474d32c45c72ce7b7a")
+		addr1   = crypto.PubkeyToAddress(key1.PublicKey)
+		addr2   = crypto.PubkeyToAddress(key2.PublicKey)
+		funds   = new(big.Int).Mul(common.Big1, big.NewInt(params.Ether))
+		gspec   = &Genesis{
+			Config: params.AllEthashProtocolChanges,
+			Alloc: GenesisAlloc{
+				addr1: {Balance: funds},
+				addr2: {Balance: funds},
+				// The address 0xAAAA sloads 0x00 and 0x01
+				aa: {
+					Code: []byte{
+						byte(vm.PC),
+						byte(vm.PC),
+						byte(vm.SLOAD),
+						byte(vm.SLOAD),
+					},
+					Nonce:   0,
+					Balance: big.NewInt(0),
+				},
+			},
+		}
+	)
+
+	gspec.Config.BerlinBlock = common.Big0
+	gspec.Config.LondonBlock = common.Big0
+	signer := types.LatestSigner(gspec.Config)
+
+	genDb, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{1})
+
+		// One transaction to 0xAAAA
+		accesses := types.AccessList{types.AccessTuple{
+			Address:     aa,
+			StorageKeys: []common.Hash{{0}},
+		}}
+
+		txdata := &types.DynamicFeeTx{
+			ChainID:    gspec.Config.ChainID,
+			Nonce:      0,
+			To:         &aa,
+			Gas:        30000,
+			GasFeeCap:  newGwei(5),
+			GasTipCap:  big.NewInt(2),
+			AccessList: accesses,
+			Data:       []byte{},
+		}
+		tx := types.NewTx(txdata)
+		tx, _ = types.SignTx(tx, signer, key1)
+
+		b.AddTx(tx)
+	})
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+
+	block := chain.GetBlockByNumber(1)
+
+	// 1+2: Ensure EIP-1559 access lists are accounted for via gas usage.
+	expectedGas := params.TxGas + params.TxAccessListAddressGas + params.TxAccessListStorageKeyGas +
+		vm.GasQuickStep*2 + params.WarmStorageReadCostEIP2929 + params.ColdSloadCostEIP2929
+	if block.GasUsed() != expectedGas {
+		t.Fatalf("incorrect amount of gas spent: expected %d, got %d", expectedGas, block.GasUsed())
+	}
+
+	state, _ := chain.State()
+
+	// 3: Ensure that miner received only the tx's tip.
+	actual := state.GetBalance(block.Coinbase())
+	expected := new(big.Int).Add(
+		new(big.Int).SetUint64(block.GasUsed()*block.Transactions()[0].GasTipCap().Uint64()),
+		ethash.ConstantinopleBlockReward,
+	)
+	if actual.Cmp(expected) != 0 {
+		t.Fatalf("miner balance incorrect: expected %d, got %d", expected, actual)
+	}
+
+	// 4: Ensure the tx sender paid for the gasUsed * (tip + block baseFee).
+	actual = new(big.Int).Sub(funds, state.GetBalance(addr1))
+	expected = new(big.Int).SetUint64(block.GasUsed() * (block.Transactions()[0].GasTipCap().Uint64() + block.BaseFee().Uint64()))
+	if actual.Cmp(expected) != 0 {
+		t.Fatalf("sender balance incorrect: expected %d, got %d", expected, actual)
+	}
+
+	blocks, _ = GenerateChain(gspec.Config, block, engine, genDb, 1, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{2})
+
+		txdata := &types.LegacyTx{
+			Nonce:    0,
+			To:       &aa,
+			Gas:      30000,
+			GasPrice: newGwei(5),
+		}
+		tx := types.NewTx(txdata)
+		tx, _ = types.SignTx(tx, signer, key2)
+
+		b.AddTx(tx)
+	})
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+
+	block = chain.GetBlockByNumber(2)
+	state, _ = chain.State()
+	effectiveTip := block.Transactions()[0].GasTipCap().Uint64() - block.BaseFee().Uint64()
+
+	// 6+5: Ensure that miner received only the tx's effective tip.
+	actual = state.GetBalance(block.Coinbase())
+	expected = new(big.Int).Add(
+		new(big.Int).SetUint64(block.GasUsed()*effectiveTip),
+		ethash.ConstantinopleBlockReward,
+	)
+	if actual.Cmp(expected) != 0 {
+		t.Fatalf("miner balance incorrect: expected %d, got %d", expected, actual)
+	}
+
+	// 4: Ensure the tx sender paid for the gasUsed * (effectiveTip + block baseFee).
+	actual = new(big.Int).Sub(funds, state.GetBalance(addr2))
+	expected = new(big.Int).SetUint64(block.GasUsed() * (effectiveTip + block.BaseFee().Uint64()))
+	if actual.Cmp(expected) != 0 {
+		t.Fatalf("sender balance incorrect: expected %d, got %d", expected, actual)
+	}
+}
+
+// Tests the scenario the chain is requested to another point with the missing state.
+// It expects the state is recovered and all relevant chain markers are set correctly.
+func TestSetCanonical(t *testing.T) {
+	//log.Root().SetHandler(log.LvlFilterHandler(log.LvlDebug, log.StreamHandler(os.Stderr, log.TerminalFormat(true))))
+
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		funds   = big.NewInt(100000000000000000)
+		gspec   = &Genesis{
+			Config:  params.TestChainConfig,
+			Alloc:   GenesisAlloc{address: {Balance: funds}},
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+		signer = types.LatestSigner(gspec.Config)
+		engine = ethash.NewFaker()
+	)
+	// Generate and import the canonical chain
+	_, canon, _ := GenerateChainWithGenesis(gspec, engine, 2*TriesInMemory, func(i int, gen *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(gen.TxNonce(address), common.Address{0x00}, big.NewInt(1000), params.TxGas, gen.header.BaseFee, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		gen.AddTx(tx)
+	})
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(canon); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+
+	// Generate the side chain and import them
+	_, side, _ := GenerateChainWithGenesis(gspec, engine, 2*TriesInMemory, func(i int, gen *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(gen.TxNonce(address), common.Address{0x00}, big.NewInt(1), params.TxGas, gen.header.BaseFee, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		gen.AddTx(tx)
+	})
+	for _, block := range side {
+		err := chain.InsertBlockWithoutSetHead(block)
+		if err != nil {
+			t.Fatalf("Failed to insert into chain: %v", err)
+		}
+	}
+	for _, block := range side {
+		got := chain.GetBlockByHash(block.Hash())
+		if got == nil {
+			t.Fatalf("Lost the inserted block")
+		}
+	}
+
+	// Set the chain head to the side chain, ensure all the relevant markers are updated.
+	verify := func(head *types.Block) {
+		if chain.CurrentBlock().Hash() != head.Hash() {
+			t.Fatalf("Unexpected block hash, want %x, got %x", head.Hash(), chain.CurrentBlock().Hash())
+		}
+		if chain.CurrentSnapBlock().Hash() != head.Hash() {
+			t.Fatalf("Unexpected fast block hash, want %x, got %x", head.Hash(), chain.CurrentSnapBlock().Hash())
+		}
+		if chain.CurrentHeader().Hash() != head.Hash() {
+			t.Fatalf("Unexpected head header, want %x, got %x", head.Hash(), chain.CurrentHeader().Hash())
+		}
+		if !chain.HasState(head.Root()) {
+			t.Fatalf("Lost block state %v %x", head.Number(), head.Hash())
+		}
+	}
+	chain.SetCanonical(side[len(side)-1])
+	verify(side[len(side)-1])
+
+	// Reset the chain head to original chain
+	chain.SetCanonical(canon[TriesInMemory-1])
+	verify(canon[TriesInMemory-1])
+}
+
+// TestCanonicalHashMarker tests all the canonical hash markers are updated/deleted
+// correctly in case reorg is called.
+func TestCanonicalHashMarker(t *testing.T) {
+	var cases = []struct {
+		forkA int
+		forkB int
+	}{
+		// ForkA: 10 blocks
+		// ForkB: 1 blocks
+		//
+		// reorged:
+		//      markers [2, 10] should be deleted
+		//      markers [1] should be updated
+		{10, 1},
+
+		// ForkA: 10 blocks
+		// ForkB: 2 blocks
+		//
+		// reorged:
+		//      markers [3, 10] should be deleted
+		//      markers [1, 2] should be updated
+		{10, 2},
+
+		// ForkA: 10 blocks
+		// ForkB: 10 blocks
+		//
+		// reorged:
+		//      markers [1, 10] should be updated
+		{10, 10},
+
+		// ForkA: 10 blocks
+		// ForkB: 11 blocks
+		//
+		// reorged:
+		//      markers [1, 11] should be updated
+		{10, 11},
+	}
+	for _, c := range cases {
+		var (
+			gspec = &Genesis{
+				Config:  params.TestChainConfig,
+				Alloc:   GenesisAlloc{},
+				BaseFee: big.NewInt(params.InitialBaseFee),
+			}
+			engine = ethash.NewFaker()
+		)
+		_, forkA, _ := GenerateChainWithGenesis(gspec, engine, c.forkA, func(i int, gen *BlockGen) {})
+		_, forkB, _ := GenerateChainWithGenesis(gspec, engine, c.forkB, func(i int, gen *BlockGen) {})
+
+		// Initialize test chain
+		chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to create tester chain: %v", err)
+		}
+		// Insert forkA and forkB, the canonical should on forkA still
+		if n, err := chain.InsertChain(forkA); err != nil {
+			t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+		}
+		if n, err := chain.InsertChain(forkB); err != nil {
+			t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+		}
+
+		verify := func(head *types.Block) {
+			if chain.CurrentBlock().Hash() != head.Hash() {
+				t.Fatalf("Unexpected block hash, want %x, got %x", head.Hash(), chain.CurrentBlock().Hash())
+			}
+			if chain.CurrentSnapBlock().Hash() != head.Hash() {
+				t.Fatalf("Unexpected fast block hash, want %x, got %x", head.Hash(), chain.CurrentSnapBlock().Hash())
+			}
+			if chain.CurrentHeader().Hash() != head.Hash() {
+				t.Fatalf("Unexpected head header, want %x, got %x", head.Hash(), chain.CurrentHeader().Hash())
+			}
+			if !chain.HasState(head.Root()) {
+				t.Fatalf("Lost block state %v %x", head.Number(), head.Hash())
+			}
+		}
+
+		// Switch canonical chain to forkB if necessary
+		if len(forkA) < len(forkB) {
+			verify(forkB[len(forkB)-1])
+		} else {
+			verify(forkA[len(forkA)-1])
+			chain.SetCanonical(forkB[len(forkB)-1])
+			verify(forkB[len(forkB)-1])
+		}
+
+		// Ensure all hash markers are updated correctly
+		for i := 0; i < len(forkB); i++ {
+			block := forkB[i]
+			hash := chain.GetCanonicalHash(block.NumberU64())
+			if hash != block.Hash() {
+				t.Fatalf("Unexpected canonical hash %d", block.NumberU64())
+			}
+		}
+		if c.forkA > c.forkB {
+			for i := uint64(c.forkB) + 1; i <= uint64(c.forkA); i++ {
+				hash := chain.GetCanonicalHash(i)
+				if hash != (common.Hash{}) {
+					t.Fatalf("Unexpected canonical hash %d", i)
+				}
+			}
+		}
+		chain.Stop()
+	}
+}
+
+// TestTxIndexer tests the tx indexes are updated correctly.
+func TestTxIndexer(t *testing.T) {
+	var (
+		testBankKey, _  = crypto.GenerateKey()
+		testBankAddress = crypto.PubkeyToAddress(testBankKey.PublicKey)
+		testBankFunds   = big.NewInt(1000000000000000000)
+
+		gspec = &Genesis{
+			Config:  params.TestChainConfig,
+			Alloc:   GenesisAlloc{testBankAddress: {Balance: testBankFunds}},
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+		engine = ethash.NewFaker()
+		nonce  = uint64(0)
+	)
+	_, blocks, receipts := GenerateChainWithGenesis(gspec, engine, 128, func(i int, gen *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, common.HexToAddress("0xdeadbeef"), big.NewInt(1000), params.TxGas, big.NewInt(10*params.InitialBaseFee), nil), types.HomesteadSigner{}, testBankKey)
+		gen.AddTx(tx)
+		nonce += 1
+	})
+
+	// verifyIndexes checks if the transaction indexes are present or not
+	// of the specified block.
+	verifyIndexes := func(db ethdb.Database, number uint64, exist bool) {
+		if number == 0 {
+			return
+		}
+		block := blocks[number-1]
+		for _, tx := range block.Transactions() {
+			lookup := rawdb.ReadTxLookupEntry(db, tx.Hash())
+			if exist && lookup == nil {
+				t.Fatalf("missing %d %x", number, tx.Hash().Hex())
+			}
+			if !exist && lookup != nil {
+				t.Fatalf("unexpected %d %x", number, tx.Hash().Hex())
+			}
+		}
+	}
+	// verifyRange runs verifyIndexes for a range of blocks, from and to are included.
+	verifyRange := func(db ethdb.Database, from, to uint64, exist bool) {
+		for number := from; number <= to; number += 1 {
+			verifyIndexes(db, number, exist)
+		}
+	}
+	verify := func(db ethdb.Database, expTail uint64) {
+		tail := rawdb.ReadTxIndexTail(db)
+		if tail == nil {
+			t.Fatal("Failed to write tx index tail")
+		}
+		if *tail != expTail {
+			t.Fatalf("Unexpected tx index tail, want %v, got %d", expTail, *tail)
+		}
+		if *tail != 0 {
+			verifyRange(db, 0, *tail-1, false)
+		}
+		verifyRange(db, *tail, 128, true)
+	}
+
+	var cases = []struct {
+		limitA uint64
+		tailA  uint64
+		limitB uint64
+		tailB  uint64
+		limitC uint64
+		tailC  uint64
+	}{
+		{
+			// LimitA: 0
+			// TailA:  0
+			//
+			// all blocks are indexed
+			limitA: 0,
+			tailA:  0,
+
+			// LimitB: 1
+			// TailB:  128
+			//
+			// block-128 is indexed
+			limitB: 1,
+			tailB:  128,
+
+			// LimitB: 64
+			// TailB:  65
+			//
+			// block [65, 128] are indexed
+			limitC: 64,
+			tailC:  65,
+		},
+		{
+			// LimitA: 64
+			// TailA:  65
+			//
+			// block [65, 128] are indexed
+			limitA: 64,
+			tailA:  65,
+
+			// LimitB: 1
+			// TailB:  128
+			//
+			// block-128 is indexed
+			limitB: 1,
+			tailB:  128,
+
+			// LimitB: 64
+			// TailB:  65
+			//
+			// block [65, 128] are indexed
+			limitC: 64,
+			tailC:  65,
+		},
+		{
+			// LimitA: 127
+			// TailA:  2
+			//
+			// block [2, 128] are indexed
+			limitA: 127,
+			tailA:  2,
+
+			// LimitB: 1
+			// TailB:  128
+			//
+			// block-128 is indexed
+			limitB: 1,
+			tailB:  128,
+
+			// LimitB: 64
+			// TailB:  65
+			//
+			// block [65, 128] are indexed
+			limitC: 64,
+			tailC:  65,
+		},
+		{
+			// LimitA: 128
+			// TailA:  1
+			//
+			// block [2, 128] are indexed
+			limitA: 128,
+			tailA:  1,
+
+			// LimitB: 1
+			// TailB:  128
+			//
+			// block-128 is indexed
+			limitB: 1,
+			tailB:  128,
+
+			// LimitB: 64
+			// TailB:  65
+			//
+			// block [65, 128] are indexed
+			limitC: 64,
+			tailC:  65,
+		},
+		{
+			// LimitA: 129
+			// TailA:  0
+			//
+			// block [0, 128] are indexed
+			limitA: 129,
+			tailA:  0,
+
+			// LimitB: 1
+			// TailB:  128
+			//
+			// block-128 is indexed
+			limitB: 1,
+			tailB:  128,
+
+			// LimitB: 64
+			// TailB:  65
+			//
+			// block [65, 128] are indexed
+			limitC: 64,
+			tailC:  65,
+		},
+	}
+	for _, c := range cases {
+		frdir := t.TempDir()
+		db, _ := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "", false, false, false, false)
+		rawdb.WriteAncientBlocks(db, append([]*types.Block{gspec.ToBlock()}, blocks...), append([]types.Receipts{{}}, receipts...), big.NewInt(0))
+
+		// Index the initial blocks from ancient store
+		chain, _ := NewBlockChain(db, nil, gspec, nil, engine, vm.Config{}, nil, &c.limitA)
+		chain.indexBlocks(nil, 128, make(chan struct{}))
+		verify(db, c.tailA)
+
+		chain.SetTxLookupLimit(c.limitB)
+		chain.indexBlocks(rawdb.ReadTxIndexTail(db), 128, make(chan struct{}))
+		verify(db, c.tailB)
+
+		chain.SetTxLookupLimit(c.limitC)
+		chain.indexBlocks(rawdb.ReadTxIndexTail(db), 128, make(chan struct{}))
+		verify(db, c.tailC)
+
+		// Recover all indexes
+		chain.SetTxLookupLimit(0)
+		chain.indexBlocks(rawdb.ReadTxIndexTail(db), 128, make(chan struct{}))
+		verify(db, 0)
+
+		chain.Stop()
+		db.Close()
+		os.RemoveAll(frdir)
 	}
 }
 
-// TestInitThenFailCreateContract tests a pretty notorious case that happened
-// on mainnet over blocks 7338108, 7338110 and 7338115.
-//   - Block 7338108: address e771789f5cccac282f23bb7add5690e1f6ca467c is initiated
-//     with 0.001 ether (thus created but no code)
-//   - Block 7338110: a CREATE2 is attempted. The CREATE2 would deploy code on
-//     the same address e771789f5cccac282f23bb7add5690e1f6ca467c. However, the
-//     deployment fails due to OOG during initcode execution
-//   - Block 7338115: another tx checks the balance of
-//     e771789f5cccac282f23bb7add5690e1f6ca467c, and the snapshotter returned it as
-//     zero.
-//
-// The problem being that the snapshotter maintains a destructset, and adds items
-// to the destructset in case something is created "onto" an existing item.
-// We need to either roll back the snapDestructs, or not place it into snapDestructs
-// in the first place.
-func TestInitThenFailCreateContract(t *testing.T) {
+func TestCreateThenDeletePreByzantium(t *testing.T) {
+	// We use Ropsten chain config instead of Testchain config, this is
+	// deliberate: we want to use pre-byz rules where we have intermediate state roots
+	// between transactions.
+	testCreateThenDelete(t, &params.ChainConfig{
+		ChainID:        big.NewInt(3),
+		HomesteadBlock: big.NewInt(0),
+		EIP150Block:    big.NewInt(0),
+		EIP155Block:    big.NewInt(10),
+		EIP158Block:    big.NewInt(10),
+		ByzantiumBlock: big.NewInt(1_700_000),
+	})
+}
+func TestCreateThenDeletePostByzantium(t *testing.T) {
+	testCreateThenDelete(t, params.TestChainConfig)
+}
+
+// testCreateThenDelete tests a creation and subsequent deletion of a contract, happening
+// within the same block.
+func testCreateThenDelete(t *testing.T, config *params.ChainConfig) {
 	var (
 		engine = ethash.NewFaker()
-
 		// A sender who makes transactions, has some funds
-		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		address = crypto.PubkeyToAddress(key.PublicKey)
-		funds   = big.NewInt(1000000000000000)
-		bb      = common.HexToAddress("0x000000000000000000000000000000000000bbbb")
+		key, _      = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address     = crypto.PubkeyToAddress(key.PublicKey)
+		destAddress = crypto.CreateAddress(address, 0)
+		funds       = big.NewInt(1000000000000000)
 	)
 
-	// The bb-code needs to CREATE2 the aa contract. It consists of
-	// both initcode and deployment code
-	// initcode:
-	// 1. If blocknum < 1, error out (e.g invalid opcode)
-	// 2. else, return a snippet of code
+	// runtime code is 	0x60ffff : PUSH1 0xFF SELFDESTRUCT, a.k.a SELFDESTRUCT(0xFF)
+	code := append([]byte{0x60, 0xff, 0xff}, make([]byte, 32-3)...)
 	initCode := []byte{
-		byte(vm.PUSH1), 0x1, // y (2)
-		byte(vm.NUMBER), // x (number)
-		byte(vm.GT),     // x > y?
-		byte(vm.PUSH1), byte(0x8),
-		byte(vm.JUMPI), // jump to label if number > 2
-		byte(0xFE),     // illegal opcode
-		byte(vm.JUMPDEST),
-		byte(vm.PUSH1), 0x2, // size
+		// SSTORE 1:1
+		byte(vm.PUSH1), 0x1,
+		byte(vm.PUSH1), 0x1,
+		byte(vm.SSTORE),
+		// Get the runtime-code on the stack
+		byte(vm.PUSH32)}
+	initCode = append(initCode, code...)
+	initCode = append(initCode, []byte{
 		byte(vm.PUSH1), 0x0, // offset
-		byte(vm.RETURN), // return 2 bytes of zero-code
-	}
-	if l := len(initCode); l > 32 {
-		t.Fatalf("init code is too long for a pushx, need a more elaborate deployer")
-	}
-	bbCode := []byte{
-		// Push initcode onto stack
-		byte(vm.PUSH1) + byte(len(initCode)-1)}
-	bbCode = append(bbCode, initCode...)
-	bbCode = append(bbCode, []byte{
-		byte(vm.PUSH1), 0x0, // memory start on stack
 		byte(vm.MSTORE),
-		byte(vm.PUSH1), 0x00, // salt
-		byte(vm.PUSH1), byte(len(initCode)), // size
-		byte(vm.PUSH1), byte(32 - len(initCode)), // offset
-		byte(vm.PUSH1), 0x00, // endowment
-		byte(vm.CREATE2),
+		byte(vm.PUSH1), 0x3, // size
+		byte(vm.PUSH1), 0x0, // offset
+		byte(vm.RETURN), // return 3 bytes of zero-code
 	}...)
-
-	initHash := crypto.Keccak256Hash(initCode)
-	aa := crypto.CreateAddress2(bb, [32]byte{}, initHash[:])
-	t.Logf("Destination address: %x\n", aa)
-
 	gspec := &Genesis{
-		Config: params.TestChainConfig,
+		Config: config,
 		Alloc: GenesisAlloc{
 			address: {Balance: funds},
-			// The address aa has some funds
-			aa: {Balance: big.NewInt(100000)},
-			// The contract BB tries to create code onto AA
-			bb: {
-				Code:    bbCode,
-				Balance: big.NewInt(1),
-			},
 		},
 	}
 	nonce := uint64(0)
-	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 4, func(i int, b *BlockGen) {
+	signer := types.HomesteadSigner{}
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 2, func(i int, b *BlockGen) {
+		fee := big.NewInt(1)
+		if b.header.BaseFee != nil {
+			fee = b.header.BaseFee
+		}
 		b.SetCoinbase(common.Address{1})
-		// One transaction to BB
-		tx, _ := types.SignTx(types.NewTransaction(nonce, bb,
-			big.NewInt(0), 100000, b.header.BaseFee, nil), types.HomesteadSigner{}, key)
+		tx, _ := types.SignNewTx(key, signer, &types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: new(big.Int).Set(fee),
+			Gas:      100000,
+			Data:     initCode,
+		})
+		nonce++
+		b.AddTx(tx)
+		tx, _ = types.SignNewTx(key, signer, &types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: new(big.Int).Set(fee),
+			Gas:      100000,
+			To:       &destAddress,
+		})
 		b.AddTx(tx)
 		nonce++
 	})
-
 	// Import the canonical chain
 	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{
 		//Debug:  true,
-		//Tracer: vm.NewJSONLogger(nil, os.Stdout),
+		//Tracer: logger.NewJSONLogger(nil, os.Stdout),
 	}, nil, nil)
 	if err != nil {
 		t.Fatalf("failed to create tester chain: %v", err)
 	}
 	defer chain.Stop()
-
-	statedb, _ := chain.State()
-	if got, exp := statedb.GetBalance(aa), big.NewInt(100000); got.Cmp(exp) != 0 {
-		t.Fatalf("Genesis err, got %v exp %v", got, exp)
-	}
-	// First block tries to create, but fails
-	{
-		block := blocks[0]
-		if _, err := chain.InsertChain([]*types.Block{blocks[0]}); err != nil {
-			t.Fatalf("block %d: failed to insert into chain: %v", block.NumberU64(), err)
-		}
-		statedb, _ = chain.State()
-		if got, exp := statedb.GetBalance(aa), big.NewInt(100000); got.Cmp(exp) != 0 {
-			t.Fatalf("block %d: got %v exp %v", block.NumberU64(), got, exp)
-		}
-	}
-	// Import the rest of the blocks
-	for _, block := range blocks[1:] {
+	// Import the blocks
+	for _, block := range blocks {
 		if _, err := chain.InsertChain([]*types.Block{block}); err != nil {
 			t.Fatalf("block %d: failed to insert into chain: %v", block.NumberU64(), err)
 		}
 	}
 }
 
-// TestEIP2718Transition* tests that an EIP-2718 transaction will be accepted
-// after the fork block has passed. This is verified by sending an EIP-2930
-// access list transaction, which specifies a single slot access, and then
-// checking that the gas usage of a hot SLOAD and a cold SLOAD are calculated
-// correctly.
-
-// TestEIP2718TransitionWithTestChainConfig tests EIP-2718 with TestChainConfig.
-func TestEIP2718TransitionWithTestChainConfig(t *testing.T) {
-	testEIP2718TransitionWithConfig(t, params.TestChainConfig)
-}
-
-// TestEIP2718TransitionWithSatoshiConfig tests EIP-2718 with Satoshi Config.
-func TestEIP2718TransitionWithSatoshiConfig(t *testing.T) {
-	testEIP2718TransitionWithConfig(t, params.SatoshiTestChainConfig)
-}
-
-// testEIP2718TransitionWithConfig tests EIP02718 with given ChainConfig.
-func testEIP2718TransitionWithConfig(t *testing.T, config *params.ChainConfig) {
+// TestTransientStorageReset ensures the transient storage is wiped correctly
+// between transactions.
+func TestTransientStorageReset(t *testing.T) {
 	var (
-		aa     = common.HexToAddress("0x000000000000000000000000000000000000aaaa")
-		engine = ethash.NewFaker()
-
-		// A sender who makes transactions, has some funds
-		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		address = crypto.PubkeyToAddress(key.PublicKey)
-		funds   = big.NewInt(1000000000000000)
-		gspec   = &Genesis{
-			Config: config,
-			Alloc: GenesisAlloc{
-				address: {Balance: funds},
-				// The address 0xAAAA sloads 0x00 and 0x01
-				aa: {
-					Code: []byte{
-						byte(vm.PC),
-						byte(vm.PC),
-						byte(vm.SLOAD),
-						byte(vm.SLOAD),
-					},
-					Nonce:   0,
-					Balance: big.NewInt(0),
-				},
-			},
+		engine      = ethash.NewFaker()
+		key, _      = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address     = crypto.PubkeyToAddress(key.PublicKey)
+		destAddress = crypto.CreateAddress(address, 0)
+		funds       = big.NewInt(1000000000000000)
+		vmConfig    = vm.Config{
+			ExtraEips: []int{1153}, // Enable transient storage EIP
 		}
 	)
-	// Generate blocks
+	code := append([]byte{
+		// TLoad value with location 1
+		byte(vm.PUSH1), 0x1,
+		byte(vm.TLOAD),
+
+		// PUSH location
+		byte(vm.PUSH1), 0x1,
+
+		// SStore location:value
+		byte(vm.SSTORE),
+	}, make([]byte, 32-6)...)
+	initCode := []byte{
+		// TSTORE 1:1
+		byte(vm.PUSH1), 0x1,
+		byte(vm.PUSH1), 0x1,
+		byte(vm.TSTORE),
+
+		// Get the runtime-code on the stack
+		byte(vm.PUSH32)}
+	initCode = append(initCode, code...)
+	initCode = append(initCode, []byte{
+		byte(vm.PUSH1), 0x0, // offset
+		byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x6, // size
+		byte(vm.PUSH1), 0x0, // offset
+		byte(vm.RETURN), // return 6 bytes of zero-code
+	}...)
+	gspec := &Genesis{
+		Config: params.TestChainConfig,
+		Alloc: GenesisAlloc{
+			address: {Balance: funds},
+		},
+	}
+	nonce := uint64(0)
+	signer := types.HomesteadSigner{}
 	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
+		fee := big.NewInt(1)
+		if b.header.BaseFee != nil {
+			fee = b.header.BaseFee
+		}
 		b.SetCoinbase(common.Address{1})
+		tx, _ := types.SignNewTx(key, signer, &types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: new(big.Int).Set(fee),
+			Gas:      100000,
+			Data:     initCode,
+		})
+		nonce++
+		b.AddTxWithVMConfig(tx, vmConfig)
 
-		// One transaction to 0xAAAA
-		signer := types.LatestSigner(gspec.Config)
-		tx, _ := types.SignNewTx(key, signer, &types.AccessListTx{
-			ChainID:  gspec.Config.ChainID,
-			Nonce:    0,
-			To:       &aa,
-			Gas:      30000,
-			GasPrice: b.header.BaseFee,
-			AccessList: types.AccessList{{
-				Address:     aa,
-				StorageKeys: []common.Hash{{0}},
-			}},
+		tx, _ = types.SignNewTx(key, signer, &types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: new(big.Int).Set(fee),
+			Gas:      100000,
+			To:       &destAddress,
 		})
-		b.AddTx(tx)
+		b.AddTxWithVMConfig(tx, vmConfig)
+		nonce++
 	})
 
-	// Import the canonical chain
-	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	// Initialize the blockchain with 1153 enabled.
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vmConfig, nil, nil)
 	if err != nil {
 		t.Fatalf("failed to create tester chain: %v", err)
 	}
 	defer chain.Stop()
-
-	if n, err := chain.InsertChain(blocks); err != nil {
-		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	// Import the blocks
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert into chain: %v", err)
 	}
-
-	block := chain.GetBlockByNumber(1)
-
-	// Expected gas is intrinsic + 2 * pc + hot load + cold load, since only one load is in the access list
-	expected := params.TxGas + params.TxAccessListAddressGas + params.TxAccessListStorageKeyGas +
-		vm.GasQuickStep*2 + params.WarmStorageReadCostEIP2929 + params.ColdSloadCostEIP2929
-	if block.GasUsed() != expected {
-		t.Fatalf("incorrect amount of gas spent: expected %d, got %d", expected, block.GasUsed())
+	// Check the storage
+	state, err := chain.StateAt(chain.CurrentHeader().Root)
+	if err != nil {
+		t.Fatalf("Failed to load state %v", err)
+	}
+	loc := common.BytesToHash([]byte{1})
+	slot := state.GetState(destAddress, loc)
+	if slot != (common.Hash{}) {
+		t.Fatalf("Unexpected dirty storage slot")
 	}
 }
 
-// TestEIP1559Transition tests the following:
-//
-//  1. A transaction whose gasFeeCap is greater than the baseFee is valid.
-//  2. Gas accounting for access lists on EIP-1559 transactions is correct.
-//  3. Only the transaction's tip will be received by the coinbase.
-//  4. The transaction sender pays for both the tip and baseFee.
-//  5. The coinbase receives only the partially realized tip when
-//     gasFeeCap - gasTipCap < baseFee.
-//  6. Legacy transaction behave as expected (e.g. gasPrice = gasFeeCap = gasTipCap).
-func TestEIP1559Transition(t *testing.T) {
+func TestEIP3651(t *testing.T) {
 	var (
 		aa     = common.HexToAddress("0x000000000000000000000000000000000000aaaa")
-		engine = ethash.NewFaker()
+		bb     = common.HexToAddress("0x000000000000000000000000000000000000bbbb")
+		engine = beacon.NewFaker()
 
 		// A sender who makes transactions, has some funds
 		key1, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
@@ -3650,8 +5597,9 @@ func TestEIP1559Transition(t *testing.T) {
 		addr1   = crypto.PubkeyToAddress(key1.PublicKey)
 		addr2   = crypto.PubkeyToAddress(key2.PublicKey)
 		funds   = new(big.Int).Mul(common.Big1, big.NewInt(params.Ether))
+		config  = *params.AllEthashProtocolChanges
 		gspec   = &Genesis{
-			Config: params.AllEthashProtocolChanges,
+			Config: &config,
 			Alloc: GenesisAlloc{
 				addr1: {Balance: funds},
 				addr2: {Balance: funds},
@@ -3666,31 +5614,44 @@ func TestEIP1559Transition(t *testing.T) {
 					Nonce:   0,
 					Balance: big.NewInt(0),
 				},
+				// The address 0xBBBB calls 0xAAAA
+				bb: {
+					Code: []byte{
+						byte(vm.PUSH1), 0, // out size
+						byte(vm.DUP1),  // out offset
+						byte(vm.DUP1),  // out insize
+						byte(vm.DUP1),  // in offset
+						byte(vm.PUSH2), // address
+						byte(0xaa),
+						byte(0xaa),
+						byte(vm.GAS), // gas
+						byte(vm.DELEGATECALL),
+					},
+					Nonce:   0,
+					Balance: big.NewInt(0),
+				},
 			},
 		}
 	)
 
 	gspec.Config.BerlinBlock = common.Big0
 	gspec.Config.LondonBlock = common.Big0
+	gspec.Config.TerminalTotalDifficulty = common.Big0
+	gspec.Config.TerminalTotalDifficultyPassed = true
+	gspec.Config.ShanghaiTime = u64(0)
 	signer := types.LatestSigner(gspec.Config)
 
-	genDb, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
-		b.SetCoinbase(common.Address{1})
-
-		// One transaction to 0xAAAA
-		accesses := types.AccessList{types.AccessTuple{
-			Address:     aa,
-			StorageKeys: []common.Hash{{0}},
-		}}
-
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
+		b.SetCoinbase(aa)
+		// One transaction to Coinbase
 		txdata := &types.DynamicFeeTx{
 			ChainID:    gspec.Config.ChainID,
 			Nonce:      0,
-			To:         &aa,
-			Gas:        30000,
+			To:         &bb,
+			Gas:        500000,
 			GasFeeCap:  newGwei(5),
 			GasTipCap:  big.NewInt(2),
-			AccessList: accesses,
+			AccessList: nil,
 			Data:       []byte{},
 		}
 		tx := types.NewTx(txdata)
@@ -3698,12 +5659,11 @@ func TestEIP1559Transition(t *testing.T) {
 
 		b.AddTx(tx)
 	})
-	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{Tracer: logger.NewMarkdownLogger(&logger.Config{}, os.Stderr)}, nil, nil)
 	if err != nil {
 		t.Fatalf("failed to create tester chain: %v", err)
 	}
 	defer chain.Stop()
-
 	if n, err := chain.InsertChain(blocks); err != nil {
 		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
 	}
@@ -3711,8 +5671,8 @@ func TestEIP1559Transition(t *testing.T) {
 	block := chain.GetBlockByNumber(1)
 
 	// 1+2: Ensure EIP-1559 access lists are accounted for via gas usage.
-	expectedGas := params.TxGas + params.TxAccessListAddressGas + params.TxAccessListStorageKeyGas +
-		vm.GasQuickStep*2 + params.WarmStorageReadCostEIP2929 + params.ColdSloadCostEIP2929
+	innerGas := vm.GasQuickStep*2 + params.ColdSloadCostEIP2929*2
+	expectedGas := params.TxGas + 5*vm.GasFastestStep + vm.GasQuickStep + 100 + innerGas // 100 because 0xaaaa is in access list
 	if block.GasUsed() != expectedGas {
 		t.Fatalf("incorrect amount of gas spent: expected %d, got %d", expectedGas, block.GasUsed())
 	}
@@ -3721,10 +5681,7 @@ func TestEIP1559Transition(t *testing.T) {
 
 	// 3: Ensure that miner received only the tx's tip.
 	actual := state.GetBalance(block.Coinbase())
-	expected := new(big.Int).Add(
-		new(big.Int).SetUint64(block.GasUsed()*block.Transactions()[0].GasTipCap().Uint64()),
-		ethash.ConstantinopleBlockReward,
-	)
+	expected := new(big.Int).SetUint64(block.GasUsed() * block.Transactions()[0].GasTipCap().Uint64())
 	if actual.Cmp(expected) != 0 {
 		t.Fatalf("miner balance incorrect: expected %d, got %d", expected, actual)
 	}
@@ -3735,733 +5692,1091 @@ func TestEIP1559Transition(t *testing.T) {
 	if actual.Cmp(expected) != 0 {
 		t.Fatalf("sender balance incorrect: expected %d, got %d", expected, actual)
 	}
+}
 
-	blocks, _ = GenerateChain(gspec.Config, block, engine, genDb, 1, func(i int, b *BlockGen) {
-		b.SetCoinbase(common.Address{2})
-
-		txdata := &types.LegacyTx{
-			Nonce:    0,
-			To:       &aa,
-			Gas:      30000,
-			GasPrice: newGwei(5),
-		}
-		tx := types.NewTx(txdata)
-		tx, _ = types.SignTx(tx, signer, key2)
-
-		b.AddTx(tx)
-	})
+// Tests that the seal-to-import latency is tracked for committed blocks, with
+// live blocks showing a small latency and historical ones a large latency.
+func TestSealToImportLatency(t *testing.T) {
+	var (
+		engine = ethash.NewFaker()
+		now    = uint64(time.Now().Unix())
+	)
+	// Historical blocks, sealed shortly after the unix epoch
+	gspec := &Genesis{Config: params.TestChainConfig, BaseFee: big.NewInt(params.InitialBaseFee)}
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 3, func(i int, b *BlockGen) {})
 
-	if n, err := chain.InsertChain(blocks); err != nil {
-		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	latency, err := chain.SealToImportLatency(blocks[2].Hash())
+	if err != nil {
+		t.Fatalf("failed to get import latency: %v", err)
+	}
+	if latency < 24*time.Hour {
+		t.Errorf("historical block latency too small: %v", latency)
+	}
+	if _, err := chain.SealToImportLatency(chain.Genesis().Hash()); err != ErrUnknownImportTime {
+		t.Errorf("genesis latency error mismatch: have %v, want %v", err, ErrUnknownImportTime)
 	}
 
-	block = chain.GetBlockByNumber(2)
-	state, _ = chain.State()
-	effectiveTip := block.Transactions()[0].GasTipCap().Uint64() - block.BaseFee().Uint64()
+	// Live block, sealed right now
+	gspec = &Genesis{Config: params.TestChainConfig, BaseFee: big.NewInt(params.InitialBaseFee), Timestamp: now - 10}
+	_, blocks, _ = GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {})
 
-	// 6+5: Ensure that miner received only the tx's effective tip.
-	actual = state.GetBalance(block.Coinbase())
-	expected = new(big.Int).Add(
-		new(big.Int).SetUint64(block.GasUsed()*effectiveTip),
-		ethash.ConstantinopleBlockReward,
-	)
-	if actual.Cmp(expected) != 0 {
-		t.Fatalf("miner balance incorrect: expected %d, got %d", expected, actual)
+	live, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
 	}
-
-	// 4: Ensure the tx sender paid for the gasUsed * (effectiveTip + block baseFee).
-	actual = new(big.Int).Sub(funds, state.GetBalance(addr2))
-	expected = new(big.Int).SetUint64(block.GasUsed() * (effectiveTip + block.BaseFee().Uint64()))
-	if actual.Cmp(expected) != 0 {
-		t.Fatalf("sender balance incorrect: expected %d, got %d", expected, actual)
+	defer live.Stop()
+	if _, err := live.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	latency, err = live.SealToImportLatency(blocks[0].Hash())
+	if err != nil {
+		t.Fatalf("failed to get import latency: %v", err)
+	}
+	if latency < -time.Second || latency > time.Minute {
+		t.Errorf("live block latency out of range: %v", latency)
 	}
 }
 
-// Tests the scenario the chain is requested to another point with the missing state.
-// It expects the state is recovered and all relevant chain markers are set correctly.
-func TestSetCanonical(t *testing.T) {
-	//log.Root().SetHandler(log.LvlFilterHandler(log.LvlDebug, log.StreamHandler(os.Stderr, log.TerminalFormat(true))))
+func TestChainAcceptedEvent(t *testing.T) {
+	engine := ethash.NewFaker()
+	gspec := &Genesis{Config: params.TestChainConfig, BaseFee: big.NewInt(params.InitialBaseFee)}
+	genDb, blocks, _ := GenerateChainWithGenesis(gspec, engine, 30, func(i int, b *BlockGen) {})
+	fork, _ := GenerateChain(gspec.Config, blocks[9], engine, genDb, 25, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{1}) })
 
-	var (
-		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		address = crypto.PubkeyToAddress(key.PublicKey)
-		funds   = big.NewInt(100000000000000000)
-		gspec   = &Genesis{
-			Config:  params.TestChainConfig,
-			Alloc:   GenesisAlloc{address: {Balance: funds}},
-			BaseFee: big.NewInt(params.InitialBaseFee),
-		}
-		signer = types.LatestSigner(gspec.Config)
-		engine = ethash.NewFaker()
-	)
-	// Generate and import the canonical chain
-	_, canon, _ := GenerateChainWithGenesis(gspec, engine, 2*TriesInMemory, func(i int, gen *BlockGen) {
-		tx, err := types.SignTx(types.NewTransaction(gen.TxNonce(address), common.Address{0x00}, big.NewInt(1000), params.TxGas, gen.header.BaseFee, nil), signer, key)
-		if err != nil {
-			panic(err)
-		}
-		gen.AddTx(tx)
-	})
-	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil, EnableFinalityDepth(5))
 	if err != nil {
 		t.Fatalf("failed to create tester chain: %v", err)
 	}
 	defer chain.Stop()
 
-	if n, err := chain.InsertChain(canon); err != nil {
-		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
-	}
+	acceptedCh := make(chan ChainAcceptedEvent, 64)
+	sub := chain.SubscribeChainAcceptedEvent(acceptedCh)
+	defer sub.Unsubscribe()
 
-	// Generate the side chain and import them
-	_, side, _ := GenerateChainWithGenesis(gspec, engine, 2*TriesInMemory, func(i int, gen *BlockGen) {
-		tx, err := types.SignTx(types.NewTransaction(gen.TxNonce(address), common.Address{0x00}, big.NewInt(1), params.TxGas, gen.header.BaseFee, nil), signer, key)
-		if err != nil {
-			panic(err)
+	expect := func(from, to uint64, chain []*types.Block) {
+		t.Helper()
+		for number := from; number <= to; number++ {
+			select {
+			case ev := <-acceptedCh:
+				if ev.Block.NumberU64() != number || ev.Block.Hash() != chain[number-chain[0].NumberU64()].Hash() {
+					t.Fatalf("accepted block mismatch: have %d, want %d", ev.Block.NumberU64(), number)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("missing accepted event for block %d", number)
+			}
 		}
-		gen.AddTx(tx)
-	})
-	for _, block := range side {
-		err := chain.InsertBlockWithoutSetHead(block)
-		if err != nil {
-			t.Fatalf("Failed to insert into chain: %v", err)
+		select {
+		case ev := <-acceptedCh:
+			t.Fatalf("unexpected accepted event for block %d", ev.Block.NumberU64())
+		case <-time.After(50 * time.Millisecond):
 		}
 	}
-	for _, block := range side {
-		got := chain.GetBlockByHash(block.Hash())
-		if got == nil {
-			t.Fatalf("Lost the inserted block")
+	// Import blocks one by one, each new head buries exactly one more block
+	for i := 0; i < 20; i++ {
+		if _, err := chain.InsertChain(blocks[i : i+1]); err != nil {
+			t.Fatalf("failed to insert block %d: %v", i, err)
 		}
 	}
+	expect(1, 15, blocks)
 
-	// Set the chain head to the side chain, ensure all the relevant markers are updated.
-	verify := func(head *types.Block) {
-		if chain.CurrentBlock().Hash() != head.Hash() {
-			t.Fatalf("Unexpected block hash, want %x, got %x", head.Hash(), chain.CurrentBlock().Hash())
-		}
-		if chain.CurrentSnapBlock().Hash() != head.Hash() {
-			t.Fatalf("Unexpected fast block hash, want %x, got %x", head.Hash(), chain.CurrentSnapBlock().Hash())
-		}
-		if chain.CurrentHeader().Hash() != head.Hash() {
-			t.Fatalf("Unexpected head header, want %x, got %x", head.Hash(), chain.CurrentHeader().Hash())
-		}
-		if !chain.HasState(head.Root()) {
-			t.Fatalf("Lost block state %v %x", head.Number(), head.Hash())
-		}
+	// A batch import announces every block it buried, not only the deepest
+	if _, err := chain.InsertChain(blocks[20:]); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
 	}
-	chain.SetCanonical(side[len(side)-1])
-	verify(side[len(side)-1])
-
-	// Reset the chain head to original chain
-	chain.SetCanonical(canon[TriesInMemory-1])
-	verify(canon[TriesInMemory-1])
-}
+	expect(16, 25, blocks)
 
-// TestCanonicalHashMarker tests all the canonical hash markers are updated/deleted
-// correctly in case reorg is called.
-func TestCanonicalHashMarker(t *testing.T) {
-	var cases = []struct {
-		forkA int
-		forkB int
-	}{
-		// ForkA: 10 blocks
-		// ForkB: 1 blocks
-		//
-		// reorged:
-		//      markers [2, 10] should be deleted
-		//      markers [1] should be updated
-		{10, 1},
+	// Rewinding the head announces the blocks buried again on top of it
+	if err := chain.SetHead(22); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks[22:]); err != nil {
+		t.Fatalf("failed to reinsert chain: %v", err)
+	}
+	expect(18, 25, blocks)
 
-		// ForkA: 10 blocks
-		// ForkB: 2 blocks
-		//
-		// reorged:
-		//      markers [3, 10] should be deleted
-		//      markers [1, 2] should be updated
-		{10, 2},
+	// A reorg announces the blocks of the new chain above the common ancestor
+	if _, err := chain.InsertChain(fork); err != nil {
+		t.Fatalf("failed to insert fork: %v", err)
+	}
+	if head := chain.CurrentBlock().Hash(); head != fork[len(fork)-1].Hash() {
+		t.Fatalf("reorg to heavier fork failed: have %x, want %x", head, fork[len(fork)-1].Hash())
+	}
+	expect(11, 30, fork)
+}
 
-		// ForkA: 10 blocks
-		// ForkB: 10 blocks
-		//
-		// reorged:
-		//      markers [1, 10] should be updated
-		{10, 10},
+// finalityEngine is a fake PoSA engine considering a fixed canonical block final.
+type finalityEngine struct {
+	*ethash.Ethash
+	finalized uint64
+}
 
-		// ForkA: 10 blocks
-		// ForkB: 11 blocks
-		//
-		// reorged:
-		//      markers [1, 11] should be updated
-		{10, 11},
+func (e *finalityEngine) IsSystemTransaction(tx *types.Transaction, header *types.Header) (bool, error) {
+	return false, nil
+}
+func (e *finalityEngine) IsSystemContract(to *common.Address) bool { return false }
+func (e *finalityEngine) EnoughDistance(chain consensus.ChainReader, header *types.Header) bool {
+	return true
+}
+func (e *finalityEngine) IsLocalBlock(header *types.Header) bool { return false }
+func (e *finalityEngine) GetJustifiedNumberAndHash(chain consensus.ChainHeaderReader, headers []*types.Header) (uint64, common.Hash, error) {
+	return 0, common.Hash{}, nil
+}
+func (e *finalityEngine) GetFinalizedHeader(chain consensus.ChainHeaderReader, header *types.Header) *types.Header {
+	if header.Number.Uint64() < e.finalized {
+		return nil
 	}
-	for _, c := range cases {
-		var (
-			gspec = &Genesis{
-				Config:  params.TestChainConfig,
-				Alloc:   GenesisAlloc{},
-				BaseFee: big.NewInt(params.InitialBaseFee),
-			}
-			engine = ethash.NewFaker()
-		)
-		_, forkA, _ := GenerateChainWithGenesis(gspec, engine, c.forkA, func(i int, gen *BlockGen) {})
-		_, forkB, _ := GenerateChainWithGenesis(gspec, engine, c.forkB, func(i int, gen *BlockGen) {})
+	return chain.GetHeaderByNumber(e.finalized)
+}
+func (e *finalityEngine) VerifyVote(chain consensus.ChainHeaderReader, vote *types.VoteEnvelope) error {
+	return nil
+}
+func (e *finalityEngine) IsActiveValidatorAt(chain consensus.ChainHeaderReader, header *types.Header, checkVoteKeyFn func(bLSPublicKey *types.BLSPublicKey) bool) bool {
+	return false
+}
+func (e *finalityEngine) EffectiveValidatorSet(chain consensus.ChainHeaderReader, header *types.Header) ([]common.Address, error) {
+	return nil, nil
+}
 
-		// Initialize test chain
-		chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
-		if err != nil {
-			t.Fatalf("failed to create tester chain: %v", err)
-		}
-		// Insert forkA and forkB, the canonical should on forkA still
-		if n, err := chain.InsertChain(forkA); err != nil {
-			t.Fatalf("block %d: failed to insert into chain: %v", n, err)
-		}
-		if n, err := chain.InsertChain(forkB); err != nil {
-			t.Fatalf("block %d: failed to insert into chain: %v", n, err)
-		}
+// systemTxEngine is a fake PoSA engine considering transactions to a fixed
+// address system transactions.
+type systemTxEngine struct {
+	finalityEngine
+	system common.Address
+}
 
-		verify := func(head *types.Block) {
-			if chain.CurrentBlock().Hash() != head.Hash() {
-				t.Fatalf("Unexpected block hash, want %x, got %x", head.Hash(), chain.CurrentBlock().Hash())
-			}
-			if chain.CurrentSnapBlock().Hash() != head.Hash() {
-				t.Fatalf("Unexpected fast block hash, want %x, got %x", head.Hash(), chain.CurrentSnapBlock().Hash())
-			}
-			if chain.CurrentHeader().Hash() != head.Hash() {
-				t.Fatalf("Unexpected head header, want %x, got %x", head.Hash(), chain.CurrentHeader().Hash())
-			}
-			if !chain.HasState(head.Root()) {
-				t.Fatalf("Lost block state %v %x", head.Number(), head.Hash())
-			}
-		}
+func (e *systemTxEngine) IsSystemTransaction(tx *types.Transaction, header *types.Header) (bool, error) {
+	return tx.To() != nil && *tx.To() == e.system, nil
+}
 
-		// Switch canonical chain to forkB if necessary
-		if len(forkA) < len(forkB) {
-			verify(forkB[len(forkB)-1])
-		} else {
-			verify(forkA[len(forkA)-1])
-			chain.SetCanonical(forkB[len(forkB)-1])
-			verify(forkB[len(forkB)-1])
-		}
+// Tests that only the gas of system transactions is counted as system gas.
+func TestSystemGasUsed(t *testing.T) {
+	engine := &systemTxEngine{system: common.Address{0xff}}
 
-		// Ensure all hash markers are updated correctly
-		for i := 0; i < len(forkB); i++ {
-			block := forkB[i]
-			hash := chain.GetCanonicalHash(block.NumberU64())
-			if hash != block.Hash() {
-				t.Fatalf("Unexpected canonical hash %d", block.NumberU64())
-			}
+	txs := []*types.Transaction{
+		types.NewTransaction(0, common.Address{0x01}, big.NewInt(1), 50000, big.NewInt(1), nil),
+		types.NewTransaction(1, engine.system, big.NewInt(1), 50000, big.NewInt(1), nil),
+		types.NewTransaction(2, engine.system, big.NewInt(1), 50000, big.NewInt(1), nil),
+	}
+	receipts := types.Receipts{{GasUsed: 21000}, {GasUsed: 30000}, {GasUsed: 40000}}
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1)}).WithBody(txs, nil)
+
+	if have, want := systemGasUsed(engine, block, receipts), uint64(70000); have != want {
+		t.Fatalf("system gas used mismatch: have %d, want %d", have, want)
+	}
+}
+
+// Tests that the system gas used is recorded for blocks imported by a PoSA
+// engine only.
+func TestSystemGasUsedRecorded(t *testing.T) {
+	gspec := &Genesis{Config: params.TestChainConfig, BaseFee: big.NewInt(params.InitialBaseFee)}
+	for _, engine := range []consensus.Engine{ethash.NewFaker(), &finalityEngine{Ethash: ethash.NewFaker()}} {
+		_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 3, nil)
+
+		chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to create tester chain: %v", err)
 		}
-		if c.forkA > c.forkB {
-			for i := uint64(c.forkB) + 1; i <= uint64(c.forkA); i++ {
-				hash := chain.GetCanonicalHash(i)
-				if hash != (common.Hash{}) {
-					t.Fatalf("Unexpected canonical hash %d", i)
-				}
+		if _, err := chain.InsertChain(blocks); err != nil {
+			t.Fatalf("failed to insert chain: %v", err)
+		}
+		_, posa := engine.(consensus.PoSA)
+		for _, block := range blocks {
+			gas, ok := chain.GetSystemGasUsed(block.Hash(), block.NumberU64())
+			if ok != posa || gas != 0 {
+				t.Errorf("block %d, posa %v: system gas used mismatch: have %d (%v)", block.NumberU64(), posa, gas, ok)
 			}
 		}
 		chain.Stop()
 	}
 }
 
-// TestTxIndexer tests the tx indexes are updated correctly.
-func TestTxIndexer(t *testing.T) {
-	var (
-		testBankKey, _  = crypto.GenerateKey()
-		testBankAddress = crypto.PubkeyToAddress(testBankKey.PublicKey)
-		testBankFunds   = big.NewInt(1000000000000000000)
+// Tests that a reorg unwinding a finalized block is rejected and reported.
+func TestFinalizedReorg(t *testing.T) {
+	engine := &finalityEngine{Ethash: ethash.NewFaker(), finalized: 5}
+	gspec := &Genesis{Config: params.TestChainConfig, BaseFee: big.NewInt(params.InitialBaseFee)}
+	genDb, blocks, _ := GenerateChainWithGenesis(gspec, engine, 10, func(i int, b *BlockGen) {})
 
-		gspec = &Genesis{
-			Config:  params.TestChainConfig,
-			Alloc:   GenesisAlloc{testBankAddress: {Balance: testBankFunds}},
-			BaseFee: big.NewInt(params.InitialBaseFee),
-		}
-		engine = ethash.NewFaker()
-		nonce  = uint64(0)
-	)
-	_, blocks, receipts := GenerateChainWithGenesis(gspec, engine, 128, func(i int, gen *BlockGen) {
-		tx, _ := types.SignTx(types.NewTransaction(nonce, common.HexToAddress("0xdeadbeef"), big.NewInt(1000), params.TxGas, big.NewInt(10*params.InitialBaseFee), nil), types.HomesteadSigner{}, testBankKey)
-		gen.AddTx(tx)
-		nonce += 1
+	// Fork off below the finalized block with a heavier chain
+	forks, _ := GenerateChain(gspec.Config, blocks[2], engine, genDb, 12, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{0x01})
 	})
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
 
-	// verifyIndexes checks if the transaction indexes are present or not
-	// of the specified block.
-	verifyIndexes := func(db ethdb.Database, number uint64, exist bool) {
-		if number == 0 {
-			return
-		}
-		block := blocks[number-1]
-		for _, tx := range block.Transactions() {
-			lookup := rawdb.ReadTxLookupEntry(db, tx.Hash())
-			if exist && lookup == nil {
-				t.Fatalf("missing %d %x", number, tx.Hash().Hex())
-			}
-			if !exist && lookup != nil {
-				t.Fatalf("unexpected %d %x", number, tx.Hash().Hex())
-			}
-		}
+	reorgCh := make(chan FinalizedReorgEvent, 1)
+	sub := chain.SubscribeFinalizedReorgEvent(reorgCh)
+	defer sub.Unsubscribe()
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
 	}
-	// verifyRange runs verifyIndexes for a range of blocks, from and to are included.
-	verifyRange := func(db ethdb.Database, from, to uint64, exist bool) {
-		for number := from; number <= to; number += 1 {
-			verifyIndexes(db, number, exist)
-		}
+	if _, err := chain.InsertChain(forks); !errors.Is(err, ErrFinalizedReorg) {
+		t.Fatalf("reorg error mismatch: have %v, want %v", err, ErrFinalizedReorg)
 	}
-	verify := func(db ethdb.Database, expTail uint64) {
-		tail := rawdb.ReadTxIndexTail(db)
-		if tail == nil {
-			t.Fatal("Failed to write tx index tail")
+	if head := chain.CurrentBlock(); head.Hash() != blocks[9].Hash() {
+		t.Fatalf("head block mismatch: have %d, want %d", head.Number, blocks[9].NumberU64())
+	}
+	select {
+	case ev := <-reorgCh:
+		if ev.Finalized.Hash() != blocks[4].Hash() {
+			t.Errorf("finalized header mismatch: have %d, want %d", ev.Finalized.Number, blocks[4].NumberU64())
 		}
-		if *tail != expTail {
-			t.Fatalf("Unexpected tx index tail, want %v, got %d", expTail, *tail)
+		if ev.Common.Hash() != blocks[2].Hash() {
+			t.Errorf("common ancestor mismatch: have %d, want %d", ev.Common.Number, blocks[2].NumberU64())
 		}
-		if *tail != 0 {
-			verifyRange(db, 0, *tail-1, false)
+		if ev.OldHead.Hash() != blocks[9].Hash() {
+			t.Errorf("old head mismatch: have %d, want %d", ev.OldHead.Number, blocks[9].NumberU64())
 		}
-		verifyRange(db, *tail, 128, true)
+	default:
+		t.Fatal("missing finalized reorg event")
 	}
+}
 
-	var cases = []struct {
-		limitA uint64
-		tailA  uint64
-		limitB uint64
-		tailB  uint64
-		limitC uint64
-		tailC  uint64
+func TestGenesisForkState(t *testing.T) {
+	engine := ethash.NewFaker()
+	for _, tt := range []struct {
+		name    string
+		genesis *Genesis
+		active  []string
+		pending []string
 	}{
 		{
-			// LimitA: 0
-			// TailA:  0
-			//
-			// all blocks are indexed
-			limitA: 0,
-			tailA:  0,
-
-			// LimitB: 1
-			// TailB:  128
-			//
-			// block-128 is indexed
-			limitB: 1,
-			tailB:  128,
-
-			// LimitB: 64
-			// TailB:  65
-			//
-			// block [65, 128] are indexed
-			limitC: 64,
-			tailC:  65,
+			// Pigeon launched before Athena was scheduled
+			name:    "pigeon",
+			genesis: DefaultPigeonGenesisBlock(),
+			active:  []string{"London", "HashPower", "Hertz", "Shanghai", "Kepler", "Demeter"},
+			pending: []string{"Athena", "Cancun", "Luban", "Plato"},
 		},
 		{
-			// LimitA: 64
-			// TailA:  65
-			//
-			// block [65, 128] are indexed
-			limitA: 64,
-			tailA:  65,
-
-			// LimitB: 1
-			// TailB:  128
-			//
-			// block-128 is indexed
-			limitB: 1,
-			tailB:  128,
-
-			// LimitB: 64
-			// TailB:  65
-			//
-			// block [65, 128] are indexed
-			limitC: 64,
-			tailC:  65,
+			name:    "core",
+			genesis: DefaultCOREGenesisBlock(),
+			active:  []string{"Istanbul", "HashPower"},
+			pending: []string{"London", "Zeus", "Hertz", "Shanghai", "Kepler", "Demeter", "Athena"},
 		},
-		{
-			// LimitA: 127
-			// TailA:  2
-			//
-			// block [2, 128] are indexed
-			limitA: 127,
-			tailA:  2,
+	} {
+		chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, tt.genesis, nil, engine, vm.Config{}, nil, nil)
+		if err != nil {
+			t.Fatalf("%s: failed to create chain: %v", tt.name, err)
+		}
+		state := chain.GenesisForkState()
+		chain.Stop()
 
-			// LimitB: 1
-			// TailB:  128
-			//
-			// block-128 is indexed
-			limitB: 1,
-			tailB:  128,
+		for _, fork := range tt.active {
+			if active, ok := state[fork]; !ok || !active {
+				t.Errorf("%s: fork %s not active at genesis", tt.name, fork)
+			}
+		}
+		for _, fork := range tt.pending {
+			if active, ok := state[fork]; !ok || active {
+				t.Errorf("%s: fork %s active at genesis", tt.name, fork)
+			}
+		}
+	}
+}
 
-			// LimitB: 64
-			// TailB:  65
-			//
-			// block [65, 128] are indexed
-			limitC: 64,
-			tailC:  65,
-		},
-		{
-			// LimitA: 128
-			// TailA:  1
-			//
-			// block [2, 128] are indexed
-			limitA: 128,
-			tailA:  1,
+// Tests that the receipt audit accepts the receipts of a healthy block and
+// pinpoints tampered ones.
+func TestValidateReceiptsAgainstReexecution(t *testing.T) {
+	var (
+		key, _ = crypto.GenerateKey()
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		gspec  = &Genesis{
+			Config:  params.TestChainConfig,
+			Alloc:   GenesisAlloc{addr: {Balance: big.NewInt(params.Ether)}},
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+		signer = types.LatestSigner(gspec.Config)
+	)
+	_, blocks, _ := GenerateChainWithGenesis(gspec, ethash.NewFaker(), 3, func(i int, b *BlockGen) {
+		for j := 0; j < 2; j++ {
+			tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), common.Address{0xaa}, big.NewInt(1), params.TxGas, b.header.BaseFee, nil), signer, key)
+			b.AddTx(tx)
+		}
+	})
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
 
-			// LimitB: 1
-			// TailB:  128
-			//
-			// block-128 is indexed
-			limitB: 1,
-			tailB:  128,
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	for _, block := range blocks {
+		if err := chain.ValidateReceiptsAgainstReexecution(block.Hash()); err != nil {
+			t.Fatalf("block %d: healthy receipts flagged: %v", block.NumberU64(), err)
+		}
+	}
+	// Tamper with the status of the second receipt of block 2
+	block := blocks[1]
+	receipts := rawdb.ReadReceipts(chain.db, block.Hash(), block.NumberU64(), block.Time(), chain.Config())
+	receipts[1].Status = types.ReceiptStatusFailed
+	rawdb.WriteReceipts(chain.db, block.Hash(), block.NumberU64(), receipts)
 
-			// LimitB: 64
-			// TailB:  65
-			//
-			// block [65, 128] are indexed
-			limitC: 64,
-			tailC:  65,
-		},
-		{
-			// LimitA: 129
-			// TailA:  0
-			//
-			// block [0, 128] are indexed
-			limitA: 129,
-			tailA:  0,
+	var mismatch *ReceiptMismatchError
+	if err := chain.ValidateReceiptsAgainstReexecution(block.Hash()); !errors.As(err, &mismatch) {
+		t.Fatalf("tampered receipts error mismatch: have %v, want %T", err, mismatch)
+	}
+	if mismatch.Number != 2 || mismatch.Index != 1 || mismatch.Field != "status" {
+		t.Fatalf("unexpected mismatch reported: %v", mismatch)
+	}
+}
 
-			// LimitB: 1
-			// TailB:  128
-			//
-			// block-128 is indexed
-			limitB: 1,
-			tailB:  128,
+func TestExportRange(t *testing.T) {
+	var (
+		gspec = &Genesis{
+			Config:  params.TestChainConfig,
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+		engine = ethash.NewFaker()
+	)
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 100, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{byte(i)})
+	})
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
 
-			// LimitB: 64
-			// TailB:  65
-			//
-			// block [65, 128] are indexed
-			limitC: 64,
-			tailC:  65,
-		},
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := chain.ExportRange(&buf, 1, 100, true); err != nil {
+		t.Fatalf("failed to export range: %v", err)
+	}
+	reader, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("failed to open gzip stream: %v", err)
+	}
+	var (
+		stream   = rlp.NewStream(reader, 0)
+		imported []*types.Block
+	)
+	for {
+		block := new(types.Block)
+		if err := stream.Decode(block); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("failed to decode block %d: %v", len(imported)+1, err)
+		}
+		imported = append(imported, block)
+	}
+	if len(imported) != len(blocks) {
+		t.Fatalf("exported block count mismatch: have %d, want %d", len(imported), len(blocks))
+	}
+	// Import the exported blocks into a fresh chain and check they're identical
+	replica, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create replica chain: %v", err)
 	}
-	for _, c := range cases {
-		frdir := t.TempDir()
-		db, _ := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "", false, false, false, false)
-		rawdb.WriteAncientBlocks(db, append([]*types.Block{gspec.ToBlock()}, blocks...), append([]types.Receipts{{}}, receipts...), big.NewInt(0))
-
-		// Index the initial blocks from ancient store
-		chain, _ := NewBlockChain(db, nil, gspec, nil, engine, vm.Config{}, nil, &c.limitA)
-		chain.indexBlocks(nil, 128, make(chan struct{}))
-		verify(db, c.tailA)
+	defer replica.Stop()
 
-		chain.SetTxLookupLimit(c.limitB)
-		chain.indexBlocks(rawdb.ReadTxIndexTail(db), 128, make(chan struct{}))
-		verify(db, c.tailB)
+	if _, err := replica.InsertChain(imported); err != nil {
+		t.Fatalf("failed to import exported blocks: %v", err)
+	}
+	for i, block := range blocks {
+		if have := replica.GetBlockByNumber(block.NumberU64()); have == nil || have.Hash() != block.Hash() {
+			t.Fatalf("block %d: imported block mismatch", i+1)
+		}
+	}
+	// Ranges reaching past the head must be rejected before writing anything
+	buf.Reset()
+	if err := chain.ExportRange(&buf, 90, 101, false); !errors.Is(err, ErrBlockUnavailable) {
+		t.Fatalf("unavailable range error mismatch: have %v, want %v", err, ErrBlockUnavailable)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("data written for unavailable range: %d bytes", buf.Len())
+	}
+}
 
-		chain.SetTxLookupLimit(c.limitC)
-		chain.indexBlocks(rawdb.ReadTxIndexTail(db), 128, make(chan struct{}))
-		verify(db, c.tailC)
+func TestReplayBlock(t *testing.T) {
+	testReplayBlock(t, rawdb.HashScheme)
+	testReplayBlock(t, rawdb.PathScheme)
+}
 
-		// Recover all indexes
-		chain.SetTxLookupLimit(0)
-		chain.indexBlocks(rawdb.ReadTxIndexTail(db), 128, make(chan struct{}))
-		verify(db, 0)
+func testReplayBlock(t *testing.T, scheme string) {
+	var (
+		key, _ = crypto.GenerateKey()
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		gspec  = &Genesis{
+			Config:  params.TestChainConfig,
+			Alloc:   GenesisAlloc{addr: {Balance: big.NewInt(params.Ether)}},
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+		signer = types.LatestSigner(gspec.Config)
+	)
+	_, blocks, _ := GenerateChainWithGenesis(gspec, ethash.NewFaker(), 4, func(i int, b *BlockGen) {
+		for j := 0; j < 3; j++ {
+			tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), common.Address{0xaa}, big.NewInt(1), params.TxGas, b.header.BaseFee, nil), signer, key)
+			b.AddTx(tx)
+		}
+	})
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), DefaultCacheConfigWithScheme(scheme), gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
 
-		chain.Stop()
-		db.Close()
-		os.RemoveAll(frdir)
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	head := chain.CurrentBlock()
+	for _, block := range blocks {
+		statedb, receipts, err := chain.ReplayBlock(block.NumberU64(), vm.Config{})
+		if err != nil {
+			t.Fatalf("%s, block %d: failed to replay: %v", scheme, block.NumberU64(), err)
+		}
+		if len(receipts) != len(block.Transactions()) {
+			t.Fatalf("%s, block %d: receipt count mismatch: have %d, want %d", scheme, block.NumberU64(), len(receipts), len(block.Transactions()))
+		}
+		if root := statedb.IntermediateRoot(true); root != block.Root() {
+			t.Fatalf("%s, block %d: state root mismatch: have %x, want %x", scheme, block.NumberU64(), root, block.Root())
+		}
+	}
+	if _, _, err := chain.ReplayBlock(uint64(len(blocks)+1), vm.Config{}); !errors.Is(err, ErrBlockUnavailable) {
+		t.Fatalf("%s: unknown block error mismatch: have %v, want %v", scheme, err, ErrBlockUnavailable)
+	}
+	if chain.CurrentBlock().Hash() != head.Hash() {
+		t.Fatalf("%s: chain head changed by replay", scheme)
 	}
 }
 
-func TestCreateThenDeletePreByzantium(t *testing.T) {
-	// We use Ropsten chain config instead of Testchain config, this is
-	// deliberate: we want to use pre-byz rules where we have intermediate state roots
-	// between transactions.
-	testCreateThenDelete(t, &params.ChainConfig{
-		ChainID:        big.NewInt(3),
-		HomesteadBlock: big.NewInt(0),
-		EIP150Block:    big.NewInt(0),
-		EIP155Block:    big.NewInt(10),
-		EIP158Block:    big.NewInt(10),
-		ByzantiumBlock: big.NewInt(1_700_000),
+// Tests that reorgs dropping more canonical blocks than the configured limit are
+// refused until explicitly allowed.
+func TestMaxReorgDepth(t *testing.T) {
+	var (
+		engine = ethash.NewFaker()
+		gspec  = &Genesis{
+			Config:  params.TestChainConfig,
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+	)
+	_, canon, _ := GenerateChainWithGenesis(gspec, engine, 10, nil)
+	_, fork, _ := GenerateChainWithGenesis(gspec, engine, 12, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{0x01})
 	})
-}
-func TestCreateThenDeletePostByzantium(t *testing.T) {
-	testCreateThenDelete(t, params.TestChainConfig)
+	cacheConfig := *defaultCacheConfig
+	cacheConfig.MaxReorgDepth = 5
+
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), &cacheConfig, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(canon); err != nil {
+		t.Fatalf("failed to insert canonical chain: %v", err)
+	}
+	if _, err := chain.InsertChain(fork); !errors.Is(err, ErrReorgTooDeep) {
+		t.Fatalf("deep reorg error mismatch: have %v, want %v", err, ErrReorgTooDeep)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != canon[len(canon)-1].Hash() {
+		t.Fatalf("head changed by refused reorg: have #%d [%x]", head.Number, head.Hash())
+	}
+	chain.AllowDeepReorg(true)
+	if _, err := chain.InsertChain(fork); err != nil {
+		t.Fatalf("failed to reorg after override: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != fork[len(fork)-1].Hash() {
+		t.Fatalf("head mismatch after override: have #%d [%x], want #%d [%x]", head.Number, head.Hash(), fork[len(fork)-1].NumberU64(), fork[len(fork)-1].Hash())
+	}
 }
 
-// testCreateThenDelete tests a creation and subsequent deletion of a contract, happening
-// within the same block.
-func testCreateThenDelete(t *testing.T, config *params.ChainConfig) {
+// Tests that reorgs whose common ancestor is below the block marked finalized
+// are refused.
+func TestSetFinalizedRejectsReorg(t *testing.T) {
 	var (
 		engine = ethash.NewFaker()
-		// A sender who makes transactions, has some funds
-		key, _      = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		address     = crypto.PubkeyToAddress(key.PublicKey)
-		destAddress = crypto.CreateAddress(address, 0)
-		funds       = big.NewInt(1000000000000000)
+		gspec  = &Genesis{
+			Config:  params.TestChainConfig,
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
 	)
-
-	// runtime code is 	0x60ffff : PUSH1 0xFF SELFDESTRUCT, a.k.a SELFDESTRUCT(0xFF)
-	code := append([]byte{0x60, 0xff, 0xff}, make([]byte, 32-3)...)
-	initCode := []byte{
-		// SSTORE 1:1
-		byte(vm.PUSH1), 0x1,
-		byte(vm.PUSH1), 0x1,
-		byte(vm.SSTORE),
-		// Get the runtime-code on the stack
-		byte(vm.PUSH32)}
-	initCode = append(initCode, code...)
-	initCode = append(initCode, []byte{
-		byte(vm.PUSH1), 0x0, // offset
-		byte(vm.MSTORE),
-		byte(vm.PUSH1), 0x3, // size
-		byte(vm.PUSH1), 0x0, // offset
-		byte(vm.RETURN), // return 3 bytes of zero-code
-	}...)
-	gspec := &Genesis{
-		Config: config,
-		Alloc: GenesisAlloc{
-			address: {Balance: funds},
-		},
-	}
-	nonce := uint64(0)
-	signer := types.HomesteadSigner{}
-	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 2, func(i int, b *BlockGen) {
-		fee := big.NewInt(1)
-		if b.header.BaseFee != nil {
-			fee = b.header.BaseFee
+	_, canon, _ := GenerateChainWithGenesis(gspec, engine, 10, nil)
+	// The fork shares the first 4 blocks and diverges from block 5 onwards
+	_, fork, _ := GenerateChainWithGenesis(gspec, engine, 12, func(i int, b *BlockGen) {
+		if i >= 4 {
+			b.SetCoinbase(common.Address{0x01})
 		}
-		b.SetCoinbase(common.Address{1})
-		tx, _ := types.SignNewTx(key, signer, &types.LegacyTx{
-			Nonce:    nonce,
-			GasPrice: new(big.Int).Set(fee),
-			Gas:      100000,
-			Data:     initCode,
-		})
-		nonce++
-		b.AddTx(tx)
-		tx, _ = types.SignNewTx(key, signer, &types.LegacyTx{
-			Nonce:    nonce,
-			GasPrice: new(big.Int).Set(fee),
-			Gas:      100000,
-			To:       &destAddress,
-		})
-		b.AddTx(tx)
-		nonce++
 	})
-	// Import the canonical chain
-	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{
-		//Debug:  true,
-		//Tracer: logger.NewJSONLogger(nil, os.Stdout),
-	}, nil, nil)
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("failed to create tester chain: %v", err)
 	}
 	defer chain.Stop()
-	// Import the blocks
-	for _, block := range blocks {
-		if _, err := chain.InsertChain([]*types.Block{block}); err != nil {
-			t.Fatalf("block %d: failed to insert into chain: %v", block.NumberU64(), err)
+
+	if _, err := chain.InsertChain(canon); err != nil {
+		t.Fatalf("failed to insert canonical chain: %v", err)
+	}
+	if _, err := chain.InsertChain(fork[:8]); err != nil {
+		t.Fatalf("failed to insert side chain: %v", err)
+	}
+	// Only the head and its ancestors may be finalized
+	if err := chain.SetFinalized(fork[6].Header()); !errors.Is(err, errFinalizedNotAncestor) {
+		t.Fatalf("side block finalization error mismatch: have %v, want %v", err, errFinalizedNotAncestor)
+	}
+	if err := chain.SetFinalized(fork[11].Header()); !errors.Is(err, errFinalizedNotAncestor) {
+		t.Fatalf("future block finalization error mismatch: have %v, want %v", err, errFinalizedNotAncestor)
+	}
+	if err := chain.SetFinalized(canon[4].Header()); err != nil {
+		t.Fatalf("failed to finalize block 5: %v", err)
+	}
+	if final := chain.CurrentFinalBlock(); final == nil || final.Hash() != canon[4].Hash() {
+		t.Fatalf("finalized block mismatch: have %v, want %x", final, canon[4].Hash())
+	}
+	// The heavier fork from block 4 must be refused
+	if _, err := chain.InsertChain(fork[8:]); !errors.Is(err, ErrFinalizedReorg) {
+		t.Fatalf("reorg below finalized error mismatch: have %v, want %v", err, ErrFinalizedReorg)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != canon[len(canon)-1].Hash() {
+		t.Fatalf("head changed by refused reorg: have #%d [%x]", head.Number, head.Hash())
+	}
+}
+
+// Tests that the finalized marker survives a restart, and that it's dropped if
+// the chain is rewound below it.
+func TestSetFinalizedPersistence(t *testing.T) {
+	var (
+		engine = ethash.NewFaker()
+		gspec  = &Genesis{
+			Config:  params.TestChainConfig,
+			BaseFee: big.NewInt(params.InitialBaseFee),
 		}
+		db = rawdb.NewMemoryDatabase()
+	)
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 10, nil)
+
+	chain, err := NewBlockChain(db, nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	if err := chain.SetFinalized(blocks[7].Header()); err != nil {
+		t.Fatalf("failed to finalize block 8: %v", err)
+	}
+	chain.Stop()
+
+	chain, err = NewBlockChain(db, nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to reopen tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if final := chain.CurrentFinalBlock(); final == nil || final.Hash() != blocks[7].Hash() {
+		t.Fatalf("restored finalized block mismatch: have %v, want %x", final, blocks[7].Hash())
+	}
+	// Rewinding below the finalized block must drop the marker
+	if err := chain.SetHead(5); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	if final := chain.CurrentFinalBlock(); final != nil {
+		t.Fatalf("finalized block retained above head: #%d", final.Number)
+	}
+	if hash := rawdb.ReadFinalizedBlockHash(db); hash != (common.Hash{}) {
+		t.Fatalf("finalized block hash retained in database: %x", hash)
 	}
 }
 
-// TestTransientStorageReset ensures the transient storage is wiped correctly
-// between transactions.
-func TestTransientStorageReset(t *testing.T) {
+func TestGetCanonicalHashes(t *testing.T) {
 	var (
-		engine      = ethash.NewFaker()
-		key, _      = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		address     = crypto.PubkeyToAddress(key.PublicKey)
-		destAddress = crypto.CreateAddress(address, 0)
-		funds       = big.NewInt(1000000000000000)
-		vmConfig    = vm.Config{
-			ExtraEips: []int{1153}, // Enable transient storage EIP
+		engine = ethash.NewFaker()
+		gspec  = &Genesis{
+			Config:  params.TestChainConfig,
+			BaseFee: big.NewInt(params.InitialBaseFee),
 		}
 	)
-	code := append([]byte{
-		// TLoad value with location 1
-		byte(vm.PUSH1), 0x1,
-		byte(vm.TLOAD),
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 20, nil)
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
 
-		// PUSH location
-		byte(vm.PUSH1), 0x1,
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	for _, tt := range []struct {
+		from, count uint64
+		want        int
+	}{
+		{0, 5, 5},
+		{3, 10, 10},
+		{10, 10, 10},
+		{15, 10, 6}, // truncated at the head
+		{20, 1, 1},
+		{21, 5, 0},
+		{5, 0, 0},
+	} {
+		hashes, err := chain.GetCanonicalHashes(tt.from, tt.count)
+		if err != nil {
+			t.Fatalf("range %d+%d: failed to read hashes: %v", tt.from, tt.count, err)
+		}
+		if len(hashes) != tt.want {
+			t.Fatalf("range %d+%d: hash count mismatch: have %d, want %d", tt.from, tt.count, len(hashes), tt.want)
+		}
+		for i, hash := range hashes {
+			if want := chain.GetCanonicalHash(tt.from + uint64(i)); hash != want {
+				t.Fatalf("range %d+%d: hash %d mismatch: have %x, want %x", tt.from, tt.count, tt.from+uint64(i), hash, want)
+			}
+		}
+	}
+}
 
-		// SStore location:value
-		byte(vm.SSTORE),
-	}, make([]byte, 32-6)...)
-	initCode := []byte{
-		// TSTORE 1:1
-		byte(vm.PUSH1), 0x1,
-		byte(vm.PUSH1), 0x1,
-		byte(vm.TSTORE),
+// splitDistributor credits half of every fee reward to each of two addresses.
+type splitDistributor struct {
+	a, b common.Address
+}
 
-		// Get the runtime-code on the stack
-		byte(vm.PUSH32)}
-	initCode = append(initCode, code...)
-	initCode = append(initCode, []byte{
-		byte(vm.PUSH1), 0x0, // offset
-		byte(vm.MSTORE),
-		byte(vm.PUSH1), 0x6, // size
-		byte(vm.PUSH1), 0x0, // offset
-		byte(vm.RETURN), // return 6 bytes of zero-code
-	}...)
-	gspec := &Genesis{
-		Config: params.TestChainConfig,
-		Alloc: GenesisAlloc{
-			address: {Balance: funds},
-		},
+func (d *splitDistributor) Distribute(state vm.StateDB, header *types.Header, reward *uint256.Int) {
+	half := new(uint256.Int).Rsh(reward, 1)
+	state.AddBalance(d.a, half.ToBig())
+	state.AddBalance(d.b, new(uint256.Int).Sub(reward, half).ToBig())
+}
+
+// Tests that a custom reward distributor replaces the beneficiary fee credit
+// both when building and when importing blocks.
+func TestRewardDistributor(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr    = crypto.PubkeyToAddress(key.PublicKey)
+		dist    = &splitDistributor{a: common.Address{0xaa}, b: common.Address{0xbb}}
+		engine  = ethash.NewFaker()
+		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{addr: {Balance: big.NewInt(params.Ether)}}}
+		signer  = types.LatestSigner(gspec.Config)
+		tip     = big.NewInt(params.GWei)
+		reward  = new(big.Int).Mul(big.NewInt(int64(params.TxGas)), tip)
+		halfRwd = new(big.Int).Rsh(reward, 1)
+	)
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil, EnableRewardDistributor(dist))
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	defer chain.Stop()
+
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
+		gasPrice := new(big.Int).Add(b.BaseFee(), tip)
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), common.Address{0x01}, big.NewInt(1), params.TxGas, gasPrice, nil), signer, key)
+		b.AddTxWithChain(chain, tx)
+	})
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
 	}
-	nonce := uint64(0)
-	signer := types.HomesteadSigner{}
+	state, err := chain.State()
+	if err != nil {
+		t.Fatalf("failed to open head state: %v", err)
+	}
+	if have := state.GetBalance(dist.a); have.Cmp(halfRwd) != 0 {
+		t.Errorf("first recipient balance mismatch: have %v, want %v", have, halfRwd)
+	}
+	if have, want := state.GetBalance(dist.b), new(big.Int).Sub(reward, halfRwd); have.Cmp(want) != 0 {
+		t.Errorf("second recipient balance mismatch: have %v, want %v", have, want)
+	}
+	if have := state.GetBalance(blocks[0].Coinbase()); have.Cmp(ethash.ConstantinopleBlockReward) != 0 {
+		t.Errorf("coinbase balance mismatch: have %v, want %v", have, ethash.ConstantinopleBlockReward)
+	}
+}
+
+// Tests that transaction pages of a block are served both from the database
+// and from the body cache.
+func TestGetBlockTransactions(t *testing.T) {
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		addr   = crypto.PubkeyToAddress(key.PublicKey)
+		engine = ethash.NewFaker()
+		gspec  = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{addr: {Balance: big.NewInt(params.Ether)}}}
+		signer = types.LatestSigner(gspec.Config)
+	)
 	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
-		fee := big.NewInt(1)
-		if b.header.BaseFee != nil {
-			fee = b.header.BaseFee
+		for j := 0; j < 10; j++ {
+			tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(addr), common.Address{0x01}, big.NewInt(1), params.TxGas, b.BaseFee(), nil), signer, key)
+			b.AddTx(tx)
 		}
-		b.SetCoinbase(common.Address{1})
-		tx, _ := types.SignNewTx(key, signer, &types.LegacyTx{
-			Nonce:    nonce,
-			GasPrice: new(big.Int).Set(fee),
-			Gas:      100000,
-			Data:     initCode,
-		})
-		nonce++
-		b.AddTxWithVMConfig(tx, vmConfig)
-
-		tx, _ = types.SignNewTx(key, signer, &types.LegacyTx{
-			Nonce:    nonce,
-			GasPrice: new(big.Int).Set(fee),
-			Gas:      100000,
-			To:       &destAddress,
-		})
-		b.AddTxWithVMConfig(tx, vmConfig)
-		nonce++
 	})
-
-	// Initialize the blockchain with 1153 enabled.
-	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vmConfig, nil, nil)
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("failed to create tester chain: %v", err)
 	}
 	defer chain.Stop()
-	// Import the blocks
+
 	if _, err := chain.InsertChain(blocks); err != nil {
-		t.Fatalf("failed to insert into chain: %v", err)
+		t.Fatalf("failed to insert chain: %v", err)
 	}
-	// Check the storage
-	state, err := chain.StateAt(chain.CurrentHeader().Root)
-	if err != nil {
-		t.Fatalf("Failed to load state %v", err)
+	hash, want := blocks[0].Hash(), blocks[0].Transactions()
+	for _, cached := range []bool{false, true} {
+		if cached {
+			chain.GetBody(hash)
+		} else {
+			chain.bodyCache.Purge()
+		}
+		txs, err := chain.GetBlockTransactions(hash, 4, 3)
+		if err != nil {
+			t.Fatalf("cached %v: failed to read transactions: %v", cached, err)
+		}
+		if len(txs) != 3 {
+			t.Fatalf("cached %v: transaction count mismatch: have %d, want 3", cached, len(txs))
+		}
+		for i, tx := range txs {
+			if tx.Hash() != want[4+i].Hash() {
+				t.Errorf("cached %v: transaction %d mismatch", cached, 4+i)
+			}
+		}
+		// Modifying the returned range must not corrupt the cached body
+		txs[0] = nil
+		if body := chain.GetBody(hash); body.Transactions[4] == nil {
+			t.Fatalf("cached %v: returned transactions alias the cached body", cached)
+		}
+		if txs, err := chain.GetBlockTransactions(hash, 10, 5); err != nil || len(txs) != 0 {
+			t.Errorf("cached %v: page past the end: have %d transactions, err %v", cached, len(txs), err)
+		}
 	}
-	loc := common.BytesToHash([]byte{1})
-	slot := state.GetState(destAddress, loc)
-	if slot != (common.Hash{}) {
-		t.Fatalf("Unexpected dirty storage slot")
+	if _, err := chain.GetBlockTransactions(common.Hash{0x01}, 0, 1); !errors.Is(err, ErrBlockUnavailable) {
+		t.Errorf("unknown block error mismatch: have %v, want %v", err, ErrBlockUnavailable)
+	}
+	if _, err := chain.GetBlockTransactions(hash, 0, 0); err == nil {
+		t.Error("zero limit accepted")
 	}
 }
 
-func TestEIP3651(t *testing.T) {
+// countdownContext is a context that reports cancellation after its Err method
+// has been called a given number of times.
+type countdownContext struct {
+	context.Context
+	remaining int
+}
+
+func (c *countdownContext) Err() error {
+	if c.remaining == 0 {
+		return context.Canceled
+	}
+	c.remaining--
+	return nil
+}
+
+// Tests that a cancelled import keeps the blocks committed before the
+// cancellation and leaves a consistent, queryable chain behind.
+func TestInsertChainWithContextCancel(t *testing.T) {
 	var (
-		aa     = common.HexToAddress("0x000000000000000000000000000000000000aaaa")
-		bb     = common.HexToAddress("0x000000000000000000000000000000000000bbbb")
-		engine = beacon.NewFaker()
+		engine = ethash.NewFaker()
+		gspec  = &Genesis{
+			Config:  params.TestChainConfig,
+			BaseFee: big.NewInt(params.InitialBaseFee),
+		}
+	)
+	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 10, nil)
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
 
-		// A sender who makes transactions, has some funds
-		key1, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
-		key2, _ = crypto.HexToECDSA("8a1f9a8f95be41cd7ccb6168179afb4504aefe388d1e14474d32c45c72ce7b7a")
-		addr1   = crypto.PubkeyToAddress(key1.PublicKey)
-		addr2   = crypto.PubkeyToAddress(key2.PublicKey)
-		funds   = new(big.Int).Mul(common.Big1, big.NewInt(params.Ether))
-		config  = *params.AllEthashProtocolChanges
+	// Allow the initial check and three blocks, cancel on the fourth
+	ctx := &countdownContext{Context: context.Background(), remaining: 4}
+	n, err := chain.InsertChainWithContext(ctx, blocks)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error mismatch: have %v, want %v", err, context.Canceled)
+	}
+	if n != 3 {
+		t.Fatalf("processed block count mismatch: have %d, want 3", n)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != blocks[2].Hash() {
+		t.Fatalf("head mismatch: have #%d [%x], want #%d [%x]", head.Number, head.Hash(), blocks[2].NumberU64(), blocks[2].Hash())
+	}
+	if _, err := chain.State(); err != nil {
+		t.Fatalf("head state unavailable: %v", err)
+	}
+	if chain.HasBlock(blocks[3].Hash(), blocks[3].NumberU64()) {
+		t.Fatal("block after the cancellation was committed")
+	}
+	// The rest of the batch can be imported afterwards
+	if _, err := chain.InsertChain(blocks[3:]); err != nil {
+		t.Fatalf("failed to import remaining blocks: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != blocks[9].Hash() {
+		t.Fatalf("head mismatch after resume: have #%d, want #%d", head.Number, blocks[9].NumberU64())
+	}
+}
+
+// Tests that the gas consumed by each executed contract is profiled only when
+// enabled, with the gas of sub-calls attributed to the callees.
+func TestGasProfiling(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		counter = common.HexToAddress("0xcc")
+		proxy   = common.HexToAddress("0xaa")
+		engine  = ethash.NewFaker()
 		gspec   = &Genesis{
-			Config: &config,
+			Config: params.TestChainConfig,
 			Alloc: GenesisAlloc{
-				addr1: {Balance: funds},
-				addr2: {Balance: funds},
-				// The address 0xAAAA sloads 0x00 and 0x01
-				aa: {
+				address: {Balance: big.NewInt(params.Ether)},
+				// Increments slot 0 on every call
+				counter: {
+					Balance: common.Big0,
 					Code: []byte{
-						byte(vm.PC),
-						byte(vm.PC),
-						byte(vm.SLOAD),
-						byte(vm.SLOAD),
+						byte(vm.PUSH1), 0x00, byte(vm.SLOAD),
+						byte(vm.PUSH1), 0x01, byte(vm.ADD),
+						byte(vm.PUSH1), 0x00, byte(vm.SSTORE),
+						byte(vm.STOP),
 					},
-					Nonce:   0,
-					Balance: big.NewInt(0),
 				},
-				// The address 0xBBBB calls 0xAAAA
-				bb: {
+				// Forwards every call to the counter
+				proxy: {
+					Balance: common.Big0,
 					Code: []byte{
-						byte(vm.PUSH1), 0, // out size
-						byte(vm.DUP1),  // out offset
-						byte(vm.DUP1),  // out insize
-						byte(vm.DUP1),  // in offset
-						byte(vm.PUSH2), // address
-						byte(0xaa),
-						byte(0xaa),
-						byte(vm.GAS), // gas
-						byte(vm.DELEGATECALL),
+						byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+						byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0xcc, byte(vm.GAS), byte(vm.CALL),
+						byte(vm.POP), byte(vm.STOP),
 					},
-					Nonce:   0,
-					Balance: big.NewInt(0),
 				},
 			},
 		}
+		signer = types.LatestSigner(gspec.Config)
 	)
+	_, blocks, receipts := GenerateChainWithGenesis(gspec, engine, 2, func(i int, b *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(address), proxy, common.Big0, 100000, b.header.BaseFee, nil), signer, key)
+		b.AddTx(tx)
+	})
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
 
-	gspec.Config.BerlinBlock = common.Big0
-	gspec.Config.LondonBlock = common.Big0
-	gspec.Config.TerminalTotalDifficulty = common.Big0
-	gspec.Config.TerminalTotalDifficultyPassed = true
-	gspec.Config.ShanghaiTime = u64(0)
-	signer := types.LatestSigner(gspec.Config)
+	// Blocks processed with profiling disabled must not be profiled
+	if _, err := chain.InsertChain(blocks[:1]); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	if profile := chain.LastBlockGasProfile(); profile != nil {
+		t.Fatalf("profile reported with profiling disabled: %v", profile)
+	}
+	chain.EnableGasProfiling(true)
+	if _, err := chain.InsertChain(blocks[1:]); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	profile := chain.LastBlockGasProfile()
+	if profile[counter] == 0 || profile[proxy] == 0 {
+		t.Fatalf("missing contract gas: %v", profile)
+	}
+	// The counter's storage write must be charged to the counter only
+	if profile[proxy] >= profile[counter] {
+		t.Errorf("sub-call gas attributed to the caller: proxy %d, counter %d", profile[proxy], profile[counter])
+	}
+	if have, want := profile[proxy]+profile[counter], receipts[1][0].GasUsed-params.TxGas; have != want {
+		t.Errorf("profiled gas mismatch: have %d, want %d", have, want)
+	}
+}
 
-	_, blocks, _ := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
-		b.SetCoinbase(aa)
-		// One transaction to Coinbase
-		txdata := &types.DynamicFeeTx{
-			ChainID:    gspec.Config.ChainID,
-			Nonce:      0,
-			To:         &bb,
-			Gas:        500000,
-			GasFeeCap:  newGwei(5),
-			GasTipCap:  big.NewInt(2),
-			AccessList: nil,
-			Data:       []byte{},
+// Tests that replaying a block under an alternate config applies its rules
+// without affecting the chain.
+func TestReplayBlockWithConfig(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address  = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.HexToAddress("0xcafe")
+		engine   = ethash.NewFaker()
+		gspec    = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				address: {Balance: big.NewInt(params.Ether)},
+				// Copies a memory word with MCOPY, only valid from Theseus, then
+				// marks slot 0 so a successful copy shows up in the state
+				contract: {
+					Balance: common.Big0,
+					Code: []byte{
+						byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x20,
+						byte(vm.MCOPY),
+						byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.SSTORE),
+						byte(vm.STOP),
+					},
+				},
+			},
 		}
-		tx := types.NewTx(txdata)
-		tx, _ = types.SignTx(tx, signer, key1)
-
+		signer = types.LatestSigner(gspec.Config)
+	)
+	if gspec.Config.TheseusTime != nil {
+		t.Fatal("test chain config already on theseus")
+	}
+	_, blocks, receipts := GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(address), contract, common.Big0, 100000, b.header.BaseFee, nil), signer, key)
 		b.AddTx(tx)
 	})
-	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{Tracer: logger.NewMarkdownLogger(&logger.Config{}, os.Stderr)}, nil, nil)
+	if receipts[0][0].Status != types.ReceiptStatusFailed || receipts[0][0].GasUsed != 100000 {
+		t.Fatalf("pre-theseus MCOPY did not fail: status %d, gas %d", receipts[0][0].Status, receipts[0][0].GasUsed)
+	}
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, engine, vm.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("failed to create tester chain: %v", err)
 	}
 	defer chain.Stop()
-	if n, err := chain.InsertChain(blocks); err != nil {
-		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
 	}
+	// Replaying with the chain's own config must reproduce the block
+	_, replayed, err := chain.ReplayBlockWithConfig(blocks[0], gspec.Config)
+	if err != nil {
+		t.Fatalf("failed to replay block: %v", err)
+	}
+	if replayed[0].Status != receipts[0][0].Status || replayed[0].GasUsed != receipts[0][0].GasUsed {
+		t.Fatalf("replay mismatch: status %d, gas %d", replayed[0].Status, replayed[0].GasUsed)
+	}
+	// Replaying with theseus active must succeed the MCOPY and charge less gas
+	theseus := *gspec.Config
+	theseus.TheseusTime = new(uint64)
 
-	block := chain.GetBlockByNumber(1)
+	statedb, replayed, err := chain.ReplayBlockWithConfig(blocks[0], &theseus)
+	if err != nil {
+		t.Fatalf("failed to replay block with theseus: %v", err)
+	}
+	if replayed[0].Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("theseus replay status mismatch: have %d, want %d", replayed[0].Status, types.ReceiptStatusSuccessful)
+	}
+	if replayed[0].GasUsed >= receipts[0][0].GasUsed {
+		t.Fatalf("theseus replay gas not lower: have %d, original %d", replayed[0].GasUsed, receipts[0][0].GasUsed)
+	}
+	if statedb.GetState(contract, common.Hash{}) != common.BigToHash(common.Big1) {
+		t.Fatal("theseus replay state misses the contract's storage write")
+	}
+	if statedb.IntermediateRoot(true) == blocks[0].Root() {
+		t.Fatal("theseus replay reproduced the original state")
+	}
+	// The chain and its state must be untouched
+	if head := chain.CurrentBlock(); head.Hash() != blocks[0].Hash() {
+		t.Fatalf("head block changed: have %d, want %d", head.Number, blocks[0].NumberU64())
+	}
+	stored := chain.GetReceiptsByHash(blocks[0].Hash())
+	if len(stored) != 1 || stored[0].Status != types.ReceiptStatusFailed {
+		t.Fatal("stored receipts changed by replay")
+	}
+	if _, err := chain.StateAt(blocks[0].Root()); err != nil {
+		t.Fatalf("head state unavailable after replay: %v", err)
+	}
+}
 
-	// 1+2: Ensure EIP-1559 access lists are accounted for via gas usage.
-	innerGas := vm.GasQuickStep*2 + params.ColdSloadCostEIP2929*2
-	expectedGas := params.TxGas + 5*vm.GasFastestStep + vm.GasQuickStep + 100 + innerGas // 100 because 0xaaaa is in access list
-	if block.GasUsed() != expectedGas {
-		t.Fatalf("incorrect amount of gas spent: expected %d, got %d", expectedGas, block.GasUsed())
+// Tests that BlockByTimestamp returns the first canonical block not earlier
+// than the requested time, falling back to the head for future times.
+func TestBlockByTimestamp(t *testing.T) {
+	gspec := &Genesis{
+		Config:    params.TestChainConfig,
+		Timestamp: 1000,
+		BaseFee:   big.NewInt(params.InitialBaseFee),
+	}
+	_, blocks, _ := GenerateChainWithGenesis(gspec, ethash.NewFaker(), 32, func(i int, b *BlockGen) {
+		b.OffsetTime(int64(i % 7 * 13))
+	})
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
 	}
+	defer chain.Stop()
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	headers := []*types.Header{chain.Genesis().Header()}
+	for _, block := range blocks {
+		headers = append(headers, block.Header())
+	}
+	// Probe every timestamp from before genesis until past the head, and compare
+	// against a linear scan
+	for ts := uint64(990); ts <= headers[len(headers)-1].Time+10; ts++ {
+		want := headers[len(headers)-1]
+		for _, header := range headers {
+			if header.Time >= ts {
+				want = header
+				break
+			}
+		}
+		block, err := chain.BlockByTimestamp(ts)
+		if err != nil {
+			t.Fatalf("time %d: failed to look up block: %v", ts, err)
+		}
+		if block.Hash() != want.Hash() {
+			t.Fatalf("time %d: block mismatch: have #%d (time %d), want #%d (time %d)", ts, block.NumberU64(), block.Time(), want.Number, want.Time)
+		}
+	}
+}
 
-	state, _ := chain.State()
+// Tests that BlockByTimestamp returns the lowest numbered block if several
+// canonical blocks share the requested timestamp.
+func TestBlockByTimestampEqualTimes(t *testing.T) {
+	gspec := &Genesis{
+		Config:    params.TestChainConfig,
+		Timestamp: 1000,
+		BaseFee:   big.NewInt(params.InitialBaseFee),
+	}
+	// Blocks 3-6 share their timestamp, the full faker accepts them
+	_, blocks, _ := GenerateChainWithGenesis(gspec, ethash.NewFullFaker(), 8, func(i int, b *BlockGen) {
+		if i >= 3 && i <= 6 {
+			b.header.Time = b.parent.Time()
+		}
+	})
+	chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), nil, gspec, nil, ethash.NewFullFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	shared := blocks[2].Time()
+	if blocks[6].Time() != shared || blocks[7].Time() <= shared {
+		t.Fatalf("unexpected block times: %d, %d, %d", blocks[2].Time(), blocks[6].Time(), blocks[7].Time())
+	}
+	for _, ts := range []uint64{shared - 1, shared} {
+		block, err := chain.BlockByTimestamp(ts)
+		if err != nil {
+			t.Fatalf("time %d: failed to look up block: %v", ts, err)
+		}
+		if block.Hash() != blocks[2].Hash() {
+			t.Fatalf("time %d: block mismatch: have #%d, want #%d", ts, block.NumberU64(), blocks[2].NumberU64())
+		}
+	}
+	block, err := chain.BlockByTimestamp(shared + 1)
+	if err != nil {
+		t.Fatalf("time %d: failed to look up block: %v", shared+1, err)
+	}
+	if block.Hash() != blocks[7].Hash() {
+		t.Fatalf("time %d: block mismatch: have #%d, want #%d", shared+1, block.NumberU64(), blocks[7].NumberU64())
+	}
+}
 
-	// 3: Ensure that miner received only the tx's tip.
-	actual := state.GetBalance(block.Coinbase())
-	expected := new(big.Int).SetUint64(block.GasUsed() * block.Transactions()[0].GasTipCap().Uint64())
-	if actual.Cmp(expected) != 0 {
-		t.Fatalf("miner balance incorrect: expected %d, got %d", expected, actual)
+// Tests that the configured ancient freeze threshold is reported by the chain,
+// and that it can't be set below the immutability threshold.
+func TestAncientFreezeThreshold(t *testing.T) {
+	tests := []struct {
+		threshold uint64
+		want      uint64
+	}{
+		{0, params.FullImmutabilityThreshold},
+		{10, params.FullImmutabilityThreshold},
+		{2 * params.FullImmutabilityThreshold, 2 * params.FullImmutabilityThreshold},
 	}
+	for i, tt := range tests {
+		cacheConfig := *defaultCacheConfig
+		cacheConfig.AncientFreezeThreshold = tt.threshold
 
-	// 4: Ensure the tx sender paid for the gasUsed * (tip + block baseFee).
-	actual = new(big.Int).Sub(funds, state.GetBalance(addr1))
-	expected = new(big.Int).SetUint64(block.GasUsed() * (block.Transactions()[0].GasTipCap().Uint64() + block.BaseFee().Uint64()))
-	if actual.Cmp(expected) != 0 {
-		t.Fatalf("sender balance incorrect: expected %d, got %d", expected, actual)
+		chain, err := NewBlockChain(rawdb.NewMemoryDatabase(), &cacheConfig, &Genesis{Config: params.TestChainConfig}, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+		if err != nil {
+			t.Fatalf("test %d: failed to create tester chain: %v", i, err)
+		}
+		if have := chain.AncientFreezeThreshold(); have != tt.want {
+			t.Errorf("test %d: threshold mismatch: have %d, want %d", i, have, tt.want)
+		}
+		chain.Stop()
 	}
 }
@@ -18,7 +18,9 @@ package core
 
 import (
 	"errors"
+	"fmt"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 )
 
@@ -42,6 +44,42 @@ var (
 
 	// ErrKnownBadBlock is return when the block is a known bad block
 	ErrKnownBadBlock = errors.New("already known bad block")
+
+	// ErrKnownPrunedBlock is returned when a batch hitting a pruned ancestor is
+	// resubmitted too often on top of the same chain head. The batch was already
+	// processed, so it does not indicate an invalid chain.
+	ErrKnownPrunedBlock = errors.New("pruned ancestor batch already processed")
+
+	// ErrUnknownImportTime is returned when the local import time of a block is not tracked.
+	ErrUnknownImportTime = errors.New("block import time unknown")
+
+	// ErrFinalizedReorg is returned when a reorg would unwind a finalized block,
+	// either finalized by the consensus engine or marked via SetFinalized.
+	ErrFinalizedReorg = errors.New("reorg below finalized block")
+
+	// ErrReorgTooDeep is returned when a reorg would drop more canonical blocks
+	// than permitted.
+	ErrReorgTooDeep = errors.New("reorg too deep")
+
+	// ErrBlockUnavailable is returned when a canonical block is not available in
+	// the local database, e.g. because it was pruned.
+	ErrBlockUnavailable = errors.New("block unavailable")
+
+	// ErrBlobGasMismatch is returned when the blob gas fields of a block header
+	// don't match the blob transactions in its body.
+	ErrBlobGasMismatch = errors.New("blob gas mismatch")
+
+	// ErrNotPoSA is returned when a validator query is made on a chain whose
+	// consensus engine does not have validators.
+	ErrNotPoSA = errors.New("consensus engine is not PoSA")
+
+	// ErrTxNotCanonical is returned when a transaction is indexed, but not part
+	// of the canonical chain anymore.
+	ErrTxNotCanonical = errors.New("transaction not canonical")
+
+	// ErrNoReachableState is returned when a missing historical state can't be
+	// recomputed, because no ancestor state is available within the limit.
+	ErrNoReachableState = errors.New("no reachable state")
 )
 
 // List of evm-call-message pre-checking errors. All state transition messages will
@@ -114,3 +152,42 @@ var (
 	// blob gas fee of the block.
 	ErrBlobFeeCapTooLow = errors.New("max fee per blob gas less than block blob gas fee")
 )
+
+// NonceOrderError is returned by block validation if the transactions of a
+// sender are not included in strictly increasing nonce order.
+type NonceOrderError struct {
+	Sender common.Address // Sender of the offending transaction
+	TxHash common.Hash    // Hash of the offending transaction
+	Nonce  uint64         // Nonce of the offending transaction
+	Prev   uint64         // Nonce of the sender's previous transaction in the block
+}
+
+func (e *NonceOrderError) Error() string {
+	return fmt.Sprintf("transaction nonce out of order: sender %v, tx %v, nonce %d after %d", e.Sender, e.TxHash, e.Nonce, e.Prev)
+}
+
+// ReceiptsSizeError is returned by block validation if the encoded receipts of
+// a block exceed the configured size limit.
+type ReceiptsSizeError struct {
+	Number uint64 // Number of the offending block
+	Size   uint64 // Encoded size of the receipts, counted up to exceeding the limit
+	Limit  uint64 // Configured maximum encoded size
+}
+
+func (e *ReceiptsSizeError) Error() string {
+	return fmt.Sprintf("block #%d receipts too large: %d bytes, limit %d", e.Number, e.Size, e.Limit)
+}
+
+// ReceiptMismatchError is returned by the receipt audit if a stored receipt
+// differs from the one regenerated by re-executing the block.
+type ReceiptMismatchError struct {
+	Number uint64      // Number of the audited block
+	Index  int         // Index of the diverging receipt, -1 if the receipt counts differ
+	Field  string      // Name of the diverging field
+	Stored interface{} // Value in the stored receipt
+	Local  interface{} // Value in the regenerated receipt
+}
+
+func (e *ReceiptMismatchError) Error() string {
+	return fmt.Sprintf("block #%d receipt %d %s mismatch: stored %v, local %v", e.Number, e.Index, e.Field, e.Stored, e.Local)
+}
@@ -33,12 +33,38 @@ type NewMinedBlockEvent struct{ Block *types.Block }
 // RemovedLogsEvent is posted when a reorg happens
 type RemovedLogsEvent struct{ Logs []*types.Log }
 
+// CanonicalLogsEvent is posted when logs are added to or removed from the
+// canonical chain, the latter flagged by types.Log.Removed. Both kinds share a
+// single feed, so subscribers observe them in chain order.
+type CanonicalLogsEvent struct{ Logs []*types.Log }
+
 // NewVoteEvent is posted when a batch of votes enters the vote pool.
 type NewVoteEvent struct{ Vote *types.VoteEnvelope }
 
 // FinalizedHeaderEvent is posted when a finalized header is reached.
 type FinalizedHeaderEvent struct{ Header *types.Header }
 
+// ChainAcceptedEvent is posted when a canonical block is buried FinalityDepth
+// blocks deep and can be considered economically final.
+type ChainAcceptedEvent struct{ Block *types.Block }
+
+// FinalizedReorgEvent is posted when a reorg attempting to unwind a finalized
+// block is rejected.
+type FinalizedReorgEvent struct {
+	Finalized *types.Header // Finalized header of the current chain
+	Common    *types.Header // Common ancestor of the current and the new chain
+	OldHead   *types.Header // Head of the current chain
+	NewHead   *types.Header // Head of the rejected chain
+}
+
+// ReorgEvent is posted once per chain reorg that drops canonical blocks, with
+// the blocks leaving and entering the canonical chain ordered by number.
+type ReorgEvent struct {
+	OldChain       []common.Hash // Blocks removed from the canonical chain
+	NewChain       []common.Hash // Blocks added to the canonical chain, up to the new head
+	CommonAncestor common.Hash   // Last block shared by the old and the new chain
+}
+
 type ChainEvent struct {
 	Block *types.Block
 	Hash  common.Hash
@@ -23,6 +23,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
 )
 
 // ChainContext supports retrieving headers and consensus parameters from the
@@ -33,6 +34,20 @@ type ChainContext interface {
 
 	// GetHeader returns the header corresponding to the hash/number argument pair.
 	GetHeader(common.Hash, uint64) *types.Header
+
+	// RewardDistributor returns the custom transaction fee reward distributor,
+	// or nil to credit the block beneficiary.
+	RewardDistributor() RewardDistributor
+}
+
+// RewardDistributor decides where the fee reward of each transaction goes. It
+// allows chains derived from Core to customise the reward flow without editing
+// the consensus engine.
+type RewardDistributor interface {
+	// Distribute credits the fee reward of a transaction included in the block
+	// with the given header. It runs after the transaction has been executed
+	// and therefore cannot fail.
+	Distribute(state vm.StateDB, header *types.Header, reward *uint256.Int)
 }
 
 // NewEVMBlockContext creates a new context for use in the EVM.
@@ -55,7 +70,7 @@ func NewEVMBlockContext(header *types.Header, chain ChainContext, author *common
 	if header.Difficulty.Cmp(common.Big0) == 0 {
 		random = &header.MixDigest
 	}
-	return vm.BlockContext{
+	context := vm.BlockContext{
 		CanTransfer:   CanTransfer,
 		Transfer:      Transfer,
 		GetHash:       GetHashFn(header, chain),
@@ -68,6 +83,14 @@ func NewEVMBlockContext(header *types.Header, chain ChainContext, author *common
 		Random:        random,
 		ExcessBlobGas: header.ExcessBlobGas,
 	}
+	if chain != nil {
+		if distributor := chain.RewardDistributor(); distributor != nil {
+			context.DistributeReward = func(db vm.StateDB, reward *uint256.Int) {
+				distributor.Distribute(db, header, reward)
+			}
+		}
+	}
+	return context
 }
 
 // NewEVMTxContext creates a new transaction context for a single transaction.
@@ -42,7 +42,10 @@ import (
 //go:generate go run github.com/fjl/gencodec -type Genesis -field-override genesisSpecMarshaling -out gen_genesis.go
 //go:generate go run github.com/fjl/gencodec -type GenesisAccount -field-override genesisAccountMarshaling -out gen_genesis_account.go
 
-var errGenesisNoConfig = errors.New("genesis has no chain configuration")
+var (
+	errGenesisNoConfig     = errors.New("genesis has no chain configuration")
+	errGenesisHashMismatch = errors.New("genesis hash mismatch")
+)
 
 // Genesis specifies the header fields, state of a genesis block. It also defines hard
 // fork switch-over blocks through the chain configuration.
@@ -280,6 +283,10 @@ type ChainOverrides struct {
 	OverrideKepler   *uint64
 	OverrideCancun   *uint64
 	OverrideVerkle   *uint64
+
+	// ForkTimes re-times the forks set in it, none of them may be moved before
+	// the time of the chain head.
+	ForkTimes *params.ForkTimeOverrides
 }
 
 // SetupGenesisBlock writes or updates the genesis block in db.
@@ -303,7 +310,14 @@ func SetupGenesisBlockWithOverride(db ethdb.Database, triedb *trie.Database, gen
 	if genesis != nil && genesis.Config == nil {
 		return params.AllEthashProtocolChanges, common.Hash{}, errGenesisNoConfig
 	}
-	applyOverrides := func(config *params.ChainConfig) {
+	// Fail fast if the genesis of a built-in network was tampered with, the
+	// node would otherwise end up on a chain no one else is on.
+	if genesis != nil {
+		if err := genesis.VerifyHash(); err != nil {
+			return genesis.Config, common.Hash{}, err
+		}
+	}
+	applyOverrides := func(config *params.ChainConfig, headTime uint64) error {
 		if config != nil {
 			if overrides != nil && overrides.OverrideShanghai != nil {
 				config.ShanghaiTime = overrides.OverrideShanghai
@@ -317,7 +331,11 @@ func SetupGenesisBlockWithOverride(db ethdb.Database, triedb *trie.Database, gen
 			if overrides != nil && overrides.OverrideVerkle != nil {
 				config.VerkleTime = overrides.OverrideVerkle
 			}
+			if overrides != nil && overrides.ForkTimes != nil {
+				return config.ApplyForkOverrides(*overrides.ForkTimes, headTime)
+			}
 		}
+		return nil
 	}
 	// Just commit the new block if there is no stored genesis block.
 	stored := rawdb.ReadCanonicalHash(db, 0)
@@ -332,7 +350,9 @@ func SetupGenesisBlockWithOverride(db ethdb.Database, triedb *trie.Database, gen
 		if err != nil {
 			return genesis.Config, common.Hash{}, err
 		}
-		applyOverrides(genesis.Config)
+		if err := applyOverrides(genesis.Config, genesis.Timestamp); err != nil {
+			return genesis.Config, block.Hash(), err
+		}
 		log.Info("genesis block hash", "hash", block.Hash())
 		return genesis.Config, block.Hash(), nil
 	}
@@ -354,7 +374,9 @@ func SetupGenesisBlockWithOverride(db ethdb.Database, triedb *trie.Database, gen
 		if err != nil {
 			return genesis.Config, hash, err
 		}
-		applyOverrides(genesis.Config)
+		if err := applyOverrides(genesis.Config, genesis.Timestamp); err != nil {
+			return genesis.Config, hash, err
+		}
 		return genesis.Config, block.Hash(), nil
 	}
 	// Check whether the genesis block is already written.
@@ -364,9 +386,16 @@ func SetupGenesisBlockWithOverride(db ethdb.Database, triedb *trie.Database, gen
 			return genesis.Config, hash, &GenesisMismatchError{stored, hash}
 		}
 	}
-	// Get the existing chain configuration.
+	// Get the existing chain configuration, forks can't be re-timed before the
+	// current head.
+	var headTime uint64
+	if head := rawdb.ReadHeadHeader(db); head != nil {
+		headTime = head.Time
+	}
 	newcfg := genesis.configOrDefault(stored)
-	applyOverrides(newcfg)
+	if err := applyOverrides(newcfg, headTime); err != nil {
+		return newcfg, stored, err
+	}
 	if err := newcfg.CheckConfigForkOrder(); err != nil {
 		return newcfg, common.Hash{}, err
 	}
@@ -385,7 +414,9 @@ func SetupGenesisBlockWithOverride(db ethdb.Database, triedb *trie.Database, gen
 	if genesis == nil && stored != params.MainnetGenesisHash && stored != params.BuffaloGenesisHash &&
 		stored != params.PigeonGenesisHash && stored != params.CoreGenesisHash {
 		newcfg = storedcfg
-		applyOverrides(newcfg)
+		if err := applyOverrides(newcfg, headTime); err != nil {
+			return newcfg, stored, err
+		}
 	}
 	// Check config compatibility and write the config. Compatibility errors
 	// are returned to the caller unless we're already at block zero.
@@ -453,6 +484,32 @@ func (g *Genesis) configOrDefault(ghash common.Hash) *params.ChainConfig {
 	return params.AllEthashProtocolChanges
 }
 
+// VerifyAgainst checks whether the genesis specification produces the genesis
+// block with the given hash.
+func (g *Genesis) VerifyAgainst(expected common.Hash) error {
+	if hash := g.ToBlock().Hash(); hash != expected {
+		return fmt.Errorf("%w: have %x, want %x", errGenesisHashMismatch, hash, expected)
+	}
+	return nil
+}
+
+// VerifyHash checks the genesis specification of a built-in network against the
+// known genesis hash of its chain id. Genesis specifications of custom networks
+// are not checked.
+func (g *Genesis) VerifyHash() error {
+	if g.Config == nil {
+		return errGenesisNoConfig
+	}
+	expected, ok := params.GetBuiltInGenesisHash(g.Config.ChainID)
+	if !ok {
+		return nil
+	}
+	if err := g.VerifyAgainst(expected); err != nil {
+		return fmt.Errorf("invalid genesis for chain %v: %w", g.Config.ChainID, err)
+	}
+	return nil
+}
+
 // ToBlock returns the genesis block according to genesis specification.
 func (g *Genesis) ToBlock() *types.Block {
 	root, err := g.Alloc.hash()
@@ -489,7 +546,7 @@ func (g *Genesis) ToBlock() *types.Block {
 	var withdrawals []*types.Withdrawal
 	if conf := g.Config; conf != nil {
 		num := big.NewInt(int64(g.Number))
-		if conf.Satoshi == nil && conf.IsShanghai(num, g.Timestamp) {
+		if !conf.IsSatoshi() && conf.IsShanghai(num, g.Timestamp) {
 			head.WithdrawalsHash = &types.EmptyWithdrawalsHash
 			withdrawals = make([]*types.Withdrawal, 0)
 		}
@@ -530,14 +587,20 @@ func (g *Genesis) Commit(db ethdb.Database, triedb *trie.Database) (*types.Block
 	if err := g.Alloc.flush(db, triedb, block.Hash()); err != nil {
 		return nil, err
 	}
-	rawdb.WriteTd(db, block.Hash(), block.NumberU64(), block.Difficulty())
-	rawdb.WriteBlock(db, block)
-	rawdb.WriteReceipts(db, block.Hash(), block.NumberU64(), nil)
-	rawdb.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
-	rawdb.WriteHeadBlockHash(db, block.Hash())
-	rawdb.WriteHeadFastBlockHash(db, block.Hash())
-	rawdb.WriteHeadHeaderHash(db, block.Hash())
-	rawdb.WriteChainConfig(db, block.Hash(), config)
+	// Write the block related data atomically, a crash in between must not
+	// leave a genesis block without its receipts or markers behind.
+	batch := db.NewBatch()
+	rawdb.WriteTd(batch, block.Hash(), block.NumberU64(), block.Difficulty())
+	rawdb.WriteBlock(batch, block)
+	rawdb.WriteReceipts(batch, block.Hash(), block.NumberU64(), nil)
+	rawdb.WriteCanonicalHash(batch, block.Hash(), block.NumberU64())
+	rawdb.WriteHeadBlockHash(batch, block.Hash())
+	rawdb.WriteHeadFastBlockHash(batch, block.Hash())
+	rawdb.WriteHeadHeaderHash(batch, block.Hash())
+	rawdb.WriteChainConfig(batch, block.Hash(), config)
+	if err := batch.Write(); err != nil {
+		return nil, err
+	}
 	return block, nil
 }
 
@@ -18,6 +18,7 @@ package core
 
 import (
 	"encoding/json"
+	"errors"
 	"math/big"
 	"reflect"
 	"testing"
@@ -175,6 +176,51 @@ func TestGenesisHashes(t *testing.T) {
 	}
 }
 
+// Tests that the genesis specifications of the built-in networks are verified
+// against their known hashes, while custom networks are left unchecked.
+func TestGenesisVerifyHash(t *testing.T) {
+	for i, c := range []struct {
+		genesis *Genesis
+		want    common.Hash
+	}{
+		{DefaultCOREGenesisBlock(), params.CoreGenesisHash},
+		{DefaultBuffaloGenesisBlock(), params.BuffaloGenesisHash},
+		{DefaultPigeonGenesisBlock(), params.PigeonGenesisHash},
+	} {
+		if err := c.genesis.VerifyAgainst(c.want); err != nil {
+			t.Errorf("case %d: failed to verify against known hash: %v", i, err)
+		}
+		if err := c.genesis.VerifyHash(); err != nil {
+			t.Errorf("case %d: failed to verify hash: %v", i, err)
+		}
+	}
+	// Tamper with the CORE mainnet allocation and ensure it's rejected
+	tampered := DefaultCOREGenesisBlock()
+	tampered.Alloc[common.Address{1}] = GenesisAccount{Balance: big.NewInt(1)}
+
+	if err := tampered.VerifyAgainst(params.CoreGenesisHash); !errors.Is(err, errGenesisHashMismatch) {
+		t.Errorf("tampered genesis verification error mismatch: have %v, want %v", err, errGenesisHashMismatch)
+	}
+	if err := tampered.VerifyHash(); !errors.Is(err, errGenesisHashMismatch) {
+		t.Errorf("tampered genesis hash error mismatch: have %v, want %v", err, errGenesisHashMismatch)
+	}
+	db := rawdb.NewMemoryDatabase()
+	if _, _, err := SetupGenesisBlock(db, trie.NewDatabase(db, trie.HashDefaults), tampered); !errors.Is(err, errGenesisHashMismatch) {
+		t.Errorf("tampered genesis setup error mismatch: have %v, want %v", err, errGenesisHashMismatch)
+	}
+	if stored := rawdb.ReadCanonicalHash(db, 0); stored != (common.Hash{}) {
+		t.Errorf("tampered genesis written to database: %x", stored)
+	}
+	// Custom networks have no known hash to be verified against
+	custom := &Genesis{
+		Config: &params.ChainConfig{ChainID: big.NewInt(1337)},
+		Alloc:  GenesisAlloc{{1}: {Balance: big.NewInt(1)}},
+	}
+	if err := custom.VerifyHash(); err != nil {
+		t.Errorf("custom genesis verification failed: %v", err)
+	}
+}
+
 func TestGenesis_Commit(t *testing.T) {
 	genesis := &Genesis{
 		BaseFee: big.NewInt(params.InitialBaseFee),
@@ -202,6 +248,111 @@ func TestGenesis_Commit(t *testing.T) {
 	}
 }
 
+// crashingDB is a database simulating a crash once a given number of writes,
+// direct or buffered in batches, has been reached.
+type crashingDB struct {
+	ethdb.Database
+	writes int
+	limit  int
+}
+
+func (db *crashingDB) write() {
+	if db.writes == db.limit {
+		panic("simulated crash")
+	}
+	db.writes++
+}
+
+func (db *crashingDB) Put(key []byte, value []byte) error {
+	db.write()
+	return db.Database.Put(key, value)
+}
+
+func (db *crashingDB) NewBatch() ethdb.Batch {
+	return &crashingBatch{Batch: db.Database.NewBatch(), db: db}
+}
+
+func (db *crashingDB) NewBatchWithSize(size int) ethdb.Batch {
+	return &crashingBatch{Batch: db.Database.NewBatchWithSize(size), db: db}
+}
+
+// crashingBatch crashes its database while buffering or, once the write limit
+// is reached, right before flushing the buffered data.
+type crashingBatch struct {
+	ethdb.Batch
+	db *crashingDB
+}
+
+func (b *crashingBatch) Put(key []byte, value []byte) error {
+	b.db.write()
+	return b.Batch.Put(key, value)
+}
+
+func (b *crashingBatch) Write() error {
+	if b.db.writes == b.db.limit {
+		panic("simulated crash")
+	}
+	return b.Batch.Write()
+}
+
+// Tests that committing a genesis block either writes all of its block data or
+// none of it, regardless of where the commit is interrupted.
+func TestGenesisCommitAtomic(t *testing.T) {
+	genesis := &Genesis{
+		Config: params.TestChainConfig,
+		Alloc:  GenesisAlloc{common.HexToAddress("0x01"): {Balance: big.NewInt(1)}},
+	}
+	hash := genesis.ToBlock().Hash()
+
+	for limit := 0; ; limit++ {
+		var (
+			db      = rawdb.NewMemoryDatabase()
+			crashdb = &crashingDB{Database: db, limit: limit}
+		)
+		crashed := func() (crashed bool) {
+			defer func() {
+				if recover() != nil {
+					crashed = true
+				}
+			}()
+			if _, err := genesis.Commit(crashdb, trie.NewDatabase(crashdb, trie.HashDefaults)); err != nil {
+				t.Fatalf("limit %d: failed to commit genesis: %v", limit, err)
+			}
+			return false
+		}()
+		present := []bool{
+			rawdb.HasHeader(db, hash, 0),
+			rawdb.HasBody(db, hash, 0),
+			rawdb.HasReceipts(db, hash, 0),
+			rawdb.ReadTd(db, hash, 0) != nil,
+			rawdb.ReadCanonicalHash(db, 0) == hash,
+			rawdb.ReadHeadBlockHash(db) == hash,
+			rawdb.ReadHeadFastBlockHash(db) == hash,
+			rawdb.ReadHeadHeaderHash(db) == hash,
+			rawdb.ReadChainConfig(db, hash) != nil,
+		}
+		for i, have := range present {
+			if have != present[0] {
+				t.Fatalf("limit %d: partial genesis block data: item %d present %v, item 0 present %v", limit, i, have, present[0])
+			}
+		}
+		if present[0] == crashed {
+			t.Fatalf("limit %d: genesis block data presence mismatch: have %v, crashed %v", limit, present[0], crashed)
+		}
+		if !crashed {
+			break
+		}
+		// The interrupted commit must be recoverable by committing again
+		block, err := genesis.Commit(db, trie.NewDatabase(db, trie.HashDefaults))
+		if err != nil {
+			t.Fatalf("limit %d: failed to recommit genesis: %v", limit, err)
+		}
+		if block.Hash() != hash || rawdb.ReadHeadBlockHash(db) != hash {
+			t.Fatalf("limit %d: recommitted genesis mismatch: have %x, want %x", limit, block.Hash(), hash)
+		}
+	}
+}
+
 func TestReadWriteGenesisAlloc(t *testing.T) {
 	var (
 		db    = rawdb.NewMemoryDatabase()
@@ -253,3 +404,43 @@ func newDbConfig(scheme string) *trie.Config {
 	}
 	return &trie.Config{PathDB: pathdb.Defaults}
 }
+
+// Tests that fork time overrides are applied on top of an existing chain, but
+// can't move a fork before the time of the chain head.
+func TestSetupGenesisForkTimeOverrides(t *testing.T) {
+	var (
+		config = *params.TestChainConfig
+		gspec  = &Genesis{Config: &config, BaseFee: big.NewInt(params.InitialBaseFee)}
+		db     = rawdb.NewMemoryDatabase()
+	)
+	config.CancunTime = nil
+
+	_, blocks, _ := GenerateChainWithGenesis(gspec, ethash.NewFaker(), 4, nil)
+	chain, err := NewBlockChain(db, nil, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	chain.Stop()
+
+	headTime := blocks[len(blocks)-1].Time()
+	triedb := trie.NewDatabase(db, nil)
+
+	past := &ChainOverrides{ForkTimes: &params.ForkTimeOverrides{Cancun: uint64ptr(headTime - 1)}}
+	if _, _, err := SetupGenesisBlockWithOverride(db, triedb, gspec, past); err == nil {
+		t.Fatalf("fork override before the head accepted")
+	}
+	if config.CancunTime != nil {
+		t.Fatalf("rejected fork override applied: %d", *config.CancunTime)
+	}
+	future := &ChainOverrides{ForkTimes: &params.ForkTimeOverrides{Cancun: uint64ptr(headTime + 100)}}
+	cfg, _, err := SetupGenesisBlockWithOverride(db, triedb, gspec, future)
+	if err != nil {
+		t.Fatalf("failed to apply fork override: %v", err)
+	}
+	if cfg.CancunTime == nil || *cfg.CancunTime != headTime+100 {
+		t.Fatalf("cancun time mismatch: have %v, want %d", cfg.CancunTime, headTime+100)
+	}
+}
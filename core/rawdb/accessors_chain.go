@@ -217,6 +217,22 @@ func WriteHeadFastBlockHash(db ethdb.KeyValueWriter, hash common.Hash) {
 	}
 }
 
+// ReadFinalizedBlockHash retrieves the hash of the finalized block.
+func ReadFinalizedBlockHash(db ethdb.KeyValueReader) common.Hash {
+	data, _ := db.Get(headFinalizedBlockKey)
+	if len(data) == 0 {
+		return common.Hash{}
+	}
+	return common.BytesToHash(data)
+}
+
+// WriteFinalizedBlockHash stores the hash of the finalized block.
+func WriteFinalizedBlockHash(db ethdb.KeyValueWriter, hash common.Hash) {
+	if err := db.Put(headFinalizedBlockKey, hash.Bytes()); err != nil {
+		log.Crit("Failed to store last finalized block's hash", "err", err)
+	}
+}
+
 // ReadLastPivotNumber retrieves the number of the last pivot block. If the node
 // full synced, the last pivot will always be nil.
 func ReadLastPivotNumber(db ethdb.KeyValueReader) *uint64 {
@@ -489,6 +505,52 @@ func ReadBody(db ethdb.Reader, hash common.Hash, number uint64) *types.Body {
 	return body
 }
 
+// ReadTransactions retrieves up to limit transactions of a block body, starting
+// at the given index. Only the requested transactions are decoded, the rest of
+// the body is skipped. Nil is returned if the body is missing or corrupted, an
+// empty list if the offset is past the last transaction.
+func ReadTransactions(db ethdb.Reader, hash common.Hash, number uint64, offset, limit int) types.Transactions {
+	data := ReadBodyRLP(db, hash, number)
+	if len(data) == 0 {
+		return nil
+	}
+	txs, err := decodeBodyTransactions(data, offset, limit)
+	if err != nil {
+		log.Error("Invalid block body RLP", "hash", hash, "err", err)
+		return nil
+	}
+	return txs
+}
+
+// decodeBodyTransactions decodes up to limit transactions starting at offset
+// from the transaction list of an RLP encoded block body.
+func decodeBodyTransactions(body []byte, offset, limit int) (types.Transactions, error) {
+	fields, _, err := rlp.SplitList(body)
+	if err != nil {
+		return nil, err
+	}
+	list, _, err := rlp.SplitList(fields)
+	if err != nil {
+		return nil, err
+	}
+	txs := make(types.Transactions, 0)
+	for i := 0; len(list) > 0 && len(txs) < limit; i++ {
+		_, _, rest, err := rlp.Split(list)
+		if err != nil {
+			return nil, err
+		}
+		if i >= offset {
+			tx := new(types.Transaction)
+			if err := rlp.DecodeBytes(list[:len(list)-len(rest)], tx); err != nil {
+				return nil, fmt.Errorf("transaction %d: %w", i, err)
+			}
+			txs = append(txs, tx)
+		}
+		list = rest
+	}
+	return txs, nil
+}
+
 // WriteBody stores a block body into the database.
 func WriteBody(db ethdb.KeyValueWriter, hash common.Hash, number uint64, body *types.Body) {
 	data, err := rlp.EncodeToBytes(body)
@@ -591,6 +653,31 @@ func DeleteTd(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
 	}
 }
 
+// ReadSystemGasUsed retrieves the gas consumed by the system transactions of a
+// block, reporting whether it was recorded at all.
+func ReadSystemGasUsed(db ethdb.KeyValueReader, hash common.Hash, number uint64) (uint64, bool) {
+	data, _ := db.Get(headerSysGasKey(number, hash))
+	if len(data) != 8 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(data), true
+}
+
+// WriteSystemGasUsed stores the gas consumed by the system transactions of a
+// block into the database.
+func WriteSystemGasUsed(db ethdb.KeyValueWriter, hash common.Hash, number uint64, gas uint64) {
+	if err := db.Put(headerSysGasKey(number, hash), encodeBlockNumber(gas)); err != nil {
+		log.Crit("Failed to store block system gas used", "err", err)
+	}
+}
+
+// DeleteSystemGasUsed removes the system gas used record of a block.
+func DeleteSystemGasUsed(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
+	if err := db.Delete(headerSysGasKey(number, hash)); err != nil {
+		log.Crit("Failed to delete block system gas used", "err", err)
+	}
+}
+
 // HasReceipts verifies the existence of all the transaction receipts belonging
 // to a block.
 func HasReceipts(db ethdb.Reader, hash common.Hash, number uint64) bool {
@@ -679,6 +766,108 @@ func ReadReceipts(db ethdb.Reader, hash common.Hash, number uint64, time uint64,
 	return receipts
 }
 
+// MaxReceiptsRange is the maximum number of blocks whose receipts can be read
+// at once by ReadReceiptsRange.
+const MaxReceiptsRange = 1024
+
+// ReadReceiptsRange retrieves the receipts of the canonical blocks in the range
+// [from, to], including their metadata fields derived the same way as by
+// ReadReceipts. The blocks already moved into the ancient store are read in bulk,
+// the rest from the key-value store. An error is returned if any block of the
+// range is missing, or if the range spans more than MaxReceiptsRange blocks.
+func ReadReceiptsRange(db ethdb.Reader, config *params.ChainConfig, from, to uint64) ([]types.Receipts, error) {
+	if from > to {
+		return nil, fmt.Errorf("invalid receipt range [%d, %d]", from, to)
+	}
+	if to-from >= MaxReceiptsRange {
+		return nil, fmt.Errorf("receipt range [%d, %d] exceeds %d blocks", from, to, MaxReceiptsRange)
+	}
+	var (
+		count    = to - from + 1
+		hashes   = make([][]byte, count)
+		headers  = make([][]byte, count)
+		bodies   = make([][]byte, count)
+		receipts = make([][]byte, count)
+	)
+	err := db.ReadAncients(func(reader ethdb.AncientReaderOp) error {
+		// Read the frozen part of the range in bulk, if there's an ancient store
+		frozen, _ := reader.Ancients()
+		next := from
+		if from < frozen {
+			n := min(frozen, to+1) - from
+			for _, table := range []struct {
+				kind  string
+				items [][]byte
+			}{
+				{ChainFreezerHashTable, hashes},
+				{ChainFreezerHeaderTable, headers},
+				{ChainFreezerBodiesTable, bodies},
+				{ChainFreezerReceiptTable, receipts},
+			} {
+				items, err := reader.AncientRange(table.kind, from, n, 0)
+				if err != nil {
+					return fmt.Errorf("failed to read ancient %s [%d, %d]: %w", table.kind, from, from+n-1, err)
+				}
+				if uint64(len(items)) != n {
+					return fmt.Errorf("short ancient %s read: have %d, want %d", table.kind, len(items), n)
+				}
+				copy(table.items, items)
+			}
+			next += n
+		}
+		// Read the rest of the range from the key-value store
+		for number := next; number <= to; number++ {
+			i := number - from
+			hashes[i], _ = db.Get(headerHashKey(number))
+			if len(hashes[i]) == 0 {
+				continue
+			}
+			hash := common.BytesToHash(hashes[i])
+			headers[i], _ = db.Get(headerKey(number, hash))
+			bodies[i], _ = db.Get(blockBodyKey(number, hash))
+			receipts[i], _ = db.Get(blockReceiptsKey(number, hash))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]types.Receipts, count)
+	for i := range result {
+		number := from + uint64(i)
+		if len(hashes[i]) == 0 || len(headers[i]) == 0 || len(bodies[i]) == 0 || len(receipts[i]) == 0 {
+			return nil, fmt.Errorf("missing block %d", number)
+		}
+		hash := common.BytesToHash(hashes[i])
+
+		header := new(types.Header)
+		if err := rlp.DecodeBytes(headers[i], header); err != nil {
+			return nil, fmt.Errorf("invalid header of block %d: %w", number, err)
+		}
+		body := new(types.Body)
+		if err := rlp.DecodeBytes(bodies[i], body); err != nil {
+			return nil, fmt.Errorf("invalid body of block %d: %w", number, err)
+		}
+		var storageReceipts []*types.ReceiptForStorage
+		if err := rlp.DecodeBytes(receipts[i], &storageReceipts); err != nil {
+			return nil, fmt.Errorf("invalid receipts of block %d: %w", number, err)
+		}
+		blockReceipts := make(types.Receipts, len(storageReceipts))
+		for j, receipt := range storageReceipts {
+			blockReceipts[j] = (*types.Receipt)(receipt)
+		}
+		var blobGasPrice *big.Int
+		if header.ExcessBlobGas != nil {
+			blobGasPrice = eip4844.CalcBlobFee(*header.ExcessBlobGas)
+		}
+		if err := blockReceipts.DeriveFields(config, hash, number, header.Time, header.BaseFee, blobGasPrice, body.Transactions); err != nil {
+			return nil, fmt.Errorf("failed to derive receipt fields of block %d: %w", number, err)
+		}
+		result[i] = blockReceipts
+	}
+	return result, nil
+}
+
 // WriteReceipts stores all the transaction receipts belonging to a block.
 func WriteReceipts(db ethdb.KeyValueWriter, hash common.Hash, number uint64, receipts types.Receipts) {
 	// Convert the receipts into their storage form and serialize them
@@ -824,19 +1013,19 @@ func WriteAncientBlocks(db ethdb.AncientWriter, blocks []*types.Block, receipts
 func writeAncientBlock(op ethdb.AncientWriteOp, block *types.Block, header *types.Header, receipts []*types.ReceiptForStorage, td *big.Int) error {
 	num := block.NumberU64()
 	if err := op.AppendRaw(ChainFreezerHashTable, num, block.Hash().Bytes()); err != nil {
-		return fmt.Errorf("can't add block %d hash: %v", num, err)
+		return fmt.Errorf("can't add block %d hash: %w", num, err)
 	}
 	if err := op.Append(ChainFreezerHeaderTable, num, header); err != nil {
-		return fmt.Errorf("can't append block header %d: %v", num, err)
+		return fmt.Errorf("can't append block header %d: %w", num, err)
 	}
 	if err := op.Append(ChainFreezerBodiesTable, num, block.Body()); err != nil {
-		return fmt.Errorf("can't append block body %d: %v", num, err)
+		return fmt.Errorf("can't append block body %d: %w", num, err)
 	}
 	if err := op.Append(ChainFreezerReceiptTable, num, receipts); err != nil {
-		return fmt.Errorf("can't append block %d receipts: %v", num, err)
+		return fmt.Errorf("can't append block %d receipts: %w", num, err)
 	}
 	if err := op.Append(ChainFreezerDifficultyTable, num, td); err != nil {
-		return fmt.Errorf("can't append block %d total difficulty: %v", num, err)
+		return fmt.Errorf("can't append block %d total difficulty: %w", num, err)
 	}
 	return nil
 }
@@ -847,10 +1036,12 @@ func DeleteBlock(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
 	DeleteHeader(db, hash, number)
 	DeleteBody(db, hash, number)
 	DeleteTd(db, hash, number)
+	DeleteSystemGasUsed(db, hash, number)
 }
 
 // DeleteBlockWithoutNumber removes all block data associated with a hash, except
-// the hash to number mapping.
+// the hash to number mapping and the system gas used, which are not migrated
+// into the freezer.
 func DeleteBlockWithoutNumber(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
 	DeleteReceipts(db, hash, number)
 	deleteHeaderWithoutNumber(db, hash, number)
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"math"
 	"math/big"
 	"math/rand"
 	"os"
@@ -105,6 +106,40 @@ func TestBodyStorage(t *testing.T) {
 	}
 }
 
+// Tests that a range of transactions can be read from a stored block body.
+func TestReadTransactions(t *testing.T) {
+	db := NewMemoryDatabase()
+	block := makeTestTxBlock(10)
+	WriteBlock(db, block)
+
+	if txs := ReadTransactions(db, common.Hash{0x01}, 0, 0, 1); txs != nil {
+		t.Fatalf("non existent body returned transactions: %v", txs)
+	}
+	tests := []struct {
+		offset, limit int
+		want          []uint64 // nonces of the expected transactions
+	}{
+		{0, 3, []uint64{0, 1, 2}},
+		{8, 5, []uint64{8, 9}},
+		{0, 100, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{10, 1, nil},
+	}
+	for _, tt := range tests {
+		txs := ReadTransactions(db, block.Hash(), block.NumberU64(), tt.offset, tt.limit)
+		if txs == nil {
+			t.Fatalf("offset %d, limit %d: stored body not found", tt.offset, tt.limit)
+		}
+		if len(txs) != len(tt.want) {
+			t.Fatalf("offset %d, limit %d: transaction count mismatch: have %d, want %d", tt.offset, tt.limit, len(txs), len(tt.want))
+		}
+		for i, tx := range txs {
+			if want := block.Transactions()[tt.want[i]]; tx.Hash() != want.Hash() {
+				t.Errorf("offset %d, limit %d: transaction %d mismatch: have %x, want %x", tt.offset, tt.limit, i, tx.Hash(), want.Hash())
+			}
+		}
+	}
+}
+
 // Tests block storage and retrieval operations.
 func TestBlockStorage(t *testing.T) {
 	db := NewMemoryDatabase()
@@ -281,6 +316,27 @@ func TestTdStorage(t *testing.T) {
 	}
 }
 
+// Tests system gas used storage and retrieval operations.
+func TestSystemGasUsedStorage(t *testing.T) {
+	db := NewMemoryDatabase()
+
+	hash, number := common.Hash{0x01}, uint64(314)
+	if _, ok := ReadSystemGasUsed(db, hash, number); ok {
+		t.Fatal("Non existent system gas used returned")
+	}
+	WriteSystemGasUsed(db, hash, number, 21000)
+	if gas, ok := ReadSystemGasUsed(db, hash, number); !ok {
+		t.Fatal("Stored system gas used not found")
+	} else if gas != 21000 {
+		t.Fatalf("Retrieved system gas used mismatch: have %d, want %d", gas, 21000)
+	}
+	// Deleting the block must drop the system gas used too
+	DeleteBlock(db, hash, number)
+	if _, ok := ReadSystemGasUsed(db, hash, number); ok {
+		t.Fatal("Deleted system gas used returned")
+	}
+}
+
 // Tests that canonical numbers can be mapped to hashes and retrieved.
 func TestCanonicalMappingStorage(t *testing.T) {
 	db := NewMemoryDatabase()
@@ -612,6 +668,131 @@ func BenchmarkWriteAncientBlocks(b *testing.B) {
 	b.SetBytes(totalSize / int64(b.N))
 }
 
+// BenchmarkReadTransactions compares reading a page of transactions with
+// decoding the full block.
+func BenchmarkReadTransactions(b *testing.B) {
+	db := NewMemoryDatabase()
+	block := makeTestTxBlock(500)
+	WriteBlock(db, block)
+
+	b.Run("ReadTransactions", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if txs := ReadTransactions(db, block.Hash(), block.NumberU64(), 0, 50); len(txs) != 50 {
+				b.Fatalf("transaction count mismatch: have %d, want 50", len(txs))
+			}
+		}
+	})
+	b.Run("ReadBlock", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if block := ReadBlock(db, block.Hash(), block.NumberU64()); len(block.Transactions()[:50]) != 50 {
+				b.Fatal("transaction count mismatch")
+			}
+		}
+	})
+}
+
+// makeTestTxBlock creates a block with the given number of distinct transactions.
+func makeTestTxBlock(ntxs int) *types.Block {
+	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	signer := types.LatestSignerForChainID(big.NewInt(8))
+
+	txs := make([]*types.Transaction, ntxs)
+	for i := range txs {
+		txs[i], _ = types.SignNewTx(key, signer, &types.DynamicFeeTx{
+			ChainID:   big.NewInt(8),
+			Nonce:     uint64(i),
+			GasTipCap: big.NewInt(1),
+			GasFeeCap: big.NewInt(30000),
+			Gas:       params.TxGas,
+			To:        &common.Address{0x01},
+		})
+	}
+	header := &types.Header{Number: big.NewInt(1), Extra: []byte("test block")}
+	return types.NewBlockWithHeader(header).WithBody(txs, []*types.Header{{Extra: []byte("test uncle")}})
+}
+
+// Tests that reading the receipts of a block range in bulk, spanning both the
+// ancient and the key-value store, matches reading them block by block.
+func TestReadReceiptsRange(t *testing.T) {
+	db, err := NewDatabaseWithFreezer(NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
+	if err != nil {
+		t.Fatalf("failed to create database with ancient backend: %v", err)
+	}
+	defer db.Close()
+
+	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	signer := types.LatestSigner(params.TestChainConfig)
+
+	var (
+		blocks   []*types.Block
+		receipts []types.Receipts
+		nonce    uint64
+	)
+	for i := 0; i < 200; i++ {
+		var (
+			txs           types.Transactions
+			blockReceipts types.Receipts
+		)
+		for j := 0; j < i%4; j++ {
+			tx, _ := types.SignNewTx(key, signer, &types.LegacyTx{Nonce: nonce, GasPrice: big.NewInt(1), Gas: params.TxGas, To: &common.Address{0x01}})
+			txs = append(txs, tx)
+			blockReceipts = append(blockReceipts, &types.Receipt{
+				Status:            types.ReceiptStatusSuccessful,
+				CumulativeGasUsed: uint64(j+1) * params.TxGas,
+				Logs:              []*types.Log{{Address: common.Address{0x01}, Data: []byte{byte(i), byte(j)}}},
+			})
+			nonce++
+		}
+		header := &types.Header{Number: big.NewInt(int64(i)), Time: uint64(i) * 3, BaseFee: big.NewInt(1)}
+		if len(blocks) > 0 {
+			header.ParentHash = blocks[len(blocks)-1].Hash()
+		}
+		blocks = append(blocks, types.NewBlockWithHeader(header).WithBody(txs, nil))
+		receipts = append(receipts, blockReceipts)
+	}
+	// Freeze the first half of the chain, keep the rest in the key-value store
+	if _, err := WriteAncientBlocks(db, blocks[:100], receipts[:100], big.NewInt(100)); err != nil {
+		t.Fatalf("failed to write ancient blocks: %v", err)
+	}
+	for i, block := range blocks[100:] {
+		WriteBlock(db, block)
+		WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		WriteReceipts(db, block.Hash(), block.NumberU64(), receipts[100+i])
+	}
+	for _, r := range [][2]uint64{{0, 199}, {0, 99}, {100, 199}, {90, 110}, {150, 150}} {
+		from, to := r[0], r[1]
+		have, err := ReadReceiptsRange(db, params.TestChainConfig, from, to)
+		if err != nil {
+			t.Fatalf("range [%d, %d]: failed to read receipts: %v", from, to, err)
+		}
+		if len(have) != int(to-from+1) {
+			t.Fatalf("range [%d, %d]: receipt count mismatch: have %d, want %d", from, to, len(have), to-from+1)
+		}
+		for i, block := range blocks[from : to+1] {
+			want := ReadReceipts(db, block.Hash(), block.NumberU64(), block.Time(), params.TestChainConfig)
+			if !reflect.DeepEqual(have[i], want) {
+				t.Fatalf("range [%d, %d]: block %d receipts mismatch", from, to, block.NumberU64())
+			}
+		}
+	}
+	// Ranges reaching past the stored chain or reversed are rejected
+	if _, err := ReadReceiptsRange(db, params.TestChainConfig, 190, 200); err == nil {
+		t.Fatal("range past the chain head accepted")
+	}
+	if _, err := ReadReceiptsRange(db, params.TestChainConfig, 10, 9); err == nil {
+		t.Fatal("reversed range accepted")
+	}
+	// Oversized ranges are rejected without allocating them
+	if _, err := ReadReceiptsRange(db, params.TestChainConfig, 0, MaxReceiptsRange); err == nil {
+		t.Fatal("oversized range accepted")
+	}
+	if _, err := ReadReceiptsRange(db, params.TestChainConfig, 0, math.MaxUint64); err == nil {
+		t.Fatal("unbounded range accepted")
+	}
+}
+
 // makeTestBlocks creates fake blocks for the ancient write benchmark.
 func makeTestBlocks(nblock int, txsPerBlock int) []*types.Block {
 	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
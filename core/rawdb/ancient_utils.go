@@ -21,6 +21,7 @@ import (
 	"path/filepath"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
@@ -169,3 +170,66 @@ func ResetStateFreezerTableOffset(ancient string, virtualTail uint64) error {
 	}
 	return nil
 }
+
+// chainFreezerTables is the list of chain freezer tables checked for
+// consistency, in a stable order.
+var chainFreezerTables = []string{
+	ChainFreezerHeaderTable,
+	ChainFreezerHashTable,
+	ChainFreezerBodiesTable,
+	ChainFreezerReceiptTable,
+	ChainFreezerDifficultyTable,
+}
+
+// VerifyFreezerConsistency walks the chain freezer over the block range [from, to]
+// and returns the numbers of the blocks for which any of the tables is missing the
+// item, or the stored canonical hash doesn't match the header. Items are read one
+// block at a time, so arbitrarily large ranges can be verified.
+//
+// Blocks frozen beyond the head header of the key-value store, which may be left
+// over after a crash, are reported as inconsistent as well. The part of the range
+// above the freezer head is not checked.
+func VerifyFreezerConsistency(db ethdb.Database, from, to uint64) ([]uint64, error) {
+	if from > to {
+		return nil, fmt.Errorf("invalid range: from %d > to %d", from, to)
+	}
+	frozen, err := db.Ancients()
+	if err != nil {
+		return nil, err
+	}
+	tail, err := db.Tail()
+	if err != nil {
+		return nil, err
+	}
+	var head uint64
+	if number := ReadHeaderNumber(db, ReadHeadHeaderHash(db)); number != nil {
+		head = *number
+	}
+	if from < tail {
+		from = tail
+	}
+	var missing []uint64
+	for number := from; number <= to && number < frozen; number++ {
+		if number > head || !hasConsistentAncient(db, number) {
+			missing = append(missing, number)
+		}
+	}
+	if len(missing) > 0 {
+		log.Warn("Inconsistent ancient blocks found", "count", len(missing), "first", missing[0], "last", missing[len(missing)-1], "head", head, "frozen", frozen)
+	}
+	return missing, nil
+}
+
+// hasConsistentAncient reports whether all chain freezer tables contain the
+// given block and the stored hash belongs to the stored header.
+func hasConsistentAncient(db ethdb.AncientReader, number uint64) bool {
+	items := make(map[string][]byte, len(chainFreezerTables))
+	for _, kind := range chainFreezerTables {
+		blob, err := db.Ancient(kind, number)
+		if err != nil || len(blob) == 0 {
+			return false
+		}
+		items[kind] = blob
+	}
+	return crypto.Keccak256Hash(items[ChainFreezerHeaderTable]) == common.BytesToHash(items[ChainFreezerHashTable])
+}
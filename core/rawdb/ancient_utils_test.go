@@ -0,0 +1,63 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyFreezerConsistency(t *testing.T) {
+	db, err := NewDatabaseWithFreezer(NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
+	if err != nil {
+		t.Fatalf("failed to create database with ancient backend: %v", err)
+	}
+	defer db.Close()
+
+	blocks := makeTestBlocks(50, 1)
+	if _, err := WriteAncientBlocks(db, blocks, makeTestReceipts(50, 1), big.NewInt(100)); err != nil {
+		t.Fatalf("failed to write ancient blocks: %v", err)
+	}
+	// The freezer is ahead of the (empty) key-value store, the gap is reported
+	missing, err := VerifyFreezerConsistency(db, 0, 100)
+	if err != nil {
+		t.Fatalf("failed to verify freezer: %v", err)
+	}
+	if len(missing) != 49 || missing[0] != 1 || missing[48] != 49 {
+		t.Fatalf("post-crash gap mismatch: have %v", missing)
+	}
+	// Once the head is in place the freezer is consistent
+	WriteHeadHeaderHash(db, blocks[49].Hash())
+	WriteHeaderNumber(db, blocks[49].Hash(), 49)
+
+	if missing, err = VerifyFreezerConsistency(db, 0, 100); err != nil || len(missing) != 0 {
+		t.Fatalf("consistent freezer reported: %v, %v", missing, err)
+	}
+	// Truncate the receipts table only and check the missing range
+	table := db.(*freezerdb).AncientStore.(*chainFreezer).tables[ChainFreezerReceiptTable]
+	if err := table.truncateHead(40); err != nil {
+		t.Fatalf("failed to truncate receipts: %v", err)
+	}
+	if missing, err = VerifyFreezerConsistency(db, 30, 100); err != nil {
+		t.Fatalf("failed to verify freezer: %v", err)
+	}
+	want := []uint64{40, 41, 42, 43, 44, 45, 46, 47, 48, 49}
+	if !reflect.DeepEqual(missing, want) {
+		t.Fatalf("missing blocks mismatch: have %v, want %v", missing, want)
+	}
+}
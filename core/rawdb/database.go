@@ -24,6 +24,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/olekukonko/tablewriter"
@@ -101,6 +102,19 @@ func (frdb *freezerdb) Freeze(threshold uint64) error {
 	return nil
 }
 
+// SetFreezeThreshold sets the number of recent blocks kept in the key-value
+// store instead of being moved into the freezer. The freezer is append-only,
+// so lowering the threshold never unfreezes blocks, it only limits the future
+// freezing cycles.
+func (frdb *freezerdb) SetFreezeThreshold(threshold uint64) {
+	switch freezer := frdb.AncientStore.(type) {
+	case *chainFreezer:
+		freezer.threshold.Store(threshold)
+	case *prunedfreezer:
+		atomic.StoreUint64(&freezer.threshold, threshold)
+	}
+}
+
 // nofreezedb is a database wrapper that disables freezer data retrievals.
 type nofreezedb struct {
 	ethdb.KeyValueStore
@@ -721,7 +735,7 @@ func InspectDatabase(db ethdb.Database, keyPrefix, keyStart []byte) error {
 		default:
 			var accounted bool
 			for _, meta := range [][]byte{
-				databaseVersionKey, headHeaderKey, headBlockKey, headFastBlockKey,
+				databaseVersionKey, headHeaderKey, headBlockKey, headFastBlockKey, headFinalizedBlockKey,
 				lastPivotKey, fastTrieProgressKey, snapshotDisabledKey, SnapshotRootKey, snapshotJournalKey,
 				snapshotGeneratorKey, snapshotRecoveryKey, txIndexTailKey, fastTxLookupLimitKey,
 				uncleanShutdownKey, badBlockKey, transitionStatusKey, skeletonSyncStatusKey,
@@ -18,6 +18,7 @@ package rawdb
 
 import (
 	"fmt"
+	"math/big"
 	"os"
 	"testing"
 )
@@ -92,3 +93,59 @@ func Test_resolveChainFreezerDir(t *testing.T) {
 		})
 	}
 }
+
+// Tests that the freezer keeps the configured number of recent blocks in the
+// key-value store, and that lowering the threshold never unfreezes blocks.
+func TestFreezeThreshold(t *testing.T) {
+	db, err := NewDatabaseWithFreezer(NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
+	if err != nil {
+		t.Fatalf("failed to create database with ancient backend: %v", err)
+	}
+	defer db.Close()
+
+	blocks := makeTestBlocks(100, 1)
+	receipts := makeTestReceipts(100, 1)
+	for i, block := range blocks {
+		WriteBlock(db, block)
+		WriteReceipts(db, block.Hash(), block.NumberU64(), receipts[i])
+		WriteTd(db, block.Hash(), block.NumberU64(), big.NewInt(int64(i+1)))
+		WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+	}
+	WriteHeadBlockHash(db, blocks[99].Hash())
+
+	freeze := func(threshold uint64) {
+		db.(*freezerdb).SetFreezeThreshold(threshold)
+
+		trigger := make(chan struct{}, 1)
+		db.(*freezerdb).AncientStore.(*chainFreezer).trigger <- trigger
+		<-trigger
+	}
+	check := func(frozen uint64) {
+		t.Helper()
+
+		if have, _ := db.Ancients(); have != frozen {
+			t.Fatalf("frozen blocks mismatch: have %d, want %d", have, frozen)
+		}
+		nfdb := &nofreezedb{KeyValueStore: db}
+		for _, block := range blocks[1:] {
+			number := block.NumberU64()
+			if have, want := HasBody(nfdb, block.Hash(), number), number >= frozen; have != want {
+				t.Fatalf("block %d: key-value presence mismatch: have %v, want %v", number, have, want)
+			}
+			if ReadBlock(db, block.Hash(), number) == nil {
+				t.Fatalf("block %d: missing", number)
+			}
+		}
+	}
+	// A threshold beyond the chain length must keep everything hot
+	freeze(1000)
+	check(0)
+
+	// Lowering it freezes everything apart from the last threshold blocks
+	freeze(64)
+	check(100 - 64)
+
+	// Raising it again must not move any frozen block back
+	freeze(90)
+	check(100 - 64)
+}
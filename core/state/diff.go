@@ -0,0 +1,144 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// StateDiff is the set of account and storage changes between two states.
+// Accounts destroyed and recreated in between are listed both as destroyed
+// and as created. An account recreated empty is deleted again (EIP-161), it
+// is only listed as destroyed.
+type StateDiff struct {
+	Created   map[common.Address]*AccountDiff `json:"created"`
+	Destroyed map[common.Address]*AccountDiff `json:"destroyed"`
+	Modified  map[common.Address]*AccountDiff `json:"modified"`
+}
+
+// DiffAccount is the account data recorded in a state diff.
+type DiffAccount struct {
+	Nonce    hexutil.Uint64 `json:"nonce"`
+	Balance  *hexutil.Big   `json:"balance"`
+	CodeHash common.Hash    `json:"codeHash"`
+}
+
+// AccountDiff is the change of a single account. From is nil for created
+// accounts, To is nil for destroyed ones.
+type AccountDiff struct {
+	From    *DiffAccount                `json:"from,omitempty"`
+	To      *DiffAccount                `json:"to,omitempty"`
+	Storage map[common.Hash]StorageDiff `json:"storage,omitempty"`
+}
+
+// StorageDiff is the change of a single storage slot.
+type StorageDiff struct {
+	From common.Hash `json:"from"`
+	To   common.Hash `json:"to"`
+}
+
+// DiffFrom computes the changes leading from the other state to this one, e.g.
+// from the parent state of a block to the state after executing it. Only the
+// accounts and slots loaded in either state are compared, which after executing
+// a block covers everything it touched. The other state is only read.
+func (s *StateDB) DiffFrom(other *StateDB) StateDiff {
+	diff := StateDiff{
+		Created:   make(map[common.Address]*AccountDiff),
+		Destroyed: make(map[common.Address]*AccountDiff),
+		Modified:  make(map[common.Address]*AccountDiff),
+	}
+	addrs := make(map[common.Address]struct{})
+	for _, db := range []*StateDB{s, other} {
+		for addr := range db.stateObjects {
+			addrs[addr] = struct{}{}
+		}
+		for addr := range db.stateObjectsDestruct {
+			addrs[addr] = struct{}{}
+		}
+	}
+	for addr := range addrs {
+		var (
+			prev = other.getStateObject(addr)
+			post = s.getStateObject(addr)
+		)
+		_, destructed := s.stateObjectsDestruct[addr]
+
+		switch {
+		case prev == nil && post == nil:
+			continue
+		case post == nil:
+			diff.Destroyed[addr] = &AccountDiff{From: newDiffAccount(prev)}
+		case prev == nil:
+			diff.Created[addr] = &AccountDiff{To: newDiffAccount(post), Storage: diffStorage(nil, post)}
+		case destructed:
+			diff.Destroyed[addr] = &AccountDiff{From: newDiffAccount(prev)}
+			diff.Created[addr] = &AccountDiff{To: newDiffAccount(post), Storage: diffStorage(nil, post)}
+		default:
+			from, to := newDiffAccount(prev), newDiffAccount(post)
+			storage := diffStorage(prev, post)
+			if from.Nonce != to.Nonce || from.Balance.ToInt().Cmp(to.Balance.ToInt()) != 0 || from.CodeHash != to.CodeHash || len(storage) > 0 {
+				diff.Modified[addr] = &AccountDiff{From: from, To: to, Storage: storage}
+			}
+		}
+	}
+	return diff
+}
+
+// newDiffAccount copies the account data of a state object.
+func newDiffAccount(obj *stateObject) *DiffAccount {
+	return &DiffAccount{
+		Nonce:    hexutil.Uint64(obj.Nonce()),
+		Balance:  (*hexutil.Big)(new(big.Int).Set(obj.Balance())),
+		CodeHash: common.BytesToHash(obj.CodeHash()),
+	}
+}
+
+// diffStorage compares the slots cached in either of the state objects, a nil
+// object is treated as empty storage.
+func diffStorage(prev, post *stateObject) map[common.Hash]StorageDiff {
+	keys := make(map[common.Hash]struct{})
+	for _, obj := range []*stateObject{prev, post} {
+		if obj == nil {
+			continue
+		}
+		for _, storage := range []Storage{obj.originStorage, obj.pendingStorage, obj.dirtyStorage} {
+			for key := range storage {
+				keys[key] = struct{}{}
+			}
+		}
+	}
+	diff := make(map[common.Hash]StorageDiff)
+	for key := range keys {
+		var from, to common.Hash
+		if prev != nil {
+			from = prev.GetState(key)
+		}
+		if post != nil {
+			to = post.GetState(key)
+		}
+		if from != to {
+			diff[key] = StorageDiff{From: from, To: to}
+		}
+	}
+	if len(diff) == 0 {
+		return nil
+	}
+	return diff
+}
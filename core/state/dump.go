@@ -19,6 +19,7 @@ package state
 import (
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -259,3 +260,87 @@ func (s *StateDB) IteratorDump(opts *DumpConfig) IteratorDump {
 	iterator.Next = s.DumpToCollector(iterator, opts)
 	return *iterator
 }
+
+// AccountState is the complete state of a single account, with the storage
+// slots keyed by their original, unhashed keys. Slots whose key preimage is
+// unknown are keyed by the hashed trie key instead, and counted as missing.
+type AccountState struct {
+	Balance          *big.Int                    `json:"balance"`
+	Nonce            uint64                      `json:"nonce"`
+	Code             hexutil.Bytes               `json:"code,omitempty"`
+	Storage          map[common.Hash]common.Hash `json:"storage,omitempty"`
+	MissingPreimages int                         `json:"missingPreimages,omitempty"`
+}
+
+// DumpAccountState retrieves the state of the given account. The storage slots
+// are streamed into onSlot if it's given, keeping the memory use bounded for
+// contracts with huge storage, otherwise they are collected into the returned
+// account state. Recovering the slot keys requires the trie key preimages, the
+// slots without one are reported by their hashed key. Storage changes not yet
+// committed are not included.
+func (s *StateDB) DumpAccountState(addr common.Address, onSlot func(key, value common.Hash) error) (*AccountState, error) {
+	obj := s.getStateObject(addr)
+	if obj == nil {
+		return nil, fmt.Errorf("account %x not found", addr)
+	}
+	account := &AccountState{
+		Balance: new(big.Int).Set(obj.Balance()),
+		Nonce:   obj.Nonce(),
+		Code:    common.CopyBytes(obj.Code()),
+	}
+	if onSlot == nil {
+		account.Storage = make(map[common.Hash]common.Hash)
+		onSlot = func(key, value common.Hash) error {
+			account.Storage[key] = value
+			return nil
+		}
+	}
+	tr, err := obj.getTrie()
+	if err != nil {
+		return nil, err
+	}
+	trieIt, err := tr.NodeIterator(nil)
+	if err != nil {
+		return nil, err
+	}
+	it := trie.NewIterator(trieIt)
+	for it.Next() {
+		key := s.trie.GetKey(it.Key)
+		if key == nil {
+			key = it.Key
+			account.MissingPreimages++
+		}
+		_, content, _, err := rlp.Split(it.Value)
+		if err != nil {
+			return nil, err
+		}
+		if err := onSlot(common.BytesToHash(key), common.BytesToHash(content)); err != nil {
+			return nil, err
+		}
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	if account.MissingPreimages > 0 {
+		log.Warn("Account dump keyed by hashes due to missing preimages", "address", addr, "missing", account.MissingPreimages)
+	}
+	return account, nil
+}
+
+// ImportAccountState sets the balance, nonce and code of the given account and
+// writes the storage slots of the account state on top of its existing storage.
+// Account states with slots keyed by their hash can't be imported.
+func (s *StateDB) ImportAccountState(addr common.Address, account *AccountState) error {
+	if account.MissingPreimages > 0 {
+		return fmt.Errorf("account state has %d slots without key preimage", account.MissingPreimages)
+	}
+	if account.Balance != nil {
+		s.SetBalance(addr, account.Balance)
+	}
+	s.SetNonce(addr, account.Nonce)
+	s.SetCode(addr, account.Code)
+	for key, value := range account.Storage {
+		s.SetState(addr, key, value)
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const diffExportVersion uint64 = 0
+
+// Kinds of the entries in an exported state diff.
+const (
+	diffEntryAccount uint8 = iota // Account entry, empty data marks a deleted account
+	diffEntryStorage              // Storage slot entry, empty data marks a deleted slot
+	diffEntryEnd                  // Terminator, detects truncated exports
+)
+
+// diffHeader is the leading entry of an exported state diff.
+type diffHeader struct {
+	Version uint64
+	From    common.Hash
+	To      common.Hash
+}
+
+// diffEntry is a single account or storage slot change in an exported state diff.
+// Storage entries belong to the account of the preceding account entry.
+type diffEntry struct {
+	Kind uint8
+	Hash common.Hash
+	Data []byte
+}
+
+// ExportDiff writes the accounts and storage slots which differ between the
+// states of fromRoot and toRoot into w, in a format suitable for ImportDiff.
+// Both states need to be available in the snapshot tree. The storage of an
+// account is only compared if its account data changed, which always holds as
+// the account contains the root of its storage.
+func ExportDiff(snaptree *Tree, fromRoot, toRoot common.Hash, w io.Writer) error {
+	fromIt, err := snaptree.AccountIterator(fromRoot, common.Hash{})
+	if err != nil {
+		return err // The required snapshot might not exist.
+	}
+	defer fromIt.Release()
+
+	toIt, err := snaptree.AccountIterator(toRoot, common.Hash{})
+	if err != nil {
+		return err // The required snapshot might not exist.
+	}
+	defer toIt.Release()
+
+	if err := rlp.Encode(w, diffHeader{Version: diffExportVersion, From: fromRoot, To: toRoot}); err != nil {
+		return err
+	}
+	var (
+		accounts, slots int
+		fromOk          = fromIt.Next()
+		toOk            = toIt.Next()
+	)
+	for fromOk || toOk {
+		var cmp int
+		switch {
+		case !fromOk:
+			cmp = 1
+		case !toOk:
+			cmp = -1
+		default:
+			cmp = bytes.Compare(fromIt.Hash().Bytes(), toIt.Hash().Bytes())
+		}
+		switch {
+		case cmp < 0:
+			// The account was deleted, its storage is dropped along with it
+			if err := rlp.Encode(w, diffEntry{Kind: diffEntryAccount, Hash: fromIt.Hash()}); err != nil {
+				return err
+			}
+			accounts++
+			fromOk = fromIt.Next()
+
+		case cmp > 0:
+			// The account was created, export its entire storage
+			if err := rlp.Encode(w, diffEntry{Kind: diffEntryAccount, Hash: toIt.Hash(), Data: toIt.Account()}); err != nil {
+				return err
+			}
+			accounts++
+			n, err := exportStorageDiff(snaptree, common.Hash{}, toRoot, toIt.Hash(), w)
+			if err != nil {
+				return err
+			}
+			slots += n
+			toOk = toIt.Next()
+
+		default:
+			if !bytes.Equal(fromIt.Account(), toIt.Account()) {
+				if err := rlp.Encode(w, diffEntry{Kind: diffEntryAccount, Hash: toIt.Hash(), Data: toIt.Account()}); err != nil {
+					return err
+				}
+				accounts++
+				n, err := exportStorageDiff(snaptree, fromRoot, toRoot, toIt.Hash(), w)
+				if err != nil {
+					return err
+				}
+				slots += n
+			}
+			fromOk, toOk = fromIt.Next(), toIt.Next()
+		}
+	}
+	if err := fromIt.Error(); err != nil {
+		return err
+	}
+	if err := toIt.Error(); err != nil {
+		return err
+	}
+	if err := rlp.Encode(w, diffEntry{Kind: diffEntryEnd}); err != nil {
+		return err
+	}
+	log.Info("Exported state diff", "from", fromRoot, "to", toRoot, "accounts", accounts, "slots", slots)
+	return nil
+}
+
+// exportStorageDiff writes the storage slots of an account which differ between
+// the states of fromRoot and toRoot into w. If fromRoot is empty, the account
+// is treated as having no storage in the original state.
+func exportStorageDiff(snaptree *Tree, fromRoot, toRoot common.Hash, account common.Hash, w io.Writer) (int, error) {
+	var fromIt StorageIterator
+	if fromRoot != (common.Hash{}) {
+		it, err := snaptree.StorageIterator(fromRoot, account, common.Hash{})
+		if err != nil {
+			return 0, err
+		}
+		defer it.Release()
+		fromIt = it
+	}
+	toIt, err := snaptree.StorageIterator(toRoot, account, common.Hash{})
+	if err != nil {
+		return 0, err
+	}
+	defer toIt.Release()
+
+	var (
+		slots  int
+		fromOk = fromIt != nil && fromIt.Next()
+		toOk   = toIt.Next()
+	)
+	for fromOk || toOk {
+		var cmp int
+		switch {
+		case !fromOk:
+			cmp = 1
+		case !toOk:
+			cmp = -1
+		default:
+			cmp = bytes.Compare(fromIt.Hash().Bytes(), toIt.Hash().Bytes())
+		}
+		switch {
+		case cmp < 0:
+			if err := rlp.Encode(w, diffEntry{Kind: diffEntryStorage, Hash: fromIt.Hash()}); err != nil {
+				return 0, err
+			}
+			slots++
+			fromOk = fromIt.Next()
+
+		case cmp > 0:
+			if err := rlp.Encode(w, diffEntry{Kind: diffEntryStorage, Hash: toIt.Hash(), Data: toIt.Slot()}); err != nil {
+				return 0, err
+			}
+			slots++
+			toOk = toIt.Next()
+
+		default:
+			if !bytes.Equal(fromIt.Slot(), toIt.Slot()) {
+				if err := rlp.Encode(w, diffEntry{Kind: diffEntryStorage, Hash: toIt.Hash(), Data: toIt.Slot()}); err != nil {
+					return 0, err
+				}
+				slots++
+			}
+			fromOk, toOk = fromIt.Next(), toIt.Next()
+		}
+	}
+	if fromIt != nil {
+		if err := fromIt.Error(); err != nil {
+			return 0, err
+		}
+	}
+	return slots, toIt.Error()
+}
+
+// ImportDiff applies a state diff exported by ExportDiff onto the persisted
+// snapshot in db, which must contain the fully generated state of the diff's
+// origin. The snapshot root is moved to the diff's target on success, while
+// the journalled diff layers, referring to the old root, are left to be
+// discarded on the next load. The target root of the diff is returned.
+func ImportDiff(db ethdb.KeyValueStore, r io.Reader) (common.Hash, error) {
+	stream := rlp.NewStream(r, 0)
+
+	var header diffHeader
+	if err := stream.Decode(&header); err != nil {
+		return common.Hash{}, fmt.Errorf("failed to decode diff header: %v", err)
+	}
+	if header.Version != diffExportVersion {
+		return common.Hash{}, fmt.Errorf("unsupported diff version: have %d, want %d", header.Version, diffExportVersion)
+	}
+	if root := rawdb.ReadSnapshotRoot(db); root != header.From {
+		return common.Hash{}, fmt.Errorf("snapshot root mismatch: have %x, want %x", root, header.From)
+	}
+	if blob := rawdb.ReadSnapshotGenerator(db); len(blob) > 0 {
+		var generator journalGenerator
+		if err := rlp.DecodeBytes(blob, &generator); err != nil {
+			return common.Hash{}, fmt.Errorf("failed to decode snapshot generator: %v", err)
+		}
+		if !generator.Done {
+			return common.Hash{}, ErrNotConstructed
+		}
+	}
+	// Mark the snapshot invalid while it's being updated, so that a crash in
+	// between leaves no inconsistent state behind.
+	batch := db.NewBatch()
+	rawdb.DeleteSnapshotRoot(batch)
+
+	var (
+		account         common.Hash
+		haveAccount     bool
+		accounts, slots int
+	)
+	for {
+		var entry diffEntry
+		if err := stream.Decode(&entry); err != nil {
+			if err == io.EOF {
+				return common.Hash{}, errors.New("truncated state diff")
+			}
+			return common.Hash{}, fmt.Errorf("failed to decode diff entry: %v", err)
+		}
+		if entry.Kind == diffEntryEnd {
+			break
+		}
+		switch entry.Kind {
+		case diffEntryAccount:
+			account, haveAccount = entry.Hash, true
+			if len(entry.Data) == 0 {
+				rawdb.DeleteAccountSnapshot(batch, entry.Hash)
+
+				it := rawdb.IterateStorageSnapshots(db, entry.Hash)
+				for it.Next() {
+					batch.Delete(it.Key())
+					if batch.ValueSize() > ethdb.IdealBatchSize {
+						if err := batch.Write(); err != nil {
+							it.Release()
+							return common.Hash{}, err
+						}
+						batch.Reset()
+					}
+				}
+				err := it.Error()
+				it.Release()
+				if err != nil {
+					return common.Hash{}, err
+				}
+			} else {
+				rawdb.WriteAccountSnapshot(batch, entry.Hash, entry.Data)
+			}
+			accounts++
+
+		case diffEntryStorage:
+			if !haveAccount {
+				return common.Hash{}, errors.New("storage entry without account")
+			}
+			if len(entry.Data) == 0 {
+				rawdb.DeleteStorageSnapshot(batch, account, entry.Hash)
+			} else {
+				rawdb.WriteStorageSnapshot(batch, account, entry.Hash, entry.Data)
+			}
+			slots++
+
+		default:
+			return common.Hash{}, fmt.Errorf("unknown diff entry kind %d", entry.Kind)
+		}
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return common.Hash{}, err
+			}
+			batch.Reset()
+		}
+	}
+	rawdb.WriteSnapshotRoot(batch, header.To)
+	if err := batch.Write(); err != nil {
+		return common.Hash{}, err
+	}
+	log.Info("Imported state diff", "from", header.From, "to", header.To, "accounts", accounts, "slots", slots)
+	return header.To, nil
+}
@@ -0,0 +1,184 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/VictoriaMetrics/fastcache"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// stateRoots reproduces the account trie root of a state in the snapshot tree,
+// along with the storage roots of all its accounts.
+func stateRoots(t *testing.T, snaps *Tree, root common.Hash) (common.Hash, map[common.Hash]common.Hash) {
+	t.Helper()
+
+	it, err := snaps.AccountIterator(root, common.Hash{})
+	if err != nil {
+		t.Fatalf("failed to iterate accounts: %v", err)
+	}
+
+	storages := make(map[common.Hash]common.Hash)
+	for it.Next() {
+		storageIt, err := snaps.StorageIterator(root, it.Hash(), common.Hash{})
+		if err != nil {
+			t.Fatalf("failed to iterate storage: %v", err)
+		}
+		storages[it.Hash()], err = GenerateStorageTrieRoot(it.Hash(), storageIt)
+		if err != nil {
+			t.Fatalf("failed to generate storage root: %v", err)
+		}
+		storageIt.Release()
+	}
+	it.Release()
+
+	it, _ = snaps.AccountIterator(root, common.Hash{})
+	defer it.Release()
+
+	accountRoot, err := GenerateAccountTrieRoot(it)
+	if err != nil {
+		t.Fatalf("failed to generate account root: %v", err)
+	}
+	return accountRoot, storages
+}
+
+// Tests that a state diff exported over a long stack of diff layers, imported
+// onto a copy of the original disk snapshot, reproduces the latest state.
+func TestExportImportDiff(t *testing.T) {
+	accountHash := func(i int) common.Hash {
+		var h common.Hash
+		binary.BigEndian.PutUint64(h[24:], uint64(i+1))
+		return h
+	}
+	slotHash := func(i int) common.Hash {
+		var h common.Hash
+		binary.BigEndian.PutUint64(h[:], uint64(i+1))
+		return h
+	}
+	// Create the initial flat state in two separate databases
+	var (
+		db1  = rawdb.NewMemoryDatabase()
+		db2  = rawdb.NewMemoryDatabase()
+		base = randomHash()
+	)
+	for _, db := range []ethdb.KeyValueWriter{db1, db2} {
+		rawdb.WriteSnapshotRoot(db, base)
+	}
+	for i := 0; i < 100; i++ {
+		account := randomAccount()
+		for _, db := range []ethdb.KeyValueWriter{db1, db2} {
+			rawdb.WriteAccountSnapshot(db, accountHash(i), account)
+		}
+		for j := 0; j < 10; j++ {
+			slot := randomHash().Bytes()
+			for _, db := range []ethdb.KeyValueWriter{db1, db2} {
+				rawdb.WriteStorageSnapshot(db, accountHash(i), slotHash(j), slot)
+			}
+		}
+	}
+	snaps := &Tree{
+		layers: map[common.Hash]snapshot{
+			base: &diskLayer{diskdb: db1, root: base, cache: fastcache.New(1024 * 500)},
+		},
+	}
+	// Stack a diff layer for each of 500 blocks, modifying, creating and
+	// deleting accounts along with their storage
+	var (
+		parent = base
+		rng    = rand.New(rand.NewSource(1))
+	)
+	for i := 0; i < 500; i++ {
+		var (
+			root      = randomHash()
+			destructs = make(map[common.Hash]struct{})
+			accounts  = make(map[common.Hash][]byte)
+			storage   = make(map[common.Hash]map[common.Hash][]byte)
+		)
+		for j := 0; j < 3; j++ {
+			hash := accountHash(rng.Intn(150))
+			accounts[hash] = randomAccount()
+			storage[hash] = make(map[common.Hash][]byte)
+			for k := 0; k < 4; k++ {
+				if rng.Intn(4) == 0 {
+					storage[hash][slotHash(rng.Intn(20))] = nil
+				} else {
+					storage[hash][slotHash(rng.Intn(20))] = randomHash().Bytes()
+				}
+			}
+		}
+		if i%10 == 0 {
+			hash := accountHash(rng.Intn(150))
+			if _, ok := accounts[hash]; !ok {
+				destructs[hash] = struct{}{}
+			}
+		}
+		if err := snaps.Update(root, parent, destructs, accounts, storage, nil); err != nil {
+			t.Fatalf("block %d: failed to update snapshot tree: %v", i, err)
+		}
+		parent = root
+	}
+	// Export the diff between the disk state and the head, then import it
+	var diff bytes.Buffer
+	if err := ExportDiff(snaps, base, parent, &diff); err != nil {
+		t.Fatalf("failed to export diff: %v", err)
+	}
+	root, err := ImportDiff(db2, bytes.NewReader(diff.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to import diff: %v", err)
+	}
+	if root != parent {
+		t.Fatalf("imported root mismatch: have %x, want %x", root, parent)
+	}
+	if have := rawdb.ReadSnapshotRoot(db2); have != parent {
+		t.Fatalf("snapshot root mismatch: have %x, want %x", have, parent)
+	}
+	// Ensure the imported state matches the head of the original tree
+	imported := &Tree{
+		layers: map[common.Hash]snapshot{
+			parent: &diskLayer{diskdb: db2, root: parent, cache: fastcache.New(1024 * 500)},
+		},
+	}
+	wantRoot, wantStorages := stateRoots(t, snaps, parent)
+	haveRoot, haveStorages := stateRoots(t, imported, parent)
+	if haveRoot != wantRoot {
+		t.Fatalf("account root mismatch: have %x, want %x", haveRoot, wantRoot)
+	}
+	if len(haveStorages) != len(wantStorages) {
+		t.Fatalf("account count mismatch: have %d, want %d", len(haveStorages), len(wantStorages))
+	}
+	for account, want := range wantStorages {
+		if have := haveStorages[account]; have != want {
+			t.Errorf("account %x: storage root mismatch: have %x, want %x", account, have, want)
+		}
+	}
+	// Importing the same diff again must be rejected, the snapshot moved on
+	if _, err := ImportDiff(db2, bytes.NewReader(diff.Bytes())); err == nil {
+		t.Fatal("diff imported onto mismatching snapshot")
+	}
+	// Truncated diffs must be rejected
+	db3 := rawdb.NewMemoryDatabase()
+	rawdb.WriteSnapshotRoot(db3, base)
+	if _, err := ImportDiff(db3, bytes.NewReader(diff.Bytes()[:diff.Len()-1])); err == nil {
+		t.Fatal("truncated diff imported")
+	}
+}
@@ -19,6 +19,7 @@ package state
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"math"
 	"math/big"
@@ -1168,3 +1169,76 @@ func TestDeleteStorage(t *testing.T) {
 		t.Fatalf("difference found:\nfast: %v\nslow: %v\n", fastRes, slowRes)
 	}
 }
+
+func TestStateDiff(t *testing.T) {
+	var (
+		db       = NewDatabase(rawdb.NewMemoryDatabase())
+		state, _ = New(types.EmptyRootHash, db, nil)
+		addrA    = common.Address{0xa}
+		addrB    = common.Address{0xb}
+		addrC    = common.Address{0xc}
+		slot1    = common.Hash{0x01}
+		slot2    = common.Hash{0x02}
+	)
+	state.AddBalance(addrA, big.NewInt(100))
+	state.SetState(addrA, slot1, common.Hash{0x01})
+	state.SetNonce(addrB, 1)
+	state.IntermediateRoot(false)
+	root, _, err := state.Commit(0, nil)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	pre, _ := New(root, db, nil)
+	post, _ := New(root, db, nil)
+
+	// Destroy and recreate A the way CREATE does, with nonce 1, modify B and
+	// create C
+	post.SelfDestruct(addrA)
+	post.Finalise(true)
+	post.CreateAccount(addrA)
+	post.SetNonce(addrA, 1)
+	post.SetState(addrA, slot2, common.Hash{0x02})
+	post.SetNonce(addrB, 2)
+	post.AddBalance(addrC, big.NewInt(1))
+	post.Finalise(true)
+
+	diff := post.DiffFrom(pre)
+	if d, ok := diff.Destroyed[addrA]; !ok || d.From.Balance.ToInt().Int64() != 100 {
+		t.Fatalf("recreated account not reported as destroyed: %v", d)
+	}
+	created, ok := diff.Created[addrA]
+	if !ok {
+		t.Fatal("recreated account not reported as created")
+	}
+	if len(created.Storage) != 1 || created.Storage[slot2].To != (common.Hash{0x02}) {
+		t.Fatalf("recreated account storage mismatch: %v", created.Storage)
+	}
+	if _, ok := diff.Modified[addrA]; ok {
+		t.Fatal("recreated account reported as modified")
+	}
+	if d, ok := diff.Modified[addrB]; !ok || d.From.Nonce != 1 || d.To.Nonce != 2 {
+		t.Fatalf("modified account mismatch: %v", d)
+	}
+	if d, ok := diff.Created[addrC]; !ok || d.To.Balance.ToInt().Int64() != 1 {
+		t.Fatalf("created account mismatch: %v", d)
+	}
+	if _, err := json.Marshal(diff); err != nil {
+		t.Fatalf("failed to marshal state diff: %v", err)
+	}
+	// An account recreated empty is removed again by EIP-161, even if it has
+	// storage, so it must only be reported as destroyed
+	post, _ = New(root, db, nil)
+	post.SelfDestruct(addrA)
+	post.Finalise(true)
+	post.CreateAccount(addrA)
+	post.SetState(addrA, slot2, common.Hash{0x02})
+	post.Finalise(true)
+
+	diff = post.DiffFrom(pre)
+	if _, ok := diff.Destroyed[addrA]; !ok {
+		t.Fatal("empty recreated account not reported as destroyed")
+	}
+	if _, ok := diff.Created[addrA]; ok {
+		t.Fatal("empty recreated account reported as created")
+	}
+}
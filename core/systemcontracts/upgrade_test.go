@@ -1,11 +1,20 @@
 package systemcontracts
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/big"
 	"net/http"
 	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
 )
 
 type Config struct {
@@ -89,3 +98,112 @@ func TestUpgrade(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+// allSystemContracts lists every system contract an upgrade may touch.
+var allSystemContracts = []string{
+	ValidatorContract, SlashContract, SystemRewardContract, LightClientContract,
+	RelayerHubContract, CandidateHubContract, GovHubContract, PledgeCandidateContract,
+	BurnContract, FoundationContract, StakeHubContract, CoreAgentContract,
+	HashAgentContract, BTCAgentContract, BTCStakeContract, BTCLSTStakeContract,
+	BTCLSTTokenContract,
+}
+
+// newUpgradeTestState creates a state with a placeholder code deployed at every
+// system contract.
+func newUpgradeTestState(t *testing.T) *state.StateDB {
+	statedb, err := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	if err != nil {
+		t.Fatalf("failed to create state: %v", err)
+	}
+	for i, addr := range allSystemContracts {
+		statedb.SetCode(common.HexToAddress(addr), []byte{0x60, byte(i), 0x00})
+	}
+	return statedb
+}
+
+// Tests that crossing each hard fork applies its system contract upgrade on every
+// network it is defined for: the upgraded contracts get the configured code, no
+// other system contract is touched, and the result is deterministic.
+func TestSystemContractUpgrades(t *testing.T) {
+	const (
+		forkBlock = 10
+		forkTime  = 1000
+		period    = 3
+	)
+	networks := map[string]common.Hash{
+		mainNet:    params.CoreGenesisHash,
+		buffaloNet: params.BuffaloGenesisHash,
+		pigeonNet:  params.PigeonGenesisHash,
+	}
+	forks := []struct {
+		name     string
+		upgrades map[string]*Upgrade
+		enable   func(config *params.ChainConfig)
+	}{
+		{"HashPower", hashPowerUpgrade, func(c *params.ChainConfig) { c.HashPowerBlock = big.NewInt(forkBlock) }},
+		{"Zeus", zeusUpgrade, func(c *params.ChainConfig) { c.ZeusBlock = big.NewInt(forkBlock) }},
+		{"Hera", heraUpgrade, func(c *params.ChainConfig) { c.HeraBlock = big.NewInt(forkBlock) }},
+		{"Poseidon", poseidonUpgrade, func(c *params.ChainConfig) { c.PoseidonBlock = big.NewInt(forkBlock) }},
+		{"Hertz", nil, func(c *params.ChainConfig) { c.HertzBlock = big.NewInt(forkBlock) }},
+		{"Kepler", nil, func(c *params.ChainConfig) { c.KeplerTime = newUint64(forkTime) }},
+		{"Demeter", demeterUpgrade, func(c *params.ChainConfig) { c.DemeterTime = newUint64(forkTime) }},
+		{"Athena", athenaUpgrade, func(c *params.ChainConfig) { c.AthenaTime = newUint64(forkTime) }},
+	}
+	defer func(hash common.Hash) { GenesisHash = hash }(GenesisHash)
+
+	for _, fork := range forks {
+		for network, genesis := range networks {
+			config := &params.ChainConfig{ChainID: big.NewInt(1), LondonBlock: big.NewInt(0)}
+			fork.enable(config)
+			GenesisHash = genesis
+
+			// Execute the block before and the block crossing the fork
+			execute := func() (*state.StateDB, map[common.Address]common.Hash, common.Hash) {
+				statedb := newUpgradeTestState(t)
+				before := make(map[common.Address]common.Hash)
+				for _, addr := range allSystemContracts {
+					before[common.HexToAddress(addr)] = statedb.GetCodeHash(common.HexToAddress(addr))
+				}
+				UpgradeBuildInSystemContract(config, big.NewInt(forkBlock-1), forkTime-2*period, forkTime-period, statedb)
+				for addr, hash := range before {
+					if have := statedb.GetCodeHash(addr); have != hash {
+						t.Fatalf("%s/%s: contract %x modified before the fork", fork.name, network, addr)
+					}
+				}
+				UpgradeBuildInSystemContract(config, big.NewInt(forkBlock), forkTime-period, forkTime, statedb)
+				return statedb, before, statedb.IntermediateRoot(true)
+			}
+			statedb, before, root := execute()
+
+			expected := make(map[common.Address]common.Hash)
+			if upgrade := fork.upgrades[network]; upgrade != nil {
+				for _, cfg := range upgrade.Configs {
+					code, err := hex.DecodeString(cfg.Code)
+					if err != nil {
+						t.Fatalf("%s/%s: invalid code for %x: %v", fork.name, network, cfg.ContractAddr, err)
+					}
+					expected[cfg.ContractAddr] = crypto.Keccak256Hash(code)
+				}
+			}
+			for addr, hash := range before {
+				want, upgraded := expected[addr]
+				if !upgraded {
+					want = hash
+				}
+				if have := statedb.GetCodeHash(addr); have != want {
+					t.Errorf("%s/%s: contract %x code hash mismatch: have %x, want %x (upgraded %v)", fork.name, network, addr, have, want, upgraded)
+				}
+			}
+			for addr := range expected {
+				if _, ok := before[addr]; !ok {
+					t.Errorf("%s/%s: upgrade targets unknown system contract %x", fork.name, network, addr)
+				}
+			}
+			if _, _, again := execute(); again != root {
+				t.Errorf("%s/%s: state root not stable across executions: %x != %x", fork.name, network, root, again)
+			}
+		}
+	}
+}
+
+func newUint64(val uint64) *uint64 { return &val }
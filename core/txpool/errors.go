@@ -30,6 +30,10 @@ var (
 	// configured for the transaction pool.
 	ErrUnderpriced = errors.New("transaction underpriced")
 
+	// ErrTipTooLow is returned if a transaction's effective tip is below the floor
+	// a validator is configured to accept.
+	ErrTipTooLow = errors.New("effective tip below validator minimum")
+
 	// ErrReplaceUnderpriced is returned if a transaction is attempted to be replaced
 	// with a different one without the required price bump.
 	ErrReplaceUnderpriced = errors.New("replacement transaction underpriced")
@@ -19,6 +19,7 @@ package legacypool
 
 import (
 	"errors"
+	"fmt"
 	"math"
 	"math/big"
 	"sort"
@@ -134,6 +135,8 @@ type Config struct {
 	PriceLimit uint64 // Minimum gas price to enforce for acceptance into the pool
 	PriceBump  uint64 // Minimum price bump percentage to replace an already existing transaction (nonce)
 
+	MinValidatorTip uint64 // Minimum effective tip in wei to accept any transaction, local ones included (0 = no floor)
+
 	AccountSlots uint64 // Number of executable transaction slots guaranteed per account
 	GlobalSlots  uint64 // Maximum number of executable transaction slots for all accounts
 	AccountQueue uint64 // Maximum number of non-executable transaction slots permitted per account
@@ -641,6 +644,15 @@ func (pool *LegacyPool) validateTxBasics(tx *types.Transaction, local bool) erro
 // validateTx checks whether a transaction is valid according to the consensus
 // rules and adheres to some heuristic limits of the local node (price and size).
 func (pool *LegacyPool) validateTx(tx *types.Transaction, local bool) error {
+	if pool.config.MinValidatorTip > 0 {
+		tip, err := tx.EffectiveGasTip(pool.currentHead.Load().BaseFee)
+		if err != nil {
+			return err
+		}
+		if floor := new(big.Int).SetUint64(pool.config.MinValidatorTip); tip.Cmp(floor) < 0 {
+			return fmt.Errorf("%w: effective tip %v, minimum %v", txpool.ErrTipTooLow, tip, floor)
+		}
+	}
 	if !local && tx.GasTipCapIntCmp(pool.gasTip.Load()) < 0 {
 		return txpool.ErrUnderpriced
 	}
@@ -691,7 +703,7 @@ func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, e
 	// If the transaction is already known, discard it
 	hash := tx.Hash()
 	if pool.all.Get(hash) != nil {
-		log.Trace("Discarding already known transaction", "hash", hash)
+		pool.logRejection(tx, RejectKnown, txpool.ErrAlreadyKnown)
 		knownTxMeter.Mark(1)
 		return false, txpool.ErrAlreadyKnown
 	}
@@ -701,7 +713,7 @@ func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, e
 
 	// If the transaction fails basic validation, discard it
 	if err := pool.validateTx(tx, isLocal); err != nil {
-		log.Trace("Discarding invalid transaction", "hash", hash, "err", err)
+		pool.logRejection(tx, rejectionReason(err), err)
 		invalidTxMeter.Mark(1)
 		return false, err
 	}
@@ -716,6 +728,7 @@ func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, e
 	)
 	if !hasPending && !hasQueued {
 		if err := pool.reserve(from, true); err != nil {
+			pool.logRejection(tx, rejectionReason(err), err)
 			return false, err
 		}
 		defer func() {
@@ -734,7 +747,7 @@ func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, e
 	if uint64(pool.all.Slots()+numSlots(tx)) > pool.config.GlobalSlots+pool.config.GlobalQueue {
 		// If the new transaction is underpriced, don't accept it
 		if !isLocal && pool.priced.Underpriced(tx) {
-			log.Trace("Discarding underpriced transaction", "hash", hash, "gasTipCap", tx.GasTipCap(), "gasFeeCap", tx.GasFeeCap())
+			pool.logRejection(tx, RejectUnderpriced, txpool.ErrUnderpriced)
 			underpricedTxMeter.Mark(1)
 			return false, txpool.ErrUnderpriced
 		}
@@ -744,6 +757,7 @@ func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, e
 		// do too many replacements between reorg-runs, so we cap the number of
 		// replacements to 25% of the slots
 		if pool.changesSinceReorg > int(pool.config.GlobalSlots/4) {
+			pool.logRejection(tx, RejectThrottled, ErrTxPoolOverflow)
 			throttleTxMeter.Mark(1)
 			return false, ErrTxPoolOverflow
 		}
@@ -755,7 +769,7 @@ func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, e
 
 		// Special case, we still can't make the room for the new remote one.
 		if !isLocal && !success {
-			log.Trace("Discarding overflown transaction", "hash", hash)
+			pool.logRejection(tx, RejectOverflow, ErrTxPoolOverflow)
 			overflowedTxMeter.Mark(1)
 			return false, ErrTxPoolOverflow
 		}
@@ -775,7 +789,7 @@ func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, e
 				for _, dropTx := range drop {
 					pool.priced.Put(dropTx, false)
 				}
-				log.Trace("Discarding future transaction replacing pending tx", "hash", hash)
+				pool.logRejection(tx, RejectFutureReplacePending, txpool.ErrFutureReplacePending)
 				return false, txpool.ErrFutureReplacePending
 			}
 		}
@@ -797,6 +811,7 @@ func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, e
 		// Nonce already pending, check if required price bump is met
 		inserted, old := list.Add(tx, pool.config.PriceBump)
 		if !inserted {
+			pool.logRejection(tx, RejectReplaceUnderpriced, txpool.ErrReplaceUnderpriced)
 			pendingDiscardMeter.Mark(1)
 			return false, txpool.ErrReplaceUnderpriced
 		}
@@ -819,6 +834,7 @@ func (pool *LegacyPool) add(tx *types.Transaction, local bool) (replaced bool, e
 	// New transaction isn't replacing a pending one, push into queue
 	replaced, err = pool.enqueueTx(hash, tx, isLocal, true)
 	if err != nil {
+		pool.logRejection(tx, rejectionReason(err), err)
 		return false, err
 	}
 	// Mark local addresses and journal local transactions
@@ -1017,6 +1033,7 @@ func (pool *LegacyPool) addTxs(txs []*types.Transaction, local, sync bool) []err
 		// If the transaction is known, pre-set the error slot
 		if pool.all.Get(tx.Hash()) != nil {
 			errs[i] = txpool.ErrAlreadyKnown
+			pool.logRejection(tx, RejectKnown, txpool.ErrAlreadyKnown)
 			knownTxMeter.Mark(1)
 			continue
 		}
@@ -1025,6 +1042,7 @@ func (pool *LegacyPool) addTxs(txs []*types.Transaction, local, sync bool) []err
 		// in transactions before obtaining lock
 		if err := pool.validateTxBasics(tx, local); err != nil {
 			errs[i] = err
+			pool.logRejection(tx, rejectionReason(err), err)
 			invalidTxMeter.Mark(1)
 			continue
 		}
@@ -37,6 +37,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/trie"
 )
@@ -357,6 +358,37 @@ func TestInvalidTransactions(t *testing.T) {
 	}
 }
 
+// Tests that rejected transactions are accounted for under their rejection reason.
+func TestRejectionCounters(t *testing.T) {
+	counter, ok := metrics.DefaultRegistry.Get("txpool/rejected/" + string(RejectNonceTooLow)).(metrics.Counter)
+	if !ok {
+		t.Fatal("rejection counter not registered")
+	}
+	// The counter is shared with the other tests, only check the changes
+	base := counter.Count()
+
+	pool, key := setupPool()
+	defer pool.Close()
+
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	testSetNonce(pool, from, 1)
+	testAddBalance(pool, from, big.NewInt(0xffffffffffffff))
+
+	if err := pool.addRemote(transaction(0, 100000, key)); !errors.Is(err, core.ErrNonceTooLow) {
+		t.Fatalf("want %v have %v", core.ErrNonceTooLow, err)
+	}
+	if have := counter.Count() - base; have != 1 {
+		t.Fatalf("rejection counter change mismatch: have %d, want %d", have, 1)
+	}
+	// Accepted transactions must not be counted
+	if err := pool.addRemote(transaction(1, 100000, key)); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	if have := counter.Count() - base; have != 1 {
+		t.Fatalf("rejection counter change mismatch: have %d, want %d", have, 1)
+	}
+}
+
 func TestQueue(t *testing.T) {
 	t.Parallel()
 
@@ -441,6 +473,38 @@ func TestTipAboveFeeCap(t *testing.T) {
 	}
 }
 
+// Tests that the minimum validator tip rejects transactions below the floor,
+// local ones included, and that a zero floor accepts everything.
+func TestMinValidatorTip(t *testing.T) {
+	t.Parallel()
+
+	for _, floor := range []uint64{0, params.GWei} {
+		statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		blockchain := newTestBlockChain(eip1559Config, 10000000, statedb, new(event.Feed))
+
+		config := testTxPoolConfig
+		config.MinValidatorTip = floor
+		pool := New(config, blockchain)
+		if err := pool.Init(new(big.Int).SetUint64(config.PriceLimit), blockchain.CurrentBlock(), makeAddressReserver()); err != nil {
+			t.Fatalf("failed to init pool: %v", err)
+		}
+		<-pool.initDoneCh
+
+		key, _ := crypto.GenerateKey()
+		testAddBalance(pool, crypto.PubkeyToAddress(key.PublicKey), big.NewInt(params.Ether))
+
+		tx := dynamicFeeTx(0, 100000, big.NewInt(params.GWei), big.NewInt(0), key)
+		err := pool.addLocal(tx)
+		switch {
+		case floor == 0 && err != nil:
+			t.Errorf("zero tip rejected without floor: %v", err)
+		case floor > 0 && !errors.Is(err, txpool.ErrTipTooLow):
+			t.Errorf("zero tip error mismatch with floor %d: have %v, want %v", floor, err, txpool.ErrTipTooLow)
+		}
+		pool.Close()
+	}
+}
+
 func TestVeryHighValues(t *testing.T) {
 	t.Parallel()
 
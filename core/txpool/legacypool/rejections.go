@@ -0,0 +1,124 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package legacypool
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/txpool"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// TxRejectionReason is a short, stable identifier of the reason a transaction
+// was refused by the pool. It is attached to the rejection logs and used to
+// name the rejection counters, so the values must remain unchanged.
+type TxRejectionReason string
+
+const (
+	RejectKnown                TxRejectionReason = "known"
+	RejectInvalidSender        TxRejectionReason = "invalid_sender"
+	RejectTxType               TxRejectionReason = "tx_type"
+	RejectNonceTooLow          TxRejectionReason = "nonce_too_low"
+	RejectInsufficientFunds    TxRejectionReason = "insufficient_funds"
+	RejectIntrinsicGas         TxRejectionReason = "intrinsic_gas"
+	RejectGasLimit             TxRejectionReason = "gas_limit"
+	RejectOversized            TxRejectionReason = "oversized"
+	RejectTipTooLow            TxRejectionReason = "tip_too_low"
+	RejectUnderpriced          TxRejectionReason = "underpriced"
+	RejectReplaceUnderpriced   TxRejectionReason = "replace_underpriced"
+	RejectAccountLimit         TxRejectionReason = "account_limit"
+	RejectBlacklisted          TxRejectionReason = "blacklisted"
+	RejectThrottled            TxRejectionReason = "throttled"
+	RejectOverflow             TxRejectionReason = "overflow"
+	RejectFutureReplacePending TxRejectionReason = "future_replace_pending"
+	RejectInvalid              TxRejectionReason = "invalid" // Any other validation failure
+)
+
+// rejectedCounters counts the rejected transactions per rejection reason. The
+// metrics library has no labels, so every reason gets its own counter, which
+// is exported as txpool_rejected_<reason>. The counters are cheap, they count
+// even if metrics collection is disabled.
+var rejectedCounters = make(map[TxRejectionReason]metrics.Counter)
+
+func init() {
+	for _, reason := range []TxRejectionReason{
+		RejectKnown, RejectInvalidSender, RejectTxType, RejectNonceTooLow,
+		RejectInsufficientFunds, RejectIntrinsicGas, RejectGasLimit, RejectOversized,
+		RejectTipTooLow, RejectUnderpriced, RejectReplaceUnderpriced, RejectAccountLimit,
+		RejectBlacklisted, RejectThrottled, RejectOverflow, RejectFutureReplacePending,
+		RejectInvalid,
+	} {
+		rejectedCounters[reason] = metrics.NewRegisteredCounterForced("txpool/rejected/"+string(reason), nil)
+	}
+}
+
+// rejectionReason classifies a validation error returned for a transaction.
+func rejectionReason(err error) TxRejectionReason {
+	switch {
+	case errors.Is(err, txpool.ErrAlreadyKnown):
+		return RejectKnown
+	case errors.Is(err, txpool.ErrInvalidSender), errors.Is(err, types.ErrInvalidSig):
+		return RejectInvalidSender
+	case errors.Is(err, core.ErrTxTypeNotSupported):
+		return RejectTxType
+	case errors.Is(err, core.ErrNonceTooLow):
+		return RejectNonceTooLow
+	case errors.Is(err, core.ErrInsufficientFunds):
+		return RejectInsufficientFunds
+	case errors.Is(err, core.ErrIntrinsicGas):
+		return RejectIntrinsicGas
+	case errors.Is(err, txpool.ErrGasLimit):
+		return RejectGasLimit
+	case errors.Is(err, txpool.ErrOversizedData), errors.Is(err, core.ErrMaxInitCodeSizeExceeded):
+		return RejectOversized
+	case errors.Is(err, txpool.ErrTipTooLow):
+		return RejectTipTooLow
+	case errors.Is(err, txpool.ErrUnderpriced):
+		return RejectUnderpriced
+	case errors.Is(err, txpool.ErrReplaceUnderpriced):
+		return RejectReplaceUnderpriced
+	case errors.Is(err, txpool.ErrAccountLimitExceeded):
+		return RejectAccountLimit
+	case errors.Is(err, ErrInBlackList):
+		return RejectBlacklisted
+	case errors.Is(err, txpool.ErrFutureReplacePending):
+		return RejectFutureReplacePending
+	case errors.Is(err, ErrTxPoolOverflow):
+		return RejectOverflow
+	default:
+		return RejectInvalid
+	}
+}
+
+// logRejection records the refusal of a transaction, both in the trace logs and
+// in the rejection counter of the given reason.
+func (pool *LegacyPool) logRejection(tx *types.Transaction, reason TxRejectionReason, err error) {
+	rejectedCounters[reason].Inc(1)
+
+	// Recovering the sender is not free and not always possible, only do it if
+	// the log is actually emitted.
+	sender := func() common.Address {
+		from, _ := types.Sender(pool.signer, tx)
+		return from
+	}
+	log.Trace("Rejected transaction", "from", log.Lazy{Fn: sender}, "hash", tx.Hash(), "nonce", tx.Nonce(),
+		"gasPrice", tx.GasPrice(), "reason", reason, "err", err)
+}
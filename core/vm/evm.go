@@ -42,6 +42,9 @@ type (
 	// GetHashFunc returns the n'th block hash in the blockchain
 	// and is used by the BLOCKHASH EVM op code.
 	GetHashFunc func(uint64) common.Hash
+	// DistributeRewardFunc credits the fee reward of a transaction in place of
+	// the default block beneficiary credit.
+	DistributeRewardFunc func(StateDB, *uint256.Int)
 )
 
 func (evm *EVM) precompile(addr common.Address) (PrecompiledContract, bool) {
@@ -74,6 +77,9 @@ type BlockContext struct {
 	Transfer TransferFunc
 	// GetHash returns the hash corresponding to n
 	GetHash GetHashFunc
+	// DistributeReward credits the fee reward of a transaction, or nil to
+	// credit the block beneficiary
+	DistributeReward DistributeRewardFunc
 
 	// Block information
 	Coinbase      common.Address // Provides information for COINBASE
@@ -232,6 +238,12 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 		}
 	}
 
+	if evm.Config.GasProfiler != nil {
+		evm.Config.GasProfiler.enter()
+		defer func(startGas uint64) {
+			evm.Config.GasProfiler.exit(addr, startGas-gas)
+		}(gas)
+	}
 	if isPrecompile {
 		ret, gas, err = RunPrecompiledContract(p, input, gas)
 	} else {
@@ -294,6 +306,12 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 		}(gas)
 	}
 
+	if evm.Config.GasProfiler != nil {
+		evm.Config.GasProfiler.enter()
+		defer func(startGas uint64) {
+			evm.Config.GasProfiler.exit(addr, startGas-gas)
+		}(gas)
+	}
 	// It is allowed to call precompiles, even via delegatecall
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
 		ret, gas, err = RunPrecompiledContract(p, input, gas)
@@ -339,6 +357,12 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 		}(gas)
 	}
 
+	if evm.Config.GasProfiler != nil {
+		evm.Config.GasProfiler.enter()
+		defer func(startGas uint64) {
+			evm.Config.GasProfiler.exit(addr, startGas-gas)
+		}(gas)
+	}
 	// It is allowed to call precompiles, even via delegatecall
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
 		ret, gas, err = RunPrecompiledContract(p, input, gas)
@@ -388,6 +412,12 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 			evm.Config.Tracer.CaptureExit(ret, startGas-gas, err)
 		}(gas)
 	}
+	if evm.Config.GasProfiler != nil {
+		evm.Config.GasProfiler.enter()
+		defer func(startGas uint64) {
+			evm.Config.GasProfiler.exit(addr, startGas-gas)
+		}(gas)
+	}
 
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
 		ret, gas, err = RunPrecompiledContract(p, input, gas)
@@ -472,6 +502,9 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 			evm.Config.Tracer.CaptureEnter(typ, caller.Address(), address, codeAndHash.code, gas, value)
 		}
 	}
+	if evm.Config.GasProfiler != nil {
+		evm.Config.GasProfiler.enter()
+	}
 
 	ret, err := evm.interpreter.Run(contract, nil, false)
 
@@ -515,6 +548,9 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 			evm.Config.Tracer.CaptureExit(ret, gas-contract.Gas, err)
 		}
 	}
+	if evm.Config.GasProfiler != nil {
+		evm.Config.GasProfiler.exit(address, gas-contract.Gas)
+	}
 	return ret, address, contract.Gas, err
 }
 
@@ -536,3 +572,7 @@ func (evm *EVM) Create2(caller ContractRef, code []byte, gas uint64, endowment *
 
 // ChainConfig returns the environment's chain configuration
 func (evm *EVM) ChainConfig() *params.ChainConfig { return evm.chainConfig }
+
+// ChainRules returns the rules of the environment's block, derived once when
+// the block context is set.
+func (evm *EVM) ChainRules() params.Rules { return evm.chainRules }
@@ -0,0 +1,65 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import "github.com/ethereum/go-ethereum/common"
+
+// GasProfiler accumulates the gas consumed by the code of each called contract
+// across all the EVM executions it is attached to. The gas a frame forwards to
+// its sub-calls is attributed to the callees, not to the frame itself, so the
+// totals add up to the gas spent on contract execution.
+//
+// The profiler is not thread safe, it must only be shared by executions which
+// run sequentially.
+type GasProfiler struct {
+	gas    map[common.Address]uint64
+	frames []uint64 // Gas consumed by the sub-calls of each open frame
+}
+
+// NewGasProfiler creates an empty gas profiler.
+func NewGasProfiler() *GasProfiler {
+	return &GasProfiler{gas: make(map[common.Address]uint64)}
+}
+
+// enter opens a new call frame.
+func (p *GasProfiler) enter() {
+	p.frames = append(p.frames, 0)
+}
+
+// exit closes the innermost call frame, attributing the gas it consumed minus
+// that of its sub-calls to the executed code.
+func (p *GasProfiler) exit(addr common.Address, used uint64) {
+	n := len(p.frames) - 1
+	nested := p.frames[n]
+	p.frames = p.frames[:n]
+
+	if used > nested {
+		p.gas[addr] += used - nested
+	}
+	if n > 0 {
+		p.frames[n-1] += used
+	}
+}
+
+// Profile returns a copy of the accumulated gas per executed contract.
+func (p *GasProfiler) Profile() map[common.Address]uint64 {
+	profile := make(map[common.Address]uint64, len(p.gas))
+	for addr, gas := range p.gas {
+		profile[addr] = gas
+	}
+	return profile
+}
@@ -928,3 +928,54 @@ func TestOpMCopy(t *testing.T) {
 		}
 	}
 }
+
+// Tests that MCOPY is only available from CORE's Theseus fork on, and that it
+// is charged 3 + 3*words plus the memory expansion.
+func TestOpMCopyTheseus(t *testing.T) {
+	var (
+		config  = *params.CoreChainConfig
+		theseus = *config.AthenaTime + 3600
+		number  = new(big.Int).Add(config.HertzBlock, common.Big1)
+	)
+	config.TheseusTime = &theseus
+	for i, tc := range []struct {
+		time    uint64
+		code    string
+		gas     uint64
+		invalid bool  // Whether MCOPY is expected to be an invalid opcode
+		err     error // Expected execution error otherwise
+	}{
+		// MCOPY 0x20 0 0x40 before Theseus, also after Athena
+		{time: *config.AthenaTime, code: "6040600060205e00", gas: 100, invalid: true},
+		{time: theseus - 1, code: "6040600060205e00", gas: 100, invalid: true},
+		// MCOPY 0x20 0 0x40: 3 pushes, 3 + 2*3 for the copy and 9 for expanding to 3 words
+		{time: theseus, code: "6040600060205e00", gas: 27},
+		{time: theseus + 1, code: "6040600060205e00", gas: 26, err: ErrOutOfGas},
+		// MCOPY 0 1 0x20, overlapping backward copy: 3 + 3 for the copy and 6 for 2 words
+		{time: theseus, code: "6020600160005e00", gas: 21},
+		{time: theseus, code: "6020600160005e00", gas: 20, err: ErrOutOfGas},
+		// MCOPY 0xffff 0 0 copying nothing doesn't expand memory
+		{time: theseus, code: "600060006200ffff5e00", gas: 12},
+		{time: theseus, code: "600060006200ffff5e00", gas: 11, err: ErrOutOfGas},
+	} {
+		statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		env := NewEVM(BlockContext{BlockNumber: number, Time: tc.time}, TxContext{}, statedb, &config, Config{})
+
+		contract := NewContract(AccountRef(common.Address{1}), AccountRef(common.Address{2}), new(big.Int), tc.gas)
+		contract.Code = common.FromHex(tc.code)
+
+		_, err := env.interpreter.Run(contract, nil, false)
+		if tc.invalid {
+			if _, ok := err.(*ErrInvalidOpCode); !ok {
+				t.Errorf("case %d: error mismatch: have %v, want invalid opcode", i, err)
+			}
+			continue
+		}
+		if err != tc.err {
+			t.Errorf("case %d: error mismatch: have %v, want %v", i, err, tc.err)
+		}
+		if err == nil && contract.Gas != 0 {
+			t.Errorf("case %d: gas left %d, want 0", i, contract.Gas)
+		}
+	}
+}
@@ -38,6 +38,8 @@ type Config struct {
 	NoRecursion             bool      // Disables call, callcode, delegate call and create
 	EnablePreimageRecording bool      // Enables recording of SHA3/keccak preimages
 	ExtraEips               []int     // Additional EIPS that are to be enabled
+
+	GasProfiler *GasProfiler // Per-contract gas accumulator (nil = disabled)
 }
 
 // ScopeContext contains the things that are per-call, such as stack and memory,
@@ -67,6 +69,8 @@ func NewEVMInterpreter(evm *EVM) *EVMInterpreter {
 	switch {
 	case evm.chainRules.IsCancun:
 		table = &cancunInstructionSet
+	case evm.chainRules.IsTheseus:
+		table = &theseusInstructionSet
 	case evm.chainRules.IsShanghai:
 		table = &shanghaiInstructionSet
 	case evm.chainRules.IsMerge:
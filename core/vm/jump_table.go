@@ -56,6 +56,7 @@ var (
 	londonInstructionSet           = newLondonInstructionSet()
 	mergeInstructionSet            = newMergeInstructionSet()
 	shanghaiInstructionSet         = newShanghaiInstructionSet()
+	theseusInstructionSet          = newTheseusInstructionSet()
 	cancunInstructionSet           = newCancunInstructionSet()
 )
 
@@ -89,6 +90,14 @@ func newCancunInstructionSet() JumpTable {
 	return validate(instructionSet)
 }
 
+// newTheseusInstructionSet returns the instructions of CORE's Theseus fork, which
+// extends Shanghai with the MCOPY opcode of Cancun.
+func newTheseusInstructionSet() JumpTable {
+	instructionSet := newShanghaiInstructionSet()
+	enable5656(&instructionSet) // EIP-5656 (MCOPY opcode)
+	return validate(instructionSet)
+}
+
 func newShanghaiInstructionSet() JumpTable {
 	instructionSet := newLondonInstructionSet()
 	enable3855(&instructionSet) // PUSH0 instruction
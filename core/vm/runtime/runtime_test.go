@@ -235,6 +235,11 @@ func (d *dummyChain) Engine() consensus.Engine {
 	return nil
 }
 
+// RewardDistributor retrieves the custom fee reward distributor.
+func (d *dummyChain) RewardDistributor() core.RewardDistributor {
+	return nil
+}
+
 // GetHeader returns the hash corresponding to their hash.
 func (d *dummyChain) GetHeader(h common.Hash, n uint64) *types.Header {
 	d.counter++
@@ -106,6 +106,14 @@ func (m *mockInvalidPOSA) IsActiveValidatorAt(chain consensus.ChainHeaderReader,
 	return true
 }
 
+func (m *mockPOSA) EffectiveValidatorSet(chain consensus.ChainHeaderReader, header *types.Header) ([]common.Address, error) {
+	return nil, nil
+}
+
+func (m *mockInvalidPOSA) EffectiveValidatorSet(chain consensus.ChainHeaderReader, header *types.Header) ([]common.Address, error) {
+	return nil, nil
+}
+
 func (pool *VotePool) verifyStructureSizeOfVotePool(receivedVotes, curVotes, futureVotes, curVotesPq, futureVotesPq int) bool {
 	for i := 0; i < timeThreshold; i++ {
 		time.Sleep(1 * time.Second)
@@ -27,6 +27,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/internal/ethapi"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -357,6 +358,35 @@ func (api *DebugAPI) getModifiedAccounts(startBlock, endBlock *types.Block) ([]c
 	return dirty, nil
 }
 
+// GetStateDiff re-executes the given canonical block on top of its parent state
+// and returns the accounts and storage slots it created, destroyed or modified.
+func (api *DebugAPI) GetStateDiff(ctx context.Context, blockHash common.Hash) (state.StateDiff, error) {
+	block := api.eth.blockchain.GetBlockByHash(blockHash)
+	if block == nil {
+		return state.StateDiff{}, fmt.Errorf("block %x not found", blockHash)
+	}
+	number := block.NumberU64()
+	if api.eth.blockchain.GetCanonicalHash(number) != blockHash {
+		return state.StateDiff{}, fmt.Errorf("block %x is not canonical", blockHash)
+	}
+	if number == 0 {
+		return state.StateDiff{}, errors.New("genesis has no state diff")
+	}
+	parent := api.eth.blockchain.GetHeader(block.ParentHash(), number-1)
+	if parent == nil {
+		return state.StateDiff{}, fmt.Errorf("block %x has no parent", blockHash)
+	}
+	pre, err := api.eth.blockchain.StateAt(parent.Root)
+	if err != nil {
+		return state.StateDiff{}, err
+	}
+	post, _, err := api.eth.blockchain.ReplayBlock(number, vm.Config{})
+	if err != nil {
+		return state.StateDiff{}, err
+	}
+	return post.DiffFrom(pre), nil
+}
+
 // GetAccessibleState returns the first number where the node has accessible
 // state on disk. Note this being the post-state of that block and the pre-state
 // of the next block.
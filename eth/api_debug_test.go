@@ -223,3 +223,46 @@ func TestStorageRangeAt(t *testing.T) {
 		}
 	}
 }
+
+// Tests that storage ranges starting deep within a large storage trie are
+// served from the seeked position, and that paging continues correctly.
+func TestStorageRangeAtSeek(t *testing.T) {
+	t.Parallel()
+
+	var (
+		state, _ = state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		addr     = common.Address{0x01}
+		hashes   = make([]common.Hash, 0, 10000)
+	)
+	for i := 0; i < 10000; i++ {
+		key := common.BigToHash(big.NewInt(int64(i)))
+		state.SetState(addr, key, common.BigToHash(big.NewInt(int64(i+1))))
+		hashes = append(hashes, crypto.Keccak256Hash(key.Bytes()))
+	}
+	slices.SortFunc(hashes, common.Hash.Cmp)
+
+	tr, err := state.StorageTrie(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := hashes[5000]
+	for page := 0; page < 3; page++ {
+		result, err := storageRangeAt(tr, start.Bytes(), 100)
+		if err != nil {
+			t.Fatalf("page %d: failed to retrieve storage range: %v", page, err)
+		}
+		if len(result.Storage) != 100 {
+			t.Fatalf("page %d: entry count mismatch: have %d, want %d", page, len(result.Storage), 100)
+		}
+		first := 5000 + page*100
+		for _, hash := range hashes[first : first+100] {
+			if _, ok := result.Storage[hash]; !ok {
+				t.Fatalf("page %d: missing slot %x", page, hash)
+			}
+		}
+		if result.NextKey == nil || *result.NextKey != hashes[first+100] {
+			t.Fatalf("page %d: next key mismatch: have %v, want %x", page, result.NextKey, hashes[first+100])
+		}
+		start = *result.NextKey
+	}
+}
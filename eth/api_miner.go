@@ -17,11 +17,15 @@
 package eth
 
 import (
+	"context"
+	"fmt"
 	"math/big"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/internal/ethapi"
 )
 
 // MinerAPI provides an API to control the miner.
@@ -83,3 +87,31 @@ func (api *MinerAPI) SetEtherbase(etherbase common.Address) bool {
 func (api *MinerAPI) SetRecommitInterval(interval int) {
 	api.e.Miner().SetRecommitInterval(time.Duration(interval) * time.Millisecond)
 }
+
+// SimulateBlock returns the block the miner would produce on top of the current
+// head, along with its receipts, without sealing it. If transactions are given,
+// they are included in order instead of the pending ones of the transaction pool.
+func (api *MinerAPI) SimulateBlock(ctx context.Context, timestamp *hexutil.Uint64, txs *[]hexutil.Bytes) (map[string]interface{}, error) {
+	var overrides types.Transactions
+	if txs != nil {
+		overrides = make(types.Transactions, 0, len(*txs))
+		for i, input := range *txs {
+			tx := new(types.Transaction)
+			if err := tx.UnmarshalBinary(input); err != nil {
+				return nil, fmt.Errorf("transaction %d: %w", i, err)
+			}
+			overrides = append(overrides, tx)
+		}
+	}
+	var ts uint64
+	if timestamp != nil {
+		ts = uint64(*timestamp)
+	}
+	block, receipts, err := api.e.Miner().SimulateBlock(ctx, nil, ts, overrides)
+	if err != nil {
+		return nil, err
+	}
+	fields := ethapi.RPCMarshalBlock(block, true, true, api.e.blockchain.Config())
+	fields["receipts"] = receipts
+	return fields, nil
+}
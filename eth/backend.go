@@ -23,6 +23,7 @@ import (
 	"math/big"
 	"runtime"
 	"sync"
+	"sync/atomic"
 
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
@@ -108,6 +109,9 @@ type Ethereum struct {
 	shutdownTracker *shutdowncheck.ShutdownTracker // Tracks if and when the node has shutdown ungracefully
 
 	votePool *vote.VotePool
+
+	acceptedHeader atomic.Pointer[types.Header] // Latest block announced by ChainAcceptedEvent
+	acceptedSub    event.Subscription           // Subscription to ChainAcceptedEvent
 }
 
 // New creates a new Ethereum object (including the
@@ -178,6 +182,22 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 		chainConfig.KeplerTime = config.OverrideKepler
 		overrides.OverrideKepler = config.OverrideKepler
 	}
+	if config.OverrideDemeter != nil || config.OverrideAthena != nil || config.OverrideTheseus != nil {
+		overrides.ForkTimes = &params.ForkTimeOverrides{
+			Demeter: config.OverrideDemeter,
+			Athena:  config.OverrideAthena,
+			Theseus: config.OverrideTheseus,
+		}
+		if config.OverrideDemeter != nil {
+			chainConfig.DemeterTime = config.OverrideDemeter
+		}
+		if config.OverrideAthena != nil {
+			chainConfig.AthenaTime = config.OverrideAthena
+		}
+		if config.OverrideTheseus != nil {
+			chainConfig.TheseusTime = config.OverrideTheseus
+		}
+	}
 	if config.OverrideCancun != nil {
 		chainConfig.CancunTime = config.OverrideCancun
 		overrides.OverrideCancun = config.OverrideCancun
@@ -247,6 +267,9 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 			StateHistory:        config.StateHistory,
 			StateScheme:         config.StateScheme,
 			PathSyncFlush:       config.PathSyncFlush,
+
+			AncientFreezeThreshold:    config.AncientFreezeThreshold,
+			ConcurrentStateValidation: true,
 		}
 	)
 	bcOps := make([]core.BlockChainOption, 0)
@@ -259,6 +282,9 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 	if stack.Config().EnableDoubleSignMonitor {
 		bcOps = append(bcOps, core.EnableDoubleSignChecker)
 	}
+	if config.StrictNonceOrder {
+		bcOps = append(bcOps, core.EnableStrictNonceOrder)
+	}
 
 	peers := newPeerSet()
 	bcOps = append(bcOps, core.EnableBlockValidator(chainConfig, eth.engine, config.TriesVerifyMode, peers))
@@ -592,6 +618,11 @@ func (s *Ethereum) Start() error {
 	// Regularly update shutdown marker
 	s.shutdownTracker.Start()
 
+	// Track the accepted blocks to serve as finalized ones over RPC
+	acceptedCh := make(chan core.ChainAcceptedEvent, 16)
+	s.acceptedSub = s.blockchain.SubscribeChainAcceptedEvent(acceptedCh)
+	go s.acceptedLoop(acceptedCh)
+
 	// Figure out a max peers count based on the server limits
 	maxPeers := s.p2pServer.MaxPeers
 	if s.config.LightServ > 0 {
@@ -605,6 +636,25 @@ func (s *Ethereum) Start() error {
 	return nil
 }
 
+// acceptedLoop records the latest block the chain announced as accepted, until
+// the subscription is torn down.
+func (s *Ethereum) acceptedLoop(ch chan core.ChainAcceptedEvent) {
+	for {
+		select {
+		case ev := <-ch:
+			s.acceptedHeader.Store(ev.Block.Header())
+		case <-s.acceptedSub.Err():
+			return
+		}
+	}
+}
+
+// AcceptedHeader returns the latest block announced as accepted since startup,
+// or nil if none was announced yet.
+func (s *Ethereum) AcceptedHeader() *types.Header {
+	return s.acceptedHeader.Load()
+}
+
 // Stop implements node.Lifecycle, terminating all internal goroutines used by the
 // Ethereum protocol.
 func (s *Ethereum) Stop() error {
@@ -615,6 +665,9 @@ func (s *Ethereum) Stop() error {
 	s.handler.Stop()
 
 	// Then stop everything else.
+	if s.acceptedSub != nil {
+		s.acceptedSub.Unsubscribe()
+	}
 	s.bloomIndexer.Close()
 	close(s.closeBloomHandler)
 	s.txPool.Close()
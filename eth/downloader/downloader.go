@@ -201,6 +201,10 @@ type BlockChain interface {
 	// InsertReceiptChain inserts a batch of receipts into the local chain.
 	InsertReceiptChain(types.Blocks, []types.Receipts, uint64) (int, error)
 
+	// AncientFreezeThreshold returns the number of recent blocks kept in the
+	// key-value store before freezing.
+	AncientFreezeThreshold() uint64
+
 	// Snapshots returns the blockchain snapshot tree to paused it during sync.
 	Snapshots() *snapshot.Tree
 
@@ -552,8 +556,15 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td, ttd *
 
 		// Legacy sync, use the best announcement we have from the remote peer.
 		// TODO(karalabe): Drop this pathway.
-		if remoteHeight > fullMaxForkAncestry+1 {
-			d.ancientLimit = remoteHeight - fullMaxForkAncestry - 1
+		//
+		// A raised freeze threshold keeps more recent blocks out of the ancient
+		// store, same as the freezer does after the sync.
+		ancestry := fullMaxForkAncestry
+		if threshold := d.blockchain.AncientFreezeThreshold(); threshold > params.FullImmutabilityThreshold {
+			ancestry = threshold
+		}
+		if remoteHeight > ancestry+1 {
+			d.ancientLimit = remoteHeight - ancestry - 1
 		} else {
 			d.ancientLimit = 0
 		}
@@ -1442,6 +1453,12 @@ func (d *Downloader) importBlockResults(results []*fetchResult) error {
 	// transition. Because the downloaded chain is guided by the
 	// consensus-layer.
 	if index, err := d.blockchain.InsertChain(blocks); err != nil {
+		// A resubmitted batch of pruned, already processed blocks is not a sign
+		// of a bad chain, don't fail the sync on it.
+		if errors.Is(err, core.ErrKnownPrunedBlock) {
+			log.Debug("Skipping resubmitted pruned blocks", "firstnum", first.Number, "lastnum", last.Number)
+			return nil
+		}
 		if index < len(results) {
 			log.Debug("Downloaded item processing failed", "number", results[index].Header.Number, "hash", results[index].Header.Hash(), "err", err)
 		} else {
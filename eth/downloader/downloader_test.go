@@ -1477,3 +1477,31 @@ func testBeaconSync(t *testing.T, protocol uint, mode SyncMode) {
 	}
 }
 */
+
+// prunedReimportChain is a chain rejecting every import as a resubmitted batch
+// of pruned blocks.
+type prunedReimportChain struct {
+	*core.BlockChain
+}
+
+func (c prunedReimportChain) InsertChain(blocks types.Blocks) (int, error) {
+	return 0, core.ErrKnownPrunedBlock
+}
+
+// Tests that a resubmitted batch of pruned blocks doesn't fail the sync as an
+// invalid chain.
+func TestImportKnownPrunedBlocks(t *testing.T) {
+	tester := newTester(t)
+	defer tester.terminate()
+
+	tester.downloader.blockchain = prunedReimportChain{tester.chain}
+
+	chain := testChainBase.shorten(3)
+	results := make([]*fetchResult, 0, len(chain.blocks)-1)
+	for _, block := range chain.blocks[1:] {
+		results = append(results, &fetchResult{Header: block.Header(), Transactions: block.Transactions()})
+	}
+	if err := tester.downloader.importBlockResults(results); err != nil {
+		t.Fatalf("import error mismatch: have %v, want nil", err)
+	}
+}
@@ -17,12 +17,19 @@
 package downloader
 
 import (
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
 )
 
+const (
+	stateSyncLogInterval = 10 * time.Second // Interval between state trie download progress reports
+	stateSyncRateAlpha   = 0.1              // Smoothing factor of the trie node download rate
+)
+
 // syncState starts downloading state with the given root hash.
 func (d *Downloader) syncState(root common.Hash) *stateSync {
 	// Create the state sync
@@ -63,6 +70,10 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 	go s.run()
 	defer s.Cancel()
 
+	progress := time.NewTicker(stateSyncLogInterval)
+	defer progress.Stop()
+
+	eta := newStateSyncETA(log.Root(), time.Now())
 	for {
 		select {
 		case next := <-d.stateSyncStart:
@@ -70,10 +81,58 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 
 		case <-s.done:
 			return nil
+
+		case <-progress.C:
+			// The account range download reports its own ETA, only cover the
+			// trie node retrieval
+			synced, pending := d.SnapSyncer.Progress()
+			if synced != nil && pending.TrienodeHeal > 0 {
+				eta.report(time.Now(), synced.TrienodeHealSynced, pending.TrienodeHeal)
+			}
 		}
 	}
 }
 
+// stateSyncETA estimates the time left of the state trie node download from the
+// rate of nodes retrieved, smoothed by an exponential moving average to even out
+// bursty deliveries.
+type stateSyncETA struct {
+	logger  log.Logger
+	rate    float64   // Smoothed download rate in nodes per second
+	synced  uint64    // Number of nodes downloaded at the last report
+	updated time.Time // Time of the last report
+}
+
+func newStateSyncETA(logger log.Logger, now time.Time) *stateSyncETA {
+	return &stateSyncETA{logger: logger, updated: now}
+}
+
+// report folds the nodes downloaded since the last report into the download
+// rate and logs the progress along with the estimated time left.
+func (e *stateSyncETA) report(now time.Time, synced uint64, pending uint64) {
+	elapsed := now.Sub(e.updated).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	var rate float64
+	if synced > e.synced {
+		rate = float64(synced-e.synced) / elapsed
+	}
+	if e.rate == 0 {
+		e.rate = rate
+	} else {
+		e.rate = stateSyncRateAlpha*rate + (1-stateSyncRateAlpha)*e.rate
+	}
+	e.synced, e.updated = synced, now
+
+	var eta time.Duration
+	if e.rate > 0 {
+		eta = time.Duration(float64(pending) / e.rate * float64(time.Second))
+	}
+	e.logger.Info("Syncing: state trie download in progress", "pct", fmt.Sprintf("%.2f%%", float64(synced)*100/float64(synced+pending)),
+		"eta", common.PrettyDuration(eta), "rate", fmt.Sprintf("%.1f/s", e.rate), "pending", pending)
+}
+
 // stateSync schedules requests for downloading a particular state trie defined
 // by a given state root.
 type stateSync struct {
@@ -0,0 +1,73 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Tests that the state trie download progress is reported with an estimate of
+// the time left, derived from the smoothed node download rate.
+func TestStateSyncETA(t *testing.T) {
+	var records []*log.Record
+	logger := log.New()
+	logger.SetHandler(log.FuncHandler(func(r *log.Record) error {
+		records = append(records, r)
+		return nil
+	}))
+	// Simulate the download of a 10000 node trie in bursts
+	var (
+		start  = time.Now()
+		eta    = newStateSyncETA(logger, start)
+		total  = uint64(10000)
+		synced uint64
+	)
+	for i := 1; synced < total; i++ {
+		if i%2 == 0 {
+			synced += 1000
+		} else {
+			synced += 200
+		}
+		if synced > total {
+			synced = total
+		}
+		eta.report(start.Add(time.Duration(i)*stateSyncLogInterval), synced, total-synced)
+	}
+	if len(records) == 0 {
+		t.Fatal("no progress reported")
+	}
+	// Every report but the final one must carry a non-zero estimate
+	for i, r := range records[:len(records)-1] {
+		var have time.Duration
+		for j := 0; j+1 < len(r.Ctx); j += 2 {
+			if r.Ctx[j] == "eta" {
+				have = time.Duration(r.Ctx[j+1].(common.PrettyDuration))
+			}
+		}
+		if have <= 0 {
+			t.Errorf("report %d: missing eta: %v", i, r.Ctx)
+		}
+	}
+	// The smoothed rate must lie between the burst rates
+	if eta.rate < 20 || eta.rate > 100 {
+		t.Errorf("smoothed rate out of bounds: have %.1f/s, want 20-100/s", eta.rate)
+	}
+}
@@ -107,6 +107,7 @@ type Config struct {
 	EnableTrustProtocol bool // Whether enable trust protocol
 	PipeCommit          bool
 	RangeLimit          bool
+	StrictNonceOrder    bool // Whether to reject blocks with out of order nonces per sender
 
 	// Deprecated, use 'TransactionHistory' instead.
 	TxLookupLimit      uint64 `toml:",omitempty"` // The maximum number of blocks from head whose tx indices are reserved.
@@ -114,6 +115,11 @@ type Config struct {
 	StateHistory       uint64 `toml:",omitempty"` // The maximum number of blocks from head whose state histories are reserved.
 	PathSyncFlush      bool   `toml:",omitempty"` // State scheme used to store ethereum state and merkle trie nodes on top
 
+	// AncientFreezeThreshold is the number of recent blocks kept in the key-value
+	// store before they are moved into the ancient store. It can't be lower than
+	// params.FullImmutabilityThreshold.
+	AncientFreezeThreshold uint64 `toml:",omitempty"`
+
 	// State scheme represents the scheme used to store ethereum states and trie
 	// nodes on top. It can be 'hash', 'path', or none which means use the scheme
 	// consistent with persistent state.
@@ -192,6 +198,15 @@ type Config struct {
 	// OverrideKepler (TODO: remove after the fork)
 	OverrideKepler *uint64 `toml:",omitempty"`
 
+	// OverrideDemeter (TODO: remove after the fork)
+	OverrideDemeter *uint64 `toml:",omitempty"`
+
+	// OverrideAthena (TODO: remove after the fork)
+	OverrideAthena *uint64 `toml:",omitempty"`
+
+	// OverrideTheseus (TODO: remove after the fork)
+	OverrideTheseus *uint64 `toml:",omitempty"`
+
 	// OverrideCancun (TODO: remove after the fork)
 	OverrideCancun *uint64 `toml:",omitempty"`
 
@@ -203,7 +218,7 @@ type Config struct {
 // Clique is allowed for now to live standalone, but ethash is forbidden and can
 // only exist on already merged networks.
 func CreateConsensusEngine(config *params.ChainConfig, db ethdb.Database, ee *ethapi.BlockChainAPI, genesisHash common.Hash) (consensus.Engine, error) {
-	if config.Satoshi != nil {
+	if config.IsSatoshi() {
 		return satoshi.New(config, db, ee, genesisHash), nil
 	}
 	// If proof-of-authority is requested, set it up
@@ -32,9 +32,11 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		EnableTrustProtocol     bool
 		PipeCommit              bool
 		RangeLimit              bool
+		StrictNonceOrder        bool
 		TxLookupLimit           uint64                 `toml:",omitempty"`
 		TransactionHistory      uint64                 `toml:",omitempty"`
 		StateHistory            uint64                 `toml:",omitempty"`
+		AncientFreezeThreshold  uint64                 `toml:",omitempty"`
 		StateScheme             string                 `toml:",omitempty"`
 		PathSyncFlush           bool                   `toml:",omitempty"`
 		RequiredBlocks          map[uint64]common.Hash `toml:"-"`
@@ -71,6 +73,9 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		RPCTxFeeCap             float64
 		OverrideShanghai        *uint64 `toml:",omitempty"`
 		OverrideKepler          *uint64 `toml:",omitempty"`
+		OverrideDemeter         *uint64 `toml:",omitempty"`
+		OverrideAthena          *uint64 `toml:",omitempty"`
+		OverrideTheseus         *uint64 `toml:",omitempty"`
 		OverrideCancun          *uint64 `toml:",omitempty"`
 		OverrideVerkle          *uint64 `toml:",omitempty"`
 	}
@@ -90,9 +95,11 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.EnableTrustProtocol = c.EnableTrustProtocol
 	enc.PipeCommit = c.PipeCommit
 	enc.RangeLimit = c.RangeLimit
+	enc.StrictNonceOrder = c.StrictNonceOrder
 	enc.TxLookupLimit = c.TxLookupLimit
 	enc.TransactionHistory = c.TransactionHistory
 	enc.StateHistory = c.StateHistory
+	enc.AncientFreezeThreshold = c.AncientFreezeThreshold
 	enc.StateScheme = c.StateScheme
 	enc.PathSyncFlush = c.PathSyncFlush
 	enc.RequiredBlocks = c.RequiredBlocks
@@ -129,6 +136,9 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.RPCTxFeeCap = c.RPCTxFeeCap
 	enc.OverrideShanghai = c.OverrideShanghai
 	enc.OverrideKepler = c.OverrideKepler
+	enc.OverrideDemeter = c.OverrideDemeter
+	enc.OverrideAthena = c.OverrideAthena
+	enc.OverrideTheseus = c.OverrideTheseus
 	enc.OverrideCancun = c.OverrideCancun
 	enc.OverrideVerkle = c.OverrideVerkle
 	return &enc, nil
@@ -152,9 +162,11 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		EnableTrustProtocol     *bool
 		PipeCommit              *bool
 		RangeLimit              *bool
+		StrictNonceOrder        *bool
 		TxLookupLimit           *uint64                `toml:",omitempty"`
 		TransactionHistory      *uint64                `toml:",omitempty"`
 		StateHistory            *uint64                `toml:",omitempty"`
+		AncientFreezeThreshold  *uint64                `toml:",omitempty"`
 		StateScheme             *string                `toml:",omitempty"`
 		PathSyncFlush           *bool                  `toml:",omitempty"`
 		RequiredBlocks          map[uint64]common.Hash `toml:"-"`
@@ -191,6 +203,9 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		RPCTxFeeCap             *float64
 		OverrideShanghai        *uint64 `toml:",omitempty"`
 		OverrideKepler          *uint64 `toml:",omitempty"`
+		OverrideDemeter         *uint64 `toml:",omitempty"`
+		OverrideAthena          *uint64 `toml:",omitempty"`
+		OverrideTheseus         *uint64 `toml:",omitempty"`
 		OverrideCancun          *uint64 `toml:",omitempty"`
 		OverrideVerkle          *uint64 `toml:",omitempty"`
 	}
@@ -243,6 +258,9 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.RangeLimit != nil {
 		c.RangeLimit = *dec.RangeLimit
 	}
+	if dec.StrictNonceOrder != nil {
+		c.StrictNonceOrder = *dec.StrictNonceOrder
+	}
 	if dec.TxLookupLimit != nil {
 		c.TxLookupLimit = *dec.TxLookupLimit
 	}
@@ -252,6 +270,9 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.StateHistory != nil {
 		c.StateHistory = *dec.StateHistory
 	}
+	if dec.AncientFreezeThreshold != nil {
+		c.AncientFreezeThreshold = *dec.AncientFreezeThreshold
+	}
 	if dec.StateScheme != nil {
 		c.StateScheme = *dec.StateScheme
 	}
@@ -360,6 +381,15 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.OverrideKepler != nil {
 		c.OverrideKepler = dec.OverrideKepler
 	}
+	if dec.OverrideDemeter != nil {
+		c.OverrideDemeter = dec.OverrideDemeter
+	}
+	if dec.OverrideAthena != nil {
+		c.OverrideAthena = dec.OverrideAthena
+	}
+	if dec.OverrideTheseus != nil {
+		c.OverrideTheseus = dec.OverrideTheseus
+	}
 	if dec.OverrideCancun != nil {
 		c.OverrideCancun = dec.OverrideCancun
 	}
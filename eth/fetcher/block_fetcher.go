@@ -925,6 +925,13 @@ func (f *BlockFetcher) importBlocks(op *blockOrHeaderInject) {
 		}
 		// Run the actual import and log any issues
 		if _, err := f.insertChain(types.Blocks{block}); err != nil {
+			// A peer disagreeing on the validator set of an epoch is on another
+			// fork, it will keep feeding us blocks we can't import
+			if errors.Is(err, consensus.ErrEpochCheckpointMismatch) {
+				log.Warn("Propagated epoch block on incompatible fork", "peer", peer, "number", block.Number(), "hash", hash, "err", err)
+				f.dropPeer(peer)
+				return
+			}
 			log.Debug("Propagated block import failed", "peer", peer, "number", block.Number(), "hash", hash, "err", err)
 			return
 		}
@@ -18,6 +18,7 @@ package fetcher
 
 import (
 	"errors"
+	"fmt"
 	"math/big"
 	"sync"
 	"sync/atomic"
@@ -25,6 +26,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
@@ -674,6 +676,51 @@ func TestImportDeduplication(t *testing.T) {
 	}
 }
 
+// Tests that peers propagating blocks which fail import on an epoch checkpoint
+// mismatch get dropped, while other import failures are tolerated.
+func TestEpochCheckpointMismatchDrop(t *testing.T) {
+	_, blocks := makeChain(1, 0, genesis)
+	var block *types.Block
+	for _, b := range blocks {
+		if b.NumberU64() == 1 {
+			block = b
+		}
+	}
+	for _, tt := range []struct {
+		err  error
+		drop bool
+	}{
+		{errors.New("invalid merkle root"), false},
+		{fmt.Errorf("%w: have %x, want %x", consensus.ErrEpochCheckpointMismatch, common.Hash{1}, common.Hash{2}), true},
+	} {
+		tester := newTester(false)
+		inserted := make(chan struct{})
+		tester.fetcher.insertChain = func(blocks types.Blocks) (int, error) {
+			close(inserted)
+			return 0, tt.err
+		}
+		tester.fetcher.Enqueue("forked", block)
+		select {
+		case <-inserted:
+		case <-time.After(time.Second):
+			t.Fatalf("err %v: block not imported", tt.err)
+		}
+		// The peer is dropped after the import returns, give it some time
+		var dropped bool
+		for i := 0; i < 20 && !dropped; i++ {
+			time.Sleep(10 * time.Millisecond)
+
+			tester.lock.RLock()
+			dropped = tester.drops["forked"]
+			tester.lock.RUnlock()
+		}
+		if dropped != tt.drop {
+			t.Errorf("err %v: peer drop mismatch: have %v, want %v", tt.err, dropped, tt.drop)
+		}
+		tester.fetcher.Stop()
+	}
+}
+
 // Tests that blocks with numbers much lower or higher than out current head get
 // discarded to prevent wasting resources on useless blocks from faulty peers.
 func TestDistantPropagationDiscarding(t *testing.T) {
@@ -69,8 +69,7 @@ type Backend interface {
 	SubscribeNewTxsEvent(chan<- core.NewTxsEvent) event.Subscription
 	SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subscription
 	SubscribeFinalizedHeaderEvent(ch chan<- core.FinalizedHeaderEvent) event.Subscription
-	SubscribeRemovedLogsEvent(ch chan<- core.RemovedLogsEvent) event.Subscription
-	SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscription
+	SubscribeCanonicalLogsEvent(ch chan<- core.CanonicalLogsEvent) event.Subscription
 	SubscribePendingLogsEvent(ch chan<- []*types.Log) event.Subscription
 	SubscribeNewVoteEvent(chan<- core.NewVoteEvent) event.Subscription
 
@@ -175,9 +174,7 @@ const (
 	// txChanSize is the size of channel listening to NewTxsEvent.
 	// The number is referenced from the size of tx pool.
 	txChanSize = 4096
-	// rmLogsChanSize is the size of channel listening to RemovedLogsEvent.
-	rmLogsChanSize = 10
-	// logsChanSize is the size of channel listening to LogsEvent.
+	// logsChanSize is the size of channel listening to CanonicalLogsEvent and PendingLogsEvent.
 	logsChanSize = 10
 	// chainEvChanSize is the size of channel listening to ChainEvent.
 	chainEvChanSize = 10
@@ -209,8 +206,7 @@ type EventSystem struct {
 
 	// Subscriptions
 	txsSub             event.Subscription // Subscription for new transaction event
-	logsSub            event.Subscription // Subscription for new log event
-	rmLogsSub          event.Subscription // Subscription for removed log event
+	logsSub            event.Subscription // Subscription for new and removed log events
 	pendingLogsSub     event.Subscription // Subscription for pending log event
 	chainSub           event.Subscription // Subscription for new chain event
 	finalizedHeaderSub event.Subscription // Subscription for new finalized header
@@ -220,9 +216,8 @@ type EventSystem struct {
 	install           chan *subscription             // install filter for event notification
 	uninstall         chan *subscription             // remove filter for event notification
 	txsCh             chan core.NewTxsEvent          // Channel to receive new transactions event
-	logsCh            chan []*types.Log              // Channel to receive new log event
+	logsCh            chan core.CanonicalLogsEvent   // Channel to receive new and removed log events
 	pendingLogsCh     chan []*types.Log              // Channel to receive new log event
-	rmLogsCh          chan core.RemovedLogsEvent     // Channel to receive removed log event
 	chainCh           chan core.ChainEvent           // Channel to receive new chain event
 	finalizedHeaderCh chan core.FinalizedHeaderEvent // Channel to receive new finalized header event
 	voteCh            chan core.NewVoteEvent         // Channel to receive new vote event
@@ -241,8 +236,7 @@ func NewEventSystem(sys *FilterSystem) *EventSystem {
 		install:           make(chan *subscription),
 		uninstall:         make(chan *subscription),
 		txsCh:             make(chan core.NewTxsEvent, txChanSize),
-		logsCh:            make(chan []*types.Log, logsChanSize),
-		rmLogsCh:          make(chan core.RemovedLogsEvent, rmLogsChanSize),
+		logsCh:            make(chan core.CanonicalLogsEvent, logsChanSize),
 		pendingLogsCh:     make(chan []*types.Log, logsChanSize),
 		chainCh:           make(chan core.ChainEvent, chainEvChanSize),
 		finalizedHeaderCh: make(chan core.FinalizedHeaderEvent, finalizedHeaderEvChanSize),
@@ -251,15 +245,14 @@ func NewEventSystem(sys *FilterSystem) *EventSystem {
 
 	// Subscribe events
 	m.txsSub = m.backend.SubscribeNewTxsEvent(m.txsCh)
-	m.logsSub = m.backend.SubscribeLogsEvent(m.logsCh)
-	m.rmLogsSub = m.backend.SubscribeRemovedLogsEvent(m.rmLogsCh)
+	m.logsSub = m.backend.SubscribeCanonicalLogsEvent(m.logsCh)
 	m.chainSub = m.backend.SubscribeChainEvent(m.chainCh)
 	m.pendingLogsSub = m.backend.SubscribePendingLogsEvent(m.pendingLogsCh)
 	m.finalizedHeaderSub = m.backend.SubscribeFinalizedHeaderEvent(m.finalizedHeaderCh)
 	m.voteSub = m.backend.SubscribeNewVoteEvent(m.voteCh)
 
 	// Make sure none of the subscriptions are empty
-	if m.txsSub == nil || m.logsSub == nil || m.rmLogsSub == nil || m.chainSub == nil || m.pendingLogsSub == nil {
+	if m.txsSub == nil || m.logsSub == nil || m.chainSub == nil || m.pendingLogsSub == nil {
 		log.Crit("Subscribe for event system failed")
 	}
 	if m.voteSub == nil || m.finalizedHeaderSub == nil {
@@ -533,7 +526,6 @@ func (es *EventSystem) eventLoop() {
 	defer func() {
 		es.txsSub.Unsubscribe()
 		es.logsSub.Unsubscribe()
-		es.rmLogsSub.Unsubscribe()
 		es.pendingLogsSub.Unsubscribe()
 		es.chainSub.Unsubscribe()
 		es.finalizedHeaderSub.Unsubscribe()
@@ -556,8 +548,6 @@ func (es *EventSystem) eventLoop() {
 		case ev := <-es.txsCh:
 			es.handleTxsEvent(index, ev)
 		case ev := <-es.logsCh:
-			es.handleLogs(index, ev)
-		case ev := <-es.rmLogsCh:
 			es.handleLogs(index, ev.Logs)
 		case ev := <-es.pendingLogsCh:
 			es.handlePendingLogs(index, ev)
@@ -593,8 +583,6 @@ func (es *EventSystem) eventLoop() {
 			return
 		case <-es.logsSub.Err():
 			return
-		case <-es.rmLogsSub.Err():
-			return
 		case <-es.chainSub.Err():
 			return
 		case <-es.finalizedHeaderSub.Err():
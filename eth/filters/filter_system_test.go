@@ -46,7 +46,6 @@ type testBackend struct {
 	sections            uint64
 	txFeed              event.Feed
 	logsFeed            event.Feed
-	rmLogsFeed          event.Feed
 	pendingLogsFeed     event.Feed
 	chainFeed           event.Feed
 	finalizedHeaderFeed event.Feed
@@ -129,11 +128,7 @@ func (b *testBackend) SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Sub
 	return b.txFeed.Subscribe(ch)
 }
 
-func (b *testBackend) SubscribeRemovedLogsEvent(ch chan<- core.RemovedLogsEvent) event.Subscription {
-	return b.rmLogsFeed.Subscribe(ch)
-}
-
-func (b *testBackend) SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscription {
+func (b *testBackend) SubscribeCanonicalLogsEvent(ch chan<- core.CanonicalLogsEvent) event.Subscription {
 	return b.logsFeed.Subscribe(ch)
 }
 
@@ -525,7 +520,7 @@ func TestLogFilter(t *testing.T) {
 
 	// raise events
 	time.Sleep(1 * time.Second)
-	if nsend := backend.logsFeed.Send(allLogs); nsend == 0 {
+	if nsend := backend.logsFeed.Send(core.CanonicalLogsEvent{Logs: allLogs}); nsend == 0 {
 		t.Fatal("Logs event not delivered")
 	}
 	if nsend := backend.pendingLogsFeed.Send(allLogs); nsend == 0 {
@@ -750,6 +745,89 @@ func TestPendingLogsSubscription(t *testing.T) {
 	}
 }
 
+// TestLogsSubscriptionReorgOrder tests that concurrent log subscriptions observe
+// the logs removed by a reorg after they were added and before the logs of the
+// new canonical chain, in the order the chain posted them.
+func TestLogsSubscriptionReorgOrder(t *testing.T) {
+	t.Parallel()
+
+	var (
+		db           = rawdb.NewMemoryDatabase()
+		backend, sys = newTestFilterSystem(t, db, Config{})
+		api          = NewFilterAPI(sys, false)
+		addr         = common.HexToAddress("0x1111111111111111111111111111111111111111")
+
+		events   []core.CanonicalLogsEvent
+		expected []*types.Log
+	)
+	// Each round adds a block, reorgs it out and adds its replacement
+	for n := uint64(1); n <= 32; n++ {
+		var (
+			old     = &types.Log{Address: addr, BlockNumber: n, TxHash: common.Hash{0x01, byte(n)}}
+			removed = &types.Log{Address: addr, BlockNumber: n, TxHash: common.Hash{0x01, byte(n)}, Removed: true}
+			reborn  = &types.Log{Address: addr, BlockNumber: n, TxHash: common.Hash{0x02, byte(n)}}
+		)
+		events = append(events,
+			core.CanonicalLogsEvent{Logs: []*types.Log{old}},
+			core.CanonicalLogsEvent{Logs: []*types.Log{removed}},
+			core.CanonicalLogsEvent{Logs: []*types.Log{reborn}},
+		)
+		expected = append(expected, old, removed, reborn)
+	}
+	const subscribers = 8
+
+	var (
+		chans = make([]chan []*types.Log, subscribers)
+		subs  = make([]*Subscription, subscribers)
+		errs  = make(chan error, subscribers)
+	)
+	for i := 0; i < subscribers; i++ {
+		chans[i] = make(chan []*types.Log)
+
+		var err error
+		if subs[i], err = api.events.SubscribeLogs(ethereum.FilterQuery{}, chans[i]); err != nil {
+			t.Fatalf("SubscribeLogs %d failed: %v", i, err)
+		}
+	}
+	for i := 0; i < subscribers; i++ {
+		go func(i int) {
+			defer subs[i].Unsubscribe()
+
+			var (
+				fetched []*types.Log
+				timeout = time.After(5 * time.Second)
+			)
+			for len(fetched) < len(expected) {
+				select {
+				case logs := <-chans[i]:
+					fetched = append(fetched, logs...)
+				case <-timeout:
+					errs <- fmt.Errorf("subscriber %d: received %d logs, want %d", i, len(fetched), len(expected))
+					return
+				}
+			}
+			for j, log := range fetched {
+				if log.TxHash != expected[j].TxHash || log.Removed != expected[j].Removed {
+					errs <- fmt.Errorf("subscriber %d: log %d mismatch: have %x (removed %v), want %x (removed %v)",
+						i, j, log.TxHash, log.Removed, expected[j].TxHash, expected[j].Removed)
+					return
+				}
+			}
+			errs <- nil
+		}(i)
+	}
+	for _, ev := range events {
+		if nsend := backend.logsFeed.Send(ev); nsend == 0 {
+			t.Fatal("Logs event not delivered")
+		}
+	}
+	for i := 0; i < subscribers; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
 // TestPendingTxFilterDeadlock tests if the event loop hangs when pending
 // txes arrive at the same time that one of multiple filters is timing out.
 // Please refer to #22131 for more details.
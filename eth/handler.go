@@ -136,6 +136,7 @@ type handler struct {
 	directBroadcast bool
 
 	database             ethdb.Database
+	propagations         *propagationTracker
 	txpool               txPool
 	votepool             votePool
 	maliciousVoteMonitor *monitor.MaliciousVoteMonitor
@@ -189,6 +190,7 @@ func newHandler(config *handlerConfig) (*handler, error) {
 		disablePeerTxBroadcast: config.DisablePeerTxBroadcast,
 		eventMux:               config.EventMux,
 		database:               config.Database,
+		propagations:           newPropagationTracker(),
 		txpool:                 config.TxPool,
 		votepool:               config.VotePool,
 		chain:                  config.Chain,
@@ -577,7 +579,9 @@ func (h *handler) runSnapExtension(peer *snap.Peer, handler snap.Handler) error
 				snap.EgressRegistrationErrorMeter.Mark(1)
 			}
 		}
-		peer.Log().Warn("Snapshot extension registration failed", "err", err)
+		peer.Log().Warn("Snapshot extension registration failed", "err", err,
+			"local.eth", eth.ProtocolVersions, "local.snap", snap.ProtocolVersions,
+			"peer.snap", peer.Version(), "peer.caps", peer.Caps())
 		return err
 	}
 	return handler(peer)
@@ -933,6 +937,10 @@ func (h *handler) minedBroadcastLoop() {
 				continue
 			}
 			if ev, ok := obj.Data.(core.NewMinedBlockEvent); ok {
+				// Only measure the propagation of our own blocks, relayed ones
+				// would measure how fast peers echo them back
+				h.propagations.broadcast(ev.Block.Hash(), h.peers.len(), time.Now())
+
 				h.BroadcastBlock(ev.Block, true)  // First propagate block to peers
 				h.BroadcastBlock(ev.Block, false) // Only then announce to the rest
 			}
@@ -100,6 +100,7 @@ func (h *ethHandler) handleBlockAnnounces(peer *eth.Peer, hashes []common.Hash,
 		unknownNumbers = make([]uint64, 0, len(numbers))
 	)
 	for i := 0; i < len(hashes); i++ {
+		h.propagations.received(hashes[i], time.Now())
 		if !h.chain.HasBlock(hashes[i], numbers[i]) {
 			unknownHashes = append(unknownHashes, hashes[i])
 			unknownNumbers = append(unknownNumbers, numbers[i])
@@ -123,6 +124,8 @@ func (h *ethHandler) handleBlockBroadcast(peer *eth.Peer, block *types.Block, td
 		return nil
 		// return errors.New("unexpected block announces")
 	}
+	h.propagations.received(block.Hash(), time.Now())
+
 	// Schedule the block for import
 	h.blockFetcher.Enqueue(peer.ID(), block)
 
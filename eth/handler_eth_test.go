@@ -37,6 +37,7 @@ import (
 	"github.com/ethereum/go-ethereum/eth/protocols/bsc"
 	"github.com/ethereum/go-ethereum/eth/protocols/eth"
 	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/params"
@@ -863,3 +864,64 @@ func TestOptionMaxPeersPerIP(t *testing.T) {
 	}
 	close(doneCh5)
 }
+
+// Tests that block propagation latencies are measured from the first broadcast
+// to the first echo of the block, in the bucket of the peer count at broadcast.
+func TestBlockPropagationLatency(t *testing.T) {
+	var (
+		tracker = newPropagationTracker()
+		hash    = common.Hash{0x01}
+		start   = time.Now()
+		timer   = propagationTimer(30)
+		count   = timer.Snapshot().Count()
+	)
+	if timer != propagationTimers[2] || propagationTimer(10) != propagationTimers[0] || propagationTimer(1000) != propagationTimers[4] {
+		t.Fatal("peer count bucket mismatch")
+	}
+	// Blocks never broadcast are not measured
+	if _, ok := tracker.received(hash, start); ok {
+		t.Fatal("latency measured for unknown block")
+	}
+	// Only the first broadcast and the first echo count
+	tracker.broadcast(hash, 30, start)
+	tracker.broadcast(hash, 30, start.Add(time.Second))
+
+	latency, ok := tracker.received(hash, start.Add(500*time.Millisecond))
+	if !ok || latency != 500*time.Millisecond {
+		t.Fatalf("latency mismatch: have %v (%v), want %v", latency, ok, 500*time.Millisecond)
+	}
+	if _, ok := tracker.received(hash, start.Add(time.Second)); ok {
+		t.Fatal("latency measured twice")
+	}
+	if metrics.Enabled {
+		if have := timer.Snapshot().Count(); have != count+1 {
+			t.Fatalf("timer count mismatch: have %d, want %d", have, count+1)
+		}
+	}
+}
+
+// Tests that only the propagation of locally mined blocks is tracked, relayed
+// blocks are broadcast without waiting for their echo.
+func TestBlockPropagationMinedOnly(t *testing.T) {
+	handler := newTestHandlerWithBlocks(2)
+	defer handler.close()
+
+	relayed := handler.chain.GetBlockByNumber(1)
+	handler.handler.BroadcastBlock(relayed, true)
+	handler.handler.BroadcastBlock(relayed, false)
+	if _, ok := handler.handler.propagations.received(relayed.Hash(), time.Now()); ok {
+		t.Fatal("propagation of relayed block tracked")
+	}
+	mined := handler.chain.GetBlockByNumber(2)
+	if err := handler.handler.eventMux.Post(core.NewMinedBlockEvent{Block: mined}); err != nil {
+		t.Fatalf("failed to post mined block: %v", err)
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, ok := handler.handler.propagations.received(mined.Hash(), time.Now()); ok {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("propagation of mined block not tracked")
+		}
+	}
+}
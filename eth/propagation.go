@@ -0,0 +1,109 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/lru"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// maxTrackedPropagations is the number of recently mined blocks awaiting their
+// first echo from a peer. Blocks never echoed back are evicted.
+const maxTrackedPropagations = 128
+
+// propagationPeerBuckets are the upper bounds of the peer count buckets the
+// propagation latencies are grouped by, anything above the last one lands in
+// an overflow bucket.
+var propagationPeerBuckets = []int{10, 25, 50, 100}
+
+// propagationTimers measure the time between broadcasting a locally mined block
+// and first receiving it back from a peer, per peer count bucket at broadcast
+// time. The metrics library has no labels, so every bucket gets its own timer,
+// registered as eth/propagation/latency/peers_le<bound>, and the overflow one
+// as eth/propagation/latency/peers_gt<bound>.
+var propagationTimers = func() []metrics.Timer {
+	timers := make([]metrics.Timer, 0, len(propagationPeerBuckets)+1)
+	for _, bound := range propagationPeerBuckets {
+		timers = append(timers, metrics.NewRegisteredTimer(fmt.Sprintf("eth/propagation/latency/peers_le%d", bound), nil))
+	}
+	last := propagationPeerBuckets[len(propagationPeerBuckets)-1]
+	return append(timers, metrics.NewRegisteredTimer(fmt.Sprintf("eth/propagation/latency/peers_gt%d", last), nil))
+}()
+
+// propagationTimer returns the latency timer of the given peer count.
+func propagationTimer(peers int) metrics.Timer {
+	for i, bound := range propagationPeerBuckets {
+		if peers <= bound {
+			return propagationTimers[i]
+		}
+	}
+	return propagationTimers[len(propagationPeerBuckets)]
+}
+
+// broadcastBlock is the record of a block broadcast awaiting its first echo.
+type broadcastBlock struct {
+	time  time.Time // Time of the first broadcast
+	peers int       // Number of connected peers at broadcast time
+}
+
+// propagationTracker matches the blocks received from peers against the locally
+// mined ones recently broadcast, to measure how fast our blocks propagate
+// through the network.
+type propagationTracker struct {
+	sent lru.BasicLRU[common.Hash, broadcastBlock]
+	lock sync.Mutex
+}
+
+func newPropagationTracker() *propagationTracker {
+	return &propagationTracker{
+		sent: lru.NewBasicLRU[common.Hash, broadcastBlock](maxTrackedPropagations),
+	}
+}
+
+// broadcast records the first broadcast of a block, later ones are ignored.
+func (t *propagationTracker) broadcast(hash common.Hash, peers int, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.sent.Contains(hash) {
+		t.sent.Add(hash, broadcastBlock{time: now, peers: peers})
+	}
+}
+
+// received matches a block received from a peer against the broadcast ones,
+// recording the propagation latency on the first echo. The latency is returned
+// along with whether the block was awaited at all.
+func (t *propagationTracker) received(hash common.Hash, now time.Time) (time.Duration, bool) {
+	t.lock.Lock()
+	sent, ok := t.sent.Get(hash)
+	if ok {
+		t.sent.Remove(hash)
+	}
+	t.lock.Unlock()
+
+	if !ok {
+		return 0, false
+	}
+	latency := now.Sub(sent.time)
+	propagationTimer(sent.peers).Update(latency)
+	return latency, true
+}
@@ -53,6 +53,9 @@ type Request struct {
 
 	Peer string    // Demultiplexer if cross-peer requests are batched together
 	Sent time.Time // Timestamp when the request was sent
+
+	primary   *Request   // Identical request whose reply this one is waiting for (nil if sent itself)
+	coalesced []*Request // Identical requests waiting for the reply of this one
 }
 
 // Close aborts an in-flight request. Although there's no way to notify the
@@ -157,41 +160,78 @@ func (p *Peer) dispatchResponse(res *Response, metadata func() interface{}) erro
 		if metadata != nil {
 			res.Meta = metadata()
 		}
-		// Deliver the filled out response and wait until it's handled. This
-		// path is a bit funky as Go's select has no order, so if a response
-		// arrives to an already cancelled request, there's a 50-50% changes
-		// of picking on channel or the other. To avoid such cases delivering
-		// the packet upstream, check for cancellation first and only after
-		// block on delivery.
-		select {
-		case <-res.Req.cancel:
-			return nil // Request cancelled, silently discard response
-		default:
-			// Request not yet cancelled, attempt to deliver it, but do watch
-			// for fresh cancellations too
-			select {
-			case res.Req.sink <- res:
-				return <-res.Done // Response delivered, return any errors
-			case <-res.Req.cancel:
-				return nil // Request cancelled, silently discard response
+		// Deliver the filled out response to the original requester and any
+		// identical requests coalesced into it, reporting the first failure
+		err := deliverResponse(res)
+		for _, req := range res.Req.coalesced {
+			shared := &Response{
+				id:   req.id,
+				recv: res.recv,
+				code: res.code,
+				Req:  req,
+				Res:  res.Res,
+				Meta: res.Meta,
+				Time: res.recv.Sub(req.Sent),
+				Done: make(chan error),
+			}
+			if serr := deliverResponse(shared); err == nil {
+				err = serr
 			}
 		}
+		return err
 
 	case <-p.term:
 		return errDisconnected
 	}
 }
 
+// deliverResponse delivers a filled out response to its requester and waits
+// until it's handled. This path is a bit funky as Go's select has no order, so
+// if a response arrives to an already cancelled request, there's a 50-50%
+// changes of picking on channel or the other. To avoid such cases delivering the
+// packet upstream, check for cancellation first and only after block on delivery.
+func deliverResponse(res *Response) error {
+	select {
+	case <-res.Req.cancel:
+		return nil // Request cancelled, silently discard response
+	default:
+		// Request not yet cancelled, attempt to deliver it, but do watch
+		// for fresh cancellations too
+		select {
+		case res.Req.sink <- res:
+			return <-res.Done // Response delivered, return any errors
+		case <-res.Req.cancel:
+			return nil // Request cancelled, silently discard response
+		}
+	}
+}
+
 // dispatcher is a loop that accepts requests from higher layer packages, pushes
 // it to the network and tracks and dispatches the responses back to the original
 // requester.
 func (p *Peer) dispatcher() {
-	pending := make(map[uint64]*Request)
-
+	var (
+		pending   = make(map[uint64]*Request)
+		coalesced = make(map[uint64]*Request)                // Requests waiting for the reply of an identical one
+		inflight  = make(map[GetBlockHeadersPacket]*Request) // Header requests identical ones can coalesce into
+	)
 	for {
 		select {
 		case reqOp := <-p.reqDispatch:
 			req := reqOp.req
+
+			// If an identical header request was sent recently, wait for its
+			// reply instead of asking the remote peer for the same data again
+			query, coalescable := p.headerQuery(req)
+			if coalescable {
+				if primary := inflight[query]; primary != nil && time.Since(primary.Sent) <= p.coalesceWindow {
+					req.Sent, req.primary = primary.Sent, primary
+					primary.coalesced = append(primary.coalesced, req)
+					coalesced[req.id] = req
+					reqOp.fail <- nil
+					continue
+				}
+			}
 			req.Sent = time.Now()
 
 			requestTracker.Track(p.id, p.version, req.code, req.want, req.id)
@@ -200,9 +240,25 @@ func (p *Peer) dispatcher() {
 
 			if err == nil {
 				pending[req.id] = req
+				if coalescable {
+					inflight[query] = req
+				}
 			}
 
 		case cancelOp := <-p.reqCancel:
+			// If the request is waiting for an identical one, detach it
+			if req := coalesced[cancelOp.id]; req != nil {
+				primary := req.primary
+				for i, waiting := range primary.coalesced {
+					if waiting == req {
+						primary.coalesced = append(primary.coalesced[:i], primary.coalesced[i+1:]...)
+						break
+					}
+				}
+				delete(coalesced, cancelOp.id)
+				cancelOp.fail <- nil
+				continue
+			}
 			// Retrieve the pending request to cancel and short circuit if it
 			// has already been serviced and is not available anymore
 			req := pending[cancelOp.id]
@@ -210,10 +266,35 @@ func (p *Peer) dispatcher() {
 				cancelOp.fail <- nil
 				continue
 			}
-			// Stop tracking the request
+			// Stop tracking the request and don't let new requests coalesce into it
 			delete(pending, cancelOp.id)
+			if query, ok := p.headerQuery(req); ok && inflight[query] == req {
+				delete(inflight, query)
+			}
 			cancelOp.fail <- nil
 
+			// If identical requests were waiting for the cancelled one, send the
+			// first of them in its stead and let the rest wait for that one
+			if len(req.coalesced) == 0 {
+				continue
+			}
+			next, waiting := req.coalesced[0], req.coalesced[1:]
+			delete(coalesced, next.id)
+
+			next.Sent, next.primary, next.coalesced = time.Now(), nil, waiting
+			for _, waiter := range waiting {
+				waiter.primary = next
+			}
+			requestTracker.Track(p.id, p.version, next.code, next.want, next.id)
+			if err := p2p.Send(p.rw, next.code, next.data); err != nil {
+				p.Log().Debug("Failed to resend coalesced request", "id", next.id, "err", err)
+				continue
+			}
+			pending[next.id] = next
+			if query, ok := p.headerQuery(next); ok {
+				inflight[query] = next
+			}
+
 		case resOp := <-p.resDispatch:
 			res := resOp.res
 			res.Req = pending[res.id]
@@ -244,6 +325,12 @@ func (p *Peer) dispatcher() {
 
 				// Stop tracking the request, the response dispatcher will deliver
 				delete(pending, res.id)
+				if query, ok := p.headerQuery(res.Req); ok && inflight[query] == res.Req {
+					delete(inflight, query)
+				}
+				for _, req := range res.Req.coalesced {
+					delete(coalesced, req.id)
+				}
 			}
 
 		case <-p.term:
@@ -251,3 +338,16 @@ func (p *Peer) dispatcher() {
 		}
 	}
 }
+
+// headerQuery returns the query of a header request, used to coalesce identical
+// requests. Requests of other types are never coalesced.
+func (p *Peer) headerQuery(req *Request) (GetBlockHeadersPacket, bool) {
+	if p.coalesceWindow <= 0 {
+		return GetBlockHeadersPacket{}, false
+	}
+	packet, ok := req.data.(*GetBlockHeadersPacket66)
+	if !ok {
+		return GetBlockHeadersPacket{}, false
+	}
+	return *packet.GetBlockHeadersPacket, true
+}
@@ -21,7 +21,10 @@ package eth
 
 import (
 	"crypto/rand"
+	"math/big"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/p2p"
@@ -88,3 +91,176 @@ func TestPeerSet(t *testing.T) {
 		t.Fatalf("bad size")
 	}
 }
+
+// Tests that identical header requests fired concurrently are coalesced into a
+// single network request, with the reply delivered to every requester.
+func TestCoalesceHeaderRequests(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+
+	var id enode.ID
+	rand.Read(id[:])
+	peer := NewPeer(ETH68, p2p.NewPeer(id, "peer", nil), net, nil)
+	peer.coalesceWindow = time.Minute
+	defer peer.Close()
+
+	// Collect the requests reaching the remote side
+	sent := make(chan *GetBlockHeadersPacket66, 10)
+	go func() {
+		for {
+			msg, err := app.ReadMsg()
+			if err != nil {
+				return
+			}
+			packet := new(GetBlockHeadersPacket66)
+			if err := msg.Decode(packet); err != nil {
+				t.Errorf("failed to decode request: %v", err)
+			}
+			sent <- packet
+		}
+	}()
+	// Fire the identical requests concurrently
+	var (
+		sink = make(chan *Response)
+		reqs = make(map[*Request]bool)
+		lock sync.Mutex
+		wg   sync.WaitGroup
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, err := peer.RequestHeadersByNumber(100, 16, 0, false, sink)
+			if err != nil {
+				t.Errorf("failed to request headers: %v", err)
+				return
+			}
+			lock.Lock()
+			reqs[req] = false
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	var request *GetBlockHeadersPacket66
+	select {
+	case request = <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("header request not sent")
+	}
+	select {
+	case extra := <-sent:
+		t.Fatalf("duplicate header request sent: %v", extra.GetBlockHeadersPacket)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Reply to the single request and ensure everyone gets it
+	headers := BlockHeadersPacket{{Number: big.NewInt(100)}}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- peer.dispatchResponse(&Response{id: request.RequestId, code: BlockHeadersMsg, Res: &headers}, nil)
+	}()
+	for i := 0; i < 10; i++ {
+		select {
+		case res := <-sink:
+			if delivered, ok := reqs[res.Req]; !ok || delivered {
+				t.Fatalf("response %d: unexpected or duplicate delivery", i)
+			}
+			reqs[res.Req] = true
+			if have := *res.Res.(*BlockHeadersPacket); len(have) != 1 || have[0].Number.Uint64() != 100 {
+				t.Fatalf("response %d: unexpected headers: %v", i, have)
+			}
+			res.Done <- nil
+		case <-time.After(time.Second):
+			t.Fatalf("response %d: not delivered", i)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("failed to dispatch response: %v", err)
+	}
+}
+
+// Tests that cancelling a header request which identical ones are waiting for
+// stops tracking it, and sends the first waiting request in its stead.
+func TestCoalescedHeaderRequestCancel(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+
+	var id enode.ID
+	rand.Read(id[:])
+	peer := NewPeer(ETH68, p2p.NewPeer(id, "peer", nil), net, nil)
+	peer.coalesceWindow = time.Minute
+	defer peer.Close()
+
+	sent := make(chan *GetBlockHeadersPacket66, 10)
+	go func() {
+		for {
+			msg, err := app.ReadMsg()
+			if err != nil {
+				return
+			}
+			packet := new(GetBlockHeadersPacket66)
+			if err := msg.Decode(packet); err != nil {
+				t.Errorf("failed to decode request: %v", err)
+			}
+			sent <- packet
+		}
+	}()
+	waitSent := func() *GetBlockHeadersPacket66 {
+		select {
+		case packet := <-sent:
+			return packet
+		case <-time.After(time.Second):
+			t.Fatal("header request not sent")
+			return nil
+		}
+	}
+	primarySink, waiterSink := make(chan *Response, 1), make(chan *Response, 1)
+	primary, err := peer.RequestHeadersByNumber(100, 16, 0, false, primarySink)
+	if err != nil {
+		t.Fatalf("failed to request headers: %v", err)
+	}
+	if packet := waitSent(); packet.RequestId != primary.id {
+		t.Fatalf("request id mismatch: have %d, want %d", packet.RequestId, primary.id)
+	}
+	waiter, err := peer.RequestHeadersByNumber(100, 16, 0, false, waiterSink)
+	if err != nil {
+		t.Fatalf("failed to request headers: %v", err)
+	}
+	// Cancelling the sent request sends the waiting one
+	if err := primary.Close(); err != nil {
+		t.Fatalf("failed to cancel request: %v", err)
+	}
+	if packet := waitSent(); packet.RequestId != waiter.id {
+		t.Fatalf("resent request id mismatch: have %d, want %d", packet.RequestId, waiter.id)
+	}
+	// A late reply to the cancelled request is no longer tracked
+	headers := BlockHeadersPacket{{Number: big.NewInt(100)}}
+	if err := peer.dispatchResponse(&Response{id: primary.id, code: BlockHeadersMsg, Res: &headers}, nil); err != nil {
+		t.Fatalf("failed to dispatch late response: %v", err)
+	}
+	select {
+	case res := <-primarySink:
+		t.Fatalf("cancelled request delivered: %v", res)
+	case res := <-waiterSink:
+		t.Fatalf("waiting request delivered the cancelled reply: %v", res)
+	default:
+	}
+	// The reply to the resent request is delivered to it
+	errc := make(chan error, 1)
+	go func() {
+		errc <- peer.dispatchResponse(&Response{id: waiter.id, code: BlockHeadersMsg, Res: &headers}, nil)
+	}()
+	select {
+	case res := <-waiterSink:
+		if res.Req != waiter {
+			t.Fatalf("response delivered to wrong request")
+		}
+		res.Done <- nil
+	case <-time.After(time.Second):
+		t.Fatal("response not delivered")
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("failed to dispatch response: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snap
+
+import (
+	"encoding/binary"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that storage range responses stay within the response limit no matter
+// how many bytes a remote peer requests, signalling truncation via proofs or
+// missing accounts.
+func TestServiceGetStorageRangesLimit(t *testing.T) {
+	var (
+		alloc    = make(core.GenesisAlloc)
+		accounts []common.Hash
+	)
+	// Create enough storage to exceed the response limit a few times over
+	for i := 0; i < 40; i++ {
+		storage := make(map[common.Hash]common.Hash)
+		for j := 0; j < 2000; j++ {
+			var key, val [32]byte
+			binary.BigEndian.PutUint64(key[:], uint64(j))
+			copy(val[:], crypto.Keccak256(key[:]))
+			storage[key] = val
+		}
+		addr := common.BigToAddress(big.NewInt(int64(i + 1)))
+		alloc[addr] = core.GenesisAccount{Balance: big.NewInt(1), Storage: storage}
+		accounts = append(accounts, crypto.Keccak256Hash(addr[:]))
+	}
+	gspec := &core.Genesis{Config: params.TestChainConfig, Alloc: alloc}
+	cacheConfig := &core.CacheConfig{
+		TrieCleanLimit: 16,
+		TrieDirtyLimit: 16,
+		SnapshotLimit:  16,
+		SnapshotWait:   true,
+	}
+	chain, err := core.NewBlockChain(rawdb.NewMemoryDatabase(), cacheConfig, gspec, nil, ethash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	var (
+		root  = chain.CurrentBlock().Root
+		limit = float64(softResponseLimit) * (1 + stateLookupSlack)
+
+		// A single slot may overshoot the hard limit, it's checked before adding
+		bound = uint64(limit) + common.HashLength + 33
+	)
+	for i := 0; i < 16; i++ {
+		req := &GetStorageRangesPacket{
+			Root:     root,
+			Accounts: accounts,
+			Bytes:    softResponseLimit + rand.Uint64()>>1,
+		}
+		slots, proofs := ServiceGetStorageRangesQuery(chain, req)
+		if len(slots) == 0 {
+			t.Fatalf("request %d: no storage served", i)
+		}
+		var size uint64
+		for _, account := range slots {
+			for _, slot := range account {
+				size += uint64(common.HashLength + len(slot.Body))
+			}
+		}
+		if size > bound {
+			t.Fatalf("request %d: response too large: have %d, want <= %d", i, size, bound)
+		}
+		if len(proofs) == 0 && len(slots) == len(accounts) {
+			t.Fatalf("request %d: truncated response not signalled", i)
+		}
+	}
+}
@@ -53,11 +53,8 @@ func (eth *Ethereum) hashState(ctx context.Context, block *types.Block, reexec u
 		// The state is available in live database, create a reference
 		// on top to prevent garbage collection and return a release
 		// function to deref it.
-		if statedb, err = eth.blockchain.StateAt(block.Root()); err == nil {
-			eth.blockchain.TrieDB().Reference(block.Root(), common.Hash{})
-			return statedb, func() {
-				eth.blockchain.TrieDB().Dereference(block.Root())
-			}, nil
+		if statedb, release, err = eth.blockchain.StateAtWithRelease(block.Root()); err == nil {
+			return statedb, release, nil
 		}
 	}
 	// The state is both for reading and writing, or it's unavailable in disk,
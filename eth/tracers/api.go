@@ -68,6 +68,10 @@ const (
 	// for tracing. The creation of trace state will be paused if the unused
 	// trace states exceed this limit.
 	maximumPendingTraceStates = 128
+
+	// defaultTraceRangeLimit is the maximum number of blocks traceBlockByRange
+	// is willing to trace in a single call by default.
+	defaultTraceRangeLimit = uint64(100)
 )
 
 var errTxNotFound = errors.New("transaction not found")
@@ -87,6 +91,7 @@ type Backend interface {
 	RPCGasCap() uint64
 	ChainConfig() *params.ChainConfig
 	Engine() consensus.Engine
+	RewardDistributor() core.RewardDistributor
 	ChainDb() ethdb.Database
 	StateAtBlock(ctx context.Context, block *types.Block, reexec uint64, base *state.StateDB, readOnly bool, preferDisk bool) (*state.StateDB, StateReleaseFunc, error)
 	StateAtTransaction(ctx context.Context, block *types.Block, txIndex int, reexec uint64) (*core.Message, vm.BlockContext, *state.StateDB, StateReleaseFunc, error)
@@ -94,12 +99,19 @@ type Backend interface {
 
 // API is the collection of tracing APIs exposed over the private debugging endpoint.
 type API struct {
-	backend Backend
+	backend    Backend
+	rangeLimit uint64 // Maximum number of blocks traced by a single traceBlockByRange
 }
 
 // NewAPI creates a new API definition for the tracing methods of the Ethereum service.
 func NewAPI(backend Backend) *API {
-	return &API{backend: backend}
+	return &API{backend: backend, rangeLimit: defaultTraceRangeLimit}
+}
+
+// SetRangeLimit overrides the maximum number of blocks traceBlockByRange traces
+// in a single call.
+func (api *API) SetRangeLimit(limit uint64) {
+	api.rangeLimit = limit
 }
 
 // chainContext constructs the context reader which is used by the evm for reading
@@ -200,6 +212,31 @@ type blockTraceResult struct {
 	Traces []*txTraceResult `json:"traces"` // Trace results produced by the task
 }
 
+// missingRangeStateError is returned if a block range can't be traced as the
+// state of a block below the freezer boundary is no longer available.
+type missingRangeStateError struct {
+	block    uint64 // Block whose state is unavailable
+	ancients uint64 // Number of blocks in the freezer
+	err      error  // Failure producing the state
+}
+
+func (e *missingRangeStateError) Error() string {
+	return fmt.Sprintf("state unavailable for frozen block #%d (ancients %d): %v", e.block, e.ancients, e.err)
+}
+
+// ErrorCode returns the JSON error code for a missing state.
+func (e *missingRangeStateError) ErrorCode() int {
+	return -32000
+}
+
+// ErrorData returns the block whose state is unavailable and the freezer boundary.
+func (e *missingRangeStateError) ErrorData() interface{} {
+	return map[string]hexutil.Uint64{
+		"block":    hexutil.Uint64(e.block),
+		"ancients": hexutil.Uint64(e.ancients),
+	}
+}
+
 // txTraceTask represents a single transaction trace task when an entire block
 // is being traced.
 type txTraceTask struct {
@@ -452,6 +489,53 @@ func (api *API) TraceBlockByHash(ctx context.Context, hash common.Hash, config *
 	return api.traceBlock(ctx, block, config)
 }
 
+// TraceBlockByRange returns the structured logs created during the execution of
+// EVM for every block in the inclusive range, one item per block. The blocks are
+// traced one after the other, the state of a block being released before moving
+// on to the next one, so memory is only held for the produced traces. Clients
+// needing unbounded ranges should subscribe to traceChain instead.
+func (api *API) TraceBlockByRange(ctx context.Context, start, end rpc.BlockNumber, config *TraceConfig) ([]*blockTraceResult, error) {
+	from, err := api.blockByNumber(ctx, start)
+	if err != nil {
+		return nil, err
+	}
+	to, err := api.blockByNumber(ctx, end)
+	if err != nil {
+		return nil, err
+	}
+	if from.NumberU64() > to.NumberU64() {
+		return nil, fmt.Errorf("end block (#%d) needs to come after start block (#%d)", end, start)
+	}
+	if blocks := to.NumberU64() - from.NumberU64() + 1; blocks > api.rangeLimit {
+		return nil, fmt.Errorf("block range too large: %d blocks, maximum %d", blocks, api.rangeLimit)
+	}
+	results := make([]*blockTraceResult, 0, to.NumberU64()-from.NumberU64()+1)
+	for number := from.NumberU64(); number <= to.NumberU64(); number++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		block := from
+		if number != from.NumberU64() {
+			if block, err = api.blockByNumber(ctx, rpc.BlockNumber(number)); err != nil {
+				return nil, err
+			}
+		}
+		traces, err := api.traceBlock(ctx, block, config)
+		if err != nil {
+			if ancients, aerr := api.backend.ChainDb().Ancients(); aerr == nil && number < ancients {
+				return nil, &missingRangeStateError{block: number, ancients: ancients, err: err}
+			}
+			return nil, err
+		}
+		results = append(results, &blockTraceResult{
+			Block:  hexutil.Uint64(number),
+			Hash:   block.Hash(),
+			Traces: traces,
+		})
+	}
+	return results, nil
+}
+
 // TraceBlock returns the structured logs created during the execution of EVM
 // and returns them as a JSON object.
 func (api *API) TraceBlock(ctx context.Context, blob hexutil.Bytes, config *TraceConfig) ([]*txTraceResult, error) {
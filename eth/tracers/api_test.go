@@ -131,6 +131,10 @@ func (b *testBackend) Engine() consensus.Engine {
 	return b.engine
 }
 
+func (b *testBackend) RewardDistributor() core.RewardDistributor {
+	return b.chain.RewardDistributor()
+}
+
 func (b *testBackend) ChainDb() ethdb.Database {
 	return b.chaindb
 }
@@ -452,6 +456,56 @@ func TestTraceBlock(t *testing.T) {
 	}
 }
 
+func TestTraceBlockByRange(t *testing.T) {
+	t.Parallel()
+
+	accounts := newAccounts(2)
+	genesis := &core.Genesis{
+		Config: params.TestChainConfig,
+		Alloc: core.GenesisAlloc{
+			accounts[0].addr: {Balance: big.NewInt(params.Ether)},
+		},
+	}
+	genBlocks := 10
+	signer := types.HomesteadSigner{}
+	txHashes := make([]common.Hash, genBlocks)
+	backend := newTestBackend(t, genBlocks, genesis, func(i int, b *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(uint64(i), accounts[1].addr, big.NewInt(1000), params.TxGas, b.BaseFee(), nil), signer, accounts[0].key)
+		b.AddTx(tx)
+		txHashes[i] = tx.Hash()
+	})
+	defer backend.chain.Stop()
+	api := NewAPI(backend)
+
+	results, err := api.TraceBlockByRange(context.Background(), 3, 6, nil)
+	if err != nil {
+		t.Fatalf("failed to trace block range: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("result count mismatch: have %d, want %d", len(results), 4)
+	}
+	for i, result := range results {
+		number := uint64(3 + i)
+		if uint64(result.Block) != number || result.Hash != backend.chain.GetHeaderByNumber(number).Hash() {
+			t.Errorf("block %d: trace block mismatch: have #%d %x", number, result.Block, result.Hash)
+		}
+		if len(result.Traces) != 1 || result.Traces[0].TxHash != txHashes[number-1] {
+			t.Errorf("block %d: transaction traces mismatch", number)
+		}
+	}
+	// Reversed ranges and ranges exceeding the limit are rejected
+	if _, err := api.TraceBlockByRange(context.Background(), 6, 3, nil); err == nil {
+		t.Error("expected error for reversed range")
+	}
+	api.SetRangeLimit(3)
+	if _, err := api.TraceBlockByRange(context.Background(), 3, 6, nil); err == nil {
+		t.Error("expected error for range exceeding the limit")
+	}
+	if results, err := api.TraceBlockByRange(context.Background(), 4, 6, nil); err != nil || len(results) != 3 {
+		t.Errorf("failed to trace range at the limit: %d results, err %v", len(results), err)
+	}
+}
+
 func TestTracingWithOverrides(t *testing.T) {
 	t.Parallel()
 	// Initialize test accounts
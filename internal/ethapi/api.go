@@ -49,6 +49,7 @@ import (
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 	"github.com/tyler-smith/go-bip39"
 )
 
@@ -573,6 +574,39 @@ func (s *PersonalAccountAPI) EcRecover(ctx context.Context, data, sig hexutil.By
 	return crypto.PubkeyToAddress(*rpk), nil
 }
 
+// SignTypedData calculates an Ethereum ECDSA signature for the EIP-712 typed data:
+// keccak256("\x19\x01" ${domainSeparator} ${hashStruct(message)})
+//
+// Note, the produced signature conforms to the secp256k1 curve R, S and V values,
+// where the V value will be 27 or 28 for legacy reasons.
+//
+// The key used to calculate the signature is decrypted from the local keystore
+// with the given password.
+func (s *PersonalAccountAPI) SignTypedData(ctx context.Context, typedData apitypes.TypedData, addr common.Address, passwd string) (hexutil.Bytes, error) {
+	ks, err := fetchKeystore(s.am)
+	if err != nil {
+		return nil, err
+	}
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := ks.SignHashWithPassphrase(accounts.Account{Address: addr}, passwd, hash)
+	if err != nil {
+		log.Warn("Failed typed data sign attempt", "address", addr, "err", err)
+		return nil, err
+	}
+	signature[crypto.RecoveryIDOffset] += 27 // Transform V from 0/1 to 27/28 according to the yellow paper
+	return signature, nil
+}
+
+// RecoverTypedData returns the address for the account that was used to create
+// the signature over the EIP-712 typed data. It is the counterpart of
+// personal_signTypedData, the V value of the signature must be 27 or 28.
+func (s *PersonalAccountAPI) RecoverTypedData(ctx context.Context, typedData apitypes.TypedData, sig hexutil.Bytes) (common.Address, error) {
+	return apitypes.RecoverTypedData(typedData, sig)
+}
+
 // InitializeWallet initializes a new wallet at the provided URL, by generating and returning a new private key.
 func (s *PersonalAccountAPI) InitializeWallet(ctx context.Context, url string) (string, error) {
 	wallet, err := s.am.Wallet(url)
@@ -977,6 +1011,11 @@ func (s *BlockChainAPI) GetBlockReceipts(ctx context.Context, blockNrOrHash rpc.
 		return nil, err
 	}
 	txs := block.Transactions()
+	if receipts == nil && len(txs) > 0 {
+		// The receipts of the block have been pruned, treat it the same as
+		// a missing block instead of reporting a length mismatch.
+		return nil, nil
+	}
 	if len(txs) != len(receipts) {
 		return nil, fmt.Errorf("receipts length mismatch: %d vs %d", len(txs), len(receipts))
 	}
@@ -1091,6 +1130,7 @@ func (diff *BlockOverrides) Apply(blockCtx *vm.BlockContext) {
 type ChainContextBackend interface {
 	Engine() consensus.Engine
 	HeaderByNumber(context.Context, rpc.BlockNumber) (*types.Header, error)
+	RewardDistributor() core.RewardDistributor
 }
 
 // ChainContext is an implementation of core.ChainContext. It's main use-case
@@ -1109,6 +1149,10 @@ func (context *ChainContext) Engine() consensus.Engine {
 	return context.b.Engine()
 }
 
+func (context *ChainContext) RewardDistributor() core.RewardDistributor {
+	return context.b.RewardDistributor()
+}
+
 func (context *ChainContext) GetHeader(hash common.Hash, number uint64) *types.Header {
 	// This method is called to get the hash for a block number when executing the BLOCKHASH
 	// opcode. Hence no need to search for non-canonical blocks.
@@ -1630,6 +1674,11 @@ func (s *BlockChainAPI) rpcMarshalBlock(ctx context.Context, b *types.Block, inc
 	if inclTx {
 		fields["totalDifficulty"] = (*hexutil.Big)(s.b.GetTd(ctx, b.Hash()))
 	}
+	// Core extension: report the gas of the system transactions separately, if
+	// it was recorded while processing the block
+	if gas, ok := s.b.GetSystemGasUsed(ctx, b.Hash(), b.NumberU64()); ok {
+		fields["systemGasUsed"] = hexutil.Uint64(gas)
+	}
 	return fields, nil
 }
 
@@ -22,8 +22,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"math/big"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -33,6 +35,7 @@ import (
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip1559"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/bloombits"
 	"github.com/ethereum/go-ethereum/core/rawdb"
@@ -41,10 +44,14 @@ import (
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/internal/blocktest"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/holiman/uint256"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/exp/slices"
@@ -478,6 +485,9 @@ func (b testBackend) GetTd(ctx context.Context, hash common.Hash) *big.Int {
 	}
 	return big.NewInt(1)
 }
+func (b testBackend) GetSystemGasUsed(ctx context.Context, hash common.Hash, number uint64) (uint64, bool) {
+	return b.chain.GetSystemGasUsed(hash, number)
+}
 func (b testBackend) GetEVM(ctx context.Context, msg *core.Message, state *state.StateDB, header *types.Header, vmConfig *vm.Config, blockContext *vm.BlockContext) (*vm.EVM, func() error) {
 	vmError := func() error { return nil }
 	if vmConfig == nil {
@@ -529,13 +539,13 @@ func (b testBackend) SubscribeNewTxsEvent(events chan<- core.NewTxsEvent) event.
 }
 func (b testBackend) ChainConfig() *params.ChainConfig { return b.chain.Config() }
 func (b testBackend) Engine() consensus.Engine         { return b.chain.Engine() }
-func (b testBackend) GetLogs(ctx context.Context, blockHash common.Hash, number uint64) ([][]*types.Log, error) {
-	panic("implement me")
+func (b testBackend) RewardDistributor() core.RewardDistributor {
+	return b.chain.RewardDistributor()
 }
-func (b testBackend) SubscribeRemovedLogsEvent(ch chan<- core.RemovedLogsEvent) event.Subscription {
+func (b testBackend) GetLogs(ctx context.Context, blockHash common.Hash, number uint64) ([][]*types.Log, error) {
 	panic("implement me")
 }
-func (b testBackend) SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscription {
+func (b testBackend) SubscribeCanonicalLogsEvent(ch chan<- core.CanonicalLogsEvent) event.Subscription {
 	panic("implement me")
 }
 func (b testBackend) SubscribePendingLogsEvent(ch chan<- []*types.Log) event.Subscription {
@@ -818,6 +828,158 @@ func TestCall(t *testing.T) {
 	}
 }
 
+func TestGetTokenBalance(t *testing.T) {
+	t.Parallel()
+
+	var (
+		accounts = newAccounts(2)
+		token    = common.HexToAddress("0x1000")
+		reverter = common.HexToAddress("0x2000")
+		genesis  = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				// Returns the storage slot keyed by the queried address
+				token: {
+					Balance: common.Big0,
+					Code:    hexutil.MustDecode("0x6004355460005260206000f3"),
+					Storage: map[common.Hash]common.Hash{common.BytesToHash(accounts[0].addr.Bytes()): common.BigToHash(big.NewInt(1234))},
+				},
+				// Reverts unconditionally
+				reverter: {Balance: common.Big0, Code: hexutil.MustDecode("0x60006000fd")},
+			},
+		}
+	)
+	api := NewCoreAPI(newTestBackend(t, 1, genesis, func(i int, b *core.BlockGen) {}))
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+
+	var tests = []struct {
+		address common.Address
+		token   common.Address
+		want    int64
+	}{
+		{accounts[0].addr, token, 1234},
+		{accounts[1].addr, token, 0},
+		{accounts[0].addr, reverter, 0},
+		{accounts[0].addr, accounts[1].addr, 0}, // Not a contract
+	}
+	for i, tt := range tests {
+		balance, err := api.GetTokenBalance(context.Background(), tt.address, tt.token, latest)
+		if err != nil {
+			t.Fatalf("test %d: failed to get token balance: %v", i, err)
+		}
+		if balance.ToInt().Int64() != tt.want {
+			t.Errorf("test %d: balance mismatch: have %v, want %d", i, balance, tt.want)
+		}
+	}
+}
+
+func TestSimulateV1(t *testing.T) {
+	t.Parallel()
+	var (
+		accounts = newAccounts(2)
+		genesis  = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				accounts[0].addr: {Balance: big.NewInt(params.Ether)},
+			},
+		}
+		token = common.HexToAddress("0x1111111111111111111111111111111111111111")
+		// Minimal token: calldata sets the balance of the caller and emits a log,
+		// empty calldata returns the balance of the caller.
+		tokenCode = hex2Bytes("3615601057600035335560006000a0005b335460005260206000f3")
+		amount    = common.BigToHash(big.NewInt(1000))
+	)
+	api := NewBlockChainAPI(newTestBackend(t, 1, genesis, func(i int, b *core.BlockGen) {}))
+
+	mint := hexutil.Bytes(amount.Bytes())
+	results, err := api.SimulateV1(context.Background(), simOpts{
+		BlockStateCalls: []simBlock{
+			{
+				BlockOverrides: &BlockOverrides{BaseFee: (*hexutil.Big)(big.NewInt(params.GWei))},
+				StateOverrides: &StateOverride{token: OverrideAccount{Code: tokenCode}},
+				Calls: []TransactionArgs{
+					{From: &accounts[0].addr, To: &token, Input: &mint},
+					{From: &accounts[0].addr, To: &token},
+				},
+			},
+			{
+				Calls: []TransactionArgs{
+					{From: &accounts[0].addr, To: &token},
+					{From: &accounts[1].addr, To: &token},
+				},
+			},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("failed to simulate: %v", err)
+	}
+	if len(results) != 2 || len(results[0].Calls) != 2 || len(results[1].Calls) != 2 {
+		t.Fatalf("unexpected result shape: %+v", results)
+	}
+	if results[0].Number != 2 || results[1].Number != 3 || results[1].Timestamp <= results[0].Timestamp {
+		t.Fatalf("unexpected simulated headers: %+v, %+v", results[0], results[1])
+	}
+	if logs := results[0].Calls[0].Logs; len(logs) != 1 || logs[0].Address != token {
+		t.Fatalf("unexpected mint logs: %v", logs)
+	}
+	// The mint diff holds the written slot and the bumped sender nonce
+	diff := results[0].Calls[0].StateDiff
+	if slot := diff.Modified[token].Storage[common.BytesToHash(accounts[0].addr.Bytes())]; slot.To != amount {
+		t.Fatalf("unexpected mint storage diff: %+v", diff.Modified[token])
+	}
+	if sender := diff.Modified[accounts[0].addr]; sender == nil || sender.From.Nonce+1 != sender.To.Nonce {
+		t.Fatalf("unexpected mint sender diff: %+v", sender)
+	}
+	if diff := results[0].Calls[1].StateDiff; diff.Modified[token] != nil {
+		t.Fatalf("unexpected balance call token diff: %+v", diff.Modified[token])
+	}
+	// The base fee follows the simulated parent instead of being copied from it
+	parent := &types.Header{
+		Number:   new(big.Int).SetUint64(uint64(results[0].Number)),
+		GasLimit: uint64(results[0].GasLimit),
+		GasUsed:  uint64(results[0].GasUsed),
+		BaseFee:  results[0].BaseFeePerGas.ToInt(),
+	}
+	if have, want := results[1].BaseFeePerGas.ToInt(), eip1559.CalcBaseFee(genesis.Config, parent); have.Cmp(want) != 0 {
+		t.Fatalf("base fee mismatch: have %v, want %v", have, want)
+	}
+	if results[1].BaseFeePerGas.ToInt().Cmp(parent.BaseFee) == 0 {
+		t.Fatalf("base fee copied from parent: %v", parent.BaseFee)
+	}
+	for i, want := range []struct {
+		block, call int
+		balance     common.Hash
+	}{
+		{0, 1, amount},        // same block, after the mint
+		{1, 0, amount},        // next block, state carried over
+		{1, 1, common.Hash{}}, // other account untouched
+	} {
+		call := results[want.block].Calls[want.call]
+		if call.Status != hexutil.Uint64(types.ReceiptStatusSuccessful) {
+			t.Errorf("test %d: call failed: %+v", i, call.Error)
+		}
+		if have := common.BytesToHash(call.ReturnValue); have != want.balance {
+			t.Errorf("test %d: balance mismatch: have %x, want %x", i, have, want.balance)
+		}
+	}
+	// Nothing is persisted
+	state, _, _ := api.b.StateAndHeaderByNumber(context.Background(), rpc.LatestBlockNumber)
+	if code := state.GetCode(token); len(code) != 0 {
+		t.Fatalf("simulated state override persisted")
+	}
+	// Requests over the call limit are rejected before executing anything
+	calls := make([]TransactionArgs, maxSimulateCalls/2+1)
+	for i := range calls {
+		calls[i] = TransactionArgs{From: &accounts[0].addr, To: &token}
+	}
+	_, err = api.SimulateV1(context.Background(), simOpts{
+		BlockStateCalls: []simBlock{{Calls: calls}, {Calls: calls}},
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "too many calls") {
+		t.Fatalf("call limit not enforced: %v", err)
+	}
+}
+
 type Account struct {
 	key  *ecdsa.PrivateKey
 	addr common.Address
@@ -2112,3 +2274,263 @@ func TestRPCGetBlockReceipts(t *testing.T) {
 		require.JSONEqf(t, want, have, "test %d: json not match, want: %s, have: %s", i, want, have)
 	}
 }
+
+func TestRPCGetBlockReceiptsPruned(t *testing.T) {
+	t.Parallel()
+
+	var (
+		genBlocks  = 5
+		backend, _ = setupReceiptBackend(t, genBlocks)
+		api        = NewBlockChainAPI(backend)
+	)
+	// Drop the receipts of a block with transactions, as a pruned node would
+	header, err := backend.HeaderByNumber(context.Background(), rpc.BlockNumber(1))
+	if err != nil {
+		t.Fatalf("failed to get block: %v", err)
+	}
+	rawdb.DeleteReceipts(backend.db, header.Hash(), header.Number.Uint64())
+
+	for i, test := range []rpc.BlockNumberOrHash{
+		rpc.BlockNumberOrHashWithHash(header.Hash(), false),
+		rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(1)),
+	} {
+		result, err := api.GetBlockReceipts(context.Background(), test)
+		if err != nil {
+			t.Errorf("test %d: want no error, have %v", i, err)
+			continue
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			t.Errorf("test %d: json marshal error", i)
+			continue
+		}
+		if string(data) != "null" {
+			t.Errorf("test %d: want null, have %s", i, data)
+		}
+	}
+}
+
+// Tests that the proofs returned by eth_getProof over a path-scheme state
+// verify against the block's state root, including the proof of absence of a
+// storage slot that was never written.
+func TestGetProofPathScheme(t *testing.T) {
+	t.Parallel()
+
+	var (
+		engine  = ethash.NewFaker()
+		account = common.HexToAddress("0x000000000000000000000000000000000000dead")
+		slot    = common.HexToHash("0x01")
+		absent  = common.HexToHash("0x02")
+		gspec   = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				account: {
+					Balance: big.NewInt(params.Ether),
+					Code:    []byte{byte(vm.STOP)},
+					Storage: map[common.Hash]common.Hash{slot: common.HexToHash("0x2a")},
+				},
+			},
+		}
+	)
+	_, blocks, _ := core.GenerateChainWithGenesis(gspec, engine, 2, func(i int, b *core.BlockGen) {})
+
+	db := rawdb.NewMemoryDatabase()
+	chain, err := core.NewBlockChain(db, core.DefaultCacheConfigWithScheme(rawdb.PathScheme), gspec, nil, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	var (
+		api  = NewBlockChainAPI(&testBackend{db: db, chain: chain})
+		root = chain.CurrentBlock().Root
+	)
+	result, err := api.GetProof(context.Background(), account, []string{slot.Hex(), absent.Hex()}, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber))
+	if err != nil {
+		t.Fatalf("failed to retrieve proof: %v", err)
+	}
+	proofDB := func(proof []string) ethdb.KeyValueReader {
+		db := memorydb.New()
+		for _, node := range proof {
+			blob := hexutil.MustDecode(node)
+			db.Put(crypto.Keccak256(blob), blob)
+		}
+		return db
+	}
+	// Verify the account proof against the state root
+	blob, err := trie.VerifyProof(root, crypto.Keccak256(account.Bytes()), proofDB(result.AccountProof))
+	if err != nil {
+		t.Fatalf("failed to verify account proof: %v", err)
+	}
+	var acc types.StateAccount
+	if err := rlp.DecodeBytes(blob, &acc); err != nil {
+		t.Fatalf("failed to decode proven account: %v", err)
+	}
+	if acc.Balance.Cmp(result.Balance.ToInt()) != 0 {
+		t.Errorf("balance mismatch: proven %v, reported %v", acc.Balance, result.Balance)
+	}
+	if acc.Root != result.StorageHash {
+		t.Errorf("storage root mismatch: proven %x, reported %x", acc.Root, result.StorageHash)
+	}
+	if common.BytesToHash(acc.CodeHash) != result.CodeHash {
+		t.Errorf("code hash mismatch: proven %x, reported %x", acc.CodeHash, result.CodeHash)
+	}
+	// Verify the storage proofs against the proven storage root
+	blob, err = trie.VerifyProof(acc.Root, crypto.Keccak256(slot.Bytes()), proofDB(result.StorageProof[0].Proof))
+	if err != nil {
+		t.Fatalf("failed to verify storage proof: %v", err)
+	}
+	_, content, _, err := rlp.Split(blob)
+	if err != nil {
+		t.Fatalf("failed to decode proven slot: %v", err)
+	}
+	if have := new(big.Int).SetBytes(content); have.Cmp(result.StorageProof[0].Value.ToInt()) != 0 || have.Uint64() != 0x2a {
+		t.Errorf("slot value mismatch: proven %v, reported %v", have, result.StorageProof[0].Value)
+	}
+	blob, err = trie.VerifyProof(acc.Root, crypto.Keccak256(absent.Bytes()), proofDB(result.StorageProof[1].Proof))
+	if err != nil {
+		t.Fatalf("failed to verify proof of absence: %v", err)
+	}
+	if blob != nil {
+		t.Errorf("absent slot proven with value %x", blob)
+	}
+	if result.StorageProof[1].Value.ToInt().Sign() != 0 {
+		t.Errorf("absent slot reported with value %v", result.StorageProof[1].Value)
+	}
+}
+
+// recipientDistributor credits every fee reward to a single address.
+type recipientDistributor struct {
+	recipient common.Address
+}
+
+func (d *recipientDistributor) Distribute(state vm.StateDB, header *types.Header, reward *uint256.Int) {
+	state.AddBalance(d.recipient, reward.ToBig())
+}
+
+// Tests that calls executed through the RPC chain context honour the reward
+// distributor the chain is configured with.
+func TestCallRewardDistributor(t *testing.T) {
+	t.Parallel()
+
+	var (
+		engine   = ethash.NewFaker()
+		sender   = common.HexToAddress("0x1000000000000000000000000000000000000001")
+		dist     = &recipientDistributor{recipient: common.HexToAddress("0xaa")}
+		gspec    = &core.Genesis{Config: params.TestChainConfig, Alloc: core.GenesisAlloc{sender: {Balance: big.NewInt(params.Ether)}}}
+		gasPrice = big.NewInt(params.GWei)
+		gas      = hexutil.Uint64(params.TxGas)
+	)
+	_, blocks, _ := core.GenerateChainWithGenesis(gspec, engine, 1, func(i int, b *core.BlockGen) {})
+
+	db := rawdb.NewMemoryDatabase()
+	chain, err := core.NewBlockChain(db, nil, gspec, nil, engine, vm.Config{}, nil, nil, core.EnableRewardDistributor(dist))
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	statedb, err := chain.State()
+	if err != nil {
+		t.Fatalf("failed to open head state: %v", err)
+	}
+	coinbase := statedb.GetBalance(chain.CurrentHeader().Coinbase)
+
+	args := TransactionArgs{
+		From:     &sender,
+		To:       &common.Address{0x01},
+		Gas:      &gas,
+		GasPrice: (*hexutil.Big)(gasPrice),
+	}
+	result, err := doCall(context.Background(), &testBackend{db: db, chain: chain}, args, statedb, chain.CurrentHeader(), nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to execute call: %v", err)
+	}
+	want := new(big.Int).Mul(new(big.Int).SetUint64(result.UsedGas), gasPrice)
+	if have := statedb.GetBalance(dist.recipient); have.Cmp(want) != 0 {
+		t.Errorf("distributor recipient balance mismatch: have %v, want %v", have, want)
+	}
+	if have := statedb.GetBalance(chain.CurrentHeader().Coinbase); have.Cmp(coinbase) != 0 {
+		t.Errorf("coinbase credited with fee: have %v, want %v", have, coinbase)
+	}
+}
+
+// Tests that the gas of the system transactions is reported by the block
+// retrieval endpoints, if it was recorded for the block.
+func TestRPCGetBlockSystemGasUsed(t *testing.T) {
+	t.Parallel()
+
+	var (
+		genesis = &core.Genesis{Config: params.TestChainConfig}
+		backend = newTestBackend(t, 2, genesis, func(i int, b *core.BlockGen) {})
+		api     = NewBlockChainAPI(backend)
+		block   = backend.chain.GetBlockByNumber(1)
+	)
+	rawdb.WriteSystemGasUsed(backend.db, block.Hash(), block.NumberU64(), 42000)
+
+	fields, err := api.GetBlockByNumber(context.Background(), 1, false)
+	if err != nil {
+		t.Fatalf("failed to retrieve block 1: %v", err)
+	}
+	if have, ok := fields["systemGasUsed"]; !ok || have != hexutil.Uint64(42000) {
+		t.Errorf("system gas used mismatch: have %v, want %v", have, hexutil.Uint64(42000))
+	}
+	fields, err = api.GetBlockByNumber(context.Background(), 2, false)
+	if err != nil {
+		t.Fatalf("failed to retrieve block 2: %v", err)
+	}
+	if have, ok := fields["systemGasUsed"]; ok {
+		t.Errorf("unrecorded system gas used reported: %v", have)
+	}
+}
+
+// FuzzGetBlockReceiptsPruned requests the receipts of arbitrary blocks after
+// pruning them, ensuring missing receipts are reported as JSON null instead of
+// an internal error.
+func FuzzGetBlockReceiptsPruned(f *testing.F) {
+	const genBlocks = 5
+
+	f.Add(uint64(0), false)
+	f.Add(uint64(1), true)
+	f.Add(uint64(genBlocks), false)
+	f.Add(uint64(genBlocks+1), true)
+	f.Add(uint64(math.MaxInt64-1), false)
+
+	f.Fuzz(func(t *testing.T, number uint64, byHash bool) {
+		// Keep clear of the negative special block numbers (latest, pending, etc)
+		number %= math.MaxInt64
+
+		var (
+			backend, _ = setupReceiptBackend(t, genBlocks)
+			api        = NewBlockChainAPI(backend)
+			query      = rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(number))
+		)
+		// Drop the receipts of the requested block if it exists
+		block := backend.chain.GetBlockByNumber(number)
+		if block != nil {
+			rawdb.DeleteReceipts(backend.db, block.Hash(), block.NumberU64())
+			if byHash {
+				query = rpc.BlockNumberOrHashWithHash(block.Hash(), false)
+			}
+		}
+		result, err := api.GetBlockReceipts(context.Background(), query)
+		if err != nil {
+			t.Fatalf("block #%d: want no error, have %v", number, err)
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			t.Fatalf("block #%d: json marshal error: %v", number, err)
+		}
+		want := "null"
+		if block != nil && len(block.Transactions()) == 0 {
+			want = "[]"
+		}
+		if string(data) != want {
+			t.Fatalf("block #%d: want %s, have %s", number, want, data)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"context"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// balanceOfSelector is the ABI selector of the ERC-20 balanceOf(address) method.
+var balanceOfSelector = crypto.Keccak256([]byte("balanceOf(address)"))[:4]
+
+// tokenBalanceWarnInterval is the minimum time between two warnings about failed
+// token balance queries, so a wallet polling a broken token doesn't flood the log.
+const tokenBalanceWarnInterval = 10 * time.Second
+
+// CoreAPI provides Core chain specific extensions, which are not part of the
+// standard Ethereum JSON-RPC API. They are served in the core namespace.
+type CoreAPI struct {
+	b Backend
+
+	lastBalanceWarn atomic.Int64 // Unix nanoseconds of the last failed token balance warning
+}
+
+// NewCoreAPI creates a new Core chain specific API.
+func NewCoreAPI(b Backend) *CoreAPI {
+	return &CoreAPI{b: b}
+}
+
+// GetTokenBalance returns the ERC-20 token balance of the given address at the
+// given block, saving wallets a separate eth_call to the token contract. If the
+// balanceOf call reverts or returns no balance, e.g. because the token address
+// is not an ERC-20 contract, zero is returned and a warning is logged.
+func (api *CoreAPI) GetTokenBalance(ctx context.Context, address common.Address, tokenAddress common.Address, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Big, error) {
+	input := make(hexutil.Bytes, 0, len(balanceOfSelector)+common.HashLength)
+	input = append(input, balanceOfSelector...)
+	input = append(input, common.LeftPadBytes(address.Bytes(), common.HashLength)...)
+
+	args := TransactionArgs{To: &tokenAddress, Input: &input}
+	result, err := DoCall(ctx, api.b, args, blockNrOrHash, nil, nil, api.b.RPCEVMTimeout(), api.b.RPCGasCap())
+	if err != nil {
+		return nil, err
+	}
+	if result.Failed() || len(result.Return()) < common.HashLength {
+		now, last := time.Now().UnixNano(), api.lastBalanceWarn.Load()
+		if now-last >= int64(tokenBalanceWarnInterval) && api.lastBalanceWarn.CompareAndSwap(last, now) {
+			log.Warn("Token balance query failed", "token", tokenAddress, "address", address, "err", result.Err, "returned", len(result.Return()))
+		}
+		return (*hexutil.Big)(new(big.Int)), nil
+	}
+	return (*hexutil.Big)(new(big.Int).SetBytes(result.Return()[:common.HashLength])), nil
+}
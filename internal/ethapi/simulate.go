@@ -0,0 +1,313 @@
+// Copyright 2024 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/misc/eip1559"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// maxSimulateBlocks is the maximum number of blocks that can be simulated
+	// in a single eth_simulateV1 request.
+	maxSimulateBlocks = 256
+
+	// maxSimulateCalls is the maximum number of calls across all blocks of a
+	// single eth_simulateV1 request. Every call copies the simulated state to
+	// compute its state diff, so the cost grows with the square of the calls.
+	maxSimulateCalls = 1000
+
+	// simulateTimestampIncrement is the default timestamp increment of simulated
+	// blocks if the chain doesn't define a block period.
+	simulateTimestampIncrement = 12
+
+	// errCodeVMError is the error code of simulated calls failing in the EVM
+	// without returning revert data.
+	errCodeVMError = -32015
+)
+
+// simOpts are the inputs of eth_simulateV1.
+type simOpts struct {
+	BlockStateCalls []simBlock `json:"blockStateCalls"`
+}
+
+// simBlock is a batch of calls to be simulated sequentially in one block, on top
+// of the optionally overridden state and header fields.
+type simBlock struct {
+	BlockOverrides *BlockOverrides   `json:"blockOverrides"`
+	StateOverrides *StateOverride    `json:"stateOverrides"`
+	Calls          []TransactionArgs `json:"calls"`
+}
+
+// simCallResult is the result of a simulated call.
+type simCallResult struct {
+	ReturnValue hexutil.Bytes   `json:"returnData"`
+	Logs        []*types.Log    `json:"logs"`
+	GasUsed     hexutil.Uint64  `json:"gasUsed"`
+	Status      hexutil.Uint64  `json:"status"`
+	Error       *simCallError   `json:"error,omitempty"`
+	StateDiff   state.StateDiff `json:"stateDiff"`
+}
+
+// simCallError is the error of a failed simulated call.
+type simCallError struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+	Data    string `json:"data,omitempty"`
+}
+
+// simBlockResult is the result of a simulated block.
+type simBlockResult struct {
+	Number        hexutil.Uint64  `json:"number"`
+	Hash          common.Hash     `json:"hash"`
+	Timestamp     hexutil.Uint64  `json:"timestamp"`
+	GasLimit      hexutil.Uint64  `json:"gasLimit"`
+	GasUsed       hexutil.Uint64  `json:"gasUsed"`
+	FeeRecipient  common.Address  `json:"miner"`
+	BaseFeePerGas *hexutil.Big    `json:"baseFeePerGas,omitempty"`
+	Calls         []simCallResult `json:"calls"`
+}
+
+// SimulateV1 executes a series of blocks of calls on top of the given base block.
+// Each block can override the state and its header fields, and sees the state
+// changes made by all the calls before it. Nothing is persisted.
+func (s *BlockChainAPI) SimulateV1(ctx context.Context, opts simOpts, blockNrOrHash *rpc.BlockNumberOrHash) ([]*simBlockResult, error) {
+	if len(opts.BlockStateCalls) == 0 {
+		return nil, errors.New("empty input")
+	}
+	if len(opts.BlockStateCalls) > maxSimulateBlocks {
+		return nil, fmt.Errorf("too many blocks: %d, max %d", len(opts.BlockStateCalls), maxSimulateBlocks)
+	}
+	var calls int
+	for _, block := range opts.BlockStateCalls {
+		calls += len(block.Calls)
+	}
+	if calls > maxSimulateCalls {
+		return nil, fmt.Errorf("too many calls: %d, max %d", calls, maxSimulateCalls)
+	}
+	if blockNrOrHash == nil {
+		latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+		blockNrOrHash = &latest
+	}
+	state, base, err := s.b.StateAndHeaderByNumberOrHash(ctx, *blockNrOrHash)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	sim := &simulator{
+		b:       s.b,
+		state:   state.Copy(),
+		gasCap:  s.b.RPCGasCap(),
+		timeout: s.b.RPCEVMTimeout(),
+	}
+	return sim.execute(ctx, base, opts.BlockStateCalls)
+}
+
+// simulator executes the blocks of an eth_simulateV1 request on a private copy
+// of the base state.
+type simulator struct {
+	b       Backend
+	state   *state.StateDB
+	gasCap  uint64
+	timeout time.Duration
+}
+
+// execute runs the blocks sequentially on top of the base header.
+func (sim *simulator) execute(ctx context.Context, base *types.Header, blocks []simBlock) ([]*simBlockResult, error) {
+	var cancel context.CancelFunc
+	if sim.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, sim.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	var (
+		parent  = base
+		results = make([]*simBlockResult, 0, len(blocks))
+	)
+	for i, block := range blocks {
+		header, err := sim.makeHeader(parent, block.BlockOverrides)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+		if err := block.StateOverrides.Apply(sim.state); err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+		result, err := sim.processBlock(ctx, header, block)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+		results = append(results, result)
+		parent = header
+	}
+	return results, nil
+}
+
+// makeHeader derives the header of a simulated block from its parent and the
+// requested overrides.
+func (sim *simulator) makeHeader(parent *types.Header, overrides *BlockOverrides) (*types.Header, error) {
+	increment := uint64(simulateTimestampIncrement)
+	if config := sim.b.ChainConfig(); config.IsSatoshi() && config.Satoshi.Period > 0 {
+		increment = config.Satoshi.Period
+	}
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		UncleHash:  types.EmptyUncleHash,
+		Coinbase:   parent.Coinbase,
+		Difficulty: parent.Difficulty,
+		Number:     new(big.Int).Add(parent.Number, common.Big1),
+		GasLimit:   parent.GasLimit,
+		Time:       parent.Time + increment,
+	}
+	if overrides == nil {
+		sim.setBaseFee(header, parent)
+		return header, nil
+	}
+	if overrides.Number != nil {
+		if overrides.Number.ToInt().Cmp(parent.Number) <= 0 {
+			return nil, fmt.Errorf("block number %v not above parent %v", overrides.Number.ToInt(), parent.Number)
+		}
+		header.Number = overrides.Number.ToInt()
+	}
+	if overrides.Time != nil {
+		if uint64(*overrides.Time) <= parent.Time {
+			return nil, fmt.Errorf("block timestamp %d not above parent %d", uint64(*overrides.Time), parent.Time)
+		}
+		header.Time = uint64(*overrides.Time)
+	}
+	if overrides.Difficulty != nil {
+		header.Difficulty = overrides.Difficulty.ToInt()
+	}
+	if overrides.GasLimit != nil {
+		header.GasLimit = uint64(*overrides.GasLimit)
+	}
+	if overrides.Coinbase != nil {
+		header.Coinbase = *overrides.Coinbase
+	}
+	if overrides.Random != nil {
+		header.MixDigest = *overrides.Random
+	}
+	if overrides.BaseFee != nil {
+		header.BaseFee = overrides.BaseFee.ToInt()
+	} else {
+		sim.setBaseFee(header, parent)
+	}
+	return header, nil
+}
+
+// setBaseFee sets the base fee of a simulated block derived from its parent, as
+// a real block would have it after London.
+func (sim *simulator) setBaseFee(header, parent *types.Header) {
+	if config := sim.b.ChainConfig(); config.IsLondon(header.Number) {
+		header.BaseFee = eip1559.CalcBaseFee(config, parent)
+	}
+}
+
+// processBlock executes the calls of a block one after the other, each seeing
+// the state changes of the previous ones.
+func (sim *simulator) processBlock(ctx context.Context, header *types.Header, block simBlock) (*simBlockResult, error) {
+	var (
+		blockCtx = core.NewEVMBlockContext(header, NewChainContext(ctx, sim.b), nil)
+		gp       = new(core.GasPool).AddGas(header.GasLimit)
+		calls    = make([]simCallResult, 0, len(block.Calls))
+		gasUsed  uint64
+	)
+	if block.BlockOverrides != nil {
+		block.BlockOverrides.Apply(&blockCtx)
+	}
+	for i, call := range block.Calls {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Fill the fields needed to derive a unique hash for the call logs
+		if call.Nonce == nil {
+			nonce := hexutil.Uint64(sim.state.GetNonce(call.from()))
+			call.Nonce = &nonce
+		}
+		if call.Gas == nil {
+			remaining := hexutil.Uint64(gp.Gas())
+			call.Gas = &remaining
+		}
+		msg, err := call.ToMessage(sim.gasCap, header.BaseFee)
+		if err != nil {
+			return nil, fmt.Errorf("call %d: %w", i, err)
+		}
+		txHash := call.toTransaction().Hash()
+		sim.state.SetTxContext(txHash, i)
+
+		pre := sim.state.Copy()
+		evm, vmError := sim.b.GetEVM(ctx, msg, sim.state, header, &vm.Config{NoBaseFee: true}, &blockCtx)
+		result, err := core.ApplyMessage(evm, msg, gp)
+		if err := vmError(); err != nil {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("call %d: %w", i, err)
+		}
+		sim.state.Finalise(true)
+		gasUsed += result.UsedGas
+
+		logs := sim.state.GetLogs(txHash, header.Number.Uint64(), common.Hash{})
+		if logs == nil {
+			logs = []*types.Log{}
+		}
+		res := simCallResult{
+			ReturnValue: result.Return(),
+			Logs:        logs,
+			GasUsed:     hexutil.Uint64(result.UsedGas),
+			Status:      hexutil.Uint64(types.ReceiptStatusSuccessful),
+			StateDiff:   sim.state.DiffFrom(pre),
+		}
+		if result.Failed() {
+			res.Status = hexutil.Uint64(types.ReceiptStatusFailed)
+			if len(result.Revert()) > 0 {
+				revert := newRevertError(result)
+				res.Error = &simCallError{Message: revert.Error(), Code: revert.ErrorCode(), Data: revert.reason}
+			} else {
+				res.Error = &simCallError{Message: result.Err.Error(), Code: errCodeVMError}
+			}
+		}
+		calls = append(calls, res)
+	}
+	header.GasUsed = gasUsed
+
+	result := &simBlockResult{
+		Number:       hexutil.Uint64(header.Number.Uint64()),
+		Hash:         header.Hash(),
+		Timestamp:    hexutil.Uint64(header.Time),
+		GasLimit:     hexutil.Uint64(header.GasLimit),
+		GasUsed:      hexutil.Uint64(header.GasUsed),
+		FeeRecipient: header.Coinbase,
+		Calls:        calls,
+	}
+	if header.BaseFee != nil {
+		result.BaseFeePerGas = (*hexutil.Big)(header.BaseFee)
+	}
+	return result, nil
+}
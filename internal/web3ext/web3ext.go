@@ -20,6 +20,7 @@ package web3ext
 var Modules = map[string]string{
 	"admin":    AdminJs,
 	"clique":   CliqueJs,
+	"core":     CoreJs,
 	"ethash":   EthashJs,
 	"debug":    DebugJs,
 	"eth":      EthJs,
@@ -463,6 +464,11 @@ web3._extend({
 			params: 2,
 			inputFormatter:[null, null],
 		}),
+		new web3._extend.Method({
+			name: 'getStateDiff',
+			call: 'debug_getStateDiff',
+			params: 1,
+		}),
 		new web3._extend.Method({
 			name: 'freezeClient',
 			call: 'debug_freezeClient',
@@ -681,6 +687,12 @@ web3._extend({
 			name: 'getHashrate',
 			call: 'miner_getHashrate'
 		}),
+		new web3._extend.Method({
+			name: 'simulateBlock',
+			call: 'miner_simulateBlock',
+			params: 2,
+			inputFormatter: [null, null]
+		}),
 	],
 	properties: []
 });
@@ -719,6 +731,17 @@ web3._extend({
 			call: 'personal_ecRecover',
 			params: 2
 		}),
+		new web3._extend.Method({
+			name: 'signTypedData',
+			call: 'personal_signTypedData',
+			params: 3,
+			inputFormatter: [null, web3._extend.formatters.inputAddressFormatter, null]
+		}),
+		new web3._extend.Method({
+			name: 'recoverTypedData',
+			call: 'personal_recoverTypedData',
+			params: 2
+		}),
 		new web3._extend.Method({
 			name: 'openWallet',
 			call: 'personal_openWallet',
@@ -800,6 +823,21 @@ web3._extend({
 });
 `
 
+const CoreJs = `
+web3._extend({
+	property: 'core',
+	methods: [
+		new web3._extend.Method({
+			name: 'getTokenBalance',
+			call: 'core_getTokenBalance',
+			params: 3,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, web3._extend.formatters.inputAddressFormatter, web3._extend.formatters.inputDefaultBlockNumberFormatter],
+			outputFormatter: web3._extend.utils.toBigNumber
+		}),
+	]
+});
+`
+
 const DevJs = `
 web3._extend({
 	property: 'dev',
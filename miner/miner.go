@@ -18,6 +18,7 @@
 package miner
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 	"sync"
@@ -267,6 +268,14 @@ func (miner *Miner) SubscribePendingLogs(ch chan<- []*types.Log) event.Subscript
 	return miner.worker.pendingLogsFeed.Subscribe(ch)
 }
 
+// SimulateBlock assembles the block that would be produced on top of the given
+// parent (or the current head if nil) without sealing it. If txOverrides is
+// non-nil, those transactions are included in order instead of the pending ones
+// of the transaction pool. A zero timestamp defaults to the parent's plus one.
+func (miner *Miner) SimulateBlock(ctx context.Context, parent *types.Header, timestamp uint64, txOverrides types.Transactions) (*types.Block, types.Receipts, error) {
+	return miner.worker.simulateBlock(ctx, parent, timestamp, txOverrides)
+}
+
 // BuildPayload builds the payload according to the provided parameters.
 func (miner *Miner) BuildPayload(args *BuildPayloadArgs) (*Payload, error) {
 	return miner.worker.buildPayload(args)
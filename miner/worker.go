@@ -17,6 +17,7 @@
 package miner
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/big"
@@ -86,17 +87,20 @@ type environment struct {
 	header   *types.Header
 	txs      []*types.Transaction
 	receipts []*types.Receipt
+
+	simulated bool // whether the block is only simulated and mustn't emit pending logs
 }
 
 // copy creates a deep copy of environment.
 func (env *environment) copy() *environment {
 	cpy := &environment{
-		signer:   env.signer,
-		state:    env.state.Copy(),
-		tcount:   env.tcount,
-		coinbase: env.coinbase,
-		header:   types.CopyHeader(env.header),
-		receipts: copyReceipts(env.receipts),
+		signer:    env.signer,
+		state:     env.state.Copy(),
+		tcount:    env.tcount,
+		coinbase:  env.coinbase,
+		header:    types.CopyHeader(env.header),
+		receipts:  copyReceipts(env.receipts),
+		simulated: env.simulated,
 	}
 	if env.gasPool != nil {
 		gasPool := *env.gasPool
@@ -441,7 +445,7 @@ func (w *worker) newWorkLoop(recommit time.Duration) {
 			// If sealing is running resubmit a new work cycle periodically to pull in
 			// higher priced transactions. Disable this overhead for pending blocks.
 			if w.isRunning() && ((w.chainConfig.Clique != nil &&
-				w.chainConfig.Clique.Period > 0) || (w.chainConfig.Satoshi != nil && w.chainConfig.Satoshi.Period > 0)) {
+				w.chainConfig.Clique.Period > 0) || (w.chainConfig.IsSatoshi() && w.chainConfig.Satoshi.Period > 0)) {
 				// Short circuit if no new transaction arrives.
 				commit(commitInterruptResubmit)
 			}
@@ -822,7 +826,7 @@ LOOP:
 		}
 	}
 	bloomProcessors.Close()
-	if !w.isRunning() && len(coalescedLogs) > 0 {
+	if !w.isRunning() && !env.simulated && len(coalescedLogs) > 0 {
 		// We don't push the pendingLogsEvent while we are sealing. The reason is that
 		// when we are sealing, the worker will regenerate a sealing block every 3 seconds.
 		// In order to avoid pushing the repeated pendingLog, we disable the pending log pushing.
@@ -896,7 +900,7 @@ func (w *worker) prepareWork(genParams *generateParams) (*environment, error) {
 	// Set baseFee and GasLimit if we are on an EIP-1559 chain
 	if w.chainConfig.IsLondon(header.Number) {
 		header.BaseFee = eip1559.CalcBaseFee(w.chainConfig, parent)
-		if w.chainConfig.Satoshi == nil && !w.chainConfig.IsLondon(parent.Number) {
+		if !w.chainConfig.IsSatoshi() && !w.chainConfig.IsLondon(parent.Number) {
 			parentGasLimit := parent.GasLimit * w.chainConfig.ElasticityMultiplier()
 			header.GasLimit = core.CalcGasLimit(parentGasLimit, w.config.GasCeil)
 		}
@@ -1240,6 +1244,66 @@ func (w *worker) getSealingBlock(parent common.Hash, timestamp uint64, coinbase
 	}
 }
 
+// simulateBlock assembles the block which would be sealed on top of the given
+// parent without sealing or persisting anything. The transactions are executed
+// on a private copy of the parent state, the transaction pool is only read.
+func (w *worker) simulateBlock(ctx context.Context, parent *types.Header, timestamp uint64, txs types.Transactions) (*types.Block, types.Receipts, error) {
+	genParams := &generateParams{
+		timestamp: timestamp,
+		forceTime: timestamp != 0,
+		coinbase:  w.etherbase(),
+	}
+	if parent != nil {
+		genParams.parentHash = parent.Hash()
+	}
+	work, err := w.prepareWork(genParams)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer work.discard()
+	work.simulated = true
+
+	// Abort the transaction execution as soon as the context is cancelled
+	interruptCh := make(chan int32, 1)
+	stop := context.AfterFunc(ctx, func() {
+		interruptCh <- commitInterruptNewHead
+	})
+	defer stop()
+
+	if txs == nil {
+		err = w.fillTransactions(interruptCh, work, nil)
+	} else {
+		err = w.commitOverrides(ctx, work, txs)
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, nil, ctxErr
+	}
+	if err != nil && !errors.Is(err, errBlockInterruptedByOutOfGas) {
+		return nil, nil, err
+	}
+	return w.engine.FinalizeAndAssemble(w.chain, types.CopyHeader(work.header), work.state, work.txs, nil, work.receipts, nil)
+}
+
+// commitOverrides executes the given transactions in order into the simulated
+// block, failing on the first one which cannot be included.
+func (w *worker) commitOverrides(ctx context.Context, env *environment, txs types.Transactions) error {
+	if env.gasPool == nil {
+		env.gasPool = new(core.GasPool).AddGas(env.header.GasLimit)
+		env.gasPool.SubGas(params.SystemTxsGas)
+	}
+	for i, tx := range txs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		env.state.SetTxContext(tx.Hash(), env.tcount)
+		if _, err := w.commitTransaction(env, &txpool.Transaction{Tx: tx}); err != nil {
+			return fmt.Errorf("transaction %d (%x): %w", i, tx.Hash(), err)
+		}
+		env.tcount++
+	}
+	return nil
+}
+
 // isTTDReached returns the indicator if the given block has reached the total
 // terminal difficulty for The Merge transition.
 func (w *worker) isTTDReached(header *types.Header) bool {
@@ -17,7 +17,10 @@
 package miner // TOFIX
 
 import (
+	"context"
+	"errors"
 	"math/big"
+	"strings"
 	"testing"
 	"time"
 
@@ -26,6 +29,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/clique"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/consensus/satoshi"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/txpool"
@@ -49,9 +53,10 @@ const (
 
 var (
 	// Test chain configurations
-	testTxPoolConfig  legacypool.Config
-	ethashChainConfig *params.ChainConfig
-	cliqueChainConfig *params.ChainConfig
+	testTxPoolConfig   legacypool.Config
+	ethashChainConfig  *params.ChainConfig
+	cliqueChainConfig  *params.ChainConfig
+	satoshiChainConfig *params.ChainConfig
 
 	// Test accounts
 	testBankKey, _  = crypto.GenerateKey()
@@ -82,6 +87,9 @@ func init() {
 		Period: 10,
 		Epoch:  30000,
 	}
+	satoshiChainConfig = new(params.ChainConfig)
+	*satoshiChainConfig = *params.SatoshiTestChainConfig
+	satoshiChainConfig.ChainID = params.TestChainConfig.ChainID
 
 	signer := types.LatestSigner(params.TestChainConfig)
 	tx1 := types.MustSignNewTx(testBankKey, signer, &types.AccessListTx{
@@ -124,6 +132,10 @@ func newTestWorkerBackend(t *testing.T, chainConfig *params.ChainConfig, engine
 		e.Authorize(testBankAddress, func(account accounts.Account, s string, data []byte) ([]byte, error) {
 			return crypto.Sign(crypto.Keccak256(data), testBankKey)
 		})
+	case *satoshi.Satoshi:
+		// The engine is left unauthorized, tests sealing with it authorize it themselves
+		gspec.ExtraData = make([]byte, 32+common.AddressLength+crypto.SignatureLength)
+		copy(gspec.ExtraData[32:32+common.AddressLength], testBankAddress.Bytes())
 	case *ethash.Ethash:
 	default:
 		t.Fatalf("unexpected consensus engine type: %T", engine)
@@ -386,3 +398,101 @@ func testGetSealingWork(t *testing.T, chainConfig *params.ChainConfig, engine co
 		}
 	}
 }
+
+func TestSimulateBlock(t *testing.T) {
+	engine := ethash.NewFaker()
+	defer engine.Close()
+
+	w, b := newTestWorker(t, ethashChainConfig, engine, rawdb.NewMemoryDatabase(), 0)
+	defer w.close()
+
+	// The test transaction is added asynchronously, wait for its promotion
+	head := b.chain.CurrentBlock()
+	pending, queued := b.txPool.Stats()
+	for deadline := time.Now().Add(time.Second); pending == 0 && time.Now().Before(deadline); pending, queued = b.txPool.Stats() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pending != 1 {
+		t.Fatalf("pending transaction count mismatch: have %d, want 1", pending)
+	}
+
+	// Simulate a block from the pool contents
+	block, receipts, err := w.simulateBlock(context.Background(), nil, 0, nil)
+	if err != nil {
+		t.Fatalf("failed to simulate block: %v", err)
+	}
+	if block.NumberU64() != head.Number.Uint64()+1 || block.ParentHash() != head.Hash() {
+		t.Fatalf("simulated block not on top of head: number %d, parent %x", block.NumberU64(), block.ParentHash())
+	}
+	if len(block.Transactions()) != 1 || len(receipts) != 1 {
+		t.Fatalf("transaction count mismatch: have %d txs %d receipts, want 1", len(block.Transactions()), len(receipts))
+	}
+	// Simulate a block from explicit transactions
+	overrides := types.Transactions{pendingTxs[0].Tx, newTxs[0]}
+	block, receipts, err = w.simulateBlock(context.Background(), nil, head.Time+10, overrides)
+	if err != nil {
+		t.Fatalf("failed to simulate block with overrides: %v", err)
+	}
+	if len(block.Transactions()) != 2 || len(receipts) != 2 {
+		t.Fatalf("transaction count mismatch: have %d txs %d receipts, want 2", len(block.Transactions()), len(receipts))
+	}
+	if block.Time() != head.Time+10 {
+		t.Fatalf("timestamp mismatch: have %d, want %d", block.Time(), head.Time+10)
+	}
+	// Cancelled simulations must be aborted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := w.simulateBlock(ctx, nil, 0, overrides); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled simulation error mismatch: have %v, want %v", err, context.Canceled)
+	}
+	// Nothing must have been persisted or removed from the pool
+	if current := b.chain.CurrentBlock(); current.Hash() != head.Hash() {
+		t.Fatalf("chain head changed: have %x, want %x", current.Hash(), head.Hash())
+	}
+	if p, q := b.txPool.Stats(); p != pending || q != queued {
+		t.Fatalf("pool contents changed: have %d/%d, want %d/%d", p, q, pending, queued)
+	}
+}
+
+// Tests that simulating a block with the Satoshi engine fails cleanly until a
+// validator is authorized to sign the system transactions, and includes them
+// afterwards.
+func TestSimulateBlockSatoshi(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	engine := satoshi.New(satoshiChainConfig, db, nil, common.Hash{})
+	defer engine.Close()
+
+	w, b := newTestWorker(t, satoshiChainConfig, engine, db, 0)
+	defer w.close()
+
+	overrides := types.Transactions{pendingTxs[0].Tx}
+	if _, _, err := w.simulateBlock(context.Background(), nil, 0, overrides); err == nil || !strings.Contains(err.Error(), "no validator authorized") {
+		t.Fatalf("unauthorized simulation error mismatch: have %v, want missing signer", err)
+	}
+	engine.Authorize(testBankAddress, nil, func(account accounts.Account, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+		return types.SignTx(tx, types.LatestSignerForChainID(chainID), testBankKey)
+	})
+	block, receipts, err := w.simulateBlock(context.Background(), nil, 0, overrides)
+	if err != nil {
+		t.Fatalf("failed to simulate block: %v", err)
+	}
+	if block.Coinbase() != testBankAddress {
+		t.Fatalf("coinbase mismatch: have %x, want %x", block.Coinbase(), testBankAddress)
+	}
+	// The user transaction is followed by the signed system transactions
+	txs := block.Transactions()
+	if len(txs) < 2 || len(receipts) != len(txs) {
+		t.Fatalf("transaction count mismatch: have %d txs %d receipts, want system txs after the user one", len(txs), len(receipts))
+	}
+	if txs[0].Hash() != overrides[0].Hash() {
+		t.Fatalf("user transaction mismatch: have %x, want %x", txs[0].Hash(), overrides[0].Hash())
+	}
+	for i, tx := range txs[1:] {
+		if from, _ := types.Sender(types.LatestSignerForChainID(satoshiChainConfig.ChainID), tx); from != testBankAddress {
+			t.Fatalf("system transaction %d sender mismatch: have %x, want %x", i, from, testBankAddress)
+		}
+	}
+	if current := b.chain.CurrentBlock(); current.Number.Uint64() != 0 {
+		t.Fatalf("chain head changed: have #%d, want #0", current.Number)
+	}
+}
@@ -17,6 +17,7 @@
 package params
 
 import (
+	"errors"
 	"fmt"
 	"math/big"
 
@@ -374,6 +375,23 @@ func GetBuiltInChainConfig(ghash common.Hash) *ChainConfig {
 	}
 }
 
+// GetBuiltInGenesisHash returns the genesis hash of the built-in CORE network
+// with the given chain id, or false if the chain id is not a known one.
+func GetBuiltInGenesisHash(chainID *big.Int) (common.Hash, bool) {
+	switch {
+	case chainID == nil:
+		return common.Hash{}, false
+	case chainID.Cmp(CoreChainConfig.ChainID) == 0:
+		return CoreGenesisHash, true
+	case chainID.Cmp(BuffaloChainConfig.ChainID) == 0:
+		return BuffaloGenesisHash, true
+	case chainID.Cmp(PigeonChainConfig.ChainID) == 0:
+		return PigeonGenesisHash, true
+	default:
+		return common.Hash{}, false
+	}
+}
+
 // NetworkNames are user friendly names to use in the chain spec banner.
 var NetworkNames = map[string]string{
 	MainnetChainConfig.ChainID.String(): "mainnet",
@@ -416,6 +434,7 @@ type ChainConfig struct {
 	KeplerTime   *uint64 `json:"keplerTime,omitempty"`    // Kepler switch time (nil = no fork, 0 = already activated)
 	DemeterTime  *uint64 `json:"demeterTime,omitempty" `  // Demeter switch time (nil = no fork, 0 = already on demeter)
 	AthenaTime   *uint64 `json:"athenaTime,omitempty"`    // Athena switch time (nil = no fork, 0 = already on athena)
+	TheseusTime  *uint64 `json:"theseusTime,omitempty"`   // Theseus switch time (nil = no fork, 0 = already on theseus)
 	CancunTime   *uint64 `json:"cancunTime,omitempty" `   // Cancun switch time (nil = no fork, 0 = already on cancun)
 	PragueTime   *uint64 `json:"pragueTime,omitempty" `   // Prague switch time (nil = no fork, 0 = already on prague)
 	VerkleTime   *uint64 `json:"verkleTime,omitempty" `   // Verkle switch time (nil = no fork, 0 = already on verkle)
@@ -467,6 +486,11 @@ type SatoshiConfig struct {
 	Period uint64 `json:"period"` // Number of seconds between blocks to enforce
 	Epoch  uint64 `json:"epoch"`  // Epoch length to update validatorSet
 	Round  uint64 `json:"round"`  // Number of seconds between rounds to enforce
+
+	// EmptyBlockTimeout is the number of milliseconds after which a fallback
+	// validator may seal an empty block in place of an offline in-turn one.
+	// Zero disables fallback blocks, 1500 is the recommended value.
+	EmptyBlockTimeout uint64 `json:"emptyBlockTimeout,omitempty"`
 }
 
 // String implements the stringer interface, returning the consensus engine details.
@@ -474,6 +498,34 @@ func (b *SatoshiConfig) String() string {
 	return "satoshi"
 }
 
+// maxSatoshiPeriod is the largest block period accepted in a Satoshi config.
+const maxSatoshiPeriod = 3600
+
+// sanitize checks that the consensus parameters are usable, so a misconfigured
+// network is refused at startup instead of stalling or crashing later on.
+func (b *SatoshiConfig) sanitize() error {
+	switch {
+	case b.Period == 0:
+		return errors.New("invalid satoshi config: period must be positive")
+	case b.Period > maxSatoshiPeriod:
+		return fmt.Errorf("invalid satoshi config: period %d exceeds maximum %d", b.Period, maxSatoshiPeriod)
+	case b.Epoch == 0:
+		return errors.New("invalid satoshi config: epoch must be positive")
+	case b.Round == 0:
+		return errors.New("invalid satoshi config: round must be positive")
+	case b.Round%b.Period != 0:
+		return fmt.Errorf("invalid satoshi config: round %d is not a multiple of period %d", b.Round, b.Period)
+	case b.EmptyBlockTimeout >= b.Period*1000:
+		return fmt.Errorf("invalid satoshi config: empty block timeout %dms not shorter than period %ds", b.EmptyBlockTimeout, b.Period)
+	case b.Round < b.Epoch*b.Period:
+		// Rounds are only turned at epoch boundaries, a round shorter than an
+		// epoch would skip rounds.
+		return fmt.Errorf("invalid satoshi config: round %d shorter than epoch duration %d (epoch %d * period %d)",
+			b.Round, b.Epoch*b.Period, b.Epoch, b.Period)
+	}
+	return nil
+}
+
 func (c *ChainConfig) Description() string {
 	return ""
 }
@@ -513,7 +565,12 @@ func (c *ChainConfig) String() string {
 		AthenaTime = big.NewInt(0).SetUint64(*c.AthenaTime)
 	}
 
-	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v EIP150: %v EIP155: %v EIP158: %v Byzantium: %v Constantinople: %v Petersburg: %v Istanbul: %v, Muir Glacier: %v, Berlin: %v, YOLO v3: %v, London: %v, HashPower: %v, Zeus: %v, Hera: %v, Poseidon: %v, Luban: %v, Plato: %v, Hertz: %v, ShanghaiTime: %v, KeplerTime: %v, DemeterTime: %v, AthenaTime: %v, Engine: %v}",
+	var TheseusTime *big.Int
+	if c.TheseusTime != nil {
+		TheseusTime = big.NewInt(0).SetUint64(*c.TheseusTime)
+	}
+
+	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v EIP150: %v EIP155: %v EIP158: %v Byzantium: %v Constantinople: %v Petersburg: %v Istanbul: %v, Muir Glacier: %v, Berlin: %v, YOLO v3: %v, London: %v, HashPower: %v, Zeus: %v, Hera: %v, Poseidon: %v, Luban: %v, Plato: %v, Hertz: %v, ShanghaiTime: %v, KeplerTime: %v, DemeterTime: %v, AthenaTime: %v, TheseusTime: %v, Engine: %v}",
 		c.ChainID,
 		c.HomesteadBlock,
 		c.DAOForkBlock,
@@ -540,10 +597,16 @@ func (c *ChainConfig) String() string {
 		KeplerTime,
 		DemeterTime,
 		AthenaTime,
+		TheseusTime,
 		engine,
 	)
 }
 
+// IsSatoshi returns whether the chain is run by the Satoshi consensus engine.
+func (c *ChainConfig) IsSatoshi() bool {
+	return c.Satoshi != nil
+}
+
 // IsHomestead returns whether num is either equal to the homestead block or greater.
 func (c *ChainConfig) IsHomestead(num *big.Int) bool {
 	return isBlockForked(c.HomesteadBlock, num)
@@ -742,6 +805,11 @@ func (c *ChainConfig) IsOnAthena(currentBlockNumber *big.Int, lastBlockTime uint
 	return !c.IsAthena(lastBlockNumber, lastBlockTime) && c.IsAthena(currentBlockNumber, currentBlockTime)
 }
 
+// IsTheseus returns whether time is either equal to the theseus fork time or greater.
+func (c *ChainConfig) IsTheseus(num *big.Int, time uint64) bool {
+	return c.IsLondon(num) && isTimestampForked(c.TheseusTime, time)
+}
+
 // IsCancun returns whether num is either equal to the Cancun fork time or greater.
 func (c *ChainConfig) IsCancun(num *big.Int, time uint64) bool {
 	return c.IsLondon(num) && isTimestampForked(c.CancunTime, time)
@@ -757,6 +825,57 @@ func (c *ChainConfig) IsVerkle(num *big.Int, time uint64) bool {
 	return c.IsLondon(num) && isTimestampForked(c.VerkleTime, time)
 }
 
+// NextFork returns the earliest fork activating after the given head block.
+// Block based forks are translated to estimated activation times using the
+// Satoshi block period, timestamp based forks are returned as scheduled. It
+// returns ok=false if all known forks are already active.
+func (c *ChainConfig) NextFork(head *big.Int, headTime uint64) (name string, activationTime uint64, ok bool) {
+	consider := func(forkName string, forkTime uint64) {
+		if forkTime > headTime && (!ok || forkTime < activationTime) {
+			name, activationTime, ok = forkName, forkTime, true
+		}
+	}
+	if c.IsSatoshi() {
+		for _, fork := range []struct {
+			name  string
+			block *big.Int
+		}{
+			{"Berlin", c.BerlinBlock},
+			{"London", c.LondonBlock},
+			{"HashPower", c.HashPowerBlock},
+			{"Zeus", c.ZeusBlock},
+			{"Hera", c.HeraBlock},
+			{"Poseidon", c.PoseidonBlock},
+			{"Luban", c.LubanBlock},
+			{"Plato", c.PlatoBlock},
+			{"Hertz", c.HertzBlock},
+		} {
+			if fork.block != nil && fork.block.Cmp(head) > 0 {
+				blocks := new(big.Int).Sub(fork.block, head).Uint64()
+				consider(fork.name, headTime+blocks*c.Satoshi.Period)
+			}
+		}
+	}
+	for _, fork := range []struct {
+		name      string
+		timestamp *uint64
+	}{
+		{"Shanghai", c.ShanghaiTime},
+		{"Kepler", c.KeplerTime},
+		{"Demeter", c.DemeterTime},
+		{"Athena", c.AthenaTime},
+		{"Theseus", c.TheseusTime},
+		{"Cancun", c.CancunTime},
+		{"Prague", c.PragueTime},
+		{"Verkle", c.VerkleTime},
+	} {
+		if fork.timestamp != nil {
+			consider(fork.name, *fork.timestamp)
+		}
+	}
+	return name, activationTime, ok
+}
+
 // CheckCompatible checks whether scheduled fork transitions have been imported
 // with a mismatching chain configuration.
 func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64, time uint64) *ConfigCompatError {
@@ -782,13 +901,72 @@ func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64, time u
 	return lasterr
 }
 
+// ForkTimeOverrides contains new activation times for the timestamp based forks,
+// nil fields leave the configured time untouched.
+type ForkTimeOverrides struct {
+	Shanghai *uint64
+	Kepler   *uint64
+	Demeter  *uint64
+	Athena   *uint64
+	Theseus  *uint64
+	Cancun   *uint64
+	Prague   *uint64
+	Verkle   *uint64
+}
+
+// ApplyForkOverrides re-times the forks set in the overrides. Forks can't be
+// moved before the time of the given chain head and the resulting schedule must
+// pass the fork ordering check, otherwise an error is returned and the config
+// is left unchanged.
+func (c *ChainConfig) ApplyForkOverrides(o ForkTimeOverrides, headTime uint64) error {
+	updated := *c
+	for _, override := range []struct {
+		name string
+		time *uint64
+		dest **uint64
+	}{
+		{"shanghaiTime", o.Shanghai, &updated.ShanghaiTime},
+		{"keplerTime", o.Kepler, &updated.KeplerTime},
+		{"demeterTime", o.Demeter, &updated.DemeterTime},
+		{"athenaTime", o.Athena, &updated.AthenaTime},
+		{"theseusTime", o.Theseus, &updated.TheseusTime},
+		{"cancunTime", o.Cancun, &updated.CancunTime},
+		{"pragueTime", o.Prague, &updated.PragueTime},
+		{"verkleTime", o.Verkle, &updated.VerkleTime},
+	} {
+		if override.time == nil {
+			continue
+		}
+		if *override.time < headTime {
+			return fmt.Errorf("invalid fork override: %s %d already passed (head time %d)", override.name, *override.time, headTime)
+		}
+		*override.dest = newUint64(*override.time)
+	}
+	if err := updated.CheckConfigForkOrder(); err != nil {
+		return fmt.Errorf("invalid fork override: %w", err)
+	}
+	*c = updated
+	return nil
+}
+
+// ErrAmbiguousEngine is returned by CheckConfigForkOrder if a chain config
+// configures the Satoshi engine alongside another consensus engine.
+var ErrAmbiguousEngine = errors.New("ambiguous consensus engine: satoshi configured along with ethash or clique")
+
 // CheckConfigForkOrder checks that we don't "skip" any forks, geth isn't pluggable enough
 // to guarantee that forks can be implemented in a different order than on official networks
 func (c *ChainConfig) CheckConfigForkOrder() error {
 	// skip checking for non-Satoshi egine
-	if c.Satoshi == nil {
+	if !c.IsSatoshi() {
 		return nil
 	}
+	// Both engines would seal and verify blocks, neither one can be picked
+	if c.Ethash != nil || c.Clique != nil {
+		return ErrAmbiguousEngine
+	}
+	if err := c.Satoshi.sanitize(); err != nil {
+		return err
+	}
 	type fork struct {
 		name      string
 		block     *big.Int // forks up to - and including the merge - were defined with block numbers
@@ -797,45 +975,55 @@ func (c *ChainConfig) CheckConfigForkOrder() error {
 	}
 	var lastFork fork
 	for _, cur := range []fork{
+		{name: "homesteadBlock", block: c.HomesteadBlock},
+		{name: "eip150Block", block: c.EIP150Block},
+		{name: "eip155Block", block: c.EIP155Block},
+		{name: "eip158Block", block: c.EIP158Block},
+		{name: "byzantiumBlock", block: c.ByzantiumBlock},
+		{name: "constantinopleBlock", block: c.ConstantinopleBlock},
+		{name: "petersburgBlock", block: c.PetersburgBlock},
+		{name: "istanbulBlock", block: c.IstanbulBlock},
+		{name: "muirGlacierBlock", block: c.MuirGlacierBlock, optional: true},
 		{name: "hashPowerBlock", block: c.HashPowerBlock},
 		{name: "zeusBlock", block: c.ZeusBlock},
 		{name: "heraBlock", block: c.HeraBlock},
 		{name: "poseidonBlock", block: c.PoseidonBlock},
+		{name: "lubanBlock", block: c.LubanBlock, optional: true},
+		{name: "platoBlock", block: c.PlatoBlock, optional: true},
+		{name: "berlinBlock", block: c.BerlinBlock},
+		{name: "londonBlock", block: c.LondonBlock},
 		{name: "hertzBlock", block: c.HertzBlock},
+		{name: "arrowGlacierBlock", block: c.ArrowGlacierBlock, optional: true},
+		{name: "grayGlacierBlock", block: c.GrayGlacierBlock, optional: true},
+		{name: "mergeNetsplitBlock", block: c.MergeNetsplitBlock, optional: true},
+		{name: "shanghaiTime", timestamp: c.ShanghaiTime},
 		{name: "keplerTime", timestamp: c.KeplerTime},
 		{name: "demeterTime", timestamp: c.DemeterTime},
 		{name: "athenaTime", timestamp: c.AthenaTime},
+		{name: "theseusTime", timestamp: c.TheseusTime, optional: true},
 		{name: "cancunTime", timestamp: c.CancunTime, optional: true},
 		{name: "pragueTime", timestamp: c.PragueTime, optional: true},
 		{name: "verkleTime", timestamp: c.VerkleTime, optional: true},
 	} {
 		if lastFork.name != "" {
+			err := &ForkOrderError{
+				What: cur.name, Block: cur.block, Time: cur.timestamp,
+				Prev: lastFork.name, PrevBlock: lastFork.block, PrevTime: lastFork.timestamp,
+			}
 			switch {
 			// Non-optional forks must all be present in the chain config up to the last defined fork
 			case lastFork.block == nil && lastFork.timestamp == nil && (cur.block != nil || cur.timestamp != nil):
-				if cur.block != nil {
-					return fmt.Errorf("unsupported fork ordering: %v not enabled, but %v enabled at block %v",
-						lastFork.name, cur.name, cur.block)
-				} else {
-					return fmt.Errorf("unsupported fork ordering: %v not enabled, but %v enabled at timestamp %v",
-						lastFork.name, cur.name, cur.timestamp)
-				}
+				return err
 
 			// Fork (whether defined by block or timestamp) must follow the fork definition sequence
-			case (lastFork.block != nil && cur.block != nil) || (lastFork.timestamp != nil && cur.timestamp != nil):
-				if lastFork.block != nil && lastFork.block.Cmp(cur.block) > 0 {
-					return fmt.Errorf("unsupported fork ordering: %v enabled at block %v, but %v enabled at block %v",
-						lastFork.name, lastFork.block, cur.name, cur.block)
-				} else if lastFork.timestamp != nil && *lastFork.timestamp > *cur.timestamp {
-					return fmt.Errorf("unsupported fork ordering: %v enabled at timestamp %v, but %v enabled at timestamp %v",
-						lastFork.name, lastFork.timestamp, cur.name, cur.timestamp)
-				}
-
-				// Timestamp based forks can follow block based ones, but not the other way around
-				if lastFork.timestamp != nil && cur.block != nil {
-					return fmt.Errorf("unsupported fork ordering: %v used timestamp ordering, but %v reverted to block ordering",
-						lastFork.name, cur.name)
-				}
+			case lastFork.block != nil && cur.block != nil && lastFork.block.Cmp(cur.block) > 0:
+				return err
+			case lastFork.timestamp != nil && cur.timestamp != nil && *lastFork.timestamp > *cur.timestamp:
+				return err
+
+			// Timestamp based forks can follow block based ones, but not the other way around
+			case lastFork.timestamp != nil && cur.block != nil:
+				return err
 			}
 		}
 		// If it was optional and not set, then ignore it
@@ -932,6 +1120,9 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, headNumber *big.Int,
 	if isForkTimestampIncompatible(c.AthenaTime, newcfg.AthenaTime, headTimestamp) {
 		return newTimestampCompatError("Athena fork timestamp", c.AthenaTime, newcfg.AthenaTime)
 	}
+	if isForkTimestampIncompatible(c.TheseusTime, newcfg.TheseusTime, headTimestamp) {
+		return newTimestampCompatError("Theseus fork timestamp", c.TheseusTime, newcfg.TheseusTime)
+	}
 	if isForkTimestampIncompatible(c.CancunTime, newcfg.CancunTime, headTimestamp) {
 		return newTimestampCompatError("Cancun fork timestamp", c.CancunTime, newcfg.CancunTime)
 	}
@@ -1075,6 +1266,37 @@ func (err *ConfigCompatError) Error() string {
 	return fmt.Sprintf("mismatching %s in database (have timestamp %d, want timestamp %d, rewindto timestamp %d)", err.What, err.StoredTime, err.NewTime, err.RewindToTime)
 }
 
+// ForkOrderError is returned by ChainConfig.CheckConfigForkOrder if a fork is
+// enabled while a mandatory fork preceding it is not, or is scheduled before the
+// preceding one.
+type ForkOrderError struct {
+	What string // Name of the misordered fork
+
+	// activation block or timestamp of the misordered fork
+	Block *big.Int
+	Time  *uint64
+
+	Prev string // Name of the fork preceding the misordered one
+
+	// activation block or timestamp of the preceding fork, both nil if not enabled
+	PrevBlock *big.Int
+	PrevTime  *uint64
+}
+
+func (err *ForkOrderError) Error() string {
+	describe := func(name string, block *big.Int, time *uint64) string {
+		switch {
+		case block != nil:
+			return fmt.Sprintf("%s enabled at block %d", name, block)
+		case time != nil:
+			return fmt.Sprintf("%s enabled at timestamp %d", name, *time)
+		default:
+			return fmt.Sprintf("%s not enabled", name)
+		}
+	}
+	return fmt.Sprintf("invalid fork ordering: %s, but %s", describe(err.Prev, err.PrevBlock, err.PrevTime), describe(err.What, err.Block, err.Time))
+}
+
 // Rules wraps ChainConfig and is merely syntactic sugar or can be used for functions
 // that do not have or require information about the block.
 //
@@ -1087,7 +1309,7 @@ type Rules struct {
 	IsBerlin, IsLondon                                      bool
 	IsMerge                                                 bool
 	IsHashPower                                             bool
-	IsShanghai, IsKepler, IsCancun, IsPrague                bool
+	IsShanghai, IsKepler, IsTheseus, IsCancun, IsPrague     bool
 	IsVerkle                                                bool
 }
 
@@ -1113,8 +1335,67 @@ func (c *ChainConfig) Rules(num *big.Int, isMerge bool, timestamp uint64) Rules
 		IsHashPower:      c.IsHashPower(num),
 		IsShanghai:       c.IsShanghai(num, timestamp),
 		IsKepler:         c.IsKepler(num, timestamp),
+		IsTheseus:        c.IsTheseus(num, timestamp),
 		IsCancun:         c.IsCancun(num, timestamp),
 		IsPrague:         c.IsPrague(num, timestamp),
 		IsVerkle:         c.IsVerkle(num, timestamp),
 	}
 }
+
+// GasSchedule is a snapshot of the fork dependent gas constants in effect at a
+// given block. Costs of features not yet activated are zero.
+type GasSchedule struct {
+	SloadGas                   uint64 // Cost of SLOAD (warm access after Berlin)
+	WarmStorageReadCost        uint64 // EIP-2929 warm storage or account access
+	ColdSloadCost              uint64 // EIP-2929 cold storage slot access
+	ColdAccountAccessCost      uint64 // EIP-2929 cold account access
+	TxAccessListAddressGas     uint64 // EIP-2930 cost per access list address
+	TxAccessListStorageKeyGas  uint64 // EIP-2930 cost per access list storage key
+	TxDataNonZeroGas           uint64 // Cost per non-zero byte of transaction data
+	InitCodeWordGas            uint64 // EIP-3860 cost per word of init code
+	SstoreClearsScheduleRefund uint64 // Refund for clearing a storage slot
+	RefundQuotient             uint64 // Maximum fraction of the used gas that can be refunded
+	SystemTxsGas               uint64 // Gas reserved per block for the Satoshi system transactions
+}
+
+// GasSchedule returns the gas constants in effect at the given block number and
+// timestamp. Core's own forks don't alter the opcode costs, but Satoshi chains
+// reserve part of each block's gas for the system transactions.
+func (c *ChainConfig) GasSchedule(blockNum uint64, time uint64) GasSchedule {
+	var (
+		rules    = c.Rules(new(big.Int).SetUint64(blockNum), false, time)
+		schedule = GasSchedule{
+			SloadGas:                   SloadGasFrontier,
+			TxDataNonZeroGas:           TxDataNonZeroGasFrontier,
+			SstoreClearsScheduleRefund: SstoreRefundGas,
+			RefundQuotient:             RefundQuotient,
+		}
+	)
+	if rules.IsEIP150 {
+		schedule.SloadGas = SloadGasEIP150
+	}
+	if rules.IsIstanbul {
+		schedule.SloadGas = SloadGasEIP2200
+		schedule.TxDataNonZeroGas = TxDataNonZeroGasEIP2028
+		schedule.SstoreClearsScheduleRefund = SstoreClearsScheduleRefundEIP2200
+	}
+	if rules.IsBerlin {
+		schedule.SloadGas = WarmStorageReadCostEIP2929
+		schedule.WarmStorageReadCost = WarmStorageReadCostEIP2929
+		schedule.ColdSloadCost = ColdSloadCostEIP2929
+		schedule.ColdAccountAccessCost = ColdAccountAccessCostEIP2929
+		schedule.TxAccessListAddressGas = TxAccessListAddressGas
+		schedule.TxAccessListStorageKeyGas = TxAccessListStorageKeyGas
+	}
+	if rules.IsLondon {
+		schedule.SstoreClearsScheduleRefund = SstoreClearsScheduleRefundEIP3529
+		schedule.RefundQuotient = RefundQuotientEIP3529
+	}
+	if rules.IsShanghai {
+		schedule.InitCodeWordGas = InitCodeWordGas
+	}
+	if c.IsSatoshi() {
+		schedule.SystemTxsGas = SystemTxsGas
+	}
+	return schedule
+}
@@ -17,11 +17,14 @@
 package params
 
 import (
+	"errors"
 	"math/big"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
 )
 
@@ -137,3 +140,377 @@ func TestConfigRules(t *testing.T) {
 		t.Errorf("expected %v to be shanghai", stamp)
 	}
 }
+
+// Tests that rules flip exactly at a fork boundary, regardless of the order they
+// are queried in, and follow the forks being re-timed.
+func TestConfigRulesForkBoundary(t *testing.T) {
+	now := uint64(time.Now().Unix())
+	config := *PigeonChainConfig
+	config.CancunTime = newUint64(now + 3600)
+	fork := *config.CancunTime
+
+	for _, stamp := range []uint64{fork - 1, fork, fork - 1, fork + 1, fork} {
+		if have := config.Rules(big.NewInt(100), false, stamp); have.IsCancun != (stamp >= fork) {
+			t.Errorf("cancun mismatch at %d: have %v, want %v", stamp, have.IsCancun, stamp >= fork)
+		}
+	}
+	if err := config.ApplyForkOverrides(ForkTimeOverrides{Cancun: newUint64(fork + 60)}, now); err != nil {
+		t.Fatalf("failed to delay cancun: %v", err)
+	}
+	if config.Rules(big.NewInt(100), false, fork).IsCancun {
+		t.Errorf("stale rules after fork override")
+	}
+}
+
+func TestSatoshiConfigSanitize(t *testing.T) {
+	tests := []struct {
+		config SatoshiConfig
+		field  string // Offending field named in the error, empty if valid
+	}{
+		{SatoshiConfig{Period: 3, Epoch: 200, Round: 86400}, ""},
+		{SatoshiConfig{Period: 3, Epoch: 200, Round: 600}, ""},
+		{SatoshiConfig{Period: 1, Epoch: 1, Round: 1}, ""},
+		{SatoshiConfig{Period: 0, Epoch: 200, Round: 86400}, "period"},
+		{SatoshiConfig{Period: maxSatoshiPeriod + 1, Epoch: 200, Round: 86400}, "period"},
+		{SatoshiConfig{Period: 3, Epoch: 0, Round: 86400}, "epoch"},
+		{SatoshiConfig{Period: 3, Epoch: 200, Round: 0}, "round"},
+		{SatoshiConfig{Period: 7, Epoch: 200, Round: 86400}, "round"},
+		{SatoshiConfig{Period: 3, Epoch: 200, Round: 597}, "round"},
+		{SatoshiConfig{Period: 0, Epoch: 0, Round: 0}, "period"},
+		{SatoshiConfig{Period: 3, Epoch: 200, Round: 86400, EmptyBlockTimeout: 1500}, ""},
+		{SatoshiConfig{Period: 3, Epoch: 200, Round: 86400, EmptyBlockTimeout: 3000}, "empty block timeout"},
+	}
+	for i, tt := range tests {
+		err := tt.config.sanitize()
+		if tt.field == "" {
+			if err != nil {
+				t.Errorf("test %d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("test %d: expected error for %s", i, tt.field)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.field) {
+			t.Errorf("test %d: error %q does not name %s", i, err, tt.field)
+		}
+	}
+	// Invalid consensus parameters must be refused along the fork order check
+	config := *SatoshiTestChainConfig
+	config.Satoshi = &SatoshiConfig{Period: 3, Epoch: 0, Round: 86400}
+	if err := config.CheckConfigForkOrder(); err == nil {
+		t.Error("expected invalid satoshi config to be rejected")
+	}
+	if err := SatoshiTestChainConfig.CheckConfigForkOrder(); err != nil {
+		t.Errorf("unexpected error for test config: %v", err)
+	}
+}
+
+func TestCheckConfigForkOrderErrors(t *testing.T) {
+	for name, config := range map[string]*ChainConfig{
+		"core":    CoreChainConfig,
+		"buffalo": BuffaloChainConfig,
+		"pigeon":  PigeonChainConfig,
+		"satoshi": SatoshiTestChainConfig,
+		"mainnet": MainnetChainConfig,
+		"ethash":  AllEthashProtocolChanges,
+		"test":    TestChainConfig,
+	} {
+		if err := config.CheckConfigForkOrder(); err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+	}
+	tests := []struct {
+		name   string
+		base   *ChainConfig
+		modify func(c *ChainConfig)
+		what   string // Misordered fork named in the error
+		prev   string // Preceding fork named in the error
+	}{
+		{"eip150 before homestead", SatoshiTestChainConfig, func(c *ChainConfig) { c.HomesteadBlock = big.NewInt(10); c.EIP150Block = big.NewInt(5) }, "eip150Block", "homesteadBlock"},
+		{"eip155 without eip150", SatoshiTestChainConfig, func(c *ChainConfig) { c.EIP150Block = nil }, "eip155Block", "eip150Block"},
+		{"eip158 before eip155", SatoshiTestChainConfig, func(c *ChainConfig) { c.EIP155Block = big.NewInt(2); c.EIP158Block = big.NewInt(1) }, "eip158Block", "eip155Block"},
+		{"byzantium without eip158", SatoshiTestChainConfig, func(c *ChainConfig) { c.EIP158Block = nil }, "byzantiumBlock", "eip158Block"},
+		{"constantinople before byzantium", SatoshiTestChainConfig, func(c *ChainConfig) { c.ByzantiumBlock = big.NewInt(1) }, "constantinopleBlock", "byzantiumBlock"},
+		{"istanbul without petersburg", SatoshiTestChainConfig, func(c *ChainConfig) { c.PetersburgBlock = nil }, "istanbulBlock", "petersburgBlock"},
+		{"berlin before optional luban", SatoshiTestChainConfig, func(c *ChainConfig) { c.LubanBlock = big.NewInt(1) }, "berlinBlock", "lubanBlock"},
+		{"london without berlin", SatoshiTestChainConfig, func(c *ChainConfig) { c.BerlinBlock = nil }, "londonBlock", "berlinBlock"},
+		{"hertz without london", SatoshiTestChainConfig, func(c *ChainConfig) { c.LondonBlock = nil }, "hertzBlock", "londonBlock"},
+		{"kepler without shanghai", CoreChainConfig, func(c *ChainConfig) { c.ShanghaiTime = nil }, "keplerTime", "shanghaiTime"},
+		{"prague before cancun", CoreChainConfig, func(c *ChainConfig) {
+			c.CancunTime = newUint64(*c.AthenaTime + 20)
+			c.PragueTime = newUint64(*c.AthenaTime + 10)
+		}, "pragueTime", "cancunTime"},
+		{"hashpower before istanbul", CoreChainConfig, func(c *ChainConfig) { c.IstanbulBlock = big.NewInt(1); c.MuirGlacierBlock = big.NewInt(1) }, "hashPowerBlock", "muirGlacierBlock"},
+		{"zeus without hashpower", CoreChainConfig, func(c *ChainConfig) { c.HashPowerBlock = nil }, "zeusBlock", "hashPowerBlock"},
+		{"hera before zeus", CoreChainConfig, func(c *ChainConfig) { c.HeraBlock = big.NewInt(8_000_000) }, "heraBlock", "zeusBlock"},
+		{"poseidon without hera", CoreChainConfig, func(c *ChainConfig) { c.HeraBlock = nil }, "poseidonBlock", "heraBlock"},
+		{"poseidon before hera", BuffaloChainConfig, func(c *ChainConfig) { c.PoseidonBlock = big.NewInt(16_000_000) }, "poseidonBlock", "heraBlock"},
+		{"berlin before poseidon", CoreChainConfig, func(c *ChainConfig) { c.BerlinBlock = big.NewInt(13_000_000) }, "berlinBlock", "poseidonBlock"},
+		{"hertz before london", CoreChainConfig, func(c *ChainConfig) { c.HertzBlock = big.NewInt(19_000_000) }, "hertzBlock", "londonBlock"},
+		{"shanghai without hertz", CoreChainConfig, func(c *ChainConfig) { c.HertzBlock = nil }, "shanghaiTime", "hertzBlock"},
+		{"kepler before shanghai", CoreChainConfig, func(c *ChainConfig) { c.KeplerTime = newUint64(*c.ShanghaiTime - 1) }, "keplerTime", "shanghaiTime"},
+		{"demeter without kepler", CoreChainConfig, func(c *ChainConfig) { c.KeplerTime = nil }, "demeterTime", "keplerTime"},
+		{"demeter before kepler", BuffaloChainConfig, func(c *ChainConfig) { c.DemeterTime = newUint64(*c.KeplerTime - 1) }, "demeterTime", "keplerTime"},
+		{"athena before demeter", CoreChainConfig, func(c *ChainConfig) { c.AthenaTime = newUint64(*c.DemeterTime - 1) }, "athenaTime", "demeterTime"},
+		{"athena without demeter", PigeonChainConfig, func(c *ChainConfig) { c.DemeterTime = nil }, "athenaTime", "demeterTime"},
+		{"cancun without athena", BuffaloChainConfig, func(c *ChainConfig) { c.CancunTime = newUint64(*c.DemeterTime) }, "cancunTime", "athenaTime"},
+		{"cancun before athena", CoreChainConfig, func(c *ChainConfig) { c.CancunTime = newUint64(*c.AthenaTime - 1) }, "cancunTime", "athenaTime"},
+	}
+	for _, tt := range tests {
+		config := *tt.base
+		tt.modify(&config)
+
+		err := config.CheckConfigForkOrder()
+		if err == nil {
+			t.Errorf("%s: invalid config accepted", tt.name)
+			continue
+		}
+		orderErr, ok := err.(*ForkOrderError)
+		if !ok {
+			t.Errorf("%s: unexpected error type %T: %v", tt.name, err, err)
+			continue
+		}
+		if orderErr.What != tt.what || orderErr.Prev != tt.prev {
+			t.Errorf("%s: misordered forks mismatch: have %s after %s, want %s after %s", tt.name, orderErr.What, orderErr.Prev, tt.what, tt.prev)
+		}
+	}
+	// The fork order of non Satoshi chains is not enforced
+	config := *TestChainConfig
+	config.ShanghaiTime, config.AthenaTime, config.CancunTime = nil, newUint64(10), newUint64(0)
+	if err := config.CheckConfigForkOrder(); err != nil {
+		t.Errorf("unexpected error for non satoshi chain: %v", err)
+	}
+}
+
+func TestIsSatoshi(t *testing.T) {
+	for _, config := range []*ChainConfig{CoreChainConfig, BuffaloChainConfig, PigeonChainConfig, SatoshiTestChainConfig} {
+		if !config.IsSatoshi() {
+			t.Errorf("chain %v: not reported as satoshi", config.ChainID)
+		}
+	}
+	for _, config := range []*ChainConfig{MainnetChainConfig, AllEthashProtocolChanges, TestChainConfig} {
+		if config.IsSatoshi() {
+			t.Errorf("chain %v: reported as satoshi", config.ChainID)
+		}
+	}
+	// Configs running satoshi along with another engine must be rejected
+	ethash := *CoreChainConfig
+	ethash.Ethash = new(EthashConfig)
+	if err := ethash.CheckConfigForkOrder(); !errors.Is(err, ErrAmbiguousEngine) {
+		t.Errorf("satoshi with ethash: error mismatch: have %v, want %v", err, ErrAmbiguousEngine)
+	}
+	clique := *CoreChainConfig
+	clique.Clique = &CliqueConfig{Period: 3, Epoch: 30000}
+	if err := clique.CheckConfigForkOrder(); !errors.Is(err, ErrAmbiguousEngine) {
+		t.Errorf("satoshi with clique: error mismatch: have %v, want %v", err, ErrAmbiguousEngine)
+	}
+}
+
+func TestNextFork(t *testing.T) {
+	var (
+		athena = *CoreChainConfig.AthenaTime
+		head   = CoreChainConfig.HertzBlock
+	)
+	name, at, ok := CoreChainConfig.NextFork(head, athena-1)
+	if !ok || name != "Athena" || at != athena {
+		t.Errorf("next fork before athena mismatch: have %s at %d (ok %v), want Athena at %d", name, at, ok, athena)
+	}
+	// Forks sharing a timestamp are reported in fork order
+	name, _, ok = CoreChainConfig.NextFork(head, *CoreChainConfig.ShanghaiTime-1)
+	if !ok || name != "Shanghai" {
+		t.Errorf("next fork before shanghai mismatch: have %s (ok %v), want Shanghai", name, ok)
+	}
+	if name, _, ok := CoreChainConfig.NextFork(head, athena); ok {
+		t.Errorf("unexpected next fork after athena: %s", name)
+	}
+	// Block based forks are estimated from the head using the block period
+	config := *CoreChainConfig
+	config.HertzBlock = big.NewInt(1000)
+	config.ShanghaiTime, config.KeplerTime, config.DemeterTime, config.AthenaTime = nil, nil, nil, nil
+
+	name, at, ok = config.NextFork(big.NewInt(900), 10000)
+	if !ok || name != "Hertz" || at != 10000+100*config.Satoshi.Period {
+		t.Errorf("next block fork mismatch: have %s at %d (ok %v), want Hertz at %d", name, at, ok, 10000+100*config.Satoshi.Period)
+	}
+	if name, _, ok := config.NextFork(head, 10000); ok {
+		t.Errorf("unexpected fork after all block forks: %s", name)
+	}
+}
+
+func TestApplyForkOverrides(t *testing.T) {
+	now := uint64(time.Now().Unix())
+	newConfig := func() *ChainConfig {
+		config := *PigeonChainConfig
+		config.AthenaTime = newUint64(now + 3600)
+		return &config
+	}
+	// Moving a fork further out is accepted
+	config := newConfig()
+	if err := config.ApplyForkOverrides(ForkTimeOverrides{Athena: newUint64(now + 7200)}, now); err != nil {
+		t.Fatalf("failed to delay athena: %v", err)
+	}
+	if *config.AthenaTime != now+7200 {
+		t.Errorf("athena time mismatch: have %d, want %d", *config.AthenaTime, now+7200)
+	}
+	if *config.DemeterTime != *PigeonChainConfig.DemeterTime {
+		t.Errorf("unrelated fork modified: have %d, want %d", *config.DemeterTime, *PigeonChainConfig.DemeterTime)
+	}
+	// Moving a fork closer, but still into the future, is accepted
+	config = newConfig()
+	if err := config.ApplyForkOverrides(ForkTimeOverrides{Athena: newUint64(now + 60)}, now); err != nil {
+		t.Fatalf("failed to advance athena: %v", err)
+	}
+	if *config.AthenaTime != now+60 {
+		t.Errorf("athena time mismatch: have %d, want %d", *config.AthenaTime, now+60)
+	}
+	// Moving a fork into the past is rejected, leaving the config untouched
+	config = newConfig()
+	err := config.ApplyForkOverrides(ForkTimeOverrides{Athena: newUint64(now - 3600)}, now)
+	if err == nil || !strings.Contains(err.Error(), "already passed") {
+		t.Errorf("past override error mismatch: have %v", err)
+	}
+	if *config.AthenaTime != now+3600 {
+		t.Errorf("athena time modified on failure: have %d, want %d", *config.AthenaTime, now+3600)
+	}
+	// Breaking the fork order is rejected, leaving the config untouched
+	config = newConfig()
+	err = config.ApplyForkOverrides(ForkTimeOverrides{Demeter: newUint64(now + 7200)}, now)
+	if orderErr := new(ForkOrderError); !errors.As(err, &orderErr) {
+		t.Errorf("misordered override error mismatch: have %v", err)
+	}
+	if *config.DemeterTime != *PigeonChainConfig.DemeterTime {
+		t.Errorf("demeter time modified on failure: have %d, want %d", *config.DemeterTime, *PigeonChainConfig.DemeterTime)
+	}
+	// The unscheduled Theseus fork can be scheduled after Athena, but not before
+	config = newConfig()
+	if err := config.ApplyForkOverrides(ForkTimeOverrides{Theseus: newUint64(now + 7200)}, now); err != nil {
+		t.Fatalf("failed to schedule theseus: %v", err)
+	}
+	if config.TheseusTime == nil || *config.TheseusTime != now+7200 {
+		t.Errorf("theseus time mismatch: have %v, want %d", config.TheseusTime, now+7200)
+	}
+	config = newConfig()
+	err = config.ApplyForkOverrides(ForkTimeOverrides{Theseus: newUint64(now + 60)}, now)
+	if orderErr := new(ForkOrderError); !errors.As(err, &orderErr) {
+		t.Errorf("theseus before athena error mismatch: have %v", err)
+	}
+	if config.TheseusTime != nil {
+		t.Errorf("theseus time set on failure: have %d", *config.TheseusTime)
+	}
+}
+
+func TestIsOnAthena(t *testing.T) {
+	var (
+		athena = *PigeonChainConfig.AthenaTime
+		number = big.NewInt(100)
+	)
+	for i, tt := range []struct {
+		parent, header uint64
+		want           bool
+	}{
+		{athena - 6, athena - 3, false}, // both before the fork
+		{athena - 3, athena - 3, false}, // equal times before the fork
+		{athena - 3, athena, true},      // first block at the fork time
+		{athena - 1, athena + 2, true},  // first block past the fork time
+		{athena, athena, false},         // equal times after crossing
+		{athena, athena + 3, false},     // both after the fork
+		{athena + 3, athena + 3, false}, // equal times after the fork
+	} {
+		if have := PigeonChainConfig.IsOnAthena(number, tt.parent, tt.header); have != tt.want {
+			t.Errorf("test %d: fork block mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+	// Walk a chain straddling the fork, only a single block may be the fork one
+	var (
+		times = []uint64{athena - 6, athena - 3, athena - 3, athena, athena, athena + 3}
+		forks int
+	)
+	for i := 1; i < len(times); i++ {
+		if PigeonChainConfig.IsOnAthena(big.NewInt(int64(i)), times[i-1], times[i]) {
+			if times[i] != athena || times[i-1] == athena {
+				t.Errorf("block %d: unexpected fork block", i)
+			}
+			forks++
+		}
+	}
+	if forks != 1 {
+		t.Errorf("fork block count mismatch: have %d, want 1", forks)
+	}
+	// Unscheduled forks never activate
+	if SatoshiTestChainConfig.IsOnKepler(number, 0, 1) || SatoshiTestChainConfig.IsOnDemeter(number, 0, 1) {
+		t.Error("unscheduled fork reported as activating")
+	}
+}
+
+func TestGasSchedule(t *testing.T) {
+	c := &ChainConfig{
+		HomesteadBlock:      big.NewInt(0),
+		EIP150Block:         big.NewInt(0),
+		EIP155Block:         big.NewInt(0),
+		EIP158Block:         big.NewInt(0),
+		ByzantiumBlock:      big.NewInt(0),
+		ConstantinopleBlock: big.NewInt(0),
+		PetersburgBlock:     big.NewInt(0),
+		IstanbulBlock:       big.NewInt(0),
+		BerlinBlock:         big.NewInt(10),
+		LondonBlock:         big.NewInt(20),
+	}
+	before, after := c.GasSchedule(9, 0), c.GasSchedule(10, 0)
+	if before.WarmStorageReadCost != 0 || before.ColdSloadCost != 0 || before.ColdAccountAccessCost != 0 {
+		t.Errorf("EIP-2929 costs active before Berlin: %+v", before)
+	}
+	if before.SloadGas != SloadGasEIP2200 {
+		t.Errorf("pre-Berlin SLOAD cost mismatch: have %d, want %d", before.SloadGas, SloadGasEIP2200)
+	}
+	want := GasSchedule{
+		SloadGas:                   WarmStorageReadCostEIP2929,
+		WarmStorageReadCost:        WarmStorageReadCostEIP2929,
+		ColdSloadCost:              ColdSloadCostEIP2929,
+		ColdAccountAccessCost:      ColdAccountAccessCostEIP2929,
+		TxAccessListAddressGas:     TxAccessListAddressGas,
+		TxAccessListStorageKeyGas:  TxAccessListStorageKeyGas,
+		TxDataNonZeroGas:           TxDataNonZeroGasEIP2028,
+		SstoreClearsScheduleRefund: SstoreClearsScheduleRefundEIP2200,
+		RefundQuotient:             RefundQuotient,
+	}
+	if after != want {
+		t.Errorf("Berlin schedule mismatch: have %+v, want %+v", after, want)
+	}
+	if london := c.GasSchedule(20, 0); london.RefundQuotient != RefundQuotientEIP3529 || london.SstoreClearsScheduleRefund != SstoreClearsScheduleRefundEIP3529 {
+		t.Errorf("London refunds not applied: %+v", london)
+	}
+	// Satoshi chains reserve gas for the system transactions
+	c.Satoshi = &SatoshiConfig{Period: 3, Epoch: 200}
+	if have := c.GasSchedule(10, 0).SystemTxsGas; have != SystemTxsGas {
+		t.Errorf("system txs gas mismatch: have %d, want %d", have, SystemTxsGas)
+	}
+}
+
+func TestAthenaActivation(t *testing.T) {
+	for _, config := range []*ChainConfig{CoreChainConfig, PigeonChainConfig} {
+		var (
+			athena = *config.AthenaTime
+			number = config.LondonBlock
+		)
+		for _, tt := range []struct {
+			time uint64
+			want bool
+		}{
+			{0, false},
+			{athena - 1, false},
+			{athena, true},
+			{athena + 1, true},
+			{math.MaxUint64, true},
+		} {
+			if have := config.IsAthena(number, tt.time); have != tt.want {
+				t.Errorf("chain %v, time %d: athena mismatch: have %v, want %v", config.ChainID, tt.time, have, tt.want)
+			}
+		}
+		// Athena is gated behind London
+		if number.Sign() > 0 && config.IsAthena(new(big.Int).Sub(number, common.Big1), athena) {
+			t.Errorf("chain %v: athena active before london", config.ChainID)
+		}
+	}
+}
@@ -263,6 +263,30 @@ func TypedDataAndHash(typedData TypedData) ([]byte, string, error) {
 	return crypto.Keccak256([]byte(rawData)), rawData, nil
 }
 
+// RecoverTypedData returns the address of the account that signed the EIP-712
+// typed data. The signature is in the [R || S || V] format where V is 27 or 28,
+// as produced by personal_signTypedData.
+func RecoverTypedData(typedData TypedData, sig []byte) (common.Address, error) {
+	if len(sig) != crypto.SignatureLength {
+		return common.Address{}, fmt.Errorf("signature must be %d bytes long", crypto.SignatureLength)
+	}
+	if sig[crypto.RecoveryIDOffset] != 27 && sig[crypto.RecoveryIDOffset] != 28 {
+		return common.Address{}, errors.New("invalid Ethereum signature (V is not 27 or 28)")
+	}
+	hash, _, err := TypedDataAndHash(typedData)
+	if err != nil {
+		return common.Address{}, err
+	}
+	sig = common.CopyBytes(sig)
+	sig[crypto.RecoveryIDOffset] -= 27 // Transform yellow paper V from 27/28 to 0/1
+
+	rpk, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*rpk), nil
+}
+
 // HashStruct generates a keccak256 hash of the encoding of the provided data
 func (typedData *TypedData) HashStruct(primaryType string, data TypedDataMessage) (hexutil.Bytes, error) {
 	encodedData, err := typedData.EncodeData(primaryType, data, 1)
@@ -16,7 +16,14 @@
 
 package apitypes
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
 
 func TestIsPrimitive(t *testing.T) {
 	// Expected positives
@@ -38,3 +45,78 @@ func TestIsPrimitive(t *testing.T) {
 		}
 	}
 }
+
+// Tests recovering the signer of the EIP-712 example message against the test
+// vector of the EIP.
+func TestRecoverTypedData(t *testing.T) {
+	var typedData TypedData
+	if err := json.Unmarshal([]byte(eip712MailJSON), &typedData); err != nil {
+		t.Fatal(err)
+	}
+	hash, _, err := TypedDataAndHash(typedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := crypto.ToECDSAUnsafe(crypto.Keccak256([]byte("cow")))
+	sig, err := crypto.Sign(hash, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := common.FromHex("0x4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b9156201")
+	if !bytes.Equal(sig, want) {
+		t.Fatalf("signature mismatch: have %x, want %x", sig, want)
+	}
+	sig[crypto.RecoveryIDOffset] += 27
+
+	addr, err := RecoverTypedData(typedData, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := common.HexToAddress("0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"); addr != want {
+		t.Fatalf("signer mismatch: have %v, want %v", addr, want)
+	}
+	if sig[crypto.RecoveryIDOffset] != 28 {
+		t.Fatal("RecoverTypedData modified the signature")
+	}
+	// Signatures with a raw 0/1 recovery id or of the wrong length are rejected
+	sig[crypto.RecoveryIDOffset] -= 27
+	if _, err := RecoverTypedData(typedData, sig); err == nil {
+		t.Fatal("expected RecoverTypedData to fail with V not 27 or 28")
+	}
+	if _, err := RecoverTypedData(typedData, sig[:crypto.SignatureLength-1]); err == nil {
+		t.Fatal("expected RecoverTypedData to fail with short signature")
+	}
+}
+
+// eip712MailJSON is the example message of EIP-712.
+const eip712MailJSON = `{
+	"types": {
+		"EIP712Domain": [
+			{"name": "name", "type": "string"},
+			{"name": "version", "type": "string"},
+			{"name": "chainId", "type": "uint256"},
+			{"name": "verifyingContract", "type": "address"}
+		],
+		"Person": [
+			{"name": "name", "type": "string"},
+			{"name": "wallet", "type": "address"}
+		],
+		"Mail": [
+			{"name": "from", "type": "Person"},
+			{"name": "to", "type": "Person"},
+			{"name": "contents", "type": "string"}
+		]
+	},
+	"primaryType": "Mail",
+	"domain": {
+		"name": "Ether Mail",
+		"version": "1",
+		"chainId": 1,
+		"verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+	},
+	"message": {
+		"from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+		"to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+		"contents": "Hello, Bob!"
+	}
+}`
@@ -365,6 +365,16 @@ func (db *Database) Head() common.Hash {
 	return pdb.Head()
 }
 
+// DiskRoot returns the state root of the disk layer. It's only supported by
+// path-based database and will return empty hash for others.
+func (db *Database) DiskRoot() common.Hash {
+	pdb, ok := db.backend.(*pathdb.Database)
+	if !ok {
+		return common.Hash{}
+	}
+	return pdb.DiskRoot()
+}
+
 // GetAllHash returns all MPT root hash in diffLayer and diskLayer.
 // It's only supported by path-based database and will return nil for
 // others.
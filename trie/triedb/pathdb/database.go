@@ -100,6 +100,8 @@ type Config struct {
 	StateHistory   uint64 // Number of recent blocks to maintain state history for
 	CleanCacheSize int    // Maximum memory allowance (in bytes) for caching clean nodes
 	DirtyCacheSize int    // Maximum memory allowance (in bytes) for caching dirty nodes
+	MaxDiffLayers  int    // Maximum number of diff layers kept in memory (0 = 128)
+	FlushLayers    uint64 // Number of layers after which the node buffer is flushed regardless of its size (0 = disabled)
 	ReadOnly       bool   // Flag whether the database is opened in read only mode.
 }
 
@@ -111,6 +113,12 @@ func (c *Config) sanitize() *Config {
 		log.Warn("Sanitizing invalid node buffer size", "provided", common.StorageSize(conf.DirtyCacheSize), "updated", common.StorageSize(MaxDirtyBufferSize))
 		conf.DirtyCacheSize = MaxDirtyBufferSize
 	}
+	if conf.MaxDiffLayers <= 0 || conf.MaxDiffLayers > maxDiffLayers {
+		if conf.MaxDiffLayers != 0 {
+			log.Warn("Sanitizing invalid diff layer limit", "provided", conf.MaxDiffLayers, "updated", maxDiffLayers)
+		}
+		conf.MaxDiffLayers = maxDiffLayers
+	}
 	return &conf
 }
 
@@ -223,12 +231,16 @@ func (db *Database) Update(root common.Hash, parentRoot common.Hash, block uint6
 	if err := db.tree.add(root, parentRoot, block, nodes, states); err != nil {
 		return err
 	}
-	// Keep 128 diff layers in the memory, persistent layer is 129th.
+	// Keep 128 diff layers in the memory by default, persistent layer is 129th.
 	// - head layer is paired with HEAD state
 	// - head-1 layer is paired with HEAD-1 state
 	// - head-127 layer(bottom-most diff layer) is paired with HEAD-127 state
 	// - head-128 layer(disk layer) is paired with HEAD-128 state
-	return db.tree.cap(root, maxDiffLayers)
+	if err := db.tree.cap(root, db.config.MaxDiffLayers); err != nil {
+		return err
+	}
+	diffLayerCountGauge.Update(int64(db.tree.len() - 1))
+	return nil
 }
 
 // Commit traverses downwards the layer tree from a specified layer with the
@@ -243,7 +255,11 @@ func (db *Database) Commit(root common.Hash, report bool) error {
 	if db.readOnly {
 		return errSnapshotReadOnly
 	}
-	return db.tree.cap(root, 0)
+	if err := db.tree.cap(root, 0); err != nil {
+		return err
+	}
+	diffLayerCountGauge.Update(0)
+	return nil
 }
 
 // Reset rebuilds the database with the specified state as the base.
@@ -444,6 +460,13 @@ func (db *Database) Head() common.Hash {
 	return db.tree.front()
 }
 
+// DiskRoot returns the state root of the disk layer.
+func (db *Database) DiskRoot() common.Hash {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.tree.bottom().rootHash()
+}
+
 // GetAllRooHash returns all diffLayer and diskLayer root hash
 func (db *Database) GetAllRooHash() [][]string {
 	db.lock.Lock()
@@ -585,6 +585,86 @@ func TestTailTruncateHistory(t *testing.T) {
 	}
 }
 
+func TestMaxDiffLayers(t *testing.T) {
+	tester := newTester(t, 0)
+	defer tester.release()
+
+	// By default 128 diff layers are kept on top of the disk layer
+	if have, want := tester.db.tree.len(), maxDiffLayers+1; have != want {
+		t.Fatalf("unexpected layer count: have %d, want %d", have, want)
+	}
+	// Lowering the cap flattens the excess layers on the next update
+	tester.db.config.MaxDiffLayers = 16
+	for i := 0; i < 32; i++ {
+		parent := tester.lastHash()
+		root, nodes, states := tester.generate(parent)
+		if err := tester.db.Update(root, parent, uint64(len(tester.roots)), nodes, states); err != nil {
+			t.Fatalf("Failed to update state changes, err: %v", err)
+		}
+		tester.roots = append(tester.roots, root)
+
+		if have := tester.db.tree.len(); have > 16+1 {
+			t.Fatalf("diff layers above cap: have %d, want <= %d", have-1, 16)
+		}
+	}
+	if err := tester.verifyState(tester.lastHash()); err != nil {
+		t.Fatalf("Failed to verify state, err: %v", err)
+	}
+	if bottom := tester.bottomIndex(); bottom != len(tester.roots)-17 {
+		t.Fatalf("unexpected disk layer: have %d, want %d", bottom, len(tester.roots)-17)
+	}
+}
+
+func TestFlushLayers(t *testing.T) {
+	// Use a synchronous node buffer large enough to only flush on the cadence
+	disk, _ := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), t.TempDir(), "", false, false, false, false)
+	tester := &tester{
+		db: New(disk, &Config{
+			SyncFlush:      true,
+			CleanCacheSize: 256 * 1024,
+			DirtyCacheSize: MaxDirtyBufferSize,
+			FlushLayers:    10,
+		}),
+		preimages:    make(map[common.Hash]common.Address),
+		accounts:     make(map[common.Hash][]byte),
+		storages:     make(map[common.Hash]map[common.Hash][]byte),
+		snapAccounts: make(map[common.Hash]map[common.Hash][]byte),
+		snapStorages: make(map[common.Hash]map[common.Hash]map[common.Hash][]byte),
+	}
+	defer tester.release()
+
+	for i := 0; i < maxDiffLayers+32; i++ {
+		parent := types.EmptyRootHash
+		if len(tester.roots) != 0 {
+			parent = tester.lastHash()
+		}
+		root, nodes, states := tester.generate(parent)
+		if err := tester.db.Update(root, parent, uint64(i), nodes, states); err != nil {
+			t.Fatalf("Failed to update state changes, err: %v", err)
+		}
+		tester.roots = append(tester.roots, root)
+
+		// The disk layer never runs the cadence ahead of the persisted state,
+		// which only moves in steps of the cadence
+		var (
+			bottom    = tester.db.tree.bottom().stateID()
+			persisted = rawdb.ReadPersistentStateID(tester.db.diskdb)
+		)
+		if bottom-persisted >= 10 {
+			t.Fatalf("node buffer not flushed: disk layer %d, persisted %d", bottom, persisted)
+		}
+		if persisted%10 != 0 {
+			t.Fatalf("unexpected flush point: persisted %d", persisted)
+		}
+	}
+	if persisted := rawdb.ReadPersistentStateID(tester.db.diskdb); persisted != 30 {
+		t.Fatalf("unexpected persisted state: have %d, want %d", persisted, 30)
+	}
+	if err := tester.verifyState(tester.lastHash()); err != nil {
+		t.Fatalf("Failed to verify state, err: %v", err)
+	}
+}
+
 // copyAccounts returns a deep-copied account set of the provided one.
 func copyAccounts(set map[common.Hash][]byte) map[common.Hash][]byte {
 	copied := make(map[common.Hash][]byte, len(set))
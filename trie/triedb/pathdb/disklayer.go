@@ -274,6 +274,12 @@ func (dl *diskLayer) commit(bottom *diffLayer, force bool) (*diskLayer, error) {
 	if !force && rawdb.ReadPersistentStateID(dl.db.diskdb) < oldest {
 		force = true
 	}
+	// Flush the node buffer regardless of its size if the configured number of
+	// layers was merged into it. Only layers that already dropped out of the
+	// in-memory diff layers are ever part of the buffer.
+	if !force && dl.db.config.FlushLayers != 0 && ndl.buffer.getLayers() >= dl.db.config.FlushLayers {
+		force = true
+	}
 	if err := ndl.buffer.flush(ndl.db.diskdb, ndl.cleans, ndl.id, force); err != nil {
 		return nil, err
 	}